@@ -2,30 +2,38 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 	"unipilot/internal/app"
 	"unipilot/internal/auth"
 	"unipilot/internal/client"
+	"unipilot/internal/config"
 	"unipilot/internal/events"
+	"unipilot/internal/models"
 	"unipilot/internal/models/assignment"
 	"unipilot/internal/models/course"
 	"unipilot/internal/models/document"
 	"unipilot/internal/models/note"
 	"unipilot/internal/models/user"
 	"unipilot/internal/network"
+	"unipilot/internal/services/calendar"
 	"unipilot/internal/services/fileops"
+	"unipilot/internal/services/syllabus"
 	"unipilot/internal/sse"
 	"unipilot/internal/storage"
+	"unipilot/internal/sync"
 
 	"github.com/wailsapp/wails/v2/pkg/runtime"
+	"gorm.io/gorm"
 )
 
 // App struct
@@ -34,6 +42,7 @@ type App struct {
 	Auth   *auth.Auth
 	Events *events.Events
 	DB     *app.DatabaseHelper
+	Outbox *sync.Outbox
 }
 
 // NewApp creates a new App application struct
@@ -48,18 +57,15 @@ func NewApp() *App {
 // CREATE OPERATIONS
 // ========================================
 
-// CreateAssignment creates a new assignment
+// CreateAssignment creates a new assignment locally and queues it for the server
 func (a *App) CreateAssignment(assignmentData *assignment.LocalAssignment) error {
 	if a.DB == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
-	tx := a.DB.GetDB().Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
 
 	localAssignment := &assignment.LocalAssignment{
 		Title:      assignmentData.Title,
@@ -69,58 +75,37 @@ func (a *App) CreateAssignment(assignmentData *assignment.LocalAssignment) error
 		TypeName:   assignmentData.TypeName,
 		StatusName: assignmentData.StatusName,
 		Priority:   assignmentData.Priority,
+		SyncStatus: assignment.SyncStatusPending,
 	}
 
 	fmt.Println("Creating assignment:", localAssignment)
-
-	if !a.Auth.IsAuthenticated() {
-		return fmt.Errorf("user not authenticated")
-	}
 	fmt.Println("User ID:", a.DB.GetCurrentUserID())
 
-	// Create the assignment within the transaction
-	if err := tx.Create(localAssignment).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	fmt.Println(" local assignment success ")
-	remoteAssignment := &assignment.Assignment{
-		LocalID:    localAssignment.ID,
-		Title:      localAssignment.Title,
-		Todo:       localAssignment.Todo,
-		Deadline:   localAssignment.Deadline,
-		CourseCode: localAssignment.CourseCode,
-		TypeName:   localAssignment.TypeName,
-		StatusName: localAssignment.StatusName,
-		Priority:   localAssignment.Priority,
-	}
-
-	responseAssignment, err := client.CreateAssignment(remoteAssignment)
+	// The local write commits right away, the outbox delivers it to the server when online
+	err := a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(localAssignment).Error; err != nil {
+			return err
+		}
+		return sync.Enqueue(tx, models.Assignment, models.OperationCreate, localAssignment.ID, "", "")
+	})
 	if err != nil {
-		tx.Rollback()
-		fmt.Println("Error creating remote assignment:", err)
 		return err
 	}
 
-	tx.Commit()
-	log.Printf("Response assignment: %v\n", responseAssignment)
+	a.notifyOutbox()
 
 	return nil
 }
 
-// CreateCourse creates a new course
+// CreateCourse creates a new course locally and queues it for the server
 func (a *App) CreateCourse(courseData *course.LocalCourse) error {
 	if a.DB == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
-	tx := a.DB.GetDB().Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
 
 	localCourse := &course.LocalCourse{
 		Name:            courseData.Name,
@@ -134,118 +119,162 @@ func (a *App) CreateCourse(courseData *course.LocalCourse) error {
 		InstructorEmail: courseData.InstructorEmail,
 		StartDate:       courseData.StartDate,
 		EndDate:         courseData.EndDate,
+		SyncStatus:      course.SyncStatusPending,
 	}
 
 	fmt.Println("Creating course:", localCourse)
-
-	if !a.Auth.IsAuthenticated() {
-		return fmt.Errorf("user not authenticated")
-	}
 	fmt.Println("User ID:", a.DB.GetCurrentUserID())
 
-	// Check if a soft-deleted course with the same code exists
-	var existingCourse course.LocalCourse
-	if err := tx.Unscoped().Where("code = ? AND deleted_at IS NOT NULL", localCourse.Code).First(&existingCourse).Error; err == nil {
-		// A soft-deleted course with this code exists, permanently delete it first
-		if err := tx.Unscoped().Delete(&existingCourse).Error; err != nil {
-			tx.Rollback()
-			return fmt.Errorf("failed to clean up soft-deleted course: %w", err)
+	err := a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+		// Check if a soft-deleted course with the same code exists
+		var existingCourse course.LocalCourse
+		if err := tx.Unscoped().Where("code = ? AND deleted_at IS NOT NULL", localCourse.Code).First(&existingCourse).Error; err == nil {
+			// A soft-deleted course with this code exists, permanently delete it first
+			if err := tx.Unscoped().Delete(&existingCourse).Error; err != nil {
+				return fmt.Errorf("failed to clean up soft-deleted course: %w", err)
+			}
 		}
-	}
 
-	// Create the course within the transaction
-	if err := tx.Create(localCourse).Error; err != nil {
-		tx.Rollback()
-		return err
-	}
-
-	fmt.Println(" local assignment success ")
-	remoteCourse := &course.Course{
-		LocalID:         localCourse.ID,
-		Name:            localCourse.Name,
-		Code:            localCourse.Code,
-		Color:           localCourse.Color,
-		Semester:        localCourse.Semester,
-		Schedule:        localCourse.Schedule,
-		Credits:         localCourse.Credits,
-		RoomNumber:      localCourse.RoomNumber,
-		Instructor:      localCourse.Instructor,
-		InstructorEmail: localCourse.InstructorEmail,
-		StartDate:       localCourse.StartDate,
-		EndDate:         localCourse.EndDate,
-	}
-
-	responseCourse, err := client.CreateCourse(remoteCourse)
+		if err := tx.Create(localCourse).Error; err != nil {
+			return err
+		}
+		return sync.Enqueue(tx, models.EntityCourse, models.OperationCreate, localCourse.ID, "", "")
+	})
 	if err != nil {
-		tx.Rollback()
-		fmt.Println("Error creating remote course:", err)
 		return err
 	}
 
-	tx.Commit()
-	log.Printf("Response course: %v\n", responseCourse)
+	a.notifyOutbox()
 
 	return nil
 }
 
+// CreateNote creates a new note locally and queues it for the server.
+// The note content is generated by the server and filled in once the outbox delivers it.
 func (a *App) CreateNote(noteData *note.LocalNote) error {
 	if a.DB == nil {
 		return fmt.Errorf("database not initialized")
 	}
 
-	tx := a.DB.GetDB().Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
 
 	localNote := &note.LocalNote{
 		Title:      noteData.Title,
 		Subject:    noteData.Subject,
 		CourseCode: noteData.CourseCode,
+		SyncStatus: note.SyncStatusPending,
 	}
 
 	fmt.Println("Creating note:", localNote)
-
-	if !a.Auth.IsAuthenticated() {
-		return fmt.Errorf("user not authenticated")
-	}
 	fmt.Println("User ID:", a.DB.GetCurrentUserID())
 
-	// Create the note within the transaction
-	if err := tx.Create(localNote).Error; err != nil {
-		tx.Rollback()
+	err := a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(localNote).Error; err != nil {
+			return err
+		}
+		return sync.Enqueue(tx, models.EntityNote, models.OperationCreate, localNote.ID, "", "")
+	})
+	if err != nil {
 		return err
 	}
 
-	fmt.Println(" local note success ")
-	remoteNote := &note.Note{
-		LocalID:    localNote.ID,
-		Title:      localNote.Title,
-		Subject:    localNote.Subject,
-		CourseCode: localNote.CourseCode,
+	a.notifyOutbox()
+
+	return nil
+
+}
+
+// SelectSyllabusFile opens a file dialog to choose a syllabus to import assignments from
+func (a *App) SelectSyllabusFile() (string, error) {
+	filePath, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Select Syllabus",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "Syllabi (*.csv, *.ics, *.md, *.txt)",
+				Pattern:     "*.csv;*.ics;*.md;*.txt",
+			},
+			{
+				DisplayName: "All Files",
+				Pattern:     "*",
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open file dialog: %w", err)
+	}
+	if filePath == "" {
+		return "", fmt.Errorf("no file selected")
+	}
+	return filePath, nil
+}
+
+// readSyllabus parses the syllabus at path and checks its rows against the local
+// database. mapping sets the column of fields, the others are guessed. courseCode is
+// the course of rows without one, and its start date places dates written without a year.
+func (a *App) readSyllabus(db *gorm.DB, path, courseCode string, mapping map[string]string) (*syllabus.Preview, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read syllabus: %w", err)
+	}
+
+	opts := syllabus.Options{Mapping: mapping, CourseCode: courseCode}
+	if courseCode != "" {
+		var c course.LocalCourse
+		if err := db.Where("code = ?", courseCode).First(&c).Error; err == nil {
+			opts.Start = c.StartDate
+		}
 	}
 
-	responseNote, err := client.CreateNote(remoteNote)
+	preview, err := syllabus.Parse(data, filepath.Base(path), opts)
 	if err != nil {
-		tx.Rollback()
-		fmt.Println("Error creating remote note:", err)
-		return err
+		return nil, err
+	}
+	if err := preview.Check(db); err != nil {
+		return nil, err
 	}
+	return preview, nil
+}
 
-	localNote.Keywords = responseNote["keywords"]
-	localNote.Content = responseNote["content"]
+// PreviewSyllabusImport returns the assignments read from a syllabus with the errors
+// of each row, without importing anything
+func (a *App) PreviewSyllabusImport(path, courseCode string, mapping map[string]string) (*syllabus.Preview, error) {
+	if a.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
 
-	if err := tx.Save(&localNote).Error; err != nil {
-		tx.Rollback()
-		return err
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
 	}
 
-	tx.Commit()
+	return a.readSyllabus(a.DB.GetDB(), path, courseCode, mapping)
+}
+
+// ImportSyllabus creates the assignments of a syllabus in one transaction, queued for
+// the server. Nothing is imported when a row has errors, see PreviewSyllabusImport.
+func (a *App) ImportSyllabus(path, courseCode string, mapping map[string]string) (int, error) {
+	if a.DB == nil {
+		return 0, fmt.Errorf("database not initialized")
+	}
 
-	return nil
+	if !a.Auth.IsAuthenticated() {
+		return 0, fmt.Errorf("user not authenticated")
+	}
 
+	preview, err := a.readSyllabus(a.DB.GetDB(), path, courseCode, mapping)
+	if err != nil {
+		return 0, err
+	}
+	n, err := syllabus.Commit(a.DB.GetDB(), preview)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("[App] Imported %d assignments from %s", n, filepath.Base(path))
+	a.notifyOutbox()
+
+	return n, nil
 }
 
 // UploadDocument opens a file dialog and uploads a document to an assignment
@@ -318,37 +347,24 @@ func (a *App) UploadDocument(assignmentID uint, documentType string) (*document.
 		FileSize:     fileInfo.Size(),
 	}
 
-	// Upload the document locally
-	response, err := fileops.UploadDocument(uploadReq, a.DB.GetDB())
-	if err != nil {
-		return nil, fmt.Errorf("upload failed: %w", err)
-	}
-
-	if !response.Success {
-		return nil, fmt.Errorf("upload failed: %s", response.Message)
-	}
-
-	// Also store metadata remotely for sharing
-	if a.Auth.IsAuthenticated() && a.Auth.Client != nil {
-		metadataReq := map[string]interface{}{
-			"assignment_id": assignmentID,
-			"local_id":      response.LocalDocument.ID,
-			"type":          documentType,
-			"file_name":     filepath.Base(filePath),
-			"file_type":     fileops.GetMimeType(filepath.Base(filePath)),
-			"file_size":     fileInfo.Size(),
+	// Store the document locally, the outbox sends its metadata and file to the server
+	var response *fileops.FileUploadResponse
+	err = a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+		response, err = fileops.UploadDocument(uploadReq, tx)
+		if err != nil {
+			return fmt.Errorf("upload failed: %w", err)
 		}
-
-		jsonData, _ := json.Marshal(metadataReq)
-		resp, _ := a.Auth.Client.Post("https://newsroom.dedyn.io/acc-homework/document/metadata",
-			"application/json", strings.NewReader(string(jsonData)))
-		if resp.StatusCode == 200 {
-			defer resp.Body.Close()
+		if !response.Success {
+			return fmt.Errorf("upload failed: %s", response.Message)
 		}
-		// We don't block on this - local file upload is the priority
-
+		return sync.Enqueue(tx, models.EntityDocument, models.OperationCreate, response.LocalDocument.ID, "", "")
+	})
+	if err != nil {
+		return nil, err
 	}
 
+	a.notifyOutbox()
+
 	return response.LocalDocument, nil
 }
 
@@ -366,13 +382,7 @@ func (a *App) UpdateAssignment(LocalAssignment *assignment.LocalAssignment, colu
 		return err
 	}
 
-	assignment_id_int := int(LocalAssignment.ID)
-
-	assignment_id := strconv.Itoa(assignment_id_int)
-
-	if err := client.SendAssignmentUpdate(assignment_id, column, value); err != nil {
-		return err
-	}
+	a.notifyOutbox()
 
 	return nil
 }
@@ -386,13 +396,7 @@ func (a *App) UpdateCourse(course *course.LocalCourse, column, value string) err
 		return err
 	}
 
-	course_id_int := int(course.ID)
-
-	course_id := strconv.Itoa(course_id_int)
-
-	if err := client.SendCourseUpdate(course_id, column, value); err != nil {
-		return err
-	}
+	a.notifyOutbox()
 
 	return nil
 }
@@ -406,13 +410,7 @@ func (a *App) UpdateNote(LocalNote *note.LocalNote, column, value string) error
 		return err
 	}
 
-	note_id_int := int(LocalNote.ID)
-
-	note_id := strconv.Itoa(note_id_int)
-
-	if err := client.SendNoteUpdate(note_id, column, value); err != nil {
-		return err
-	}
+	a.notifyOutbox()
 
 	return nil
 }
@@ -487,37 +485,23 @@ func (a *App) UploadNewDocumentVersion(existingDocumentID uint) (*document.Local
 		FileSize:     fileInfo.Size(),
 	}
 
-	// Upload new version locally
-	response, err := fileops.UploadNewVersion(existingDocumentID, uploadReq, a.DB.GetDB())
+	// Store the new version locally, the outbox sends its metadata and file to the server
+	var response *fileops.FileUploadResponse
+	err = a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+		response, err = fileops.UploadNewVersion(existingDocumentID, uploadReq, tx)
+		if err != nil {
+			return fmt.Errorf("version upload failed: %w", err)
+		}
+		if !response.Success {
+			return fmt.Errorf("version upload failed: %s", response.Message)
+		}
+		return sync.Enqueue(tx, models.EntityDocument, models.OperationCreate, response.LocalDocument.ID, "", "")
+	})
 	if err != nil {
-		return nil, fmt.Errorf("version upload failed: %w", err)
+		return nil, err
 	}
 
-	if !response.Success {
-		return nil, fmt.Errorf("version upload failed: %s", response.Message)
-	}
-
-	// Also update metadata remotely for sharing (async)
-	if a.Auth.IsAuthenticated() && a.Auth.Client != nil {
-		metadataReq := map[string]interface{}{
-			"assignment_id": existingDoc.AssignmentID,
-			"local_id":      existingDoc.ID,
-			"type":          string(existingDoc.Type),
-			"file_name":     filepath.Base(filePath),
-			"file_type":     fileops.GetMimeType(filepath.Base(filePath)),
-			"file_size":     fileInfo.Size(),
-			"version":       response.LocalDocument.Version,
-		}
-
-		go func() {
-			jsonData, _ := json.Marshal(metadataReq)
-			resp, err := a.Auth.Client.Post("https://newsroom.dedyn.io/acc-homework/documents/metadata",
-				"application/json", strings.NewReader(string(jsonData)))
-			if err == nil {
-				defer resp.Body.Close()
-			}
-		}()
-	}
+	a.notifyOutbox()
 
 	return response.LocalDocument, nil
 }
@@ -550,11 +534,7 @@ func (a *App) DeleteAssignment(assignment *assignment.LocalAssignment) error {
 		return err
 	}
 
-	assignment_id_str := strconv.Itoa(int(assignment.ID))
-
-	if err := client.SendAssignmentUpdate(assignment_id_str, "deleted_at", time.Now().Format(time.RFC3339)); err != nil {
-		return err
-	}
+	a.notifyOutbox()
 
 	return nil
 }
@@ -578,16 +558,23 @@ func (a *App) DeleteCourse(course *course.LocalCourse) error {
 		}
 	}
 
-	if err := a.DB.DeleteCourse(course); err != nil {
+	// Delete the notes of the course, the server cascades the same way
+	var notes []note.LocalNote
+	if err := a.DB.GetDB().Where("course_code = ?", course.Code).Find(&notes).Error; err != nil {
 		return err
 	}
+	for i := range notes {
+		if err := a.DB.DeleteNote(&notes[i]); err != nil {
+			return err
+		}
+	}
 
-	course_id_str := strconv.Itoa(int(course.ID))
-
-	if err := client.SendCourseUpdate(course_id_str, "deleted_at", time.Now().Format(time.RFC3339)); err != nil {
+	if err := a.DB.DeleteCourse(course); err != nil {
 		return err
 	}
 
+	a.notifyOutbox()
+
 	return nil
 }
 
@@ -609,36 +596,25 @@ func (a *App) DeleteDocument(documentID uint) error {
 		return fmt.Errorf("document not found or access denied")
 	}
 
-	// Delete physical file if it exists
-	if doc.HasLocalFile && doc.FilePath != "" {
-		if err := os.Remove(doc.FilePath); err != nil && !os.IsNotExist(err) {
-			return fmt.Errorf("failed to delete file: %w", err)
+	// Delete database record, the outbox removes the metadata from the server. The file
+	// is deleted with the last document referencing the same content.
+	if err := a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+		if err := fileops.ReleaseDocumentFile(tx, &doc); err != nil {
+			return err
+		}
+		if err := tx.Delete(&doc).Error; err != nil {
+			return fmt.Errorf("failed to delete document record: %w", err)
 		}
+		return sync.Enqueue(tx, models.EntityDocument, models.OperationDelete, doc.ID, "", "")
+	}); err != nil {
+		return err
 	}
 
-	db := a.DB.GetDB()
-	db = db.Debug()
-	// Delete database record
-	if err := db.Delete(&doc).Error; err != nil {
-		return fmt.Errorf("failed to delete document record: %w", err)
+	if err := document.UpdateLocalStorageInfo(userID, a.DB.GetDB()); err != nil {
+		log.Printf("[App] Failed to update storage info: %v", err)
 	}
 
-	// Storage info is now calculated on-demand, no need to update cache
-
-	// Also store metadata remotely for sharing
-	if a.Auth.IsAuthenticated() && a.Auth.Client != nil {
-
-		resp, _ := a.Auth.Client.Post(fmt.Sprintf("https://newsroom.dedyn.io/acc-homework/document/metadata/delete?document_id=%d", documentID),
-			"application/json", nil)
-		if resp.StatusCode == 200 {
-			defer resp.Body.Close()
-		}
-
-		if resp.StatusCode != 200 {
-			return fmt.Errorf("failed to delete document metadata: %s", resp.Status)
-		}
-
-	}
+	a.notifyOutbox()
 
 	return nil
 }
@@ -652,11 +628,7 @@ func (a *App) DeleteNote(note *note.LocalNote) error {
 		return err
 	}
 
-	note_id_str := strconv.Itoa(int(note.ID))
-
-	if err := client.SendNoteUpdate(note_id_str, "deleted_at", time.Now().Format(time.RFC3339)); err != nil {
-		return err
-	}
+	a.notifyOutbox()
 
 	return nil
 }
@@ -670,12 +642,26 @@ func (a *App) DeleteNote(note *note.LocalNote) error {
 func (a *App) Startup(ctx context.Context) {
 	a.ctx = ctx
 
+	// The header refreshes when the profile changes on another device
+	a.Events.OnProfileUpdate = func(profile map[string]string) {
+		runtime.EventsEmit(a.ctx, "profile:updated", profile)
+	}
+
+	// Session secrets used to sit in plaintext files, move them to the secret store
+	if err := client.MigrateLegacySecrets(); err != nil {
+		log.Printf("[App] Failed to migrate session secrets: %v", err)
+	}
+	if err := storage.MigrateCredentials(); err != nil {
+		log.Printf("[App] Failed to migrate credentials: %v", err)
+	}
+
 	// Initialize database helper
 	dbHelper, err := app.NewDatabaseHelper()
 	if err != nil {
 		fmt.Printf("Warning: Could not initialize database helper: %v\n", err)
 	} else {
 		a.DB = dbHelper
+		a.startOutbox()
 	}
 
 	// Check if user is already authenticated and initialize HTTP client + SSE if needed
@@ -714,6 +700,7 @@ func (a *App) startSSEConnection() {
 
 	// Initialize new SSE connection
 	a.Auth.SSE = sse.NewSSE()
+	a.Auth.SSE.SetLastEventID(events.LastEventID())
 
 	// Start the SSE connection in a goroutine
 	go a.Auth.SSE.Connect(a.Auth.Client)
@@ -771,6 +758,99 @@ func (a *App) ensureSSEConnection() {
 	}
 }
 
+// startOutbox starts the worker replaying local changes to the server
+func (a *App) startOutbox() {
+	a.stopOutbox()
+
+	a.Outbox = sync.NewOutbox(a.DB.GetDB())
+	a.Outbox.Start()
+}
+
+// stopOutbox stops the outbox worker, pending entries stay queued on disk
+func (a *App) stopOutbox() {
+	if a.Outbox != nil {
+		a.Outbox.Stop()
+		a.Outbox = nil
+	}
+}
+
+// notifyOutbox asks the outbox worker to replay pending changes now
+func (a *App) notifyOutbox() {
+	if a.Outbox != nil {
+		a.Outbox.Notify()
+	}
+}
+
+// GetPendingChanges returns the local changes that have not reached the server yet
+func (a *App) GetPendingChanges() ([]models.LocalUpdate, error) {
+	if a.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	if a.Outbox == nil {
+		return nil, fmt.Errorf("sync worker not running")
+	}
+	return a.Outbox.Pending()
+}
+
+// GetConflicts returns the local edits the server rejected because the same field changed there
+func (a *App) GetConflicts() ([]models.LocalConflict, error) {
+	if a.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+	return sync.Conflicts(a.DB.GetDB())
+}
+
+// PreviewConflictMerge returns the merged text of a conflict and whether it merged cleanly
+func (a *App) PreviewConflictMerge(id uint) (map[string]interface{}, error) {
+	if a.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	merged, ok, err := sync.PreviewMerge(a.DB.GetDB(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"merged": merged,
+		"clean":  ok,
+	}, nil
+}
+
+// ResolveConflict resolves a conflict with keep-mine, keep-theirs or merge-text.
+// For merge-text, value is the merged text, or empty to merge automatically.
+func (a *App) ResolveConflict(id uint, resolution, value string) error {
+	if a.DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if err := sync.ResolveConflict(a.DB.GetDB(), id, resolution, value); err != nil {
+		return err
+	}
+
+	a.notifyOutbox()
+
+	return nil
+}
+
+// SyncNow pushes the pending local changes then pulls what changed on the server
+func (a *App) SyncNow() error {
+	if a.DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if a.Outbox == nil {
+		return fmt.Errorf("sync worker not running")
+	}
+	if !network.IsOnline() {
+		return fmt.Errorf("offline, changes will sync when the connection is back")
+	}
+
+	if err := a.Outbox.Flush(); err != nil {
+		return fmt.Errorf("failed to push local changes: %w", err)
+	}
+	return sync.PullChanges(a.DB.GetDB())
+}
+
 // Greet returns a greeting for the given name
 func (a *App) Greet(name string) string {
 	return fmt.Sprintf("Hello %s, It's show time!", name)
@@ -794,6 +874,7 @@ func (a *App) Register(username, email, password, university, language string) e
 		fmt.Printf("Warning: Could not initialize database helper after registration: %v\n", err)
 	} else {
 		a.DB = dbHelper
+		a.startOutbox()
 	}
 
 	return nil
@@ -816,15 +897,51 @@ func (a *App) Login(username, password string) error {
 		fmt.Printf("Warning: Could not initialize database helper after login: %v\n", err)
 	} else {
 		a.DB = dbHelper
+		a.startOutbox()
+	}
+
+	return nil
+}
+
+// GetServerProfiles returns the servers the app can connect to and the active one
+func (a *App) GetServerProfiles() map[string]interface{} {
+	return map[string]interface{}{
+		"active":   config.Active().Name,
+		"profiles": config.Profiles(),
 	}
+}
 
+// SetServerProfile selects the server to log in to. The profile can only be changed
+// while logged out since the session and local data belong to one server.
+func (a *App) SetServerProfile(name string) error {
+	if a.Auth.IsAuthenticated() {
+		return fmt.Errorf("log out before switching server")
+	}
+	if err := config.SetActive(name); err != nil {
+		return err
+	}
+	network.Reset()
 	return nil
 }
 
+// LoginWithProfile selects the server profile and logs in to it
+func (a *App) LoginWithProfile(profile, username, password string) error {
+	if err := a.SetServerProfile(profile); err != nil {
+		return err
+	}
+	return a.Login(username, password)
+}
+
+// SaveServerProfile sets the server URL of a profile, e.g. to configure staging
+func (a *App) SaveServerProfile(name, baseURL, pathPrefix string) error {
+	return config.SaveProfile(config.Profile{Name: name, BaseURL: baseURL, PathPrefix: pathPrefix})
+}
+
 // Logout handles user logout
 func (a *App) Logout() error {
 	// Stop SSE connection first
 	a.stopSSEConnection()
+	a.stopOutbox()
 
 	if err := a.Auth.Logout(); err != nil {
 		return err
@@ -835,6 +952,353 @@ func (a *App) Logout() error {
 	return nil
 }
 
+// RequestPasswordReset asks the server to email a reset code for a forgotten password.
+// It succeeds whether or not an account uses the address.
+func (a *App) RequestPasswordReset(email string) error {
+	if strings.TrimSpace(email) == "" {
+		return fmt.Errorf("email required")
+	}
+	return client.RequestPasswordReset(strings.TrimSpace(email))
+}
+
+// ConfirmPasswordReset sets a new password with the emailed reset code. Every device
+// of the account is logged out and logs in again with the new password.
+func (a *App) ConfirmPasswordReset(token, newPassword string) error {
+	return client.ConfirmPasswordReset(strings.TrimSpace(token), newPassword)
+}
+
+// ResendVerificationEmail asks the server for a new email address verification link
+func (a *App) ResendVerificationEmail() error {
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
+	return client.RequestVerification()
+}
+
+// GetDevices returns the devices logged in to the account with their last-seen time
+// and address, the row of this device has "current" set
+func (a *App) GetDevices() ([]map[string]string, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+	return client.GetSessions()
+}
+
+// RevokeDevice logs another device out of the account. This device logs out with Logout.
+func (a *App) RevokeDevice(sessionID uint) error {
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
+
+	sessions, err := client.GetSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		if session["id"] == strconv.Itoa(int(sessionID)) && session["current"] == "true" {
+			return fmt.Errorf("use logout to log this device out")
+		}
+	}
+
+	return client.RevokeSession(sessionID)
+}
+
+// profileFields are the fields of the profile shown in the header and settings
+var profileFields = []string{"id", "username", "email", "avatar", "university", "language", "is_verified"}
+
+// toProfile keeps the profile fields of a user returned by the server
+func toProfile(u map[string]interface{}) map[string]string {
+	profile := make(map[string]string, len(profileFields))
+	for _, field := range profileFields {
+		if value, ok := u[field]; ok && value != nil {
+			profile[field] = fmt.Sprint(value)
+		} else {
+			profile[field] = ""
+		}
+	}
+	return profile
+}
+
+// GetProfile returns the profile of the user, the cached one while offline
+func (a *App) GetProfile() (map[string]string, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+
+	if network.IsOnline() {
+		u, err := client.GetProfile()
+		if err == nil {
+			profile := toProfile(u)
+			if err := events.SaveProfile(profile); err != nil {
+				log.Printf("[App] Failed to cache profile: %v", err)
+			}
+			return profile, nil
+		}
+		log.Printf("[App] Failed to fetch profile, using the cached one: %v", err)
+	}
+
+	if profile := events.CachedProfile(); profile != nil {
+		return profile, nil
+	}
+	return nil, fmt.Errorf("profile not available offline")
+}
+
+// UpdateProfile changes the email, university and language of the user, empty values
+// are left unchanged. A new email needs the current password and has to be verified
+// again, the server sends the verification email.
+func (a *App) UpdateProfile(email, university, language, currentPassword string) (map[string]string, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+
+	fields := map[string]string{}
+	for key, value := range map[string]string{"email": email, "university": university, "language": language} {
+		if value = strings.TrimSpace(value); value != "" {
+			fields[key] = value
+		}
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("nothing to update")
+	}
+
+	u, err := client.UpdateProfile(fields, currentPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := toProfile(u)
+	if err := events.SaveProfile(profile); err != nil {
+		log.Printf("[App] Failed to cache profile: %v", err)
+	}
+	return profile, nil
+}
+
+// ChangePassword sets a new password after checking the current one. Every other
+// device is logged out, this one stays logged in with new tokens.
+func (a *App) ChangePassword(currentPassword, newPassword string) error {
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
+	if currentPassword == "" {
+		return fmt.Errorf("current password required")
+	}
+
+	if err := client.ChangePassword(currentPassword, newPassword); err != nil {
+		return err
+	}
+
+	// The event stream was opened with the revoked session
+	if network.IsOnline() {
+		a.startSSEConnection()
+	}
+	return nil
+}
+
+// UploadAvatar lets the user pick an image and makes it their avatar
+func (a *App) UploadAvatar() (map[string]string, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+
+	filePath, err := runtime.OpenFileDialog(a.ctx, runtime.OpenDialogOptions{
+		Title: "Select Avatar",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "Images",
+				Pattern:     "*.png;*.jpg;*.jpeg;*.gif;*.webp",
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file dialog: %w", err)
+	}
+	if filePath == "" {
+		return nil, fmt.Errorf("no file selected")
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := client.UploadAvatar(file); err != nil {
+		return nil, err
+	}
+	return a.GetProfile()
+}
+
+// GetAvatar returns the avatar of the user as a data URL, empty when they have none
+func (a *App) GetAvatar() (string, error) {
+	if !a.Auth.IsAuthenticated() {
+		return "", fmt.Errorf("user not authenticated")
+	}
+
+	data, contentType, err := client.DownloadAvatar()
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("data:%s;base64,%s", contentType, base64.StdEncoding.EncodeToString(data)), nil
+}
+
+// ConnectNotion links the user's own Notion workspace with an internal integration
+// token. The server keeps the token and syncs the assignments and courses databases
+// shared with the integration; the returned webhook_url is subscribed in Notion.
+func (a *App) ConnectNotion(token string) (map[string]interface{}, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return nil, fmt.Errorf("Notion token required")
+	}
+	return client.ConnectNotion(token)
+}
+
+// TestNotion checks that the server still reaches the user's Notion workspace and
+// returns the state of the sync, "connected" is false when Notion isn't linked
+func (a *App) TestNotion() (map[string]interface{}, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+	return client.NotionStatus()
+}
+
+// DisconnectNotion stops syncing with the user's Notion workspace, the server forgets
+// the token
+func (a *App) DisconnectNotion() error {
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
+	return client.DisconnectNotion()
+}
+
+// ExportICS writes the deadlines and class meetings of the courses with courseCodes,
+// all courses when empty, to an iCalendar file chosen by the user. It returns the
+// path of the file.
+func (a *App) ExportICS(courseCodes []string) (string, error) {
+	if a.DB == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+
+	db := a.DB.GetDB()
+	assignmentQuery := db.Order("deadline, id")
+	courseQuery := db.Order("code")
+	if len(courseCodes) > 0 {
+		assignmentQuery = assignmentQuery.Where("course_code IN ?", courseCodes)
+		courseQuery = courseQuery.Where("code IN ?", courseCodes)
+	}
+	var assignments []assignment.LocalAssignment
+	if err := assignmentQuery.Find(&assignments).Error; err != nil {
+		return "", fmt.Errorf("failed to load assignments: %w", err)
+	}
+	var courses []course.LocalCourse
+	if err := courseQuery.Find(&courses).Error; err != nil {
+		return "", fmt.Errorf("failed to load courses: %w", err)
+	}
+
+	// Rows synced already have the UID of the server feed, so a calendar holding both
+	// the file and the feed doesn't show them twice
+	uid := func(kind string, remoteID, localID uint) string {
+		if remoteID != 0 {
+			return calendar.UID(kind, remoteID)
+		}
+		return calendar.UID("local-"+kind, localID)
+	}
+
+	cal := &calendar.Calendar{Name: "UniPilot"}
+	if len(courseCodes) > 0 {
+		cal.Name += " - " + strings.Join(courseCodes, ", ")
+	}
+	for _, la := range assignments {
+		cal.Deadlines = append(cal.Deadlines, calendar.Deadline{
+			UID:         uid("assignment", la.RemoteID, la.ID),
+			Title:       la.Title,
+			Description: la.Todo,
+			CourseCode:  la.CourseCode,
+			Type:        la.TypeName,
+			Link:        la.Link,
+			At:          la.Deadline,
+			Completed:   la.Completed,
+			Modified:    la.UpdatedAt,
+		})
+	}
+	for _, lc := range courses {
+		cal.Courses = append(cal.Courses, calendar.Course{
+			UID:        uid("course", lc.RemoteID, lc.ID),
+			Code:       lc.Code,
+			Name:       lc.Name,
+			Room:       lc.RoomNumber,
+			Instructor: lc.Instructor,
+			Schedule:   lc.Schedule,
+			Start:      lc.StartDate,
+			End:        lc.EndDate,
+			Modified:   lc.UpdatedAt,
+		})
+	}
+
+	savePath, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+		Title:           "Export Calendar",
+		DefaultFilename: "unipilot.ics",
+		Filters: []runtime.FileFilter{
+			{
+				DisplayName: "iCalendar (*.ics)",
+				Pattern:     "*.ics",
+			},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to open save dialog: %w", err)
+	}
+	if savePath == "" {
+		return "", fmt.Errorf("no save location selected")
+	}
+
+	file, err := os.Create(savePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create calendar file: %w", err)
+	}
+	if err := calendar.Write(file, cal); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write calendar: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write calendar: %w", err)
+	}
+
+	log.Printf("[App] Exported %d deadlines and %d courses to %s", len(cal.Deadlines), len(cal.Courses), savePath)
+	return savePath, nil
+}
+
+// GetCalendarFeed tells whether the calendar feed of the user is enabled on the server
+func (a *App) GetCalendarFeed() (map[string]interface{}, error) {
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+	return client.CalendarFeed()
+}
+
+// CreateCalendarFeed returns a new URL of the calendar feed of the user, to subscribe
+// to from Google Calendar or Thunderbird. The previous URL stops working.
+func (a *App) CreateCalendarFeed() (string, error) {
+	if !a.Auth.IsAuthenticated() {
+		return "", fmt.Errorf("user not authenticated")
+	}
+	return client.CreateCalendarFeed()
+}
+
+// RevokeCalendarFeed disables the calendar feed of the user
+func (a *App) RevokeCalendarFeed() error {
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
+	return client.RevokeCalendarFeed()
+}
+
 // IsAuthenticated checks if the user is currently authenticated
 func (a *App) IsAuthenticated() (*storage.LocalCredentials, error) {
 	creds, err := storage.GetCurrentUser()
@@ -974,11 +1438,11 @@ func (a *App) GetAssignmentDocuments(assignmentID uint) ([]document.LocalDocumen
 	if !a.Auth.IsAuthenticated() {
 		return nil, fmt.Errorf("user not authenticated")
 	}
-	// Use LocalDocument and only return documents we have locally
+	// Documents uploaded from other devices are listed too, their file is downloaded when opened
 	var documents []document.LocalDocument
 	err := a.DB.GetDB().Where(
-		"assignment_id = ? AND has_local_file = ?",
-		assignmentID, true,
+		"assignment_id = ?",
+		assignmentID,
 	).Order("created_at DESC").Find(&documents).Error
 
 	return documents, err
@@ -996,8 +1460,8 @@ func (a *App) GetSupportDocuments(assignmentID uint) ([]document.LocalDocument,
 
 	var documents []document.LocalDocument
 	err := a.DB.GetDB().Where(
-		"assignment_id = ? AND type = ? AND has_local_file = ?",
-		assignmentID, document.DocumentTypeSupport, true,
+		"assignment_id = ? AND type = ?",
+		assignmentID, document.DocumentTypeSupport,
 	).Order("created_at DESC").Find(&documents).Error
 
 	return documents, err
@@ -1015,8 +1479,8 @@ func (a *App) GetSubmissionDocuments(assignmentID uint) ([]document.LocalDocumen
 
 	var documents []document.LocalDocument
 	err := a.DB.GetDB().Where(
-		"assignment_id = ? AND type = ? AND has_local_file = ?",
-		assignmentID, document.DocumentTypeSubmission, true,
+		"assignment_id = ? AND type = ?",
+		assignmentID, document.DocumentTypeSubmission,
 	).Order("created_at DESC").Find(&documents).Error
 
 	return documents, err
@@ -1038,16 +1502,9 @@ func (a *App) OpenDocument(documentID uint) error {
 		return fmt.Errorf("document not found or access denied")
 	}
 
-	// Check if we have the file locally
-	if !doc.HasLocalFile {
-		return fmt.Errorf("file not available offline - please sync to download")
-	}
-
-	// Check if file actually exists on disk
-	if _, err := os.Stat(doc.FilePath); os.IsNotExist(err) {
-		// Update database to reflect missing file
-		a.DB.GetDB().Model(&doc).Update("has_local_file", false)
-		return fmt.Errorf("file not found on disk")
+	// Download the file if this device doesn't have it yet
+	if err := a.ensureDocumentFile(&doc); err != nil {
+		return err
 	}
 
 	// Open with system default application
@@ -1055,6 +1512,72 @@ func (a *App) OpenDocument(documentID uint) error {
 	return nil
 }
 
+// ensureDocumentFile makes sure the file of a document is on disk and intact,
+// downloading it when missing or corrupted
+func (a *App) ensureDocumentFile(doc *document.LocalDocument) error {
+	if doc.HasLocalFile {
+		if _, err := os.Stat(doc.FilePath); err == nil {
+			err := fileops.VerifyDocument(doc)
+			if !errors.Is(err, fileops.ErrCorrupted) {
+				return err
+			}
+
+			log.Printf("[App] %s does not match its hash, downloading it again", doc.FileName)
+			if err := fileops.DiscardCorrupted(a.DB.GetDB(), doc.Hash); err != nil {
+				return err
+			}
+		} else {
+			// Update database to reflect missing file, its reference goes with it
+			if err := a.DB.GetDB().Transaction(func(tx *gorm.DB) error {
+				if err := fileops.ReleaseDocumentFile(tx, doc); err != nil {
+					return err
+				}
+				return tx.Model(doc).Update("has_local_file", false).Error
+			}); err != nil {
+				return err
+			}
+		}
+		doc.HasLocalFile = false
+	}
+
+	if !network.IsOnline() {
+		return fmt.Errorf("file not available offline - pin the document to keep it downloaded")
+	}
+
+	if err := sync.EnsureLocalFile(a.DB.GetDB(), doc); err != nil {
+		return err
+	}
+	return nil
+}
+
+// PinDocument keeps the file of a document downloaded for offline use. Pinning downloads
+// the file right away when online, unpinning keeps the file already downloaded.
+func (a *App) PinDocument(documentID uint, pinned bool) error {
+	if a.DB == nil {
+		return fmt.Errorf("database not initialized")
+	}
+
+	if !a.Auth.IsAuthenticated() {
+		return fmt.Errorf("user not authenticated")
+	}
+
+	var doc document.LocalDocument
+	if err := a.DB.GetDB().Where("id = ?", documentID).First(&doc).Error; err != nil {
+		return fmt.Errorf("document not found or access denied")
+	}
+
+	if err := a.DB.GetDB().Model(&doc).Update("pinned", pinned).Error; err != nil {
+		return fmt.Errorf("failed to pin document: %w", err)
+	}
+
+	if !pinned || doc.HasLocalFile || !network.IsOnline() {
+		// Pinned documents are downloaded by the sync worker once online
+		return nil
+	}
+
+	return sync.EnsureLocalFile(a.DB.GetDB(), &doc)
+}
+
 // SaveDocumentAs opens a save dialog and copies the document to chosen location
 func (a *App) SaveDocumentAs(documentID uint) error {
 	if a.DB == nil {
@@ -1071,16 +1594,9 @@ func (a *App) SaveDocumentAs(documentID uint) error {
 		return fmt.Errorf("document not found or access denied")
 	}
 
-	// Check if we have the file locally
-	if !doc.HasLocalFile {
-		return fmt.Errorf("file not available offline - please sync to download")
-	}
-
-	// Check if file actually exists on disk
-	if _, err := os.Stat(doc.FilePath); os.IsNotExist(err) {
-		// Update database to reflect missing file
-		a.DB.GetDB().Model(&doc).Update("has_local_file", false)
-		return fmt.Errorf("file not found on disk")
+	// Download the file if this device doesn't have it yet
+	if err := a.ensureDocumentFile(&doc); err != nil {
+		return err
 	}
 
 	// Open save dialog
@@ -1139,6 +1655,41 @@ func (a *App) GetUserStorageInfo() (*document.StorageInfo, error) {
 	return storageInfo, nil
 }
 
+// CheckDocumentStorage compares the documents with the files under ~/.unipilot. Without repair
+// it only reports what it found. With repair it re-indexes moved files and wrong sizes,
+// re-downloads missing or corrupted files when online and quarantines the rest.
+func (a *App) CheckDocumentStorage(repair bool) (*fileops.StorageReport, error) {
+	if a.DB == nil {
+		return nil, fmt.Errorf("database not initialized")
+	}
+
+	if !a.Auth.IsAuthenticated() {
+		return nil, fmt.Errorf("user not authenticated")
+	}
+
+	db := a.DB.GetDB()
+	userID := a.DB.GetCurrentUserID()
+
+	if !repair {
+		return fileops.ScanStorage(db, userID)
+	}
+
+	var opts fileops.RepairOptions
+	if network.IsOnline() {
+		opts.Redownload = func(doc *document.LocalDocument) error {
+			return sync.EnsureLocalFile(db, doc)
+		}
+	}
+
+	report, err := fileops.RepairStorage(db, userID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to repair document storage: %w", err)
+	}
+
+	log.Printf("[App] Document storage repaired, %d issues, quarantine in %s", len(report.Issues), report.QuarantineDir)
+	return report, nil
+}
+
 // GetRemoteDocumentMetadata retrieves document metadata from remote server (for shared assignments)
 func (a *App) GetRemoteDocumentMetadata(assignmentID uint) ([]map[string]interface{}, error) {
 	if a.DB == nil {
@@ -1154,7 +1705,7 @@ func (a *App) GetRemoteDocumentMetadata(assignmentID uint) ([]map[string]interfa
 	}
 
 	// Make API call to get remote metadata
-	url := fmt.Sprintf("https://newsroom.dedyn.io/acc-homework/documents?assignment_id=%d", assignmentID)
+	url := config.URL(fmt.Sprintf("/documents?assignment_id=%d", assignmentID))
 	resp, err := a.Auth.Client.Get(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get remote metadata: %w", err)
@@ -4,6 +4,7 @@ go 1.23
 
 require (
 	github.com/gen2brain/malgo v0.11.23
+	github.com/godbus/dbus/v5 v5.1.0
 	github.com/gomarkdown/markdown v0.0.0-20250731182530-5d03d1963446
 	github.com/gorilla/sessions v1.4.0
 	github.com/spf13/viper v1.20.1
@@ -27,7 +28,6 @@ require (
 	github.com/go-logr/stdr v1.2.2 // indirect
 	github.com/go-ole/go-ole v1.3.0 // indirect
 	github.com/go-viper/mapstructure/v2 v2.2.1 // indirect
-	github.com/godbus/dbus/v5 v5.1.0 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
 	github.com/google/s2a-go v0.1.8 // indirect
 	github.com/google/uuid v1.6.0 // indirect
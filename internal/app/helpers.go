@@ -2,11 +2,15 @@ package app
 
 import (
 	"fmt"
+	"log"
+	"unipilot/internal/models"
 	"unipilot/internal/models/assignment"
 	"unipilot/internal/models/course"
 	"unipilot/internal/models/note"
 	"unipilot/internal/models/user"
+	"unipilot/internal/services/fileops"
 	"unipilot/internal/storage"
+	"unipilot/internal/sync"
 
 	"gorm.io/gorm"
 )
@@ -24,6 +28,10 @@ func NewDatabaseHelper() (*DatabaseHelper, error) {
 		return nil, err
 	}
 
+	if err := fileops.MigrateUserStore(db); err != nil {
+		log.Printf("[App] Failed to move document files to the user store: %v", err)
+	}
+
 	db = db.Debug()
 
 	return &DatabaseHelper{db: db, userID: userID}, nil
@@ -75,15 +83,36 @@ func (h *DatabaseHelper) CreateAssignment(assignment *assignment.LocalAssignment
 	return h.db.Create(assignment).Error
 }
 
-// UpdateAssignment updates an existing assignment
+// UpdateAssignment updates an existing assignment and queues the change for the server
 func (h *DatabaseHelper) UpdateAssignment(LocalAssignment *assignment.LocalAssignment, column, value string) error {
-	// Only update the assignment fields, not the related course data
-	return h.db.Exec(fmt.Sprintf("UPDATE local_assignments SET %s = '%s' WHERE id = '%d'", column, value, LocalAssignment.ID)).Error
-}
-
-// DeleteAssignment deletes an assignment
-func (h *DatabaseHelper) DeleteAssignment(assignment *assignment.LocalAssignment) error {
-	return h.db.Delete(assignment).Error
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		// Remember the value being replaced so the server can detect concurrent edits
+		base, err := models.ColumnValue(tx, "local_assignments", LocalAssignment.ID, column)
+		if err != nil {
+			return err
+		}
+
+		// Only update the assignment fields, not the related course data
+		if err := tx.Exec(fmt.Sprintf("UPDATE local_assignments SET %s = ?, sync_status = ? WHERE id = ?", column),
+			value, assignment.SyncStatusPending, LocalAssignment.ID).Error; err != nil {
+			return err
+		}
+		return sync.EnqueueUpdate(tx, models.Assignment, LocalAssignment.ID, column, base, value)
+	})
+}
+
+// DeleteAssignment deletes an assignment and queues the deletion for the server
+func (h *DatabaseHelper) DeleteAssignment(LocalAssignment *assignment.LocalAssignment) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		// Pending until the server has the deletion, so a pull doesn't bring the row back
+		if err := tx.Model(&assignment.LocalAssignment{}).Where("id = ?", LocalAssignment.ID).Update("sync_status", assignment.SyncStatusPending).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(LocalAssignment).Error; err != nil {
+			return err
+		}
+		return sync.Enqueue(tx, models.Assignment, models.OperationDelete, LocalAssignment.ID, "", "")
+	})
 }
 
 // CreateCourse creates a new course
@@ -92,15 +121,34 @@ func (h *DatabaseHelper) CreateCourse(course *course.Course) error {
 	return h.db.Create(course).Error
 }
 
-// UpdateCourse updates an existing course
+// UpdateCourse updates an existing course and queues the change for the server
 func (h *DatabaseHelper) UpdateCourse(LocalCourse *course.LocalCourse, column, value string) error {
-	// Only update the assignment fields, not the related course data
-	return h.db.Exec(fmt.Sprintf("UPDATE local_courses SET %s = '%s' WHERE id = '%d'", column, value, LocalCourse.ID)).Error
-}
-
-// DeleteCourse deletes a course
-func (h *DatabaseHelper) DeleteCourse(course *course.LocalCourse) error {
-	return h.db.Delete(course).Error
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		// Remember the value being replaced so the server can detect concurrent edits
+		base, err := models.ColumnValue(tx, "local_courses", LocalCourse.ID, column)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Exec(fmt.Sprintf("UPDATE local_courses SET %s = ?, sync_status = ? WHERE id = ?", column),
+			value, course.SyncStatusPending, LocalCourse.ID).Error; err != nil {
+			return err
+		}
+		return sync.EnqueueUpdate(tx, models.EntityCourse, LocalCourse.ID, column, base, value)
+	})
+}
+
+// DeleteCourse deletes a course and queues the deletion for the server
+func (h *DatabaseHelper) DeleteCourse(LocalCourse *course.LocalCourse) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&course.LocalCourse{}).Where("id = ?", LocalCourse.ID).Update("sync_status", course.SyncStatusPending).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(LocalCourse).Error; err != nil {
+			return err
+		}
+		return sync.Enqueue(tx, models.EntityCourse, models.OperationDelete, LocalCourse.ID, "", "")
+	})
 }
 
 // GetNotes returns all notes for the current user
@@ -115,12 +163,32 @@ func (h *DatabaseHelper) CreateNote(note *note.LocalNote) error {
 	return h.db.Create(note).Error
 }
 
-// UpdateNote updates an existing note
+// UpdateNote updates an existing note and queues the change for the server
 func (h *DatabaseHelper) UpdateNote(LocalNote *note.LocalNote, column, value string) error {
-	return h.db.Exec(fmt.Sprintf("UPDATE local_notes SET %s = '%s' WHERE id = '%d'", column, value, LocalNote.ID)).Error
-}
-
-// DeleteNote deletes a note
-func (h *DatabaseHelper) DeleteNote(note *note.LocalNote) error {
-	return h.db.Delete(note).Error
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		// Remember the value being replaced so the server can detect concurrent edits
+		base, err := models.ColumnValue(tx, "local_notes", LocalNote.ID, column)
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Exec(fmt.Sprintf("UPDATE local_notes SET %s = ?, sync_status = ? WHERE id = ?", column),
+			value, note.SyncStatusPending, LocalNote.ID).Error; err != nil {
+			return err
+		}
+		return sync.EnqueueUpdate(tx, models.EntityNote, LocalNote.ID, column, base, value)
+	})
+}
+
+// DeleteNote deletes a note and queues the deletion for the server
+func (h *DatabaseHelper) DeleteNote(LocalNote *note.LocalNote) error {
+	return h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&note.LocalNote{}).Where("id = ?", LocalNote.ID).Update("sync_status", note.SyncStatusPending).Error; err != nil {
+			return err
+		}
+		if err := tx.Delete(LocalNote).Error; err != nil {
+			return err
+		}
+		return sync.Enqueue(tx, models.EntityNote, models.OperationDelete, LocalNote.ID, "", "")
+	})
 }
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"net/http"
+	"os"
 	"unipilot/internal/sse"
 	"unipilot/internal/storage"
 
@@ -19,6 +20,15 @@ func NewAuth() *Auth {
 	return &Auth{}
 }
 
+// deviceName names this device in the account's device list
+func deviceName() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
 func (a *Auth) IsAuthenticated() bool {
 	creds, err := storage.GetCurrentUser()
 	if err != nil {
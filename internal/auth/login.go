@@ -8,12 +8,13 @@ import (
 	"net/http"
 	"strconv"
 	"unipilot/internal/client"
+	"unipilot/internal/config"
 	"unipilot/internal/sse"
 	"unipilot/internal/storage"
 	"unipilot/internal/sync"
 )
 
-// Login handles only authentication and saving the session cookie to a file.
+// Login authenticates the user and stores the device tokens in the secret store.
 func (a *Auth) Login(username, password string) error {
 
 	httpClient, err := client.NewClientWithCookies() // Changed from NewClient()
@@ -24,10 +25,10 @@ func (a *Auth) Login(username, password string) error {
 	// Set the client to the auth struct
 	a.Client = httpClient
 
-	loginData := map[string]string{"username": username, "password": password}
+	loginData := map[string]string{"username": username, "password": password, "device_name": deviceName()}
 	jsonData, _ := json.Marshal(loginData)
 
-	resp, err := httpClient.Post("https://newsroom.dedyn.io/acc-homework/login", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := httpClient.Post(config.URL("/login"), "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("http post failed: %w", err)
 	}
@@ -38,22 +39,21 @@ func (a *Auth) Login(username, password string) error {
 		return fmt.Errorf("login failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	if err := client.SaveCookies(httpClient); err != nil {
-		return fmt.Errorf("failed to save cookies: %w", err)
+	// Parse the response to get user ID and the device tokens
+	var response struct {
+		UserID string `json:"user_id"`
+		client.Tokens
 	}
-
-	// Parse the response to get user ID
-	var response map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	// Extract user ID from response
-	userIDStr, ok := response["user_id"].(string)
-	if !ok {
-		return fmt.Errorf("invalid user_id in response")
+	if err := client.SaveTokens(response.Tokens); err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
 	}
-	userIDint, err := strconv.Atoi(userIDStr)
+
+	// Extract user ID from response
+	userIDint, err := strconv.Atoi(response.UserID)
 	if err != nil {
 		return fmt.Errorf("failed to parse user ID: %w", err)
 	}
@@ -85,19 +85,9 @@ func (a *Auth) Login(username, password string) error {
 		// Don't fail the login, just continue
 	}
 
-	// Try to migrate courses, but don't fail if it doesn't work
-	// Note: Sync functions are temporarily disabled
-	if err := sync.MigrateCourses(localDB); err != nil {
-		fmt.Printf("Warning: Failed to migrate courses: %v\n", err)
-		// Don't rollback, continue with the transaction
-	}
-
-	//Try to migrate assignments, but don't fail if it doesn't work
-	//Note: Sync functions are temporarily disabled
-	fmt.Printf("Attempting to migrate assignments...\n")
-	if err := sync.MigrateAssignments(localDB); err != nil {
-		fmt.Printf("Warning: Failed to migrate assignments: %v\n", err)
-		// Don't rollback, continue with the transaction
+	// Bring the local database up to date with the server, but don't fail the login if it doesn't work
+	if err := sync.PullChanges(localDB); err != nil {
+		fmt.Printf("Warning: Failed to pull changes: %v\n", err)
 	}
 
 	return nil
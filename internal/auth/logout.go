@@ -5,6 +5,7 @@ import (
 	"net/http"
 
 	"unipilot/internal/client"
+	"unipilot/internal/config"
 	"unipilot/internal/storage"
 )
 
@@ -12,7 +13,7 @@ func (a *Auth) Logout() error {
 
 	// Make POST request to logout endpoint (empty body)
 	resp, err := a.Client.Post(
-		"https://newsroom.dedyn.io/acc-homework/logout", // Note: changed from /login to /logout
+		config.URL("/logout"), // Note: changed from /login to /logout
 		"application/json",
 		nil, // No body needed for logout
 	)
@@ -31,6 +32,10 @@ func (a *Auth) Logout() error {
 		return fmt.Errorf("failed to clear local cookies: %w", err)
 	}
 
+	if err := client.ClearTokens(); err != nil {
+		return fmt.Errorf("failed to clear local tokens: %w", err)
+	}
+
 	if err := storage.ClearCredentials(); err != nil {
 		return fmt.Errorf("failed to clear local credentials: %w", err)
 	}
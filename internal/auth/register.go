@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"strconv"
 	"unipilot/internal/client"
+	"unipilot/internal/config"
 	"unipilot/internal/sse"
 	"unipilot/internal/storage"
 )
@@ -23,10 +24,10 @@ func (a *Auth) Register(username, email, password, university, language string)
 	// Set the client to the auth struct
 	a.Client = httpClient
 
-	loginData := map[string]string{"username": username, "password": password, "email": email, "university": university, "language": language}
+	loginData := map[string]string{"username": username, "password": password, "email": email, "university": university, "language": language, "device_name": deviceName()}
 	jsonData, _ := json.Marshal(loginData)
 
-	resp, err := httpClient.Post("https://newsroom.dedyn.io/acc-homework/register", "application/json", bytes.NewBuffer(jsonData))
+	resp, err := httpClient.Post(config.URL("/register"), "application/json", bytes.NewBuffer(jsonData))
 	if err != nil {
 		return fmt.Errorf("http post failed: %w", err)
 	}
@@ -39,24 +40,21 @@ func (a *Auth) Register(username, email, password, university, language string)
 		return fmt.Errorf("register failed with status %d: %s", resp.StatusCode, string(body))
 	}
 
-	if err := client.SaveCookies(httpClient); err != nil {
-		return fmt.Errorf("failed to save cookies: %w", err)
+	// Parse the response to get user ID and the device tokens
+	var response struct {
+		ID     string        `json:"id"`
+		Tokens client.Tokens `json:"tokens"`
 	}
-
-	// Parse the response to get user ID
-	var response map[string]interface{}
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
 		return fmt.Errorf("failed to parse response: %w", err)
 	}
 
-	fmt.Printf("Response: %v\n", response)
+	if err := client.SaveTokens(response.Tokens); err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
 
 	// Extract user ID from response
-	userIDStr, ok := response["id"].(string)
-	if !ok {
-		return fmt.Errorf("invalid id in response")
-	}
-	userIDint, err := strconv.Atoi(userIDStr)
+	userIDint, err := strconv.Atoi(response.ID)
 	if err != nil {
 		return fmt.Errorf("failed to parse user ID: %w", err)
 	}
@@ -6,11 +6,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"unipilot/internal/config"
 )
 
 func (a *Auth) GetUser() (map[string]interface{}, error) {
 
-	resp, err := a.Client.Get("https://newsroom.dedyn.io/acc-homework/user")
+	resp, err := a.Client.Get(config.URL("/user"))
 	if err != nil {
 		return nil, fmt.Errorf("request failed: %w", err)
 	}
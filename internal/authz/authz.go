@@ -0,0 +1,176 @@
+// Package authz decides which server records a user may read, change or delete.
+// A user reaches a record they own, or one shared with them by a ShareGrant. Records
+// a user can't reach are reported as not found, so callers can't probe for them.
+package authz
+
+import (
+	"errors"
+	"fmt"
+
+	"unipilot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Action is what a user wants to do with a record
+type Action string
+
+const (
+	Read   Action = "read"
+	Write  Action = "write"
+	Delete Action = "delete" // also sharing, only the owner can do it
+)
+
+// ErrNotFound is returned for records that don't exist and for records the user may
+// not act on alike
+var ErrNotFound = errors.New("not found")
+
+// tables of the entities access is checked on
+var tables = map[models.Entity]string{
+	models.Assignment:     "assignments",
+	models.EntityCourse:   "courses",
+	models.EntityNote:     "notes",
+	models.EntityDocument: "documents",
+}
+
+// permissions returns the grant permissions allowing an action, none means owner only
+func permissions(action Action) []models.Permission {
+	switch action {
+	case Read:
+		return []models.Permission{models.PermissionRead, models.PermissionWrite}
+	case Write:
+		return []models.Permission{models.PermissionWrite}
+	}
+	return nil
+}
+
+// Owned limits a query to the user's own rows. Routes addressing rows by the client's
+// local ID use it, local IDs only mean something to the owner's devices.
+func Owned(userID uint) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("user_id = ?", userID)
+	}
+}
+
+// Scope limits a query on the entity's table to the rows the user may act on: their
+// own rows and the rows shared with them with a permission allowing the action.
+// Documents are also reachable through a grant on their assignment.
+func Scope(userID uint, entity models.Entity, action Action) func(*gorm.DB) *gorm.DB {
+	table, ok := tables[entity]
+	perms := permissions(action)
+
+	return func(db *gorm.DB) *gorm.DB {
+		if !ok {
+			db.AddError(fmt.Errorf("authz: unknown entity %s", entity))
+			return db
+		}
+		if len(perms) == 0 {
+			return db.Where(table+".user_id = ?", userID)
+		}
+
+		cond := table + ".user_id = ? OR EXISTS (SELECT 1 FROM share_grants g WHERE g.entity = ? AND g.entity_id = " +
+			table + ".id AND g.grantee_id = ? AND g.permission IN ?)"
+		args := []interface{}{userID, entity, userID, perms}
+
+		// Documents keep the uploader's local assignment ID
+		if entity == models.EntityDocument {
+			cond += " OR EXISTS (SELECT 1 FROM assignments a JOIN share_grants g ON g.entity = ? AND g.entity_id = a.id" +
+				" WHERE a.user_id = documents.user_id AND a.local_id = documents.assignment_id AND a.deleted_at IS NULL" +
+				" AND g.grantee_id = ? AND g.permission IN ?)"
+			args = append(args, models.Assignment, userID, perms)
+		}
+
+		return db.Where("("+cond+")", args...)
+	}
+}
+
+// Load reads the record with the given server ID into dest if the user may act on it
+func Load(db *gorm.DB, userID uint, entity models.Entity, id uint, action Action, dest interface{}) error {
+	err := db.Scopes(Scope(userID, entity, action)).Where(tables[entity]+".id = ?", id).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// LoadByLocalID reads into dest the user's own record the device created with the given
+// local ID. Each device numbers its records, a local ID only identifies one along with
+// the device ID, empty for baseline clients.
+func LoadByLocalID(db *gorm.DB, userID uint, deviceID string, localID uint, dest interface{}) error {
+	err := db.Scopes(Owned(userID)).Where("device_id = ? AND local_id = ?", deviceID, localID).First(dest).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// CanShare checks that the owner may share the record with the permission, before
+// the grantee is known
+func CanShare(db *gorm.DB, ownerID uint, entity models.Entity, id uint, permission models.Permission) error {
+	if permission != models.PermissionRead && permission != models.PermissionWrite {
+		return fmt.Errorf("invalid permission %q", permission)
+	}
+
+	table, ok := tables[entity]
+	if !ok {
+		return fmt.Errorf("%s can't be shared", entity)
+	}
+
+	var owned int64
+	if err := db.Table(table).Scopes(Scope(ownerID, entity, Delete)).
+		Where(table+".id = ? AND "+table+".deleted_at IS NULL", id).Count(&owned).Error; err != nil {
+		return err
+	}
+	if owned == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// Share grants another user a permission on a record of the owner. Sharing again
+// changes the permission of the existing grant.
+func Share(db *gorm.DB, ownerID uint, entity models.Entity, id, granteeID uint, permission models.Permission) (*models.ShareGrant, error) {
+	if granteeID == ownerID {
+		return nil, errors.New("can't share a record with its owner")
+	}
+	if err := CanShare(db, ownerID, entity, id, permission); err != nil {
+		return nil, err
+	}
+
+	grant := models.ShareGrant{Entity: entity, EntityID: id, GranteeID: granteeID}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where(&grant).Attrs(models.ShareGrant{OwnerID: ownerID}).FirstOrInit(&grant).Error; err != nil {
+			return err
+		}
+		grant.Permission = permission
+		return tx.Save(&grant).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to share %s %d: %w", entity, id, err)
+	}
+	return &grant, nil
+}
+
+// Revoke removes a grant. The owner can revoke it and the grantee can give it up.
+func Revoke(db *gorm.DB, userID, grantID uint) (*models.ShareGrant, error) {
+	var grant models.ShareGrant
+	err := db.Where("id = ? AND (owner_id = ? OR grantee_id = ?)", grantID, userID, userID).First(&grant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Delete(&grant).Error; err != nil {
+		return nil, fmt.Errorf("failed to revoke grant %d: %w", grantID, err)
+	}
+	return &grant, nil
+}
+
+// Grants returns the grants the user gave and received
+func Grants(db *gorm.DB, userID uint) ([]models.ShareGrant, error) {
+	var grants []models.ShareGrant
+	err := db.Where("owner_id = ? OR grantee_id = ?", userID, userID).Order("id").Find(&grants).Error
+	return grants, err
+}
@@ -0,0 +1,220 @@
+package authz
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+	"unipilot/internal/models/user"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+// Users of the fixture
+const (
+	owner uint = iota + 1
+	reader
+	writer
+	stranger
+)
+
+type fixture struct {
+	db         *gorm.DB
+	assignment assignment.Assignment
+	course     course.Course
+	note       note.Note
+	document   document.Document
+	deleted    note.Note
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &course.Course{}, &models.AssignmentType{}, &models.AssignmentStatus{},
+		&assignment.Assignment{}, &note.Note{}, &document.Document{}, &models.ShareGrant{}); err != nil {
+		t.Fatal(err)
+	}
+
+	f := &fixture{db: db}
+	f.course = course.Course{UserID: owner, LocalID: 1, Code: "CS101", Name: "Intro"}
+	f.assignment = assignment.Assignment{UserID: owner, LocalID: 1, Title: "Lab", Deadline: time.Now(),
+		CourseCode: "CS101", TypeName: "Lab", StatusName: "Todo"}
+	f.note = note.Note{UserID: owner, LocalID: 1, Title: "Lecture 1"}
+	f.deleted = note.Note{UserID: owner, LocalID: 2, Title: "Lecture 2"}
+	f.document = document.Document{UserID: owner, LocalID: 1, AssignmentID: f.assignment.LocalID,
+		Type: document.DocumentType("attachment"), FileName: "lab.pdf", FileType: "pdf", FilePath: "x"}
+
+	for _, row := range []interface{}{&f.course, &f.assignment, &f.note, &f.deleted, &f.document} {
+		if err := db.Omit(clause.Associations).Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := db.Delete(&f.deleted).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	share := func(entity models.Entity, id, grantee uint, permission models.Permission) {
+		if _, err := Share(db, owner, entity, id, grantee, permission); err != nil {
+			t.Fatal(err)
+		}
+	}
+	share(models.Assignment, f.assignment.ID, reader, models.PermissionRead)
+	share(models.EntityNote, f.note.ID, reader, models.PermissionRead)
+	share(models.Assignment, f.assignment.ID, writer, models.PermissionWrite)
+	share(models.EntityCourse, f.course.ID, writer, models.PermissionWrite)
+	return f
+}
+
+func TestLoad(t *testing.T) {
+	f := newFixture(t)
+
+	type target struct {
+		entity models.Entity
+		id     uint
+		dest   func() interface{}
+	}
+	assignmentTarget := target{models.Assignment, f.assignment.ID, func() interface{} { return &assignment.Assignment{} }}
+	courseTarget := target{models.EntityCourse, f.course.ID, func() interface{} { return &course.Course{} }}
+	noteTarget := target{models.EntityNote, f.note.ID, func() interface{} { return &note.Note{} }}
+	documentTarget := target{models.EntityDocument, f.document.ID, func() interface{} { return &document.Document{} }}
+	deletedTarget := target{models.EntityNote, f.deleted.ID, func() interface{} { return &note.Note{} }}
+
+	tests := []struct {
+		name   string
+		user   uint
+		target target
+		action Action
+		want   bool
+	}{
+		{"owner reads assignment", owner, assignmentTarget, Read, true},
+		{"owner writes assignment", owner, assignmentTarget, Write, true},
+		{"owner deletes assignment", owner, assignmentTarget, Delete, true},
+		{"reader reads assignment", reader, assignmentTarget, Read, true},
+		{"reader can't write assignment", reader, assignmentTarget, Write, false},
+		{"reader can't delete assignment", reader, assignmentTarget, Delete, false},
+		{"writer reads assignment", writer, assignmentTarget, Read, true},
+		{"writer writes assignment", writer, assignmentTarget, Write, true},
+		{"writer can't delete assignment", writer, assignmentTarget, Delete, false},
+		{"stranger can't read assignment", stranger, assignmentTarget, Read, false},
+
+		{"reader can't read unshared course", reader, courseTarget, Read, false},
+		{"writer writes course", writer, courseTarget, Write, true},
+		{"stranger can't write course", stranger, courseTarget, Write, false},
+
+		{"reader reads note", reader, noteTarget, Read, true},
+		{"reader can't write note", reader, noteTarget, Write, false},
+		{"writer can't read unshared note", writer, noteTarget, Read, false},
+
+		{"owner reads document", owner, documentTarget, Read, true},
+		{"reader reads document through assignment", reader, documentTarget, Read, true},
+		{"reader can't write document", reader, documentTarget, Write, false},
+		{"writer writes document through assignment", writer, documentTarget, Write, true},
+		{"writer can't delete document", writer, documentTarget, Delete, false},
+		{"stranger can't read document", stranger, documentTarget, Read, false},
+
+		{"owner can't read deleted note", owner, deletedTarget, Read, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Load(f.db, tt.user, tt.target.entity, tt.target.id, tt.action, tt.target.dest())
+			if tt.want && err != nil {
+				t.Fatalf("Load() error = %v, want access", err)
+			}
+			if !tt.want && !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Load() error = %v, want ErrNotFound", err)
+			}
+		})
+	}
+}
+
+func TestScopeList(t *testing.T) {
+	f := newFixture(t)
+
+	count := func(user uint, entity models.Entity, action Action, model interface{}) int64 {
+		var n int64
+		if err := f.db.Model(model).Scopes(Scope(user, entity, action)).Count(&n).Error; err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	if n := count(owner, models.EntityNote, Read, &note.Note{}); n != 1 {
+		t.Errorf("owner lists %d notes, want 1", n)
+	}
+	if n := count(reader, models.EntityDocument, Read, &document.Document{}); n != 1 {
+		t.Errorf("reader lists %d documents, want 1", n)
+	}
+	if n := count(stranger, models.Assignment, Read, &assignment.Assignment{}); n != 0 {
+		t.Errorf("stranger lists %d assignments, want 0", n)
+	}
+}
+
+func TestLoadByLocalID(t *testing.T) {
+	f := newFixture(t)
+
+	// Another device numbered its assignment like the fixture's baseline one
+	phone := assignment.Assignment{UserID: owner, LocalID: f.assignment.LocalID, DeviceID: "phone", Title: "Essay",
+		Deadline: time.Now(), CourseCode: "CS101", TypeName: "Lab", StatusName: "Todo"}
+	if err := f.db.Omit(clause.Associations).Create(&phone).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	var a assignment.Assignment
+	if err := LoadByLocalID(f.db, owner, "", f.assignment.LocalID, &a); err != nil || a.ID != f.assignment.ID {
+		t.Fatalf("owner: loaded %d, %v, want %d", a.ID, err, f.assignment.ID)
+	}
+	a = assignment.Assignment{}
+	if err := LoadByLocalID(f.db, owner, "phone", f.assignment.LocalID, &a); err != nil || a.ID != phone.ID {
+		t.Fatalf("owner from phone: loaded %d, %v, want %d", a.ID, err, phone.ID)
+	}
+	// Local IDs only address the owner's rows, grants don't extend to them
+	if err := LoadByLocalID(f.db, writer, "", f.assignment.LocalID, &a); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("writer: error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestShareAndRevoke(t *testing.T) {
+	f := newFixture(t)
+
+	if _, err := Share(f.db, reader, models.EntityNote, f.note.ID, stranger, models.PermissionRead); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("grantee sharing further: error = %v, want ErrNotFound", err)
+	}
+	if _, err := Share(f.db, owner, models.EntityNote, f.note.ID, owner, models.PermissionRead); err == nil {
+		t.Fatal("sharing with the owner succeeded")
+	}
+	if _, err := Share(f.db, owner, models.EntityNote, f.deleted.ID, stranger, models.PermissionRead); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("sharing a deleted row: error = %v, want ErrNotFound", err)
+	}
+
+	// Sharing again upgrades the grant
+	grant, err := Share(f.db, owner, models.EntityNote, f.note.ID, reader, models.PermissionWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Load(f.db, reader, models.EntityNote, f.note.ID, Write, &note.Note{}); err != nil {
+		t.Fatalf("upgraded grant: %v", err)
+	}
+
+	if _, err := Revoke(f.db, stranger, grant.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("stranger revoking: error = %v, want ErrNotFound", err)
+	}
+	if _, err := Revoke(f.db, reader, grant.ID); err != nil {
+		t.Fatalf("grantee giving up: %v", err)
+	}
+	if err := Load(f.db, reader, models.EntityNote, f.note.ID, Read, &note.Note{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("revoked grant: error = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"unipilot/internal/config"
+)
+
+// postAccount sends an account request, the caller doesn't need to be logged in
+func postAccount(path string, body interface{}) error {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	resp, err := client.Post(config.URL(path), "application/json", reader)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp.StatusCode, data)
+	}
+
+	return nil
+}
+
+// RequestPasswordReset asks the server to email a reset code to the address
+func RequestPasswordReset(email string) error {
+	return postAccount("/password/reset/request", map[string]string{"email": email})
+}
+
+// ConfirmPasswordReset sets a new password with the emailed reset code
+func ConfirmPasswordReset(token, password string) error {
+	return postAccount("/password/reset/confirm", map[string]string{"token": token, "password": password})
+}
+
+// RequestVerification asks the server to email a new verification link to the user
+func RequestVerification() error {
+	return postAccount("/verify/request", nil)
+}
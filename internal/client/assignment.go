@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"unipilot/internal/config"
 	"unipilot/internal/models/assignment"
 	"unipilot/internal/network"
 )
@@ -29,7 +30,7 @@ func GetAssignments() ([]map[string]string, error) {
 			return nil, err
 		}
 
-		resp, err := client.Get("https://newsroom.dedyn.io/acc-homework/assignment/get")
+		resp, err := client.Get(config.URL("/assignment/get"))
 
 		if err != nil {
 			return nil, err
@@ -39,7 +40,7 @@ func GetAssignments() ([]map[string]string, error) {
 
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+			return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 		}
 
 		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
@@ -72,7 +73,7 @@ func CreateAssignment(a *assignment.Assignment) (map[string]interface{}, error)
 	jsonData, _ := json.Marshal(assignmentData)
 
 	resp, err := new_client.Post(
-		"https://newsroom.dedyn.io/acc-homework/assignment",
+		config.URL("/assignment"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -87,7 +88,7 @@ func CreateAssignment(a *assignment.Assignment) (map[string]interface{}, error)
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	var response struct {
@@ -111,7 +112,8 @@ func CreateAssignment(a *assignment.Assignment) (map[string]interface{}, error)
 	return response.Assignment, nil
 }
 
-func SendAssignmentUpdate(id, column, value string) error {
+// SendAssignmentUpdate changes a column of the assignment with the given server ID
+func SendAssignmentUpdate(remoteID, column, value string, base *string) error {
 
 	new_client, err := NewClientWithCookies()
 	if err != nil {
@@ -120,15 +122,19 @@ func SendAssignmentUpdate(id, column, value string) error {
 	}
 
 	updateData := map[string]interface{}{
-		"id":     id,
-		"value":  value,
-		"column": column,
+		"remote_id": remoteID,
+		"value":     value,
+		"column":    column,
+	}
+	// The server rejects the change if the column no longer holds the base value
+	if base != nil {
+		updateData["base"] = *base
 	}
 
 	jsonData, _ := json.Marshal(updateData)
 
 	resp, err := new_client.Post(
-		"https://newsroom.dedyn.io/acc-homework/assignment/update",
+		config.URL("/assignment/update"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -141,8 +147,13 @@ func SendAssignmentUpdate(id, column, value string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return newStatusError(resp.StatusCode, body)
 	}
 
 	return nil
 }
+
+// DeleteAssignment deletes a assignment and its documents on the server by its server ID
+func DeleteAssignment(remoteID uint) error {
+	return sendDelete("/assignment/delete", remoteID)
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"unipilot/internal/config"
+)
+
+// CalendarFeed tells whether the calendar feed of the user is enabled, and when it
+// was created and last fetched
+func CalendarFeed() (map[string]interface{}, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(config.URL("/calendar/feed"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp.StatusCode, body)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response, nil
+}
+
+// CreateCalendarFeed creates a new token for the calendar feed of the user, the URL
+// of the previous one stops working. It returns the URL calendar apps subscribe to.
+func CreateCalendarFeed() (string, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Post(config.URL("/calendar/feed"), "application/json", nil)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", newStatusError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		Path string `json:"path"`
+		URL  string `json:"url"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	// Servers without a public URL only know the path of the feed
+	if response.URL == "" {
+		return config.URL(response.Path), nil
+	}
+	return response.URL, nil
+}
+
+// RevokeCalendarFeed disables the calendar feed of the user
+func RevokeCalendarFeed() error {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(config.URL("/calendar/feed/revoke"), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp.StatusCode, body)
+	}
+
+	return nil
+}
@@ -2,15 +2,21 @@ package client
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
+	"unipilot/internal/config"
+	"unipilot/internal/secrets"
+
 	"golang.org/x/net/publicsuffix"
 )
 
@@ -18,49 +24,71 @@ const (
 	appName = "acc-homework" // Change this to your application name
 )
 
-type CookieJar struct {
-	cookies []*http.Cookie
+// NewClient creates a client sending the stored cookies and tokens to the server. The
+// cookies go in a standard jar, which only sends them to the host that set them.
+func NewClient() (*http.Client, error) {
+	return NewClientWithCookies()
 }
 
-func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
-	j.cookies = cookies
+// newTransport wraps base with the device header and the access token
+func newTransport(base http.RoundTripper) http.RoundTripper {
+	return &authTransport{base: &deviceTransport{base: base}}
 }
 
-func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
-	return j.cookies
-}
+// Keys of the session secrets in the secret store
+const (
+	cookiesKey = "cookies"
+	tokensKey  = "tokens"
+)
 
-func NewClient() (*http.Client, error) {
-	cookies, err := LoadCookies()
+// legacyCookieFilePath returns where cookies were kept in plaintext before they moved
+// to the secret store
+func legacyCookieFilePath() (string, error) {
+	configDir, err := os.UserConfigDir()
 	if err != nil {
-		return nil, err
+		return "", err
 	}
-
-	return &http.Client{
-		Jar: &CookieJar{cookies: cookies},
-	}, nil
+	return filepath.Join(configDir, appName, "cookies", "cookies.txt"), nil
 }
 
-// getCookieFilePath returns the canonical path for the cookie file.
-func getCookieFilePath() (string, error) {
-	configDir, err := os.UserConfigDir()
+// MigrateLegacySecrets moves the plaintext cookie and token files to the secret store
+// and deletes them. It runs on launch, a store already holding a secret wins.
+func MigrateLegacySecrets() error {
+	cookieFile, err := legacyCookieFilePath()
 	if err != nil {
-		return "", err
+		return err
 	}
 
-	// Create app directory and cookies subdirectory
-	appDir := filepath.Join(configDir, appName)
-	cookiesDir := filepath.Join(appDir, "cookies")
+	legacy := map[string]string{
+		cookiesKey: cookieFile,
+		tokensKey:  filepath.Join(filepath.Dir(cookieFile), "tokens.json"),
+	}
+	for key, path := range legacy {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("could not read %s: %w", path, err)
+		}
 
-	// Create directories with 0755 permissions (rwx for owner, rx for others)
-	if err := os.MkdirAll(cookiesDir, 0755); err != nil {
-		return "", err
+		if _, err := secrets.Default().Get(key); errors.Is(err, secrets.ErrNotFound) {
+			if err := secrets.Default().Set(key, data); err != nil {
+				return fmt.Errorf("could not migrate %s: %w", key, err)
+			}
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Printf("[Client] Moved %s to the secret store", filepath.Base(path))
 	}
 
-	return filepath.Join(cookiesDir, "cookies.txt"), nil
+	// Only removed once empty
+	os.Remove(filepath.Dir(cookieFile))
+	return nil
 }
 
-// SaveCookies serializes the cookies from the client's jar and saves them to a file.
+// SaveCookies serializes the cookies from the client's jar to the secret store
 func SaveCookies(client *http.Client) error {
 
 	// Check if client or client.Jar is nil
@@ -72,105 +100,78 @@ func SaveCookies(client *http.Client) error {
 		return fmt.Errorf("client jar is nil")
 	}
 
-	cookieFile, err := getCookieFilePath()
-	if err != nil {
-		return err
-	}
-
 	// Use proper URL instead of nil to avoid nil pointer dereference
-	targetURL, _ := url.Parse("https://newsroom.dedyn.io")
+	targetURL, _ := url.Parse(config.Origin())
 	cookies := client.Jar.Cookies(targetURL)
 
-	data, err := json.MarshalIndent(cookies, "", "  ")
+	data, err := json.Marshal(cookies)
 	if err != nil {
 		return fmt.Errorf("could not marshal cookies: %w", err)
 	}
 
-	return os.WriteFile(cookieFile, data, 0600)
+	return secrets.Default().Set(cookiesKey, data)
 }
 
-// NewClientWithCookies creates a new http.Client and loads cookies from the file.
-func NewClientWithCookies() (*http.Client, error) {
+// newCookieJar returns a jar holding the stored cookies of the server
+func newCookieJar() (*cookiejar.Jar, error) {
 	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
 	if err != nil {
 		return nil, err
 	}
 
-	// Configure transport to handle connection pooling properly
-	transport := &http.Transport{
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
-		DisableKeepAlives:   false,
-	}
-
-	cookieFile, err := getCookieFilePath()
+	cookies, err := LoadCookies()
 	if err != nil {
 		return nil, err
 	}
-	// It's okay if the cookie file doesn't exist yet.
-	if _, err := os.Stat(cookieFile); os.IsNotExist(err) {
-		log.Println("No cookie file found, creating new client.")
-		return &http.Client{
-			Jar:       jar,
-			Transport: transport,
-		}, nil
+	// The stored cookies were set by the server of the active profile
+	if len(cookies) > 0 {
+		url, _ := url.Parse(config.Origin())
+		jar.SetCookies(url, cookies)
 	}
+	return jar, nil
+}
 
-	data, err := os.ReadFile(cookieFile)
+// NewClientWithCookies creates a new http.Client with the stored cookies
+func NewClientWithCookies() (*http.Client, error) {
+	jar, err := newCookieJar()
 	if err != nil {
-		return nil, fmt.Errorf("could not read cookie file: %w", err)
+		return nil, err
 	}
 
-	var cookies []*http.Cookie
-	if err := json.Unmarshal(data, &cookies); err != nil {
-		return nil, fmt.Errorf("could not unmarshal cookies: %w", err)
-	}
-	// Assuming the cookies are for the correct domain.
-	if len(cookies) > 0 {
-		// We need a URL to set cookies in the jar.
-		// This should be the base URL of your service.
-		url, _ := url.Parse("https://newsroom.dedyn.io")
-		jar.SetCookies(url, cookies)
+	// Configure transport to handle connection pooling properly
+	transport := &http.Transport{
+		MaxIdleConns:        100,
+		MaxIdleConnsPerHost: 10,
+		IdleConnTimeout:     90 * time.Second,
+		DisableKeepAlives:   false,
 	}
 
 	return &http.Client{
 		Jar:       jar,
-		Transport: transport,
+		Transport: newTransport(transport),
 	}, nil
 }
 
+// LoadCookies returns the stored cookies, none when logged out
 func LoadCookies() ([]*http.Cookie, error) {
-	path, err := getCookieFilePath()
-	if err != nil {
-		return nil, err
+	data, err := secrets.Default().Get(cookiesKey)
+	if errors.Is(err, secrets.ErrNotFound) {
+		return nil, nil // No cookies yet
 	}
-
-	file, err := os.Open(path)
 	if err != nil {
-		if os.IsNotExist(err) {
-			return nil, nil // No cookies yet
-		}
 		return nil, err
 	}
-	defer file.Close()
 
 	var cookies []*http.Cookie
-	err = json.NewDecoder(file).Decode(&cookies)
-	return cookies, err
+	if err := json.Unmarshal(data, &cookies); err != nil {
+		return nil, fmt.Errorf("could not unmarshal cookies: %w", err)
+	}
+	return cookies, nil
 }
 
-// ClearCookies removes the cookie file from disk.
+// ClearCookies removes the stored cookies
 func ClearCookies() error {
-	cookieFile, err := getCookieFilePath()
-	if err != nil {
-		return err
-	}
-	// It's not an error if the file doesn't exist.
-	if err := os.Remove(cookieFile); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	return nil
+	return secrets.Default().Delete(cookiesKey)
 }
 
 // NewSSEClient creates an HTTP client specifically configured for SSE connections
@@ -187,31 +188,42 @@ func NewSSEClient() (*http.Client, error) {
 	}
 
 	// Include cookie jar for authentication
-	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	jar, err := newCookieJar()
 	if err != nil {
 		return nil, err
 	}
 
-	// Load cookies if they exist
-	cookieFile, err := getCookieFilePath()
+	return &http.Client{
+		Jar:       jar,
+		Transport: newTransport(transport),
+		Timeout:   0, // No timeout for SSE connections
+	}, nil
+}
+
+// sendDelete asks the server to delete a record by its server ID
+func sendDelete(path string, remoteID uint) error {
+
+	new_client, err := NewClientWithCookies()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	if _, err := os.Stat(cookieFile); !os.IsNotExist(err) {
-		data, err := os.ReadFile(cookieFile)
-		if err == nil {
-			var cookies []*http.Cookie
-			if err := json.Unmarshal(data, &cookies); err == nil && len(cookies) > 0 {
-				url, _ := url.Parse("https://newsroom.dedyn.io")
-				jar.SetCookies(url, cookies)
-			}
-		}
+	resp, err := new_client.Post(
+		config.URL(path)+"?remote_id="+strconv.Itoa(int(remoteID)),
+		"application/json",
+		nil,
+	)
+
+	if err != nil {
+		return err
 	}
 
-	return &http.Client{
-		Jar:       jar,
-		Transport: transport,
-		Timeout:   0, // No timeout for SSE connections
-	}, nil
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp.StatusCode, body)
+	}
+
+	return nil
 }
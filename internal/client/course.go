@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"unipilot/internal/config"
 	"unipilot/internal/models/course"
 	"unipilot/internal/network"
 )
@@ -29,7 +30,7 @@ func GetCourses() ([]map[string]string, error) {
 			return nil, err
 		}
 
-		resp, err := client.Get("https://newsroom.dedyn.io/acc-homework/course/get")
+		resp, err := client.Get(config.URL("/course/get"))
 
 		if err != nil {
 			return nil, err
@@ -39,7 +40,7 @@ func GetCourses() ([]map[string]string, error) {
 
 		if resp.StatusCode != http.StatusOK {
 			body, _ := io.ReadAll(resp.Body)
-			return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+			return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 		}
 
 		if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
@@ -75,7 +76,7 @@ func CreateCourse(c *course.Course) (map[string]interface{}, error) {
 	fmt.Println("Creating course:", courseData["code"])
 
 	resp, err := new_client.Post(
-		"https://newsroom.dedyn.io/acc-homework/course",
+		config.URL("/course"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -90,7 +91,7 @@ func CreateCourse(c *course.Course) (map[string]interface{}, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	var response struct {
@@ -114,7 +115,8 @@ func CreateCourse(c *course.Course) (map[string]interface{}, error) {
 	return response.Course, nil
 }
 
-func SendCourseUpdate(id, column, value string) error {
+// SendCourseUpdate changes a column of the course with the given server ID
+func SendCourseUpdate(remoteID, column, value string, base *string) error {
 
 	new_client, err := NewClientWithCookies()
 	if err != nil {
@@ -123,15 +125,19 @@ func SendCourseUpdate(id, column, value string) error {
 	}
 
 	updateData := map[string]interface{}{
-		"id":     id,
-		"value":  value,
-		"column": column,
+		"remote_id": remoteID,
+		"value":     value,
+		"column":    column,
+	}
+	// The server rejects the change if the column no longer holds the base value
+	if base != nil {
+		updateData["base"] = *base
 	}
 
 	jsonData, _ := json.Marshal(updateData)
 
 	resp, err := new_client.Post(
-		"https://newsroom.dedyn.io/acc-homework/course/update",
+		config.URL("/course/update"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -144,8 +150,13 @@ func SendCourseUpdate(id, column, value string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return newStatusError(resp.StatusCode, body)
 	}
 
 	return nil
 }
+
+// DeleteCourse deletes a course with its assignments, documents and notes on the server by its server ID
+func DeleteCourse(remoteID uint) error {
+	return sendDelete("/course/delete", remoteID)
+}
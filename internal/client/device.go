@@ -0,0 +1,61 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// DeviceHeader carries the device ID on every request so the server can tell
+// this device's own changes apart when it fans events out
+const DeviceHeader = "X-Device-ID"
+
+var (
+	deviceOnce sync.Once
+	deviceID   string
+)
+
+// DeviceID returns the ID of this installation, generated on first use and kept
+// in the app config directory. It stays the same across logins.
+func DeviceID() string {
+	deviceOnce.Do(func() {
+		configDir, err := os.UserConfigDir()
+		if err != nil {
+			deviceID = newDeviceID()
+			return
+		}
+
+		path := filepath.Join(configDir, appName, "device_id")
+		if data, err := os.ReadFile(path); err == nil && len(strings.TrimSpace(string(data))) > 0 {
+			deviceID = strings.TrimSpace(string(data))
+			return
+		}
+
+		deviceID = newDeviceID()
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			os.WriteFile(path, []byte(deviceID), 0600)
+		}
+	})
+	return deviceID
+}
+
+func newDeviceID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// deviceTransport adds the device ID header to outgoing requests
+type deviceTransport struct {
+	base http.RoundTripper
+}
+
+func (t *deviceTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set(DeviceHeader, DeviceID())
+	return t.base.RoundTrip(req)
+}
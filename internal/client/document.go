@@ -0,0 +1,170 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"unipilot/internal/config"
+	"unipilot/internal/services/blobstore"
+)
+
+// ContentHashHeader carries the SHA-256 of a document file in both directions
+const ContentHashHeader = "X-Content-SHA256"
+
+// DocumentMetadata is the metadata the server keeps for a document
+type DocumentMetadata struct {
+	ID           uint   `json:"id"`
+	LocalID      uint   `json:"local_id"`
+	AssignmentID uint   `json:"assignment_id"`
+	Type         string `json:"type"`
+	FileName     string `json:"file_name"`
+	FileType     string `json:"file_type"`
+	FileSize     int64  `json:"file_size"`
+	Version      int    `json:"version"`
+	Hash         string `json:"hash"`
+}
+
+// CreateDocumentMetadata stores the metadata of a local document on the server. Sending
+// the same local document twice returns the metadata stored the first time.
+func CreateDocumentMetadata(metadata map[string]interface{}) (*DocumentMetadata, error) {
+
+	new_client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	jsonData, _ := json.Marshal(metadata)
+
+	resp, err := new_client.Post(
+		config.URL("/document/metadata"),
+		"application/json",
+		bytes.NewBuffer(jsonData),
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	var response struct {
+		Success  bool              `json:"success"`
+		Document *DocumentMetadata `json:"document"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if response.Document == nil || response.Document.ID == 0 {
+		return nil, fmt.Errorf("no document data in response")
+	}
+
+	return response.Document, nil
+}
+
+// DeleteDocumentMetadata deletes the metadata of a document by its server ID
+func DeleteDocumentMetadata(remoteID uint) error {
+
+	new_client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	resp, err := new_client.Post(
+		config.URL("/document/metadata/delete?remote_id=")+strconv.Itoa(int(remoteID)),
+		"application/json",
+		nil,
+	)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	return nil
+}
+
+// UploadDocumentBlob uploads the file of a document, the server checks it received
+// content matching hash
+func UploadDocumentBlob(remoteID uint, hash string, content io.Reader) error {
+
+	new_client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(
+		http.MethodPost,
+		config.URL("/document/blob?document_id=")+strconv.Itoa(int(remoteID)),
+		content,
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(ContentHashHeader, hash)
+
+	resp, err := new_client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	return nil
+}
+
+// DownloadDocumentBlob writes the file of a document to w and returns its hash.
+// The content is checked against the hash announced by the server.
+func DownloadDocumentBlob(remoteID uint, w io.Writer) (string, error) {
+
+	new_client, err := NewClientWithCookies()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := new_client.Get(config.URL("/document/blob/get?document_id=") + strconv.Itoa(int(remoteID)))
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	hash, _, err := blobstore.Hash(io.TeeReader(resp.Body, w))
+	if err != nil {
+		return "", fmt.Errorf("failed to download file: %w", err)
+	}
+
+	if expected := resp.Header.Get(ContentHashHeader); expected != "" && expected != hash {
+		return "", errors.New("downloaded file does not match its hash")
+	}
+
+	return hash, nil
+}
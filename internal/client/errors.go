@@ -0,0 +1,62 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"unipilot/internal/models"
+)
+
+// StatusError is returned when the server answers with a non-success status code
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("server returned %d: %s", e.Code, e.Body)
+}
+
+// IsPermanent reports whether the request was rejected in a way that retrying
+// the exact same request cannot fix (validation errors, missing records, ...).
+func (e *StatusError) IsPermanent() bool {
+	switch e.Code {
+	case http.StatusUnauthorized, http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return false
+	}
+	return e.Code >= 400 && e.Code < 500
+}
+
+// IsPermanentError reports whether err is a StatusError that should not be retried
+func IsPermanentError(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.IsPermanent()
+	}
+	return false
+}
+
+// ConflictError is returned when the server rejected a field update because the
+// column changed there since the client read it
+type ConflictError struct {
+	models.FieldConflict
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict on %s %s column %s", e.Entity, e.EntityID, e.Column)
+}
+
+// newStatusError builds the error for a non-success response, decoding conflicts
+func newStatusError(code int, body []byte) error {
+	if code == http.StatusConflict {
+		var response struct {
+			Conflict *models.FieldConflict `json:"conflict"`
+		}
+		if err := json.Unmarshal(body, &response); err == nil && response.Conflict != nil {
+			return &ConflictError{FieldConflict: *response.Conflict}
+		}
+	}
+	return &StatusError{Code: code, Body: string(body)}
+}
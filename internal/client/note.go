@@ -8,6 +8,7 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"unipilot/internal/config"
 	"unipilot/internal/models/note"
 )
 
@@ -23,7 +24,7 @@ func CreateNote(n *note.Note) (map[string]string, error) {
 	jsonData, _ := json.Marshal(noteData)
 
 	resp, err := new_client.Post(
-		"https://newsroom.dedyn.io/acc-homework/note",
+		config.URL("/note"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -38,7 +39,7 @@ func CreateNote(n *note.Note) (map[string]string, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
 	}
 
 	var response struct {
@@ -62,7 +63,8 @@ func CreateNote(n *note.Note) (map[string]string, error) {
 	return response.Note, nil
 }
 
-func SendNoteUpdate(id, column, value string) error {
+// SendNoteUpdate changes a column of the note with the given server ID
+func SendNoteUpdate(remoteID, column, value string, base *string) error {
 
 	new_client, err := NewClientWithCookies()
 	if err != nil {
@@ -71,15 +73,19 @@ func SendNoteUpdate(id, column, value string) error {
 	}
 
 	updateData := map[string]interface{}{
-		"id":     id,
-		"value":  value,
-		"column": column,
+		"remote_id": remoteID,
+		"value":     value,
+		"column":    column,
+	}
+	// The server rejects the change if the column no longer holds the base value
+	if base != nil {
+		updateData["base"] = *base
 	}
 
 	jsonData, _ := json.Marshal(updateData)
 
 	resp, err := new_client.Post(
-		"https://newsroom.dedyn.io/acc-homework/note/update",
+		config.URL("/note/update"),
 		"application/json",
 		bytes.NewBuffer(jsonData),
 	)
@@ -92,8 +98,13 @@ func SendNoteUpdate(id, column, value string) error {
 
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return newStatusError(resp.StatusCode, body)
 	}
 
 	return nil
 }
+
+// DeleteNote deletes a note on the server by its server ID
+func DeleteNote(remoteID uint) error {
+	return sendDelete("/note/delete", remoteID)
+}
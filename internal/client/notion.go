@@ -0,0 +1,98 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"unipilot/internal/config"
+)
+
+// ConnectNotion stores the Notion token of the user on the server, which finds the
+// databases of their workspace. It returns the webhook URL to subscribe in Notion
+// and the columns the fields were mapped to.
+func ConnectNotion(token string) (map[string]interface{}, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(config.URL("/notion/connect"), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp.StatusCode, body)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response, nil
+}
+
+// NotionStatus checks the Notion integration of the user and returns the state of
+// its sync, "connected" is false when there is none
+func NotionStatus() (map[string]interface{}, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(config.URL("/notion/status"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp.StatusCode, body)
+	}
+
+	var response map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response, nil
+}
+
+// DisconnectNotion removes the Notion integration of the user
+func DisconnectNotion() error {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(config.URL("/notion/disconnect"), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp.StatusCode, body)
+	}
+
+	return nil
+}
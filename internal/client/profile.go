@@ -0,0 +1,175 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"unipilot/internal/config"
+)
+
+// GetProfile returns the account of the logged in user
+func GetProfile() (map[string]interface{}, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(config.URL("/user"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		User map[string]interface{} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response.User, nil
+}
+
+// UpdateProfile changes the given profile fields, a new email needs the current password
+func UpdateProfile(fields map[string]string, currentPassword string) (map[string]interface{}, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	body := map[string]string{"current_password": currentPassword}
+	for key, value := range fields {
+		body[key] = value
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Post(config.URL("/user/profile"), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		User map[string]interface{} `json:"user"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response.User, nil
+}
+
+// ChangePassword sets a new password. The server logs every device out, the new
+// tokens it returns for this one are stored.
+func ChangePassword(currentPassword, newPassword string) error {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(map[string]string{"current_password": currentPassword, "new_password": newPassword})
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(config.URL("/user/password"), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp.StatusCode, body)
+	}
+
+	var tokens Tokens
+	if err := json.NewDecoder(resp.Body).Decode(&tokens); err != nil {
+		return fmt.Errorf("failed to decode tokens: %w", err)
+	}
+	if err := SaveTokens(tokens); err != nil {
+		return fmt.Errorf("failed to save tokens: %w", err)
+	}
+
+	return nil
+}
+
+// UploadAvatar replaces the avatar of the user with an image and returns its hash
+func UploadAvatar(content io.Reader) (string, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := client.Post(config.URL("/user/avatar"), "application/octet-stream", content)
+	if err != nil {
+		return "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return "", newStatusError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		Avatar string `json:"avatar"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return "", fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response.Avatar, nil
+}
+
+// DownloadAvatar returns the avatar image of the user and its content type
+func DownloadAvatar() ([]byte, string, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, "", err
+	}
+
+	resp, err := client.Get(config.URL("/user/avatar/get"))
+	if err != nil {
+		return nil, "", err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, "", newStatusError(resp.StatusCode, body)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to download avatar: %w", err)
+	}
+
+	return data, resp.Header.Get("Content-Type"), nil
+}
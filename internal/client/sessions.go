@@ -0,0 +1,64 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"unipilot/internal/config"
+)
+
+// GetSessions returns the devices logged in to the account. The row of this device
+// has "current" set to "true".
+func GetSessions() ([]map[string]string, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(config.URL("/sessions"))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, newStatusError(resp.StatusCode, body)
+	}
+
+	var response struct {
+		Sessions []map[string]string `json:"sessions"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return response.Sessions, nil
+}
+
+// RevokeSession logs a device out by its session ID
+func RevokeSession(sessionID uint) error {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(fmt.Sprintf("%s?id=%d", config.URL("/sessions/revoke"), sessionID), "application/json", nil)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return newStatusError(resp.StatusCode, body)
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"unipilot/internal/config"
+)
+
+// Changes is the delta returned by the server since a cursor.
+// Rows with a non-empty "deleted_at" are tombstones.
+type Changes struct {
+	Message     string              `json:"message"`
+	Cursor      string              `json:"cursor"`
+	Assignments []map[string]string `json:"assignments"`
+	Courses     []map[string]string `json:"courses"`
+	Notes       []map[string]string `json:"notes"`
+	Documents   []map[string]string `json:"documents"`
+	Error       string              `json:"error,omitempty"`
+}
+
+// GetChanges fetches every change made on the server since cursor, an empty cursor returns everything
+func GetChanges(cursor string) (*Changes, error) {
+
+	client, err := NewClientWithCookies()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Get(config.URL("/sync/changes?cursor=") + url.QueryEscape(cursor))
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, &StatusError{Code: resp.StatusCode, Body: string(body)}
+	}
+
+	var changes Changes
+	if err := json.NewDecoder(resp.Body).Decode(&changes); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	if changes.Error != "" {
+		return nil, errors.New(changes.Error)
+	}
+
+	return &changes, nil
+}
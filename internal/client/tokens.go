@@ -0,0 +1,201 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"unipilot/internal/config"
+	"unipilot/internal/secrets"
+)
+
+// refreshMargin renews an access token this long before it expires
+const refreshMargin = 30 * time.Second
+
+// ErrSessionExpired is returned when the refresh token was rejected, e.g. because the
+// device was revoked, and the user has to log in again
+var ErrSessionExpired = errors.New("session expired, please log in again")
+
+// Tokens are the credentials of this device's session, as returned by login and refresh
+type Tokens struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+var (
+	tokenMu sync.Mutex
+	// tokens caches the stored tokens, nil until loaded
+	tokens *Tokens
+)
+
+// loadTokens returns the stored tokens, zero Tokens when logged out. The caller holds tokenMu.
+func loadTokens() (Tokens, error) {
+	if tokens != nil {
+		return *tokens, nil
+	}
+
+	data, err := secrets.Default().Get(tokensKey)
+	if errors.Is(err, secrets.ErrNotFound) {
+		tokens = &Tokens{}
+		return *tokens, nil
+	}
+	if err != nil {
+		return Tokens{}, fmt.Errorf("could not read tokens: %w", err)
+	}
+
+	var t Tokens
+	if err := json.Unmarshal(data, &t); err != nil {
+		return Tokens{}, fmt.Errorf("could not unmarshal tokens: %w", err)
+	}
+	tokens = &t
+	return t, nil
+}
+
+// storeTokens writes the tokens to the secret store. The caller holds tokenMu.
+func storeTokens(t Tokens) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return fmt.Errorf("could not marshal tokens: %w", err)
+	}
+	if err := secrets.Default().Set(tokensKey, data); err != nil {
+		return err
+	}
+	tokens = &t
+	return nil
+}
+
+// SaveTokens stores the tokens of a login
+func SaveTokens(t Tokens) error {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+	return storeTokens(t)
+}
+
+// ClearTokens removes the stored tokens on logout, with any session cookie stored by
+// older versions so requests don't fall back to it
+func ClearTokens() error {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	tokens = &Tokens{}
+	if err := secrets.Default().Delete(cookiesKey); err != nil {
+		return err
+	}
+	return secrets.Default().Delete(tokensKey)
+}
+
+// refreshTokens exchanges the refresh token for new tokens. A request that failed with
+// an access token another request has since replaced just uses the new one.
+func refreshTokens(base http.RoundTripper, failed string) (Tokens, error) {
+	tokenMu.Lock()
+	defer tokenMu.Unlock()
+
+	current, err := loadTokens()
+	if err != nil {
+		return Tokens{}, err
+	}
+	if current.AccessToken != failed && current.AccessToken != "" {
+		return current, nil
+	}
+	if current.RefreshToken == "" {
+		return Tokens{}, ErrSessionExpired
+	}
+
+	body, _ := json.Marshal(map[string]string{"refresh_token": current.RefreshToken})
+	req, err := http.NewRequest(http.MethodPost, config.URL("/token/refresh"), bytes.NewReader(body))
+	if err != nil {
+		return Tokens{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := base.RoundTrip(req)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("token refresh failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		// The session was revoked or expired, keep nothing that can't work anymore
+		tokens = &Tokens{}
+		secrets.Default().Delete(tokensKey)
+		secrets.Default().Delete(cookiesKey)
+		return Tokens{}, ErrSessionExpired
+	}
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(resp.Body)
+		return Tokens{}, newStatusError(resp.StatusCode, data)
+	}
+
+	var refreshed Tokens
+	if err := json.NewDecoder(resp.Body).Decode(&refreshed); err != nil {
+		return Tokens{}, fmt.Errorf("failed to decode tokens: %w", err)
+	}
+	if err := storeTokens(refreshed); err != nil {
+		return Tokens{}, fmt.Errorf("failed to save tokens: %w", err)
+	}
+	return refreshed, nil
+}
+
+// authTransport sends the access token with every request. It renews the token before
+// it expires, and once more when the server answers 401, then retries the request.
+type authTransport struct {
+	base http.RoundTripper
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tokenMu.Lock()
+	current, err := loadTokens()
+	tokenMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Not logged in with tokens, e.g. the login request itself
+	if current.AccessToken == "" {
+		return t.base.RoundTrip(req)
+	}
+
+	if !current.ExpiresAt.IsZero() && time.Until(current.ExpiresAt) < refreshMargin {
+		if refreshed, err := refreshTokens(t.base, current.AccessToken); err == nil {
+			current = refreshed
+		}
+	}
+
+	resp, err := t.base.RoundTrip(withBearer(req, current.AccessToken))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// The body was consumed by the first attempt, retry only if it can be read again
+	if req.Body != nil && req.GetBody == nil {
+		return resp, nil
+	}
+
+	refreshed, err := refreshTokens(t.base, current.AccessToken)
+	if err != nil {
+		return resp, nil
+	}
+
+	retry := withBearer(req, refreshed.AccessToken)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retry.Body = body
+	}
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+func withBearer(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Profile names
+const (
+	ProfileProd    = "prod"
+	ProfileStaging = "staging"
+	ProfileLocal   = "local"
+)
+
+// ProfileEnv selects a profile for the process without changing the saved one,
+// for development and tests
+const ProfileEnv = "UNIPILOT_PROFILE"
+
+// Profile is a server the desktop client can talk to
+type Profile struct {
+	Name       string `json:"name"`
+	BaseURL    string `json:"base_url"`    // scheme and host, e.g. https://newsroom.dedyn.io
+	PathPrefix string `json:"path_prefix"` // prefix of every API route, e.g. /acc-homework
+}
+
+// defaultProfiles are the built-in profiles, the profile file can override their
+// URLs. Staging has no default server and has to be configured before use.
+var defaultProfiles = map[string]Profile{
+	ProfileProd:    {Name: ProfileProd, BaseURL: "https://newsroom.dedyn.io", PathPrefix: "/acc-homework"},
+	ProfileStaging: {Name: ProfileStaging, PathPrefix: "/acc-homework"},
+	ProfileLocal:   {Name: ProfileLocal, BaseURL: "http://localhost:3000", PathPrefix: "/acc-homework"},
+}
+
+// profileFile is the content of profile.json
+type profileFile struct {
+	Active   string             `json:"active"`
+	Profiles map[string]Profile `json:"profiles,omitempty"`
+}
+
+var (
+	configLock sync.Mutex
+	loaded     *profileFile
+)
+
+// getProfilePath returns the profile file path, next to the credentials file
+func getProfilePath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, "acc-homework", "profile.json"), nil
+}
+
+// load reads the profile file once, a missing file selects prod
+func load() *profileFile {
+	if loaded != nil {
+		return loaded
+	}
+
+	loaded = &profileFile{Active: ProfileProd, Profiles: map[string]Profile{}}
+
+	path, err := getProfilePath()
+	if err != nil {
+		return loaded
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return loaded
+	}
+
+	var file profileFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return loaded
+	}
+
+	if file.Active != "" {
+		loaded.Active = file.Active
+	}
+	for name, p := range file.Profiles {
+		loaded.Profiles[name] = p
+	}
+	return loaded
+}
+
+func save(file *profileFile) error {
+	path, err := getProfilePath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, data, 0600)
+}
+
+// lookup merges a saved profile over the built-in one of the same name
+func lookup(file *profileFile, name string) (Profile, bool) {
+	p, builtin := defaultProfiles[name]
+	saved, ok := file.Profiles[name]
+	if !builtin && !ok {
+		return Profile{}, false
+	}
+
+	p.Name = name
+	if saved.BaseURL != "" {
+		p.BaseURL = saved.BaseURL
+	}
+	if saved.PathPrefix != "" {
+		p.PathPrefix = saved.PathPrefix
+	}
+	return p, true
+}
+
+// Active returns the profile every request goes to. A profile that cannot be used
+// is still returned by name, without a server, so nothing reaches another server;
+// Check reports why.
+func Active() Profile {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	p, _ := resolve(load())
+	return p
+}
+
+// Check returns an error when the selected profile is unknown or has no server URL
+func Check() error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	_, err := resolve(load())
+	return err
+}
+
+// resolve finds the profile selected by the environment or the profile file
+func resolve(file *profileFile) (Profile, error) {
+	name := file.Active
+	if env := os.Getenv(ProfileEnv); env != "" {
+		name = env
+	}
+
+	p, ok := lookup(file, name)
+	if !ok {
+		return Profile{Name: name}, fmt.Errorf("unknown profile: %s", name)
+	}
+	if p.BaseURL == "" {
+		return p, fmt.Errorf("profile %s has no server URL", name)
+	}
+	return p, nil
+}
+
+// Profiles returns every known profile sorted by name
+func Profiles() []Profile {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	file := load()
+
+	names := make(map[string]bool)
+	for name := range defaultProfiles {
+		names[name] = true
+	}
+	for name := range file.Profiles {
+		names[name] = true
+	}
+
+	profiles := make([]Profile, 0, len(names))
+	for name := range names {
+		p, _ := lookup(file, name)
+		profiles = append(profiles, p)
+	}
+	sort.Slice(profiles, func(i, j int) bool { return profiles[i].Name < profiles[j].Name })
+	return profiles
+}
+
+// SetActive selects the profile used from now on and saves the choice
+func SetActive(name string) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	file := load()
+
+	p, ok := lookup(file, name)
+	if !ok {
+		return fmt.Errorf("unknown profile: %s", name)
+	}
+	if p.BaseURL == "" {
+		return fmt.Errorf("profile %s has no server URL", name)
+	}
+
+	file.Active = name
+	return save(file)
+}
+
+// SaveProfile adds a profile or changes the server of an existing one
+func SaveProfile(p Profile) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	if p.Name == "" {
+		return fmt.Errorf("profile name required")
+	}
+	if !strings.HasPrefix(p.BaseURL, "http://") && !strings.HasPrefix(p.BaseURL, "https://") {
+		return fmt.Errorf("invalid server URL: %s", p.BaseURL)
+	}
+
+	p.BaseURL = strings.TrimRight(p.BaseURL, "/")
+	if p.PathPrefix != "" {
+		p.PathPrefix = "/" + strings.Trim(p.PathPrefix, "/")
+	}
+
+	file := load()
+	file.Profiles[p.Name] = p
+	return save(file)
+}
+
+// Origin returns the scheme and host of the active profile, cookies are scoped to it
+func Origin() string {
+	return Active().BaseURL
+}
+
+// URL returns the full URL of an API route on the active profile, path starts with a slash
+func URL(path string) string {
+	p := Active()
+	return p.BaseURL + p.PathPrefix + path
+}
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/spf13/viper"
+)
+
+// DefaultServerConfigFile is read when no config file is given, it is optional
+const DefaultServerConfigFile = ".env"
+
+// ServerConfigEnv names the config file when the -config flag is not set
+const ServerConfigEnv = "UNIPILOT_SERVER_CONFIG"
+
+// Server is the configuration of the API server. It is loaded once at boot and
+// handed to the server, nothing reads the config file while serving requests.
+type Server struct {
+	Addr        string // listen address, e.g. :3000
+	TLSCertFile string // serve HTTPS when both files are set
+	TLSKeyFile  string
+	SessionKeys [][]byte // signs session cookies, the first signs new ones, the others are still accepted
+	DatabaseDSN string
+	GeminiKey   string   // note generation is disabled without one
+	CORSOrigins []string // origins allowed to call the API from a browser, "*" for any without credentials
+	PathPrefix  string   // prefix of every route, e.g. /acc-homework
+	BlobDir     string   // directory document files are stored in
+
+	// NotionAPIURL is the Notion API the sync calls, the public API when empty
+	NotionAPIURL string
+	// NotionTokenKey encrypts the Notion tokens of users, Notion sync is disabled without one
+	NotionTokenKey []byte
+
+	// TrustedProxies are the reverse proxies whose X-Forwarded-For header is believed.
+	// Requests from any other address are attributed to that address.
+	TrustedProxies []netip.Prefix
+
+	// PublicURL is where users reach the server, e.g. https://example.com, links in
+	// emails point to it. Emails carry only the token without it.
+	PublicURL string
+
+	// Mail is sent over SMTP when SMTPHost is set, otherwise written to MailDir
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	MailFrom     string
+	MailDir      string
+}
+
+// TLS reports whether the server serves HTTPS
+func (c *Server) TLS() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+// LoadServer reads the server configuration from the config file, the environment
+// and the command line flags in args, later sources override earlier ones. The
+// result is validated, an error means the server must not start.
+func LoadServer(args []string) (*Server, error) {
+	fs := flag.NewFlagSet("server", flag.ContinueOnError)
+	configFile := fs.String("config", "", "config file (default .env, or $"+ServerConfigEnv+")")
+	addr := fs.String("addr", "", "listen address")
+	tlsCert := fs.String("tls-cert", "", "TLS certificate file")
+	tlsKey := fs.String("tls-key", "", "TLS key file")
+	prefix := fs.String("prefix", "", "path prefix of every route")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	v := viper.New()
+	v.AutomaticEnv()
+	v.SetDefault("LISTEN_ADDR", ":3000")
+	v.SetDefault("PATH_PREFIX", "/acc-homework")
+	v.SetDefault("BLOB_DIR", "data/blobs")
+	v.SetDefault("SMTP_PORT", 587)
+	v.SetDefault("MAIL_DIR", "data/mail")
+	v.SetDefault("DB_PORT", 5432)
+	v.SetDefault("DB_SSLMODE", "require")
+
+	path := *configFile
+	if path == "" {
+		path = os.Getenv(ServerConfigEnv)
+	}
+	explicit := path != ""
+	if !explicit {
+		path = DefaultServerConfigFile
+	}
+	if _, err := os.Stat(path); err == nil || explicit {
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("error reading config file: %w", err)
+		}
+	}
+
+	cfg := &Server{
+		Addr:        v.GetString("LISTEN_ADDR"),
+		TLSCertFile: v.GetString("TLS_CERT_FILE"),
+		TLSKeyFile:  v.GetString("TLS_KEY_FILE"),
+		DatabaseDSN: v.GetString("DATABASE_URL"),
+		GeminiKey:   v.GetString("GEMINI_API_KEY"),
+		PathPrefix:  v.GetString("PATH_PREFIX"),
+		BlobDir:     v.GetString("BLOB_DIR"),
+
+		NotionAPIURL:   v.GetString("NOTION_API_URL"),
+		NotionTokenKey: []byte(v.GetString("NOTION_TOKEN_KEY")),
+
+		PublicURL:    strings.TrimRight(v.GetString("PUBLIC_URL"), "/"),
+		SMTPHost:     v.GetString("SMTP_HOST"),
+		SMTPPort:     v.GetInt("SMTP_PORT"),
+		SMTPUsername: v.GetString("SMTP_USERNAME"),
+		SMTPPassword: v.GetString("SMTP_PASSWORD"),
+		MailFrom:     v.GetString("MAIL_FROM"),
+		MailDir:      v.GetString("MAIL_DIR"),
+	}
+
+	for _, origin := range splitList(v.GetString("CORS_ORIGINS")) {
+		cfg.CORSOrigins = append(cfg.CORSOrigins, strings.TrimRight(origin, "/"))
+	}
+
+	// TRUSTED_PROXIES lists addresses or networks, e.g. 127.0.0.1,10.0.0.0/8
+	for _, proxy := range splitList(v.GetString("TRUSTED_PROXIES")) {
+		prefix, err := parseProxy(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid server config: %w", err)
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, prefix)
+	}
+
+	// SESSION_KEY signs new sessions, keys moved to SESSION_PREVIOUS_KEYS on rotation
+	// keep existing sessions valid until they are dropped from the list
+	if key := v.GetString("SESSION_KEY"); key != "" {
+		cfg.SessionKeys = append(cfg.SessionKeys, []byte(key))
+	}
+	for _, key := range splitList(v.GetString("SESSION_PREVIOUS_KEYS")) {
+		cfg.SessionKeys = append(cfg.SessionKeys, []byte(key))
+	}
+
+	// Without DATABASE_URL the DSN is built from the DB_* keys of the original .env
+	if cfg.DatabaseDSN == "" && v.GetString("DB_HOST") != "" {
+		cfg.DatabaseDSN = fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=%s",
+			v.GetString("DB_HOST"), v.GetInt("DB_PORT"), v.GetString("DB_USER"),
+			v.GetString("DB_PASSWORD"), v.GetString("DB_NAME"), v.GetString("DB_SSLMODE"))
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Addr = *addr
+		case "tls-cert":
+			cfg.TLSCertFile = *tlsCert
+		case "tls-key":
+			cfg.TLSKeyFile = *tlsKey
+		case "prefix":
+			cfg.PathPrefix = *prefix
+		}
+	})
+
+	if cfg.PathPrefix != "" {
+		cfg.PathPrefix = "/" + strings.Trim(cfg.PathPrefix, "/")
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseProxy reads a trusted proxy, a single address or a network in CIDR notation
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		if err != nil {
+			return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		return prefix.Masked(), nil
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Validate checks the configuration is complete and consistent
+func (c *Server) Validate() error {
+	var errs []error
+
+	if _, _, err := net.SplitHostPort(c.Addr); err != nil {
+		errs = append(errs, fmt.Errorf("invalid listen address %q: %w", c.Addr, err))
+	}
+
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("TLS needs both a certificate and a key file"))
+	}
+	for _, file := range []string{c.TLSCertFile, c.TLSKeyFile} {
+		if file == "" {
+			continue
+		}
+		if _, err := os.Stat(file); err != nil {
+			errs = append(errs, fmt.Errorf("TLS file: %w", err))
+		}
+	}
+
+	if len(c.SessionKeys) == 0 {
+		errs = append(errs, errors.New("SESSION_KEY is required"))
+	}
+	for i, key := range c.SessionKeys {
+		if len(key) < 32 {
+			errs = append(errs, fmt.Errorf("session key %d is too short, use at least 32 random bytes", i+1))
+		}
+	}
+
+	if len(c.NotionTokenKey) > 0 && len(c.NotionTokenKey) < 32 {
+		errs = append(errs, errors.New("NOTION_TOKEN_KEY is too short, use at least 32 random bytes"))
+	}
+
+	if c.DatabaseDSN == "" {
+		errs = append(errs, errors.New("DATABASE_URL or DB_HOST is required"))
+	}
+
+	for _, origin := range c.CORSOrigins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			errs = append(errs, fmt.Errorf("invalid CORS origin %q", origin))
+		}
+	}
+
+	if c.BlobDir == "" {
+		errs = append(errs, errors.New("BLOB_DIR is required"))
+	}
+
+	if c.PublicURL != "" {
+		u, err := url.Parse(c.PublicURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("invalid public URL %q", c.PublicURL))
+		}
+	}
+
+	if c.SMTPHost != "" {
+		if c.MailFrom == "" {
+			errs = append(errs, errors.New("MAIL_FROM is required to send mail over SMTP"))
+		}
+		if c.SMTPPort <= 0 || c.SMTPPort > 65535 {
+			errs = append(errs, fmt.Errorf("invalid SMTP port %d", c.SMTPPort))
+		}
+	} else if c.MailDir == "" {
+		errs = append(errs, errors.New("MAIL_DIR is required without SMTP_HOST"))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid server config: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// splitList splits a comma separated value, dropping empty entries
+func splitList(value string) []string {
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
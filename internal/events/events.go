@@ -2,19 +2,41 @@ package events
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 
+	"unipilot/internal/models"
 	"unipilot/internal/sse"
+	"unipilot/internal/storage"
 )
 
+// lastEventIDKey is the LocalSyncState key holding the ID of the last applied event
+const lastEventIDKey = "last_event_id"
+
+// maxEventAttempts is how many times an event is replayed before it is skipped. Delta
+// sync still brings its row, a poison event must not stall the stream forever.
+const maxEventAttempts = 5
+
+// ErrUnsupportedVersion is returned for events in an envelope newer than this client
+// understands. Replaying them can't help, the app must be updated.
+var ErrUnsupportedVersion = errors.New("unsupported event version")
+
 type Events struct {
 	stopChan chan struct{}
+	mux      *sse.Mux
+
+	// OnProfileUpdate, when set, is called with the profile after another device changed it
+	OnProfileUpdate func(profile map[string]string)
 }
 
 func NewEvents() *Events {
-	return &Events{
+	h := &Events{
 		stopChan: make(chan struct{}),
+		mux:      sse.NewMux(),
 	}
+	h.routes()
+	return h
 }
 
 // Start now accepts the sseClient as a parameter.
@@ -26,6 +48,12 @@ func (h *Events) Start(sseClient *sse.SSE) {
 
 	go func() {
 		log.Println("[EventHandler] Starting to listen for events...")
+
+		// After a failed event the connection is dropped, events still queued from it
+		// are ignored and the server replays them from the last applied one
+		skipBefore := 0
+		failedID, attempts := "", 0
+
 		for {
 			select {
 			case <-h.stopChan:
@@ -37,7 +65,31 @@ func (h *Events) Start(sseClient *sse.SSE) {
 					log.Println("[EventHandler] SSE events channel closed.")
 					return
 				}
-				h.HandleEvent(event)
+				if event.Conn < skipBefore {
+					continue
+				}
+
+				// Replaying an event this client can't handle gives the same result
+				err := h.HandleEvent(event)
+				if errors.Is(err, sse.ErrNoHandler) || errors.Is(err, ErrUnsupportedVersion) {
+					log.Printf("[EventHandler] Ignoring event %s: %v", event.ID, err)
+				} else if err != nil {
+					if event.ID != failedID {
+						failedID, attempts = event.ID, 0
+					}
+					attempts++
+					if event.ID != "" && attempts < maxEventAttempts {
+						log.Printf("[EventHandler] Event %s failed (attempt %d), replaying it: %v", event.ID, attempts, err)
+						skipBefore = sseClient.Reconnect()
+						continue
+					}
+					log.Printf("[EventHandler] Skipping event %s: %v", event.ID, err)
+				}
+
+				if event.ID != "" {
+					sseClient.SetLastEventID(event.ID)
+					saveLastEventID(event.ID)
+				}
 			// Listen for errors.
 			case err, ok := <-sseClient.Errors():
 				if !ok {
@@ -50,36 +102,84 @@ func (h *Events) Start(sseClient *sse.SSE) {
 	}()
 }
 
+// LastEventID returns the ID of the last event applied on this device, so the
+// SSE connection can resume from it after a restart
+func LastEventID() string {
+	db, _, err := storage.GetLocalDB()
+	if err != nil {
+		return ""
+	}
+
+	id, err := models.GetSyncState(db, lastEventIDKey)
+	if err != nil {
+		log.Printf("[EventHandler] Failed to read last event ID: %v", err)
+		return ""
+	}
+	return id
+}
+
+func saveLastEventID(id string) {
+	db, _, err := storage.GetLocalDB()
+	if err != nil {
+		return
+	}
+
+	if err := models.SetSyncState(db, lastEventIDKey, id); err != nil {
+		log.Printf("[EventHandler] Failed to save last event ID %s: %v", id, err)
+	}
+}
+
 // Stop signals the event handling goroutine to terminate.
 func (h *Events) Stop() {
 	close(h.stopChan)
 }
 
-func (h *Events) HandleEvent(event sse.Event) {
-	var notification struct {
-		Type    string          `json:"type"`
-		Entity  string          `json:"entity"`
-		Message string          `json:"message"`
-		Data    json.RawMessage `json:"data"`
+// HandleEvent routes an event to its handler by name. Events from servers that
+// predate event names are named from their entity and type. It returns an error
+// when the event was not applied.
+func (h *Events) HandleEvent(event sse.Event) error {
+	if event.Type == "" {
+		var legacy struct {
+			Type   string `json:"type"`
+			Entity string `json:"entity"`
+		}
+		if err := json.Unmarshal(event.Data, &legacy); err != nil {
+			return fmt.Errorf("error parsing notification: %w", err)
+		}
+		event.Type = legacy.Entity + "." + legacy.Type
 	}
 
-	if err := json.Unmarshal(event.Data, &notification); err != nil {
-		log.Printf("[EventHandler] Error parsing notification: %v", err)
-		return
+	return h.mux.Dispatch(event)
+}
+
+// routes registers the handler of every event the server sends
+func (h *Events) routes() {
+	h.mux.Handle(models.EventName(models.Assignment, models.OperationCreate), envelope(h.HandleAssignmentCreate))
+	h.mux.Handle(models.EventName(models.Assignment, models.OperationUpdate), envelope(h.HandleAssignmentUpdate))
+	h.mux.Handle(models.EventName(models.Assignment, models.OperationDelete), envelope(h.HandleAssignmentDelete))
+
+	for _, op := range []models.Operation{models.OperationCreate, models.OperationUpdate, models.OperationDelete} {
+		h.mux.Handle(models.EventName(models.EntityCourse, op), envelope(h.HandleCourseChange))
+		h.mux.Handle(models.EventName(models.EntityNote, op), envelope(h.HandleNoteChange))
+		h.mux.Handle(models.EventName(models.EntityDocument, op), envelope(h.HandleDocumentChange))
 	}
 
-	// Route the event based on its entity and type.
-	switch notification.Entity {
-	case "assignment":
-		switch notification.Type {
-		case "create":
-			h.HandleAssignmentCreate(notification.Data, notification.Message)
-		case "update":
-			h.HandleAssignmentUpdate(notification.Data, notification.Message)
-		case "delete":
-			h.HandleAssignmentDelete(notification.Data, notification.Message)
+	h.mux.Handle(models.EventName(models.EntityUser, models.OperationUpdate), envelope(h.HandleProfileUpdate))
+	h.mux.Handle(models.EventResync, h.HandleResync)
+}
+
+// envelope decodes the event envelope and passes its data and message to handle
+func envelope(handle func(data json.RawMessage, message string) error) sse.Handler {
+	return func(event sse.Event) error {
+		var env models.EventEnvelope
+		if err := json.Unmarshal(event.Data, &env); err != nil {
+			return fmt.Errorf("error parsing event %q: %w", event.Type, err)
+		}
+
+		if env.Version > models.EventVersion {
+			return fmt.Errorf("event %q: %w %d", event.Type, ErrUnsupportedVersion, env.Version)
 		}
-	case "course":
-		// Placeholder for future course event handling.
+
+		return handle(env.Data, env.Message)
 	}
 }
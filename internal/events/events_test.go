@@ -0,0 +1,38 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"unipilot/internal/models"
+	"unipilot/internal/sse"
+)
+
+func TestEnvelope(t *testing.T) {
+	var data, message string
+	handler := envelope(func(d json.RawMessage, m string) error {
+		data, message = string(d), m
+		return nil
+	})
+
+	err := handler(sse.Event{Type: "note.update", Data: json.RawMessage(`{"version":1,"message":"Note updated","data":{"id":7}}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data != `{"id":7}` || message != "Note updated" {
+		t.Errorf("data = %s, message = %q", data, message)
+	}
+
+	data = ""
+	newer, _ := json.Marshal(models.EventEnvelope{Version: models.EventVersion + 1, Data: json.RawMessage(`{}`)})
+	if err := handler(sse.Event{Type: "note.update", Data: newer}); !errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("newer envelope: %v, want ErrUnsupportedVersion", err)
+	}
+	if err := handler(sse.Event{Type: "note.update", Data: json.RawMessage(`not json`)}); err == nil || errors.Is(err, ErrUnsupportedVersion) {
+		t.Errorf("malformed envelope: %v", err)
+	}
+	if data != "" {
+		t.Errorf("handler called for a rejected envelope with %s", data)
+	}
+}
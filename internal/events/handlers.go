@@ -4,172 +4,128 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"strconv"
 	"time"
 
-	"unipilot/internal/models/assignment"
 	"unipilot/internal/services/notifications"
+	"unipilot/internal/sse"
 	"unipilot/internal/storage"
-)
+	"unipilot/internal/sync"
 
-type AssignmentResponse struct {
-	ID         string `json:"id"`
-	CreatedAt  string `json:"created_at"`
-	UpdatedAt  string `json:"updated_at"`
-	Title      string `json:"title"`
-	Todo       string `json:"todo"`
-	Deadline   string `json:"deadline"`
-	Link       string `json:"link"`
-	CourseCode string `json:"course_code"`
-	TypeName   string `json:"type"`
-	StatusName string `json:"status"`
-	NotionID   string `json:"notion_id"`
-}
+	"gorm.io/gorm"
+)
 
-func (h *Events) HandleAssignmentCreate(data json.RawMessage, message string) {
+// applyRow decodes a row sent by the server and applies it to the local database
+// in a transaction. Creates, updates and deletes share the same path since deleted
+// rows carry their deleted_at value.
+func applyRow(entity string, data json.RawMessage, apply func(tx *gorm.DB, row map[string]string) error) (map[string]string, error) {
+	var row map[string]string
+	if err := json.Unmarshal(data, &row); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", entity, err)
+	}
 
 	db, _, err := storage.GetLocalDB()
 	if err != nil {
-		return
+		return nil, err
 	}
 
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	var ar AssignmentResponse
-	if err := json.Unmarshal(data, &ar); err != nil {
-		log.Printf("Error unmarshalling assignment: %v", err)
-		return
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		return apply(tx, row)
+	}); err != nil {
+		return nil, fmt.Errorf("error applying %s %s: %w", entity, row["id"], err)
 	}
+	return row, nil
+}
 
-	id, err := strconv.Atoi(ar.ID)
+func (h *Events) HandleAssignmentCreate(data json.RawMessage, message string) error {
+	row, err := applyRow("assignment", data, sync.ApplyAssignment)
 	if err != nil {
-		log.Printf("Error converting ID to int: %v", err)
-		return
+		return err
 	}
 
-	deadline, err := time.Parse(time.RFC3339, ar.Deadline)
-	if err != nil {
-		log.Printf("Error parsing deadline: %v", err)
-		return
-	}
+	go Notify("created", message, row)
+	return nil
+}
 
-	a := assignment.LocalAssignment{
-		RemoteID:   uint(id),
-		Title:      ar.Title,
-		Todo:       ar.Todo,
-		Deadline:   deadline,
-		Link:       ar.Link,
-		CourseCode: ar.CourseCode,
-		TypeName:   ar.TypeName,
-		StatusName: ar.StatusName,
-		NotionID:   ar.NotionID,
-		SyncStatus: assignment.SyncStatusSynced,
-	}
-	if err := tx.Create(&a).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Error creating assignment: %v", err)
-		return
+func (h *Events) HandleAssignmentUpdate(data json.RawMessage, message string) error {
+	row, err := applyRow("assignment", data, sync.ApplyAssignment)
+	if err != nil {
+		return err
 	}
 
-	Notify("created", message, a.ToMap())
-
-	tx.Commit()
-
+	go Notify("updated", message, row)
+	return nil
 }
 
-func (h *Events) HandleAssignmentUpdate(data json.RawMessage, message string) {
-	// Similar to handleAssignmentCreate but with update logic
-
-	db, _, err := storage.GetLocalDB()
+func (h *Events) HandleAssignmentDelete(data json.RawMessage, message string) error {
+	row, err := applyRow("assignment", data, sync.ApplyAssignment)
 	if err != nil {
-		return
+		return err
 	}
 
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	var update struct {
-		ID     string `json:"id"`
-		Column string `json:"column"`
-		Value  string `json:"value"`
-	}
+	go Notify("deleted", message, row)
+	return nil
+}
 
-	if err := json.Unmarshal(data, &update); err != nil {
-		log.Printf("Error parsing update: %v", err)
-		return
+// HandleCourseChange applies a created, updated or deleted course
+func (h *Events) HandleCourseChange(data json.RawMessage, message string) error {
+	if _, err := applyRow("course", data, sync.ApplyCourse); err != nil {
+		return err
 	}
+	log.Printf("[EventHandler] %s", message)
+	return nil
+}
 
-	if err := tx.Model(&assignment.LocalAssignment{}).Where("remote_id = ?", update.ID).Update(update.Column, update.Value).Error; err != nil {
-		fmt.Printf("Error updating assignment %s with %s = %s\n", update.ID, update.Column, update.Value)
-		tx.Rollback()
-		panic(err)
+// HandleNoteChange applies a created, updated or deleted note
+func (h *Events) HandleNoteChange(data json.RawMessage, message string) error {
+	if _, err := applyRow("note", data, sync.ApplyNote); err != nil {
+		return err
 	}
+	log.Printf("[EventHandler] %s", message)
+	return nil
+}
 
-	var a assignment.LocalAssignment
-	err = tx.Model(&assignment.LocalAssignment{}).Where("remote_id = ?", update.ID).First(&a).Error
+// HandleDocumentChange applies created or deleted document metadata
+func (h *Events) HandleDocumentChange(data json.RawMessage, message string) error {
+	userID, err := storage.GetCurrentUserID()
 	if err != nil {
-		log.Printf("Error getting assignment: %v", err)
-		return
+		return fmt.Errorf("failed to get current user ID: %w", err)
 	}
 
-	tx.Commit()
-
-	Notify("updated", message, a.ToMap())
+	if _, err := applyRow("document", data, func(tx *gorm.DB, row map[string]string) error {
+		return sync.ApplyDocument(tx, row, userID)
+	}); err != nil {
+		return err
+	}
+	log.Printf("[EventHandler] %s", message)
 
+	// A pinned document may just have had its file uploaded from another device
+	if db, _, err := storage.GetLocalDB(); err == nil {
+		go func() {
+			if err := sync.DownloadPinned(db); err != nil {
+				log.Printf("[EventHandler] Downloading pinned documents failed: %v", err)
+			}
+		}()
+	}
+	return nil
 }
 
-func (h *Events) HandleAssignmentDelete(data json.RawMessage, message string) {
-	// Similar to handleAssignmentCreate but with delete logic
-
+// HandleResync catches up with delta sync, the server sends it instead of the
+// events missed while this device was away when there are too many
+func (h *Events) HandleResync(event sse.Event) error {
 	db, _, err := storage.GetLocalDB()
 	if err != nil {
-		return
-	}
-
-	tx := db.Begin()
-	defer func() {
-		if r := recover(); r != nil {
-			tx.Rollback()
-		}
-	}()
-
-	var ar AssignmentResponse
-	if err := json.Unmarshal(data, &ar); err != nil {
-		log.Printf("Error unmarshalling assignment: %v", err)
-		return
-	}
-
-	var a assignment.LocalAssignment
-	if err := tx.Model(&assignment.LocalAssignment{}).Where("notion_id = ?", ar.NotionID).First(&a).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Error getting assignment: %v", err)
-		return
+		return err
 	}
-
-	if err := tx.Where("notion_id = ?", ar.NotionID).Delete(&assignment.LocalAssignment{}).Error; err != nil {
-		tx.Rollback()
-		log.Printf("Error deleting assignment: %v", err)
-		return
+	if err := sync.PullChanges(db); err != nil {
+		return fmt.Errorf("resync failed: %w", err)
 	}
-
-	tx.Commit()
-
-	Notify("deleted", message, a.ToMap())
-
+	log.Printf("[EventHandler] Resynced after missing too many events")
+	return nil
 }
 
 func Notify(action, message string, assignment map[string]string) {
 
-	notification_id := fmt.Sprintf("%s-%s", assignment["notion_id"], action)
+	notification_id := fmt.Sprintf("%s-%s", assignment["id"], action)
 	title := fmt.Sprintf("%s: %s", assignment["course_code"], assignment["title"])
 	subtitle := fmt.Sprintf("%s at %s", action, time.Now().Format(time.Stamp))
 
@@ -0,0 +1,67 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"unipilot/internal/models"
+	"unipilot/internal/storage"
+)
+
+// profileKey is the LocalSyncState key holding the last known profile of the user
+const profileKey = "profile"
+
+// CachedProfile returns the profile last received from the server, nil when none
+// was received yet
+func CachedProfile() map[string]string {
+	db, _, err := storage.GetLocalDB()
+	if err != nil {
+		return nil
+	}
+
+	value, err := models.GetSyncState(db, profileKey)
+	if err != nil || value == "" {
+		return nil
+	}
+
+	var profile map[string]string
+	if err := json.Unmarshal([]byte(value), &profile); err != nil {
+		log.Printf("[EventHandler] Failed to read cached profile: %v", err)
+		return nil
+	}
+	return profile
+}
+
+// SaveProfile caches the profile of the user so it shows while offline
+func SaveProfile(profile map[string]string) error {
+	db, _, err := storage.GetLocalDB()
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return err
+	}
+	return models.SetSyncState(db, profileKey, string(data))
+}
+
+// HandleProfileUpdate caches a profile changed on another device and tells the
+// frontend, which refreshes the header with it
+func (h *Events) HandleProfileUpdate(data json.RawMessage, message string) error {
+	var profile map[string]string
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return fmt.Errorf("error parsing profile: %w", err)
+	}
+
+	if err := SaveProfile(profile); err != nil {
+		return fmt.Errorf("error saving profile: %w", err)
+	}
+	log.Printf("[EventHandler] %s", message)
+
+	if h.OnProfileUpdate != nil {
+		h.OnProfileUpdate(profile)
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// AuthEventKind is what happened in an authentication attempt
+type AuthEventKind string
+
+const (
+	AuthLoginFailed   AuthEventKind = "login_failed"
+	AuthLoginLocked   AuthEventKind = "login_locked"
+	AuthRateLimited   AuthEventKind = "rate_limited"
+	AuthLockoutStarts AuthEventKind = "lockout_started"
+)
+
+// AuthEvent is the audit trail of failed and refused authentication attempts on the
+// REMOTE database. The username is the one sent, it may not belong to any user.
+type AuthEvent struct {
+	ID        uint          `gorm:"primaryKey"`
+	Kind      AuthEventKind `gorm:"index;not null"`
+	Path      string
+	Username  string `gorm:"index"`
+	IP        string `gorm:"index"`
+	UserAgent string
+	CreatedAt time.Time `gorm:"index"`
+}
+
+// MigrateAuthEvents creates the authentication audit table on the REMOTE database
+func MigrateAuthEvents(db *gorm.DB) error {
+	return db.AutoMigrate(&AuthEvent{})
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Conflict resolutions offered to the user
+const (
+	ResolutionKeepMine   = "keep-mine"
+	ResolutionKeepTheirs = "keep-theirs"
+	ResolutionMergeText  = "merge-text"
+)
+
+// FieldConflict is returned by the update endpoints when the column changed on the
+// server since the client last saw it. Base is what the client started from,
+// Mine the value it tried to write and Theirs the value currently on the server.
+type FieldConflict struct {
+	Entity   Entity `json:"entity"`
+	EntityID string `json:"id"`
+	Column   string `json:"column"`
+	Base     string `json:"base"`
+	Mine     string `json:"mine"`
+	Theirs   string `json:"theirs"`
+}
+
+// LocalConflict is a rejected field change waiting for the user to pick a resolution.
+// The local row keeps its own value and stays pending until the conflict is resolved.
+type LocalConflict struct {
+	gorm.Model
+	Entity   Entity `gorm:"index"`
+	EntityID uint   `gorm:"index"`
+	Column   string
+	Base     string
+	Mine     string
+	Theirs   string
+}
+
+func (c *LocalConflict) ToMap() map[string]string {
+	return map[string]string{
+		"id":         strconv.Itoa(int(c.ID)),
+		"entity":     string(c.Entity),
+		"entity_id":  strconv.Itoa(int(c.EntityID)),
+		"column":     c.Column,
+		"base":       c.Base,
+		"mine":       c.Mine,
+		"theirs":     c.Theirs,
+		"created_at": c.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// ColumnValue reads a single column of a row as a string. The column is checked
+// against the table schema first since it ends up in the query text.
+func ColumnValue(db *gorm.DB, table string, id uint, column string) (string, error) {
+	if !db.Migrator().HasColumn(table, column) {
+		return "", fmt.Errorf("unknown column %s on %s", column, table)
+	}
+
+	var value interface{}
+	row := db.Table(table).Select(column).Where("id = ?", id).Row()
+	if err := row.Scan(&value); err != nil {
+		if err == sql.ErrNoRows {
+			return "", fmt.Errorf("no row %d in %s", id, table)
+		}
+		return "", err
+	}
+
+	return FormatValue(value), nil
+}
+
+// FormatValue turns a value scanned from the database into the string form used by the update endpoints
+func FormatValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return fmt.Sprint(value)
+}
+
+var valueTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05-07:00",
+	"2006-01-02 15:04:05",
+	time.DateOnly,
+}
+
+func parseValueTime(value string) (time.Time, string, bool) {
+	for _, layout := range valueTimeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, layout, true
+		}
+	}
+	return time.Time{}, "", false
+}
+
+// SameValue reports whether two column values are equal once the differences between
+// how the local and remote databases store dates and booleans are ignored
+func SameValue(a, b string) bool {
+	if a == b {
+		return true
+	}
+
+	if ta, la, ok := parseValueTime(a); ok {
+		if tb, lb, ok := parseValueTime(b); ok {
+			// Date-only values are compared by calendar day
+			if la == time.DateOnly || lb == time.DateOnly {
+				return ta.Format(time.DateOnly) == tb.Format(time.DateOnly)
+			}
+			return ta.Equal(tb)
+		}
+	}
+
+	if ba, err := strconv.ParseBool(a); err == nil {
+		if bb, err := strconv.ParseBool(b); err == nil {
+			return ba == bb
+		}
+	}
+
+	return false
+}
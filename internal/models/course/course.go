@@ -18,6 +18,7 @@ type Course struct {
 	gorm.Model
 	UserID          uint      `gorm:"not null"`
 	LocalID         uint      `gorm:"not null"`
+	DeviceID        string    `gorm:"index"` // Device that assigned LocalID
 	User            user.User `gorm:"foreignKey:UserID;references:ID"`
 	NotionID        string
 	Code            string `gorm:"index:idx_courses_user_code_active,unique,where:deleted_at IS NULL;not null"`
@@ -86,10 +87,10 @@ func Get_Course_byId(id uint, db *gorm.DB) (*Course, error) {
 	}
 	return course, nil
 }
-func Get_Course_byLocalId(id uint, db *gorm.DB) (*Course, error) {
+func Get_Course_byLocalId(id, user_id uint, db *gorm.DB) (*Course, error) {
 	course := &Course{}
 	err := db.Preload("User").
-		Where("local_id = ?", id).
+		Where("local_id = ? AND user_id = ?", id, user_id).
 		First(course).Error
 
 	if err != nil {
@@ -123,6 +124,7 @@ func (c *Course) ToMap() map[string]string {
 	return map[string]string{
 		"id":               strconv.Itoa(int(c.ID)),
 		"local_id":         strconv.Itoa(int(c.LocalID)),
+		"device_id":        c.DeviceID,
 		"user_id":          strconv.Itoa(int(c.UserID)),
 		"notion_id":        c.NotionID,
 		"name":             c.Name,
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DeviceSession is a login of one device on the REMOTE database. The device holds a
+// refresh token for it, the server keeps only its hash. Revoking the session logs the
+// device out on its next request.
+type DeviceSession struct {
+	ID          uint   `gorm:"primaryKey"`
+	UserID      uint   `gorm:"index;not null"`
+	DeviceID    string `gorm:"index;not null"`
+	DeviceName  string
+	RefreshHash string `gorm:"uniqueIndex;not null"`
+	IP          string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastSeenAt  time.Time
+	ExpiresAt   time.Time
+	RevokedAt   *time.Time `gorm:"index"`
+}
+
+// Active reports whether the session can still authenticate requests
+func (s *DeviceSession) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// ToMap converts the session for the device list, never including the token hash
+func (s *DeviceSession) ToMap() map[string]string {
+	return map[string]string{
+		"id":           strconv.Itoa(int(s.ID)),
+		"device_id":    s.DeviceID,
+		"device_name":  s.DeviceName,
+		"ip":           s.IP,
+		"user_agent":   s.UserAgent,
+		"created_at":   s.CreatedAt.Format(time.RFC3339),
+		"last_seen_at": s.LastSeenAt.Format(time.RFC3339),
+		"expires_at":   s.ExpiresAt.Format(time.RFC3339),
+	}
+}
+
+// MigrateDeviceSessions creates the device session table on the REMOTE database
+func MigrateDeviceSessions(db *gorm.DB) error {
+	return db.AutoMigrate(&DeviceSession{})
+}
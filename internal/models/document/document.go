@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"time"
 
 	"unipilot/internal/models/user"
@@ -25,6 +26,7 @@ type Document struct {
 	AssignmentID uint         `gorm:"not null;index"`
 	UserID       uint         `gorm:"not null;index"` // Original uploader
 	LocalID      uint         `gorm:"not null;index"` // Local document ID
+	DeviceID     string       `gorm:"index"`          // Device the local ID belongs to
 	Type         DocumentType `gorm:"not null;index"`
 	FileName     string       `gorm:"not null"`
 	FileType     string       `gorm:"not null"` // mime type or extension
@@ -33,6 +35,7 @@ type Document struct {
 	Version      int          `gorm:"default:1"`
 	ParentDocID  *uint        `gorm:"index"`        // For version history
 	IsOriginal   bool         `gorm:"default:true"` // For shared assignment tracking
+	Hash         string       `gorm:"index"`        // SHA-256 of the content, empty until the file is uploaded
 
 	// Relationships
 	User      user.User  `gorm:"foreignKey:UserID;references:ID"`
@@ -40,6 +43,32 @@ type Document struct {
 	Versions  []Document `gorm:"foreignKey:ParentDocID;references:ID"`
 }
 
+// ToMap converts the document metadata to a map[string]string
+func (d *Document) ToMap() map[string]string {
+	parentDocID := ""
+	if d.ParentDocID != nil {
+		parentDocID = strconv.Itoa(int(*d.ParentDocID))
+	}
+
+	return map[string]string{
+		"id":            strconv.Itoa(int(d.ID)),
+		"local_id":      strconv.Itoa(int(d.LocalID)),
+		"device_id":     d.DeviceID,
+		"assignment_id": strconv.Itoa(int(d.AssignmentID)),
+		"user_id":       strconv.Itoa(int(d.UserID)),
+		"type":          string(d.Type),
+		"file_name":     d.FileName,
+		"file_type":     d.FileType,
+		"file_size":     strconv.FormatInt(d.FileSize, 10),
+		"version":       strconv.Itoa(d.Version),
+		"parent_doc_id": parentDocID,
+		"is_original":   strconv.FormatBool(d.IsOriginal),
+		"hash":          d.Hash,
+		"created_at":    d.CreatedAt.Format(time.RFC3339),
+		"updated_at":    d.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
 // DocumentStorageInfo holds storage statistics
 type DocumentStorageInfo struct {
 	UserID           uint      `gorm:"primaryKey"`
@@ -112,31 +141,17 @@ func GetAppDataPath() (string, error) {
 	return appDataPath, nil
 }
 
-// GenerateFilePath creates a unique file path for the document
-func (d *Document) GenerateFilePath() (string, error) {
-	appDataPath, err := GetAppDataPath()
-	if err != nil {
-		return "", err
-	}
+// BlobsDir is the directory under the app data path holding document files by hash
+const BlobsDir = "blobs"
 
-	// Create subdirectories: user_id/assignment_id/document_type/
-	subDir := filepath.Join(
-		fmt.Sprintf("user_%d", d.UserID),
-		fmt.Sprintf("assignment_%d", d.AssignmentID),
-		string(d.Type),
-	)
-
-	fullDir := filepath.Join(appDataPath, subDir)
-	if err := os.MkdirAll(fullDir, 0755); err != nil {
-		return "", fmt.Errorf("failed to create document directory: %w", err)
+// GenerateFilePath returns the content-addressed path of the document file, relative
+// to the app data directory. Documents with the same content share the same file.
+func (d *Document) GenerateFilePath() (string, error) {
+	if len(d.Hash) < 2 {
+		return "", fmt.Errorf("document %s has no content hash", d.FileName)
 	}
 
-	// Generate unique filename with timestamp to avoid conflicts
-	timestamp := time.Now().Unix()
-	fileName := fmt.Sprintf("%d_%s", timestamp, d.FileName)
-
-	// Return relative path for storage in DB
-	return filepath.Join(subDir, fileName), nil
+	return filepath.Join(BlobsDir, d.Hash[:2], d.Hash), nil
 }
 
 // GetFullPath returns the absolute path to the document file
@@ -1,6 +1,7 @@
 package document
 
 import (
+	"fmt"
 	"time"
 
 	"gorm.io/gorm"
@@ -10,17 +11,20 @@ import (
 // This is for local operations and caching remote metadata
 type LocalDocument struct {
 	gorm.Model
+	RemoteID     uint         `gorm:"index"` // Server metadata ID, 0 until the metadata is stored remotely
 	AssignmentID uint         `gorm:"not null;index"`
 	UserID       uint         `gorm:"not null;index"` // Original uploader
 	Type         DocumentType `gorm:"not null;index"`
 	FileName     string       `gorm:"not null"`
 	FileType     string       `gorm:"not null"`
 	FilePath     string       // Local file path (only if we have the file)
+	Hash         string       `gorm:"index"` // SHA-256 of the content, names the blob holding the file
 	FileSize     int64        `gorm:"not null"`
 	Version      int          `gorm:"default:1"`
 	ParentDocID  *uint        `gorm:"index"`
 	IsOriginal   bool         `gorm:"default:true"`
 	HasLocalFile bool         `gorm:"default:false"` // Do we have the actual file locally?
+	Pinned       bool         `gorm:"default:false"` // Keep the file downloaded for offline use
 	LastSyncAt   *time.Time   // When we last synced metadata from remote
 
 	// Local relationships
@@ -28,6 +32,16 @@ type LocalDocument struct {
 	Versions  []LocalDocument `gorm:"foreignKey:ParentDocID;references:ID"`
 }
 
+// LocalBlob counts the documents sharing a stored file. A document holds a reference
+// while it has its file locally, the file is deleted when the last reference goes away.
+type LocalBlob struct {
+	Hash      string `gorm:"primaryKey"`
+	Size      int64  `gorm:"not null"`
+	RefCount  int    `gorm:"not null;default:0"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
 // StorageInfo represents current storage statistics for a user
 type StorageInfo struct {
 	TotalSize     int64     `json:"total_size"`
@@ -49,6 +63,7 @@ func (ld *LocalDocument) ToRemoteDocument() *Document {
 		Version:      ld.Version,
 		ParentDocID:  ld.ParentDocID,
 		IsOriginal:   ld.IsOriginal,
+		Hash:         ld.Hash,
 	}
 }
 
@@ -63,6 +78,7 @@ func (ld *LocalDocument) FromRemoteDocument(rd *Document, hasLocalFile bool) {
 	ld.Version = rd.Version
 	ld.ParentDocID = rd.ParentDocID
 	ld.IsOriginal = rd.IsOriginal
+	ld.Hash = rd.Hash
 	ld.HasLocalFile = hasLocalFile
 	now := time.Now()
 	ld.LastSyncAt = &now
@@ -139,3 +155,21 @@ func GetUserStorageInfo(userID uint, db *gorm.DB) (*StorageInfo, error) {
 		CalculatedAt:  time.Now(),
 	}, nil
 }
+
+// UpdateLocalStorageInfo recalculates the cached storage totals of the files this device holds
+func UpdateLocalStorageInfo(userID uint, db *gorm.DB) error {
+	info, err := GetUserStorageInfo(userID, db)
+	if err != nil {
+		return fmt.Errorf("failed to calculate storage info: %w", err)
+	}
+
+	if err := db.Omit("User").Save(&DocumentStorageInfo{
+		UserID:           userID,
+		TotalSize:        info.TotalSize,
+		DocumentCount:    info.DocumentCount,
+		LastCalculatedAt: info.CalculatedAt,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update storage info: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,73 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EventVersion is the version of the EventEnvelope schema sent by the server.
+// Clients skip events with a newer version than they understand.
+const EventVersion = 1
+
+// EventEnvelope is the payload of every SSE event. The event is sent under the
+// name returned by EventName so clients can route it without decoding Data.
+type EventEnvelope struct {
+	Version      int             `json:"version"`
+	Entity       Entity          `json:"entity"`
+	Operation    Operation       `json:"operation"`
+	EntityID     string          `json:"entity_id"`
+	OriginDevice string          `json:"origin_device,omitempty"`
+	Timestamp    time.Time       `json:"timestamp"`
+	Seq          uint            `json:"seq"`
+	Message      string          `json:"message"`
+	Data         json.RawMessage `json:"data"`
+}
+
+// EventResync is sent instead of the missed events when a reconnecting client is too
+// far behind, it should catch up with delta sync
+const EventResync = "sync.resync"
+
+// EventName returns the SSE event name of an entity operation, e.g. "course.update"
+func EventName(entity Entity, op Operation) string {
+	return string(entity) + "." + string(op)
+}
+
+// UserEvent is a notification kept in the user's event log on the server.
+// IDs only ever increase and the server writes the log one event at a time, so
+// IDs commit in order. They are sent as SSE ids so a reconnecting client can ask
+// for everything after the last event it applied.
+type UserEvent struct {
+	ID           uint `gorm:"primaryKey"`
+	UserID       uint `gorm:"index;not null"`
+	Name         string
+	OriginDevice string
+	Payload      string `gorm:"type:text;not null"`
+	CreatedAt    time.Time
+}
+
+// MigrateEvents creates the event log table on the REMOTE database
+func MigrateEvents(db *gorm.DB) error {
+	return db.AutoMigrate(&UserEvent{})
+}
+
+// EventsAfter returns at most limit of the user's events newer than lastID, oldest first
+func EventsAfter(db *gorm.DB, userID, lastID uint, limit int) ([]UserEvent, error) {
+	var events []UserEvent
+	err := db.Where("user_id = ? AND id > ?", userID, lastID).Order("id ASC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// LatestEventID returns the ID of the user's newest event, 0 when the log is empty
+func LatestEventID(db *gorm.DB, userID uint) (uint, error) {
+	var id uint
+	err := db.Model(&UserEvent{}).Where("user_id = ?", userID).Select("COALESCE(MAX(id), 0)").Scan(&id).Error
+	return id, err
+}
+
+// PruneEvents removes events older than the retention period. Clients offline
+// for longer fall back to delta sync to catch up.
+func PruneEvents(db *gorm.DB, retention time.Duration) error {
+	return db.Where("created_at < ?", time.Now().Add(-retention)).Delete(&UserEvent{}).Error
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"strconv"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -41,23 +42,80 @@ func (a *LocalAssignmentStatus) ToMap() map[string]string {
 type Entity string
 
 const (
-	Assignment   Entity = "assignment"
-	EntityCourse Entity = "course"
+	Assignment     Entity = "assignment"
+	EntityCourse   Entity = "course"
+	EntityNote     Entity = "note"
+	EntityDocument Entity = "document"
+	EntityUser     Entity = "user"
 )
 
+// Operation is the kind of change recorded in the outbox
+type Operation string
+
+const (
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+type UpdateStatus string
+
+const (
+	UpdateStatusPending UpdateStatus = "pending" // Waiting to be replayed to the server
+	UpdateStatusFailed  UpdateStatus = "failed"  // Rejected by the server, will not be retried
+)
+
+// LocalUpdate is an outbox entry: a local change that still has to reach the server.
+// Entries are replayed in ID order and removed once the server acknowledges them.
 type LocalUpdate struct {
 	gorm.Model
-	Entity   Entity
-	EntityID uint
-	Column   string
-	Value    string
+	Entity    Entity    `gorm:"index"`
+	EntityID  uint      `gorm:"index"`
+	Operation Operation `gorm:"not null;default:'update'"`
+	Column    string
+	Value     string
+	// Base is the value the column had locally before this change. The server
+	// rejects the change when its own value moved away from it in the meantime.
+	Base          string
+	CheckBase     bool         `gorm:"default:false"`
+	Status        UpdateStatus `gorm:"not null;default:'pending';index"`
+	Attempts      int          `gorm:"default:0"`
+	NextAttemptAt time.Time
+	LastError     string
 }
 
 func (u *LocalUpdate) ToMap() map[string]string {
 	return map[string]string{
-		"entity":    string(u.Entity),
-		"entity_id": strconv.Itoa(int(u.EntityID)),
-		"column":    u.Column,
-		"value":     u.Value,
+		"id":              strconv.Itoa(int(u.ID)),
+		"entity":          string(u.Entity),
+		"entity_id":       strconv.Itoa(int(u.EntityID)),
+		"operation":       string(u.Operation),
+		"column":          u.Column,
+		"value":           u.Value,
+		"base":            u.Base,
+		"status":          string(u.Status),
+		"attempts":        strconv.Itoa(u.Attempts),
+		"next_attempt_at": u.NextAttemptAt.Format(time.RFC3339),
+		"last_error":      u.LastError,
 	}
 }
+
+// LocalSyncState stores small pieces of sync bookkeeping, such as the last change cursor.
+// The local database is per user so each user keeps their own state.
+type LocalSyncState struct {
+	Key       string `gorm:"primaryKey"`
+	Value     string
+	UpdatedAt time.Time
+}
+
+// GetSyncState returns the stored value for key, or an empty string when it was never set
+func GetSyncState(db *gorm.DB, key string) (string, error) {
+	var state LocalSyncState
+	err := db.Where(&LocalSyncState{Key: key}).Limit(1).Find(&state).Error
+	return state.Value, err
+}
+
+// SetSyncState stores value under key
+func SetSyncState(db *gorm.DB, key, value string) error {
+	return db.Save(&LocalSyncState{Key: key, Value: value}).Error
+}
@@ -28,6 +28,8 @@ func MigrateLocalDocuments(db *gorm.DB) error {
 	// This is called automatically by InitializeSchema in storage/local.go
 	err := db.AutoMigrate(
 		&document.LocalDocument{},
+		&document.LocalBlob{},
+		&document.DocumentStorageInfo{},
 	)
 
 	if err != nil {
@@ -6,15 +6,24 @@ import (
 	"gorm.io/gorm"
 )
 
+type SyncStatus string
+
+const (
+	SyncStatusPending SyncStatus = "pending" // Needs to be synced
+	SyncStatusSynced  SyncStatus = "synced"  // Already synced
+)
+
 // LocalNote represents the note stored in the local database
 type LocalNote struct {
 	gorm.Model
+	RemoteID   uint
 	CourseCode string
-	Title      string `gorm:"not null"`
-	Subject    string `gorm:"not null"`
-	Content    string `gorm:"type:text"`
-	Keywords   string `gorm:"type:text"`
-	Videos     string `gorm:"type:text"`
+	Title      string     `gorm:"not null"`
+	Subject    string     `gorm:"not null"`
+	Content    string     `gorm:"type:text"`
+	Keywords   string     `gorm:"type:text"`
+	Videos     string     `gorm:"type:text"`
+	SyncStatus SyncStatus `gorm:"not null;default:'pending'"`
 
 	Course course.Course `gorm:"foreignKey:CourseCode;references:Code"`
 }
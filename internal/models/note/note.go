@@ -12,6 +12,7 @@ import (
 type Note struct {
 	gorm.Model
 	LocalID    uint   `json:"local_id"`
+	DeviceID   string `json:"device_id" gorm:"index"`
 	UserID     uint   `json:"user_id"`
 	CourseCode string `json:"course_code"`
 	Title      string `json:"title"`
@@ -26,7 +27,9 @@ type Note struct {
 
 func (n *Note) ToMap() map[string]string {
 	return map[string]string{
+		"id":          strconv.Itoa(int(n.ID)),
 		"local_id":    strconv.Itoa(int(n.LocalID)),
+		"device_id":   n.DeviceID,
 		"user_id":     strconv.Itoa(int(n.UserID)),
 		"title":       n.Title,
 		"subject":     n.Subject,
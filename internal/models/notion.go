@@ -0,0 +1,40 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// NotionIntegration links a user to the Notion workspace their assignments and
+// courses sync with, on the REMOTE database. The databases and the columns each
+// field is stored in are discovered through the API when the user links it.
+type NotionIntegration struct {
+	ID     uint `gorm:"primaryKey"`
+	UserID uint `gorm:"uniqueIndex;not null"`
+
+	// Token is the Notion token of the user, encrypted with the server's NOTION_TOKEN_KEY
+	Token string `gorm:"not null"`
+
+	// WebhookKey names the webhook URL of the integration, VerificationToken is sent
+	// by Notion when the subscription is created and signs every delivery
+	WebhookKey        string `gorm:"uniqueIndex;not null"`
+	VerificationToken string
+
+	AssignmentsDBID string `gorm:"index"`
+	CoursesDBID     string `gorm:"index"`
+	Schema          string // JSON of the notion.Schema found by discovery
+
+	// LastSyncedAt is the last change synced either way, LastSyncError the error of the
+	// last sync when it failed
+	LastSyncedAt  *time.Time
+	LastSyncError string
+
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// MigrateNotionIntegrations creates the integrations table on the REMOTE database
+func MigrateNotionIntegrations(db *gorm.DB) error {
+	return db.AutoMigrate(&NotionIntegration{})
+}
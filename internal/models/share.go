@@ -0,0 +1,47 @@
+package models
+
+import (
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Permission is what a share grant lets the grantee do with a record
+type Permission string
+
+const (
+	PermissionRead  Permission = "read"
+	PermissionWrite Permission = "write"
+)
+
+// ShareGrant gives another user access to one record on the REMOTE database. A grant
+// on an assignment also covers its document metadata and files. Only the owner can
+// delete a record or share it further.
+type ShareGrant struct {
+	ID         uint       `gorm:"primaryKey"`
+	Entity     Entity     `gorm:"uniqueIndex:idx_share_grants_target;not null"`
+	EntityID   uint       `gorm:"uniqueIndex:idx_share_grants_target;not null"`
+	GranteeID  uint       `gorm:"uniqueIndex:idx_share_grants_target;index;not null"`
+	OwnerID    uint       `gorm:"index;not null"`
+	Permission Permission `gorm:"not null"`
+	CreatedAt  time.Time
+}
+
+// ToMap converts the grant for API responses
+func (g *ShareGrant) ToMap() map[string]string {
+	return map[string]string{
+		"id":         strconv.Itoa(int(g.ID)),
+		"entity":     string(g.Entity),
+		"entity_id":  strconv.Itoa(int(g.EntityID)),
+		"grantee_id": strconv.Itoa(int(g.GranteeID)),
+		"owner_id":   strconv.Itoa(int(g.OwnerID)),
+		"permission": string(g.Permission),
+		"created_at": g.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// MigrateShares creates the share grant table on the REMOTE database
+func MigrateShares(db *gorm.DB) error {
+	return db.AutoMigrate(&ShareGrant{})
+}
@@ -0,0 +1,47 @@
+package user
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// EmailVerificationToken is sent to the address of a user to prove they own it. Only
+// the hash of the token is stored. It verifies Email only, a token sent before the
+// user changed their address doesn't verify the new one.
+type EmailVerificationToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	Email     string `gorm:"not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// PasswordResetToken lets a user who forgot their password set a new one. Only the
+// hash of the token is stored.
+type PasswordResetToken struct {
+	ID        uint   `gorm:"primaryKey"`
+	UserID    uint   `gorm:"index;not null"`
+	TokenHash string `gorm:"uniqueIndex;not null"`
+	ExpiresAt time.Time
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+// CalendarToken gives read access to the calendar feed of a user, from calendar apps
+// that can't log in. A user has one at most. Only the hash of the token is stored.
+type CalendarToken struct {
+	ID         uint   `gorm:"primaryKey"`
+	UserID     uint   `gorm:"uniqueIndex;not null"`
+	TokenHash  string `gorm:"uniqueIndex;not null"`
+	LastUsedAt *time.Time
+	CreatedAt  time.Time
+}
+
+// MigrateTokens creates the verification, reset and calendar token tables on the
+// REMOTE database
+func MigrateTokens(db *gorm.DB) error {
+	return db.AutoMigrate(&EmailVerificationToken{}, &PasswordResetToken{}, &CalendarToken{})
+}
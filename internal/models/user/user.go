@@ -49,7 +49,7 @@ func Get_User_by_NotionID(notion_id string, db *gorm.DB) (*User, error) {
 	u := &User{}
 	err := db.Where("notion_id = ?", notion_id).First(u).Error
 	if err != nil {
-		return nil, fmt.Errorf("Error getting user with notion id: ", err)
+		return nil, fmt.Errorf("Error getting user with notion id: %w", err)
 	}
 	return u, nil
 }
@@ -3,6 +3,8 @@ package network
 import (
         "time"
         "net/http"
+
+        "unipilot/internal/config"
 )
 
 var onlineStatus bool
@@ -16,9 +18,14 @@ func IsOnline() bool {
 
     // Simple check - adjust as needed
     client := http.Client{Timeout: 3 * time.Second}
-    _, err := client.Get("https://newsroom.dedyn.io")
+    _, err := client.Get(config.Origin())
     
     onlineStatus = err == nil
     lastChecked = time.Now()
     return onlineStatus
-} 
+}
+
+// Reset forgets the cached status, e.g. after switching to another server
+func Reset() {
+    lastChecked = time.Time{}
+}
@@ -0,0 +1,157 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// scrypt parameters of the file key
+const (
+	scryptN   = 1 << 15
+	scryptR   = 8
+	scryptP   = 1
+	keyLength = 32
+)
+
+// ErrWrongPassphrase is returned when the secret file doesn't decrypt with the passphrase
+var ErrWrongPassphrase = errors.New("secret file can't be decrypted with this passphrase")
+
+// fileContent is the layout of the secret file. The secrets are encrypted together with
+// AES-GCM under a key derived from the passphrase and the salt.
+type fileContent struct {
+	Version    int    `json:"version"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// File keeps secrets in an encrypted file, rewritten on every change
+type File struct {
+	mu      sync.Mutex
+	path    string
+	salt    []byte
+	aead    cipher.AEAD
+	secrets map[string][]byte
+}
+
+// NewFile opens the secret file at path, creating it on the first Set
+func NewFile(path string, passphrase []byte) (*File, error) {
+	f := &File{path: path, secrets: make(map[string][]byte)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		f.salt = make([]byte, 16)
+		if _, err := rand.Read(f.salt); err != nil {
+			return nil, err
+		}
+		if f.aead, err = newAEAD(passphrase, f.salt); err != nil {
+			return nil, err
+		}
+		return f, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read secret file: %w", err)
+	}
+
+	var content fileContent
+	if err := json.Unmarshal(data, &content); err != nil {
+		return nil, fmt.Errorf("could not parse secret file: %w", err)
+	}
+
+	f.salt = content.Salt
+	if f.aead, err = newAEAD(passphrase, f.salt); err != nil {
+		return nil, err
+	}
+
+	plaintext, err := f.aead.Open(nil, content.Nonce, content.Ciphertext, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if err := json.Unmarshal(plaintext, &f.secrets); err != nil {
+		return nil, fmt.Errorf("could not parse secrets: %w", err)
+	}
+	return f, nil
+}
+
+func newAEAD(passphrase, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, keyLength)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// save encrypts the secrets with a new nonce and replaces the file. The caller holds mu.
+func (f *File) save() error {
+	plaintext, err := json.Marshal(f.secrets)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, f.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(fileContent{
+		Version:    1,
+		Salt:       f.salt,
+		Nonce:      nonce,
+		Ciphertext: f.aead.Seal(nil, nonce, plaintext, nil),
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0700); err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+func (f *File) Get(key string) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	value, ok := f.secrets[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (f *File) Set(key string, value []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.secrets[key] = append([]byte(nil), value...)
+	return f.save()
+}
+
+func (f *File) Delete(key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.secrets[key]; !ok {
+		return nil
+	}
+	delete(f.secrets, key)
+	return f.save()
+}
@@ -0,0 +1,198 @@
+//go:build linux
+
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+)
+
+// Secret Service API names, see https://specifications.freedesktop.org/secret-service/
+const (
+	ssDest       = "org.freedesktop.secrets"
+	ssPath       = dbus.ObjectPath("/org/freedesktop/secrets")
+	ssCollection = dbus.ObjectPath("/org/freedesktop/secrets/aliases/default")
+
+	ssServiceIface    = "org.freedesktop.Secret.Service"
+	ssCollectionIface = "org.freedesktop.Secret.Collection"
+	ssItemIface       = "org.freedesktop.Secret.Item"
+	ssPromptIface     = "org.freedesktop.Secret.Prompt"
+
+	// promptTimeout bounds how long an unlock prompt may stay open
+	promptTimeout = 2 * time.Minute
+)
+
+var errPromptDismissed = errors.New("keyring prompt dismissed")
+
+// ssSecret is the Secret struct of the API
+type ssSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// keyring stores secrets in the Secret Service over D-Bus (GNOME Keyring, KWallet, ...)
+// as items of the default collection, found by their service and key attributes
+type keyring struct {
+	mu      sync.Mutex
+	conn    *dbus.Conn
+	session dbus.ObjectPath
+	service string
+}
+
+func newKeyring(service string) (Store, error) {
+	conn, err := dbus.ConnectSessionBus()
+	if err != nil {
+		return nil, err
+	}
+
+	// Secrets travel unencrypted over the session bus, which only the user can reach
+	var output dbus.Variant
+	var session dbus.ObjectPath
+	err = conn.Object(ssDest, ssPath).Call(ssServiceIface+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &session)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("could not open secret service session: %w", err)
+	}
+
+	return &keyring{conn: conn, session: session, service: service}, nil
+}
+
+func (k *keyring) attributes(key string) map[string]string {
+	return map[string]string{"service": k.service, "key": key}
+}
+
+// search returns the unlocked items of key, unlocking locked ones
+func (k *keyring) search(key string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	err := k.conn.Object(ssDest, ssPath).Call(ssServiceIface+".SearchItems", 0, k.attributes(key)).Store(&unlocked, &locked)
+	if err != nil {
+		return nil, err
+	}
+	if len(locked) > 0 {
+		if err := k.unlock(locked); err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, locked...)
+	}
+	return unlocked, nil
+}
+
+func (k *keyring) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := k.conn.Object(ssDest, ssPath).Call(ssServiceIface+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return err
+	}
+	return k.prompt(prompt)
+}
+
+// prompt shows a prompt of the keyring, e.g. for the keyring password, and waits for
+// the user to complete it. "/" means no prompt is needed.
+func (k *keyring) prompt(prompt dbus.ObjectPath) error {
+	if prompt == "/" || prompt == "" {
+		return nil
+	}
+
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(prompt),
+		dbus.WithMatchInterface(ssPromptIface),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := k.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer k.conn.RemoveMatchSignal(match...)
+
+	signals := make(chan *dbus.Signal, 4)
+	k.conn.Signal(signals)
+	defer k.conn.RemoveSignal(signals)
+
+	if err := k.conn.Object(ssDest, prompt).Call(ssPromptIface+".Prompt", 0, "").Err; err != nil {
+		return err
+	}
+
+	timeout := time.After(promptTimeout)
+	for {
+		select {
+		case signal := <-signals:
+			if signal.Path != prompt || signal.Name != ssPromptIface+".Completed" {
+				continue
+			}
+			if len(signal.Body) > 0 {
+				if dismissed, ok := signal.Body[0].(bool); ok && dismissed {
+					return errPromptDismissed
+				}
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("keyring prompt timed out")
+		}
+	}
+}
+
+func (k *keyring) Get(key string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	items, err := k.search(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNotFound
+	}
+
+	var secret ssSecret
+	if err := k.conn.Object(ssDest, items[0]).Call(ssItemIface+".GetSecret", 0, k.session).Store(&secret); err != nil {
+		return nil, err
+	}
+	return secret.Value, nil
+}
+
+func (k *keyring) Set(key string, value []byte) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if err := k.unlock([]dbus.ObjectPath{ssCollection}); err != nil {
+		return err
+	}
+
+	properties := map[string]dbus.Variant{
+		ssItemIface + ".Label":      dbus.MakeVariant(k.service + " " + key),
+		ssItemIface + ".Attributes": dbus.MakeVariant(k.attributes(key)),
+	}
+	secret := ssSecret{Session: k.session, Value: value, ContentType: "application/octet-stream"}
+
+	var item, prompt dbus.ObjectPath
+	err := k.conn.Object(ssDest, ssCollection).Call(ssCollectionIface+".CreateItem", 0, properties, secret, true).Store(&item, &prompt)
+	if err != nil {
+		return err
+	}
+	return k.prompt(prompt)
+}
+
+func (k *keyring) Delete(key string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	items, err := k.search(key)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := k.conn.Object(ssDest, item).Call(ssItemIface+".Delete", 0).Store(&prompt); err != nil {
+			return err
+		}
+		if err := k.prompt(prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package secrets
+
+import "errors"
+
+// newKeyring has no OS keyring to use outside Linux yet, the encrypted file is used
+func newKeyring(service string) (Store, error) {
+	return nil, errors.New("no OS keyring support on this platform")
+}
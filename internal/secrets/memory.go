@@ -0,0 +1,40 @@
+package secrets
+
+import "sync"
+
+// Memory keeps secrets for the lifetime of the process, for tests and as a last resort
+type Memory struct {
+	mu      sync.Mutex
+	secrets map[string][]byte
+}
+
+func NewMemory() *Memory {
+	return &Memory{secrets: make(map[string][]byte)}
+}
+
+func (m *Memory) Get(key string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	value, ok := m.secrets[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), value...), nil
+}
+
+func (m *Memory) Set(key string, value []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.secrets[key] = append([]byte(nil), value...)
+	return nil
+}
+
+func (m *Memory) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.secrets, key)
+	return nil
+}
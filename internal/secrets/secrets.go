@@ -0,0 +1,136 @@
+// Package secrets keeps the session secrets of the app: cookies, device tokens and the
+// logged in user. They go to the OS keyring when there is one, otherwise to a file
+// encrypted with a random key kept next to it, or with a passphrase when one is set.
+package secrets
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// service names the app's entries in the keyring
+const service = "acc-homework"
+
+// ErrNotFound is returned by Get for keys without a secret
+var ErrNotFound = errors.New("secret not found")
+
+// Store holds secrets by key
+type Store interface {
+	// Get returns the secret of key or ErrNotFound
+	Get(key string) ([]byte, error)
+	// Set stores the secret of key, replacing any previous one
+	Set(key string, value []byte) error
+	// Delete removes the secret of key, deleting a missing key succeeds
+	Delete(key string) error
+}
+
+var (
+	defaultMu    sync.Mutex
+	defaultStore Store
+)
+
+// Default returns the store of the app, chosen on first use. UNIPILOT_SECRET_STORE
+// forces a backend: "keyring", "file" or "memory".
+func Default() Store {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+
+	if defaultStore == nil {
+		defaultStore = open(os.Getenv("UNIPILOT_SECRET_STORE"))
+	}
+	return defaultStore
+}
+
+// SetDefault replaces the store of the app, e.g. with a memory store in tests
+func SetDefault(store Store) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultStore = store
+}
+
+func open(backend string) Store {
+	if backend == "memory" {
+		return NewMemory()
+	}
+
+	if backend == "" || backend == "keyring" {
+		store, err := newKeyring(service)
+		if err == nil {
+			return store
+		}
+		log.Printf("[Secrets] OS keyring unavailable, using encrypted file: %v", err)
+	}
+
+	path, err := filePath()
+	if err == nil {
+		var store *File
+		if store, err = openFile(path); err == nil {
+			return store
+		}
+	}
+	log.Printf("[Secrets] Failed to open secret file, secrets won't outlive the app: %v", err)
+	return NewMemory()
+}
+
+func filePath() (string, error) {
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(configDir, service, "secrets.enc"), nil
+}
+
+// keyFileName is the random key of the secret file, next to it and readable only by
+// the user. The file is then as safe as the user's home directory, not more: use a
+// passphrase or the keyring to protect it from other programs of the user.
+const keyFileName = "secrets.key"
+
+// openFile opens the secret file at path with UNIPILOT_SECRET_PASSPHRASE if set,
+// otherwise with the key file, created on first use
+func openFile(path string) (*File, error) {
+	if p := os.Getenv("UNIPILOT_SECRET_PASSPHRASE"); p != "" {
+		return NewFile(path, []byte(p))
+	}
+
+	keyPath := filepath.Join(filepath.Dir(path), keyFileName)
+	key, err := os.ReadFile(keyPath)
+	if err == nil {
+		return NewFile(path, key)
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("could not read secret key: %w", err)
+	}
+
+	// A secret file whose key is gone can't be read again. It only holds the session,
+	// the user logs in again.
+	if _, err := os.Stat(path); err == nil {
+		log.Printf("[Secrets] Secret file without its key, starting a new one")
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("could not remove unreadable secret file: %w", err)
+		}
+	}
+
+	key = make([]byte, keyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	store, err := NewFile(path, key)
+	if err != nil {
+		return nil, err
+	}
+
+	// The key is only written once the file opens with it, a key that can't decrypt
+	// the file would send every later launch to the memory store
+	if err := os.MkdirAll(filepath.Dir(keyPath), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyPath, key, 0600); err != nil {
+		return nil, fmt.Errorf("could not write secret key: %w", err)
+	}
+	return store, nil
+}
@@ -0,0 +1,153 @@
+package secrets
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testStore(t *testing.T, store Store) {
+	t.Helper()
+
+	if _, err := store.Get("tokens"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() on empty store error = %v, want ErrNotFound", err)
+	}
+
+	if err := store.Set("tokens", []byte("first")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("tokens", []byte("second")); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.Get("tokens")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "second" {
+		t.Fatalf("Get() = %q, want %q", got, "second")
+	}
+
+	if err := store.Delete("tokens"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("tokens"); err != nil {
+		t.Fatalf("Delete() of a missing key error = %v", err)
+	}
+	if _, err := store.Get("tokens"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() after Delete() error = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	testStore(t, NewMemory())
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "secrets", "secrets.enc")
+
+	store, err := NewFile(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store)
+
+	if err := store.Set("cookies", []byte("session-auth=abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("session-auth")) {
+		t.Fatal("secret file contains the plaintext secret")
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("secret file mode = %v, want 0600", perm)
+	}
+
+	reopened, err := NewFile(path, []byte("passphrase"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := reopened.Get("cookies")
+	if err != nil || string(got) != "session-auth=abc" {
+		t.Fatalf("Get() after reopen = %q, %v", got, err)
+	}
+
+	if _, err := NewFile(path, []byte("other")); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("NewFile() with another passphrase error = %v, want ErrWrongPassphrase", err)
+	}
+}
+
+func TestOpenFileCreatesKey(t *testing.T) {
+	t.Setenv("UNIPILOT_SECRET_PASSPHRASE", "")
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+
+	store, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Set("tokens", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(filepath.Dir(path), keyFileName)
+	info, err := os.Stat(keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Fatalf("key file mode = %v, want 0600", perm)
+	}
+	if _, err := NewFile(path, []byte(service)); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("file opens without the key, error = %v", err)
+	}
+
+	reopened, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get("tokens"); err != nil || string(got) != "abc" {
+		t.Fatalf("Get() after reopen = %q, %v", got, err)
+	}
+}
+
+func TestOpenFileReplacesFileWithoutKey(t *testing.T) {
+	t.Setenv("UNIPILOT_SECRET_PASSPHRASE", "")
+	path := filepath.Join(t.TempDir(), "secrets.enc")
+
+	orphan, err := NewFile(path, []byte("lost key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := orphan.Set("tokens", []byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Get("tokens"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get() on the new file error = %v, want ErrNotFound", err)
+	}
+	if err := store.Set("tokens", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key written opens the new file on the next launch
+	reopened, err := openFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := reopened.Get("tokens"); err != nil || string(got) != "new" {
+		t.Fatalf("Get() after reopen = %q, %v", got, err)
+	}
+}
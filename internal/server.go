@@ -1,9 +1,18 @@
 package main
 
 import (
+	"log"
+	"os"
+
+	"unipilot/internal/config"
 	"unipilot/internal/server"
 )
 
 func main() {
-	server.StartServer()
+	cfg, err := config.LoadServer(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Fatal(server.StartServer(cfg))
 }
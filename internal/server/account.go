@@ -0,0 +1,289 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"unipilot/internal/models/user"
+	"unipilot/internal/services/mailer"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// Lifetimes of the tokens sent by email
+const (
+	verificationTokenTTL  = 48 * time.Hour
+	passwordResetTokenTTL = time.Hour
+
+	minPasswordLength = 8
+)
+
+var (
+	// accountMailer sends the verification and reset emails, set at boot
+	accountMailer mailer.Mailer
+	// accountLinkBase is the public URL of the routes, empty when emails carry only tokens
+	accountLinkBase string
+)
+
+// sendMail delivers a message in the background, so how long a request takes doesn't
+// tell whether an email was sent
+func sendMail(msg mailer.Message) {
+	if accountMailer == nil {
+		PrintLog(fmt.Sprintf("No mailer configured, dropping %q to %s", msg.Subject, msg.To))
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		if err := accountMailer.Send(ctx, msg); err != nil {
+			PrintLog(fmt.Sprintf("Failed to send %q to %s: %v", msg.Subject, msg.To, err))
+		}
+	}()
+}
+
+// sendVerification replaces the pending verification tokens of the user with a new
+// one and emails it to their address
+func sendVerification(db *gorm.DB, u *user.User) error {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ? AND used_at IS NULL", u.ID).Delete(&user.EmailVerificationToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&user.EmailVerificationToken{
+			UserID:    u.ID,
+			Email:     u.Email,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(verificationTokenTTL),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create verification token: %w", err)
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\nPlease confirm your email address", u.Username)
+	if accountLinkBase != "" {
+		body += fmt.Sprintf(" by opening this link:\n\n%s/verify/confirm?token=%s\n", accountLinkBase, url.QueryEscape(token))
+	} else {
+		body += fmt.Sprintf(" with this code:\n\n%s\n", token)
+	}
+	body += fmt.Sprintf("\nThe link expires in %d hours.\n", int(verificationTokenTTL.Hours()))
+
+	sendMail(mailer.Message{To: u.Email, Subject: "Confirm your email address", Body: body})
+	return nil
+}
+
+// RequestVerificationHandler sends a new verification email to the session user
+func RequestVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	var u user.User
+	if err := db.First(&u, userID).Error; err != nil {
+		PrintERROR(w, http.StatusNotFound, "User not found")
+		return
+	}
+
+	message := "Verification email sent"
+	if u.IsVerified {
+		message = "Email already verified"
+	} else if err := sendVerification(db, &u); err != nil {
+		PrintERROR(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+	})
+}
+
+// ConfirmVerificationHandler verifies the address a verification token was sent to.
+// It answers GET too, so the link in the email works from a browser.
+func ConfirmVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		PrintERROR(w, http.StatusBadRequest, "Token required")
+		return
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var t user.EmailVerificationToken
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(token), time.Now()).First(&t).Error; err != nil {
+			return err
+		}
+
+		// The user changed their address since, the token verifies the old one
+		result := tx.Model(&user.User{}).Where("id = ? AND email = ?", t.UserID, t.Email).Update("is_verified", true)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return gorm.ErrRecordNotFound
+		}
+
+		return tx.Model(&t).Update("used_at", time.Now()).Error
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		PrintERROR(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to verify email: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email verified successfully",
+	})
+}
+
+// RequestPasswordResetHandler emails a reset token to the address if a user has it.
+// The answer is the same either way so it can't be used to find accounts.
+func RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || strings.TrimSpace(body.Email) == "" {
+		PrintERROR(w, http.StatusBadRequest, "Email required")
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	var u user.User
+	err := db.Where("email = ?", strings.TrimSpace(body.Email)).First(&u).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to look up user: %v", err))
+		return
+	}
+
+	if err == nil {
+		token, hash, err := newOpaqueToken()
+		if err != nil {
+			PrintERROR(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if err := db.Create(&user.PasswordResetToken{
+			UserID:    u.ID,
+			TokenHash: hash,
+			ExpiresAt: time.Now().Add(passwordResetTokenTTL),
+		}).Error; err != nil {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create reset token: %v", err))
+			return
+		}
+
+		sendMail(mailer.Message{
+			To:      u.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to reset the password of your account. "+
+				"If it was you, enter this code in the app to choose a new password:\n\n%s\n\n"+
+				"The code expires in %d minutes. If you didn't ask for it, ignore this email.\n",
+				u.Username, token, int(passwordResetTokenTTL.Minutes())),
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account uses this email, a reset code was sent to it",
+	})
+}
+
+// ConfirmPasswordResetHandler sets a new password with a reset token. Every device
+// is logged out, and the address counts as verified since the token arrived there.
+func ConfirmPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Token == "" {
+		PrintERROR(w, http.StatusBadRequest, "Token and password required")
+		return
+	}
+	if len(body.Password) < minPasswordLength {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Password must have at least %d characters", minPasswordLength))
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.Password), bcrypt.DefaultCost)
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, "Could not process password")
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var t user.PasswordResetToken
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(body.Token), time.Now()).First(&t).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Model(&user.User{}).Where("id = ?", t.UserID).Updates(map[string]interface{}{
+			"password_hash": string(hashedPassword),
+			"is_verified":   true,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Other codes sent before this one stop working too
+		if err := tx.Model(&user.PasswordResetToken{}).Where("user_id = ? AND used_at IS NULL", t.UserID).
+			Update("used_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		// Ends the tokens and the cookies of every device
+		return tokenAuth.revokeAll(tx, t.UserID)
+	})
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		PrintERROR(w, http.StatusBadRequest, "Invalid or expired token")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to reset password: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password reset successfully",
+	})
+}
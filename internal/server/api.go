@@ -0,0 +1,698 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// APIPrefix is where the versioned REST API is mounted, under the config path prefix
+const APIPrefix = "/api/v1"
+
+// Page sizes of list endpoints
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 200
+)
+
+// APIError is the body of every error returned by the REST API
+type APIError struct {
+	Status   int                   `json:"status"`
+	Code     string                `json:"code"`
+	Message  string                `json:"message"`
+	Conflict *models.FieldConflict `json:"conflict,omitempty"`
+}
+
+// Error makes an APIError usable as an error inside transactions
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Error codes of APIError
+const (
+	ErrCodeInvalidBody  = "invalid_body"
+	ErrCodeInvalidField = "invalid_field"
+	ErrCodeUnauthorized = "unauthorized"
+	ErrCodeNotFound     = "not_found"
+	ErrCodeConflict     = "conflict"
+	ErrCodeInternal     = "internal"
+)
+
+// Page describes the slice of a list returned by a list endpoint
+type Page struct {
+	Limit   int   `json:"limit"`
+	Offset  int   `json:"offset"`
+	Total   int64 `json:"total"`
+	HasMore bool  `json:"has_more"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// PrintAPIError logs an error like PrintERROR and answers with a JSON error body
+func PrintAPIError(w http.ResponseWriter, status int, code, message string) {
+	writeAPIError(w, &APIError{Status: status, Code: code, Message: message})
+}
+
+func writeAPIError(w http.ResponseWriter, apiErr *APIError) {
+	PrintLog(fmt.Sprintf("[API] [%d] %s: %s", apiErr.Status, apiErr.Code, apiErr.Message))
+	writeJSON(w, apiErr.Status, map[string]interface{}{"error": apiErr})
+}
+
+// APIAuthMiddleware is AuthMiddleware answering with a JSON error
+func APIAuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID, sessionID, ok := requestAuth(r)
+		if !ok {
+			PrintAPIError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "Unauthorized - please login")
+			return
+		}
+		next(w, r.WithContext(withAuth(r.Context(), userID, sessionID)))
+	}
+}
+
+// apiContext returns the database and user set by DBMiddleware and APIAuthMiddleware
+func apiContext(w http.ResponseWriter, r *http.Request) (*gorm.DB, uint, bool) {
+	db, ok := r.Context().Value("db").(*gorm.DB)
+	if !ok {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, "Database connection not found")
+		return nil, 0, false
+	}
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintAPIError(w, http.StatusUnauthorized, ErrCodeUnauthorized, "User ID not found in context")
+		return nil, 0, false
+	}
+	return db, userID, true
+}
+
+// pathID reads the {id} segment of a resource route
+func pathID(r *http.Request) (uint, error) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 32)
+	if err != nil || id == 0 {
+		return 0, fmt.Errorf("invalid id %q", r.PathValue("id"))
+	}
+	return uint(id), nil
+}
+
+// parsePage reads the limit and offset query parameters
+func parsePage(r *http.Request) (Page, error) {
+	page := Page{Limit: defaultPageLimit}
+	query := r.URL.Query()
+
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		page.Limit = limit
+	}
+
+	if v := query.Get("offset"); v != "" {
+		offset, err := strconv.Atoi(v)
+		if err != nil || offset < 0 {
+			return page, errors.New("offset must be a positive number")
+		}
+		page.Offset = offset
+	}
+
+	return page, nil
+}
+
+// fieldKind is the type a field value is checked and converted to
+type fieldKind int
+
+const (
+	fieldString fieldKind = iota
+	fieldDate
+	fieldBool
+	fieldInt
+)
+
+// apiField maps a JSON field of a resource to its column
+type apiField struct {
+	Column   string
+	Kind     fieldKind
+	Required bool // must be given on create and can't be removed
+	ReadOnly bool // only accepted on create
+}
+
+// writableColumn reports whether column can be changed after create. The legacy update
+// endpoints take a column name from the client, only the columns the API exposes are
+// accepted so ownership and bookkeeping columns can't be written.
+func writableColumn(fields map[string]apiField, column string) bool {
+	for _, field := range fields {
+		if field.Column == column && !field.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// loadLegacy reads the record a legacy update or delete route addresses into dest.
+// Current clients send its server ID in remote_id, checked through authz like on the
+// v1 routes. Baseline clients only know their local ID, which reaches the records they
+// created. IDs that aren't numbers match nothing.
+func loadLegacy(r *http.Request, db *gorm.DB, userID uint, entity models.Entity, remoteID, localID string, action authz.Action, dest interface{}) error {
+	if remoteID != "" {
+		id, err := strconv.ParseUint(remoteID, 10, 0)
+		if err != nil {
+			return authz.ErrNotFound
+		}
+		return authz.Load(db, userID, entity, uint(id), action, dest)
+	}
+
+	id, err := strconv.ParseUint(localID, 10, 0)
+	if err != nil {
+		return authz.ErrNotFound
+	}
+	return authz.LoadByLocalID(db, userID, r.Header.Get(DeviceHeader), uint(id), dest)
+}
+
+// apiValues holds checked field values by column
+type apiValues map[string]interface{}
+
+func (v apiValues) String(column string) string {
+	s, _ := v[column].(string)
+	return s
+}
+
+func (v apiValues) Time(column string) time.Time {
+	t, _ := v[column].(time.Time)
+	return t
+}
+
+func (v apiValues) Bool(column string) bool {
+	b, _ := v[column].(bool)
+	return b
+}
+
+func (v apiValues) Int(column string) int64 {
+	i, _ := v[column].(int64)
+	return i
+}
+
+// convert checks a JSON value against the field kind. It returns the value to store
+// and its string form, the form the conflict checks compare.
+func (f apiField) convert(name string, raw json.RawMessage) (interface{}, string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return f.zero(), "", nil
+	}
+
+	// Clients may send any value as a string, like the legacy update endpoints take it
+	var text string
+	if err := json.Unmarshal(raw, &text); err != nil {
+		text = string(raw)
+	}
+
+	switch f.Kind {
+	case fieldDate:
+		t, err := time.Parse(time.DateOnly, text)
+		if err != nil {
+			if t, err = time.Parse(time.RFC3339, text); err != nil {
+				return nil, "", fmt.Errorf("%s must be a date (YYYY-MM-DD)", name)
+			}
+		}
+		return t, t.Format(time.DateOnly), nil
+	case fieldBool:
+		b, err := strconv.ParseBool(text)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s must be a boolean", name)
+		}
+		return b, strconv.FormatBool(b), nil
+	case fieldInt:
+		i, err := strconv.ParseInt(text, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s must be an integer", name)
+		}
+		return i, strconv.FormatInt(i, 10), nil
+	}
+	return text, text, nil
+}
+
+func (f apiField) zero() interface{} {
+	switch f.Kind {
+	case fieldDate:
+		return time.Time{}
+	case fieldBool:
+		return false
+	case fieldInt:
+		return int64(0)
+	}
+	return ""
+}
+
+// decodeCreate reads the JSON object of a create request. Unknown fields are rejected
+// so typos don't silently drop values.
+func decodeCreate(r *http.Request, fields map[string]apiField) (apiValues, uint, *APIError) {
+	var body map[string]json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, 0, &APIError{Status: http.StatusBadRequest, Code: ErrCodeInvalidBody, Message: fmt.Sprintf("Invalid request body: %v", err)}
+	}
+
+	invalid := func(format string, args ...interface{}) (apiValues, uint, *APIError) {
+		return nil, 0, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeInvalidField, Message: fmt.Sprintf(format, args...)}
+	}
+
+	// The client's own ID for the row, a retried create returns the row created first
+	var localID uint
+	if raw, ok := body["local_id"]; ok {
+		id, _, err := apiField{Kind: fieldInt}.convert("local_id", raw)
+		if err != nil || id.(int64) < 0 {
+			return invalid("local_id must be a positive integer")
+		}
+		localID = uint(id.(int64))
+		delete(body, "local_id")
+	}
+	if localID == 0 {
+		return invalid("local_id is required")
+	}
+
+	values := apiValues{}
+	for name, raw := range body {
+		field, ok := fields[name]
+		if !ok {
+			return invalid("unknown field %s", name)
+		}
+		value, text, err := field.convert(name, raw)
+		if err != nil {
+			return invalid("%s", err)
+		}
+		if field.Required && text == "" {
+			return invalid("%s is required", name)
+		}
+		values[field.Column] = value
+	}
+
+	for name, field := range fields {
+		if _, ok := values[field.Column]; field.Required && !ok {
+			return invalid("%s is required", name)
+		}
+	}
+
+	return values, localID, nil
+}
+
+// PatchOperation is one operation of a JSON Patch (RFC 6902) document. Paths name a
+// top-level field, e.g. /title. A test operation before a replace of the same path
+// gives the value the client based its change on: the change is only rejected with
+// a conflict if the server value has moved away from it since.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// fieldChange is a checked change of one column
+type fieldChange struct {
+	column string
+	value  interface{}
+	text   string
+	base   *string
+}
+
+// decodePatch reads a JSON Patch document into column changes and tests
+func decodePatch(r *http.Request, fields map[string]apiField) ([]fieldChange, map[string]string, *APIError) {
+	var ops []PatchOperation
+	if err := json.NewDecoder(r.Body).Decode(&ops); err != nil {
+		return nil, nil, &APIError{Status: http.StatusBadRequest, Code: ErrCodeInvalidBody, Message: fmt.Sprintf("Invalid JSON Patch document: %v", err)}
+	}
+	if len(ops) == 0 {
+		return nil, nil, &APIError{Status: http.StatusBadRequest, Code: ErrCodeInvalidBody, Message: "Empty JSON Patch document"}
+	}
+
+	invalid := func(format string, args ...interface{}) ([]fieldChange, map[string]string, *APIError) {
+		return nil, nil, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeInvalidField, Message: fmt.Sprintf(format, args...)}
+	}
+
+	var changes []fieldChange
+	tests := map[string]string{} // column -> tested value not yet used as a base
+	changed := map[string]int{}  // column -> index in changes
+
+	for _, op := range ops {
+		name := strings.TrimPrefix(op.Path, "/")
+		name = strings.ReplaceAll(strings.ReplaceAll(name, "~1", "/"), "~0", "~")
+		field, ok := fields[name]
+		if !ok || !strings.HasPrefix(op.Path, "/") {
+			return invalid("unsupported path %q", op.Path)
+		}
+		if field.ReadOnly {
+			return invalid("%s can't be changed", name)
+		}
+
+		switch op.Op {
+		case "test":
+			_, text, err := field.convert(name, op.Value)
+			if err != nil {
+				return invalid("%s", err)
+			}
+			tests[field.Column] = text
+
+		case "add", "replace", "remove":
+			value, text := field.zero(), ""
+			if op.Op != "remove" {
+				var err error
+				if value, text, err = field.convert(name, op.Value); err != nil {
+					return invalid("%s", err)
+				}
+			}
+			if field.Required && text == "" {
+				return invalid("%s can't be removed", name)
+			}
+
+			change := fieldChange{column: field.Column, value: value, text: text}
+			if base, ok := tests[field.Column]; ok {
+				change.base = &base
+				delete(tests, field.Column)
+			}
+			if i, ok := changed[field.Column]; ok {
+				if change.base == nil {
+					change.base = changes[i].base
+				}
+				changes[i] = change
+			} else {
+				changed[field.Column] = len(changes)
+				changes = append(changes, change)
+			}
+
+		default:
+			return invalid("unsupported operation %q", op.Op)
+		}
+	}
+
+	return changes, tests, nil
+}
+
+// applyPatch checks the tests and bases of a patch against the row and writes the changes
+func applyPatch(tx *gorm.DB, entity models.Entity, table string, id uint, changes []fieldChange, tests map[string]string) *APIError {
+	for column, expected := range tests {
+		current, err := models.ColumnValue(tx, table, id, column)
+		if err != nil {
+			return &APIError{Status: http.StatusInternalServerError, Code: ErrCodeInternal, Message: err.Error()}
+		}
+		if !models.SameValue(current, expected) {
+			return &APIError{
+				Status:   http.StatusConflict,
+				Code:     ErrCodeConflict,
+				Message:  fmt.Sprintf("Test of %s failed", column),
+				Conflict: &models.FieldConflict{Entity: entity, EntityID: strconv.Itoa(int(id)), Column: column, Base: expected, Theirs: current},
+			}
+		}
+	}
+
+	updates := map[string]interface{}{}
+	for _, change := range changes {
+		conflict, err := checkBase(tx, entity, table, id, change.column, change.base, change.text)
+		if err != nil {
+			return &APIError{Status: http.StatusInternalServerError, Code: ErrCodeInternal, Message: err.Error()}
+		}
+		if conflict != nil {
+			conflict.EntityID = strconv.Itoa(int(id))
+			return &APIError{Status: http.StatusConflict, Code: ErrCodeConflict, Message: "Field was changed on the server", Conflict: conflict}
+		}
+		updates[change.column] = change.value
+	}
+
+	updates["updated_at"] = time.Now()
+	if err := tx.Table(table).Where("id = ?", id).Updates(updates).Error; err != nil {
+		return &APIError{Status: http.StatusInternalServerError, Code: ErrCodeInternal, Message: fmt.Sprintf("Error updating %s: %v", entity, err)}
+	}
+	return nil
+}
+
+// apiResource serves the REST routes of one entity. Every route only reaches rows the
+// session user owns or that are shared with them, see authz.
+type apiResource[T any] struct {
+	entity models.Entity
+	table  string
+	fields map[string]apiField
+
+	// filter narrows a list by query parameters
+	filter func(db *gorm.DB, r *http.Request, userID uint) (*gorm.DB, error)
+	// build returns a new row from the values of a create request, localID is the ID
+	// the device sending it gave the row
+	build func(tx *gorm.DB, userID, localID uint, deviceID string, values apiValues) (*T, error)
+	// remove soft-deletes a row with the rows depending on it
+	remove func(tx *gorm.DB, item *T) ([]deletedRow, error)
+	row    func(db *gorm.DB, item *T) map[string]string
+	label  func(item *T) string
+	// created runs after a create, e.g. to update storage totals
+	created func(db *gorm.DB, userID uint)
+}
+
+// mount registers the resource routes under path, e.g. /assignments
+func (res *apiResource[T]) mount(handle func(method, path string, handler http.HandlerFunc), path string) {
+	handle(http.MethodGet, path, res.list)
+	handle(http.MethodPost, path, res.create)
+	handle(http.MethodGet, path+"/{id}", res.get)
+	handle(http.MethodPatch, path+"/{id}", res.patch)
+	handle(http.MethodDelete, path+"/{id}", res.delete)
+}
+
+// load returns a row the user may act on, nil without error when there is none
+func (res *apiResource[T]) load(db *gorm.DB, userID, id uint, action authz.Action) (*T, error) {
+	item := new(T)
+	err := authz.Load(db, userID, res.entity, id, action, item)
+	if errors.Is(err, authz.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (res *apiResource[T]) notFound(w http.ResponseWriter) {
+	PrintAPIError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("%s not found", res.entity))
+}
+
+func (res *apiResource[T]) list(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		PrintAPIError(w, http.StatusBadRequest, ErrCodeInvalidField, err.Error())
+		return
+	}
+
+	query := db.Model(new(T)).Scopes(authz.Scope(userID, res.entity, authz.Read))
+	if res.filter != nil {
+		if query, err = res.filter(query, r, userID); err != nil {
+			PrintAPIError(w, http.StatusBadRequest, ErrCodeInvalidField, err.Error())
+			return
+		}
+	}
+
+	query = query.Session(&gorm.Session{})
+
+	if err := query.Count(&page.Total).Error; err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error counting %ss: %v", res.entity, err))
+		return
+	}
+
+	var items []T
+	if err := query.Order("id").Limit(page.Limit).Offset(page.Offset).Find(&items).Error; err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error listing %ss: %v", res.entity, err))
+		return
+	}
+	page.HasMore = int64(page.Offset+len(items)) < page.Total
+
+	rows := make([]map[string]string, 0, len(items))
+	for i := range items {
+		rows = append(rows, res.row(db, &items[i]))
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"data":       rows,
+		"pagination": page,
+	})
+}
+
+func (res *apiResource[T]) get(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := pathID(r)
+	if err != nil {
+		res.notFound(w)
+		return
+	}
+
+	item, err := res.load(db, userID, id, authz.Read)
+	if err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error getting %s %d: %v", res.entity, id, err))
+		return
+	}
+	if item == nil {
+		res.notFound(w)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": res.row(db, item)})
+}
+
+func (res *apiResource[T]) create(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	values, localID, apiErr := decodeCreate(r, res.fields)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// Clients retry until they get an answer, a row this device already created is
+	// returned as is. Requests without a device ID can't be told apart and aren't deduplicated.
+	deviceID := r.Header.Get(DeviceHeader)
+	if deviceID != "" {
+		existing := new(T)
+		err := authz.LoadByLocalID(db, userID, deviceID, localID, existing)
+		if err == nil {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"data": res.row(db, existing)})
+			return
+		}
+		if !errors.Is(err, authz.ErrNotFound) {
+			PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error looking up %s: %v", res.entity, err))
+			return
+		}
+	}
+
+	var item *T
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if item, err = res.build(tx, userID, localID, deviceID, values); err != nil {
+			return err
+		}
+		return tx.Create(item).Error
+	})
+	var invalid *APIError
+	if errors.As(err, &invalid) {
+		writeAPIError(w, invalid)
+		return
+	}
+	if err != nil {
+		PrintAPIError(w, http.StatusConflict, ErrCodeConflict, fmt.Sprintf("Error creating %s: %v", res.entity, err))
+		return
+	}
+
+	if res.created != nil {
+		res.created(db, userID)
+	}
+
+	row := res.row(db, item)
+	notifyChange(r, userID, models.OperationCreate, res.entity, row, fmt.Sprintf("%s added", res.label(item)))
+
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"data": row})
+}
+
+func (res *apiResource[T]) patch(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := pathID(r)
+	if err != nil {
+		res.notFound(w)
+		return
+	}
+
+	changes, tests, apiErr := decodePatch(r, res.fields)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	var item *T
+	err = db.Transaction(func(tx *gorm.DB) error {
+		found, err := res.load(tx, userID, id, authz.Write)
+		if err != nil {
+			return err
+		}
+		if found == nil {
+			return &APIError{Status: http.StatusNotFound, Code: ErrCodeNotFound, Message: fmt.Sprintf("%s not found", res.entity)}
+		}
+
+		if apiErr := applyPatch(tx, res.entity, res.table, id, changes, tests); apiErr != nil {
+			return apiErr
+		}
+
+		item, err = res.load(tx, userID, id, authz.Write)
+		return err
+	})
+	var failed *APIError
+	if errors.As(err, &failed) {
+		writeAPIError(w, failed)
+		return
+	}
+	if err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error updating %s %d: %v", res.entity, id, err))
+		return
+	}
+
+	// The owner's devices keep the row, also when a grantee changed it
+	row := res.row(db, item)
+	notifyChange(r, rowOwner(row, userID), models.OperationUpdate, res.entity, row, fmt.Sprintf("%s updated", res.label(item)))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": row})
+}
+
+func (res *apiResource[T]) delete(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := pathID(r)
+	if err != nil {
+		res.notFound(w)
+		return
+	}
+
+	item, err := res.load(db, userID, id, authz.Delete)
+	if err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error getting %s %d: %v", res.entity, id, err))
+		return
+	}
+	if item == nil {
+		res.notFound(w)
+		return
+	}
+
+	var deleted []deletedRow
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		deleted, err = res.remove(tx, item)
+		return err
+	})
+	if err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error deleting %s %d: %v", res.entity, id, err))
+		return
+	}
+
+	refreshStorageInfo(db, userID, deleted)
+	notifyDeleted(r, userID, deleted)
+
+	w.WriteHeader(http.StatusNoContent)
+}
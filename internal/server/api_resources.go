@@ -0,0 +1,259 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+
+	"gorm.io/gorm"
+)
+
+// migrateDeviceIDs adds the device_id column creates are deduplicated by to the tables
+// the production database already has. Assignments had a unique local_id, local IDs
+// repeat across devices so it becomes a plain index.
+func migrateDeviceIDs(db *gorm.DB) error {
+	m := db.Migrator()
+	for _, model := range []interface{}{&assignment.Assignment{}, &course.Course{}, &note.Note{}} {
+		if !m.HasTable(model) {
+			continue
+		}
+		if !m.HasColumn(model, "DeviceID") {
+			if err := m.AddColumn(model, "DeviceID"); err != nil {
+				return err
+			}
+		}
+		if !m.HasIndex(model, "DeviceID") {
+			if err := m.CreateIndex(model, "DeviceID"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !m.HasTable(&assignment.Assignment{}) {
+		return nil
+	}
+	// Named by gorm, or by postgres for tables created by older gorm versions
+	for _, name := range []string{"uni_assignments_local_id", "assignments_local_id_key"} {
+		if m.HasConstraint(&assignment.Assignment{}, name) {
+			if err := m.DropConstraint(&assignment.Assignment{}, name); err != nil {
+				return err
+			}
+		}
+	}
+	if !m.HasIndex(&assignment.Assignment{}, "LocalID") {
+		return m.CreateIndex(&assignment.Assignment{}, "LocalID")
+	}
+	return nil
+}
+
+// columnFilter filters a list on columns equal to the query parameters of the same name
+func columnFilter(params ...string) func(db *gorm.DB, r *http.Request, userID uint) (*gorm.DB, error) {
+	return func(db *gorm.DB, r *http.Request, userID uint) (*gorm.DB, error) {
+		for _, param := range params {
+			if value := r.URL.Query().Get(param); value != "" {
+				db = db.Where(param+" = ?", value)
+			}
+		}
+		return db, nil
+	}
+}
+
+var assignmentResource = &apiResource[assignment.Assignment]{
+	entity: models.Assignment,
+	table:  "assignments",
+	fields: map[string]apiField{
+		"title":       {Column: "title", Required: true},
+		"todo":        {Column: "todo"},
+		"deadline":    {Column: "deadline", Kind: fieldDate, Required: true},
+		"link":        {Column: "link"},
+		"course_code": {Column: "course_code", Required: true},
+		"type":        {Column: "type_name", Required: true},
+		"status":      {Column: "status_name"},
+		"priority":    {Column: "priority"},
+		"completed":   {Column: "completed", Kind: fieldBool},
+		"notion_id":   {Column: "notion_id"},
+	},
+	filter: columnFilter("course_code"),
+	build: func(tx *gorm.DB, userID, localID uint, deviceID string, v apiValues) (*assignment.Assignment, error) {
+		a := &assignment.Assignment{
+			UserID:     userID,
+			LocalID:    localID,
+			DeviceID:   deviceID,
+			Title:      v.String("title"),
+			Todo:       v.String("todo"),
+			Deadline:   v.Time("deadline"),
+			Link:       v.String("link"),
+			CourseCode: v.String("course_code"),
+			TypeName:   v.String("type_name"),
+			StatusName: v.String("status_name"),
+			Priority:   v.String("priority"),
+			Completed:  v.Bool("completed"),
+			NotionID:   v.String("notion_id"),
+		}
+		return a, nil
+	},
+	remove: deleteAssignment,
+	row:    func(db *gorm.DB, a *assignment.Assignment) map[string]string { return assignmentRow(a) },
+	label:  func(a *assignment.Assignment) string { return a.Title },
+}
+
+var courseResource = &apiResource[course.Course]{
+	entity: models.EntityCourse,
+	table:  "courses",
+	fields: map[string]apiField{
+		"code":             {Column: "code", Required: true},
+		"name":             {Column: "name", Required: true},
+		"color":            {Column: "color"},
+		"duration":         {Column: "duration"},
+		"room_number":      {Column: "room_number"},
+		"start_date":       {Column: "start_date", Kind: fieldDate},
+		"end_date":         {Column: "end_date", Kind: fieldDate},
+		"schedule":         {Column: "schedule"},
+		"credits":          {Column: "credits", Kind: fieldInt},
+		"semester":         {Column: "semester"},
+		"instructor":       {Column: "instructor"},
+		"instructor_email": {Column: "instructor_email"},
+		"notion_id":        {Column: "notion_id"},
+	},
+	filter: columnFilter("semester"),
+	build: func(tx *gorm.DB, userID, localID uint, deviceID string, v apiValues) (*course.Course, error) {
+		c := &course.Course{
+			UserID:          userID,
+			LocalID:         localID,
+			DeviceID:        deviceID,
+			Code:            v.String("code"),
+			Name:            v.String("name"),
+			Color:           v.String("color"),
+			Duration:        v.String("duration"),
+			RoomNumber:      v.String("room_number"),
+			StartDate:       v.Time("start_date"),
+			EndDate:         v.Time("end_date"),
+			Schedule:        v.String("schedule"),
+			Credits:         int(v.Int("credits")),
+			Semester:        v.String("semester"),
+			Instructor:      v.String("instructor"),
+			InstructorEmail: v.String("instructor_email"),
+			NotionID:        v.String("notion_id"),
+		}
+		return c, nil
+	},
+	remove: deleteCourse,
+	row:    func(db *gorm.DB, c *course.Course) map[string]string { return courseRow(c) },
+	label:  func(c *course.Course) string { return c.Code },
+}
+
+var noteResource = &apiResource[note.Note]{
+	entity: models.EntityNote,
+	table:  "notes",
+	fields: map[string]apiField{
+		"course_code": {Column: "course_code"},
+		"title":       {Column: "title", Required: true},
+		"subject":     {Column: "subject"},
+		"content":     {Column: "content"},
+		"keywords":    {Column: "keywords"},
+		"videos":      {Column: "videos"},
+	},
+	filter: columnFilter("course_code"),
+	build: func(tx *gorm.DB, userID, localID uint, deviceID string, v apiValues) (*note.Note, error) {
+		n := &note.Note{
+			UserID:     userID,
+			LocalID:    localID,
+			DeviceID:   deviceID,
+			CourseCode: v.String("course_code"),
+			Title:      v.String("title"),
+			Subject:    v.String("subject"),
+			Content:    v.String("content"),
+			Keywords:   v.String("keywords"),
+			Videos:     v.String("videos"),
+		}
+		return n, nil
+	},
+	remove: deleteNote,
+	row:    func(db *gorm.DB, n *note.Note) map[string]string { return noteRow(n) },
+	label:  func(n *note.Note) string { return n.Title },
+}
+
+// Documents are stored with the uploader's local assignment ID, the API speaks
+// server IDs like the rest of the resources and maps between the two
+var documentResource = &apiResource[document.Document]{
+	entity: models.EntityDocument,
+	table:  "documents",
+	fields: map[string]apiField{
+		"assignment_id": {Column: "assignment_id", Kind: fieldInt, Required: true, ReadOnly: true},
+		"type":          {Column: "type", Required: true},
+		"file_name":     {Column: "file_name", Required: true},
+		"file_type":     {Column: "file_type", Required: true, ReadOnly: true},
+		"file_size":     {Column: "file_size", Kind: fieldInt, ReadOnly: true},
+		"version":       {Column: "version", Kind: fieldInt, ReadOnly: true},
+	},
+	filter: func(db *gorm.DB, r *http.Request, userID uint) (*gorm.DB, error) {
+		assignmentID := r.URL.Query().Get("assignment_id")
+		if assignmentID == "" {
+			return db, nil
+		}
+		id, err := strconv.ParseUint(assignmentID, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unknown assignment %s", assignmentID)
+		}
+		var a assignment.Assignment
+		if err := authz.Load(db.Session(&gorm.Session{NewDB: true}), userID, models.Assignment, uint(id), authz.Read, &a); err != nil {
+			return nil, fmt.Errorf("unknown assignment %s", assignmentID)
+		}
+		return db.Where("documents.assignment_id = ? AND documents.user_id = ?", a.LocalID, a.UserID), nil
+	},
+	build: func(tx *gorm.DB, userID, localID uint, deviceID string, v apiValues) (*document.Document, error) {
+		var a assignment.Assignment
+		if err := tx.Select("local_id").Where("id = ? AND user_id = ?", v.Int("assignment_id"), userID).First(&a).Error; err != nil {
+			return nil, &APIError{Status: http.StatusUnprocessableEntity, Code: ErrCodeInvalidField, Message: "unknown assignment"}
+		}
+
+		d := &document.Document{
+			AssignmentID: a.LocalID,
+			UserID:       userID,
+			LocalID:      localID,
+			DeviceID:     deviceID,
+			Type:         document.DocumentType(v.String("type")),
+			FileName:     v.String("file_name"),
+			FileType:     v.String("file_type"),
+			FileSize:     v.Int("file_size"),
+			Version:      int(v.Int("version")),
+			IsOriginal:   true,
+		}
+		if d.Version == 0 {
+			d.Version = 1
+		}
+		return d, nil
+	},
+	remove: deleteDocument,
+	row:    documentRow,
+	label:  func(d *document.Document) string { return d.FileName },
+	created: func(db *gorm.DB, userID uint) {
+		if err := document.UpdateStorageInfo(userID, db); err != nil {
+			PrintLog(fmt.Sprintf("Failed to update storage info for user %d: %v", userID, err))
+		}
+	},
+}
+
+// registerAPI mounts the REST API on mux under prefix. The legacy routes stay mounted
+// next to it for older clients.
+func registerAPI(mux *http.ServeMux, prefix string, db *gorm.DB) {
+	handle := func(method, path string, handler http.HandlerFunc) {
+		mux.HandleFunc(method+" "+prefix+APIPrefix+path, DBMiddleware(db, APIAuthMiddleware(handler)))
+	}
+
+	assignmentResource.mount(handle, "/assignments")
+	courseResource.mount(handle, "/courses")
+	noteResource.mount(handle, "/notes")
+	documentResource.mount(handle, "/documents")
+
+	handle(http.MethodGet, "/shares", listShares)
+	handle(http.MethodPost, "/shares", createShare)
+	handle(http.MethodDelete, "/shares/{id}", deleteShare)
+}
@@ -0,0 +1,115 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
+	"unipilot/internal/models/user"
+
+	"gorm.io/gorm"
+)
+
+// shareRequest is the body of a share, the grantee is named by username
+type shareRequest struct {
+	Entity     models.Entity     `json:"entity"`
+	EntityID   uint              `json:"entity_id"`
+	Grantee    string            `json:"grantee"`
+	Permission models.Permission `json:"permission"`
+}
+
+// listShares returns the grants the user gave and received
+func listShares(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	grants, err := authz.Grants(db, userID)
+	if err != nil {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error listing shares: %v", err))
+		return
+	}
+
+	rows := make([]map[string]string, 0, len(grants))
+	for i := range grants {
+		rows = append(rows, grants[i].ToMap())
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": rows})
+}
+
+// createShare shares a record of the user with another user. The answer is the same
+// whether the grantee exists or not, so usernames can't be probed through it.
+func createShare(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	var req shareRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		PrintAPIError(w, http.StatusBadRequest, ErrCodeInvalidBody, fmt.Sprintf("Invalid request body: %v", err))
+		return
+	}
+
+	if err := authz.CanShare(db, userID, req.Entity, req.EntityID, req.Permission); err != nil {
+		if errors.Is(err, authz.ErrNotFound) {
+			PrintAPIError(w, http.StatusNotFound, ErrCodeNotFound, fmt.Sprintf("%s not found", req.Entity))
+			return
+		}
+		PrintAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidField, err.Error())
+		return
+	}
+
+	// An unknown grantee gets the answer of a share
+	var grantee user.User
+	err := db.Where("username = ?", req.Grantee).First(&grantee).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, fmt.Sprintf("Error looking up grantee: %v", err))
+		return
+	}
+	if err == nil {
+		if _, err := authz.Share(db, userID, req.Entity, req.EntityID, grantee.ID, req.Permission); err != nil {
+			PrintAPIError(w, http.StatusUnprocessableEntity, ErrCodeInvalidField, err.Error())
+			return
+		}
+	}
+
+	writeJSON(w, http.StatusAccepted, map[string]interface{}{"data": map[string]string{
+		"entity":     string(req.Entity),
+		"entity_id":  strconv.Itoa(int(req.EntityID)),
+		"grantee":    req.Grantee,
+		"permission": string(req.Permission),
+	}})
+}
+
+// deleteShare revokes a grant, as its owner or its grantee
+func deleteShare(w http.ResponseWriter, r *http.Request) {
+	db, userID, ok := apiContext(w, r)
+	if !ok {
+		return
+	}
+
+	id, err := pathID(r)
+	if err != nil {
+		PrintAPIError(w, http.StatusNotFound, ErrCodeNotFound, "share not found")
+		return
+	}
+
+	if _, err := authz.Revoke(db, userID, id); err != nil {
+		if errors.Is(err, authz.ErrNotFound) {
+			PrintAPIError(w, http.StatusNotFound, ErrCodeNotFound, "share not found")
+			return
+		}
+		PrintAPIError(w, http.StatusInternalServerError, ErrCodeInternal, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -2,11 +2,14 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
 	"unipilot/internal/models/assignment"
 
 	"gorm.io/gorm"
@@ -129,10 +132,26 @@ func CreateAssignmentHandler(w http.ResponseWriter, r *http.Request) {
 	
 
 
+	// Clients retry until they get an answer, a row this device already created is returned as is
+	deviceID := r.Header.Get(DeviceHeader)
+	if deviceID != "" {
+		var existing assignment.Assignment
+		if err := authz.LoadByLocalID(tx, userID, deviceID, uint(local_id), &existing); err == nil {
+			tx.Rollback()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message":    "Assignment created successfully",
+				"assignment": existing.ToMap(),
+			})
+			return
+		}
+	}
+
 	aVal := assignment.Assignment{
 		Title:      input.Title,
 		UserID:     userID,
 		LocalID:    uint(local_id),
+		DeviceID:   deviceID,
 		Todo:       input.Todo,
 		Deadline:   deadline,
 		CourseCode: input.CourseCode,
@@ -143,7 +162,7 @@ func CreateAssignmentHandler(w http.ResponseWriter, r *http.Request) {
 
 	result := tx.Create(&aVal)
 	if result.Error != nil {
-		PrintERROR(w, http.StatusConflict, fmt.Sprintf("Error creating assignment in database", err))
+		PrintERROR(w, http.StatusConflict, fmt.Sprintf("Error creating assignment in database: %v", result.Error))
 		return
 	}
 
@@ -180,6 +199,8 @@ func CreateAssignmentHandler(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	notifyChange(r, userID, models.OperationCreate, models.Assignment, assignmentRow(a), fmt.Sprintf("%s added", a.Title))
+
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -222,9 +243,11 @@ func UpdateAssignmentHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	var updateData struct {
-		ID     string `json:"id"`
-		Value  string `json:"value"`
-		Column string `json:"column`
+		ID       string  `json:"id"` // local ID sent by baseline clients
+		RemoteID string  `json:"remote_id"`
+		Value    string  `json:"value"`
+		Column   string  `json:"column"`
+		Base     *string `json:"base"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&updateData)
@@ -233,21 +256,45 @@ func UpdateAssignmentHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	int_id, err := strconv.Atoi(updateData.ID)
-	if err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("failed to convert assignment ID to int: %s", err))
+	if updateData.Column == "deleted_at" {
+		tx.Rollback()
+		removeLegacy(w, r, db, userID, models.Assignment, updateData.RemoteID, updateData.ID, deleteAssignment)
 		return
 	}
 
-	a, err := assignment.Get_Assignment_byLocalID(uint(int_id), userID, tx)
-	if err != nil {
+	var a assignment.Assignment
+	if err := loadLegacy(r, tx, userID, models.Assignment, updateData.RemoteID, updateData.ID, authz.Write, &a); err != nil {
+		tx.Rollback()
+		if errors.Is(err, authz.ErrNotFound) {
+			PrintERROR(w, http.StatusNotFound, "Assignment not found")
+			return
+		}
 		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("failed to getting assignment: %s", err))
 		return
 	}
 
-	if err := tx.Exec(fmt.Sprintf("UPDATE assignments SET %s = ?, updated_at = ? WHERE id = ?", updateData.Column),	
-		updateData.Value, time.Now().Format(time.RFC3339), a.ID).Error; err != nil {
+	if !writableColumn(assignmentResource.fields, updateData.Column) {
+		tx.Rollback()
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid update: column %q can't be changed", updateData.Column))
+		return
+	}
+
+	conflict, err := checkBase(tx, models.Assignment, "assignments", a.ID, updateData.Column, updateData.Base, updateData.Value)
+	if err != nil {
+		tx.Rollback()
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid update: %s", err))
+		return
+	}
+	if conflict != nil {
+		tx.Rollback()
+		conflict.EntityID = strconv.Itoa(int(a.ID))
+		PrintConflict(w, conflict)
+		return
+	}
 
+	if err := tx.Exec(fmt.Sprintf("UPDATE assignments SET %s = ?, updated_at = ? WHERE id = ?", updateData.Column),
+		updateData.Value, time.Now(), a.ID).Error; err != nil {
+		tx.Rollback()
 		PrintERROR(w, http.StatusInternalServerError,
 			fmt.Sprintf("Error updating assignment in database: %s", err))
 		return
@@ -292,4 +339,6 @@ func UpdateAssignmentHandler(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	notifyRow(r, db, userID, models.Assignment, a.ID)
+
 }
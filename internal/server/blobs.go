@@ -0,0 +1,231 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/user"
+	"unipilot/internal/services/blobstore"
+
+	"gorm.io/gorm"
+)
+
+// ContentHashHeader carries the SHA-256 of a document file, on uploads to verify what
+// the server received and on downloads so the client can verify what it wrote
+const ContentHashHeader = "X-Content-SHA256"
+
+// blobStore holds the document files, in the BLOB_DIR of the config
+var blobStore *blobstore.Store
+
+// Blob garbage collection runs every blobGCInterval and keeps blobs written in the
+// last blobGCGrace, their upload may not have recorded the hash yet
+const (
+	blobGCInterval = 6 * time.Hour
+	blobGCGrace    = time.Hour
+)
+
+// collectBlobs runs collectUnreferencedBlobs every blobGCInterval
+func collectBlobs(db *gorm.DB) {
+	ticker := time.NewTicker(blobGCInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		deleted, err := collectUnreferencedBlobs(db, blobStore, blobGCGrace)
+		if err != nil {
+			PrintLog(fmt.Sprintf("Blob garbage collection failed: %v", err))
+			continue
+		}
+		if deleted > 0 {
+			PrintLog(fmt.Sprintf("Deleted %d unreferenced blobs", deleted))
+		}
+	}
+}
+
+// collectUnreferencedBlobs deletes the blobs no live document or avatar points at,
+// i.e. the files of deleted documents, the old contents of replaced ones and replaced
+// avatars, and returns how many it deleted
+func collectUnreferencedBlobs(db *gorm.DB, store *blobstore.Store, grace time.Duration) (int, error) {
+	cutoff := time.Now().Add(-grace)
+
+	var candidates []string
+	err := store.Walk(func(hash string, modTime time.Time) error {
+		if modTime.Before(cutoff) {
+			candidates = append(candidates, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list blobs: %w", err)
+	}
+
+	deleted := 0
+	const batch = 500
+	for start := 0; start < len(candidates); start += batch {
+		hashes := candidates[start:min(start+batch, len(candidates))]
+
+		var used []string
+		if err := db.Model(&document.Document{}).Where("hash IN ?", hashes).Distinct().Pluck("hash", &used).Error; err != nil {
+			return deleted, fmt.Errorf("failed to read document hashes: %w", err)
+		}
+		var avatars []string
+		if err := db.Model(&user.User{}).Where("avatar IN ?", hashes).Distinct().Pluck("avatar", &avatars).Error; err != nil {
+			return deleted, fmt.Errorf("failed to read avatar hashes: %w", err)
+		}
+		used = append(used, avatars...)
+		referenced := make(map[string]bool, len(used))
+		for _, hash := range used {
+			referenced[hash] = true
+		}
+
+		for _, hash := range hashes {
+			if referenced[hash] {
+				continue
+			}
+			// Uploaded again since the walk
+			if info, err := os.Stat(store.Path(hash)); err != nil || info.ModTime().After(cutoff) {
+				continue
+			}
+			if err := store.Delete(hash); err != nil {
+				return deleted, err
+			}
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// getUserDocument loads a document by its server ID if the user may act on its file,
+// as the uploader or through a grant on the document or its assignment
+func getUserDocument(db *gorm.DB, r *http.Request, userID uint, action authz.Action) (*document.Document, error) {
+	docID, err := strconv.ParseUint(r.URL.Query().Get("document_id"), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("document ID required")
+	}
+
+	var doc document.Document
+	if err := authz.Load(db, userID, models.EntityDocument, uint(docID), action, &doc); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// UploadDocumentBlobHandler stores the file of a document whose metadata already exists.
+// The body is the raw file content, identical files are stored once.
+func UploadDocumentBlobHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		PrintERROR(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	doc, err := getUserDocument(db, r, userID, authz.Write)
+	if errors.Is(err, authz.ErrNotFound) {
+		PrintERROR(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash, size, err := blobStore.Put(http.MaxBytesReader(w, r.Body, document.MaxFileSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			PrintERROR(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("File exceeds limit of %d MB", document.MaxFileSize/(1024*1024)))
+			return
+		}
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to store file: %v", err))
+		return
+	}
+
+	if expected := r.Header.Get(ContentHashHeader); expected != "" && expected != hash {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("File hash mismatch: expected %s, received %s", expected, hash))
+		return
+	}
+
+	relPath, _ := filepath.Rel(blobStore.Root(), blobStore.Path(hash))
+	if err := db.Model(doc).Updates(map[string]interface{}{
+		"hash":      hash,
+		"file_size": size,
+		"file_path": relPath,
+	}).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update document: %v", err))
+		return
+	}
+
+	// The file counts against the storage of the document owner
+	if err := document.UpdateStorageInfo(doc.UserID, db); err != nil {
+		PrintLog(fmt.Sprintf("Failed to update remote storage info for user %d: %v", doc.UserID, err))
+	}
+
+	PrintLog(fmt.Sprintf("Stored file of document %d (%s, %d bytes)", doc.ID, hash, size))
+
+	// Other devices learn the file is available and can download it
+	notifyChange(r, doc.UserID, models.OperationUpdate, models.EntityDocument, documentRow(db, doc), fmt.Sprintf("%s uploaded", doc.FileName))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"hash":    hash,
+		"size":    size,
+	})
+}
+
+// DownloadDocumentBlobHandler streams the file of a document
+func DownloadDocumentBlobHandler(w http.ResponseWriter, r *http.Request) {
+	db := r.Context().Value("db").(*gorm.DB)
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	doc, err := getUserDocument(db, r, userID, authz.Read)
+	if errors.Is(err, authz.ErrNotFound) {
+		PrintERROR(w, http.StatusNotFound, "Document not found")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if doc.Hash == "" {
+		PrintERROR(w, http.StatusNotFound, "File not uploaded yet")
+		return
+	}
+
+	file, err := blobStore.Open(doc.Hash)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		PrintERROR(w, http.StatusNotFound, "File missing from storage")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to open file: %v", err))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", doc.FileType)
+	w.Header().Set(ContentHashHeader, doc.Hash)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", doc.FileName))
+	http.ServeContent(w, r, doc.FileName, doc.UpdatedAt, file)
+}
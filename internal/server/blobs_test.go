@@ -0,0 +1,70 @@
+package server
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/user"
+	"unipilot/internal/services/blobstore"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func TestCollectUnreferencedBlobs(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&document.Document{}, &user.User{}); err != nil {
+		t.Fatal(err)
+	}
+	store, err := blobstore.New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(content string, age time.Duration) string {
+		hash, _, err := store.Put(strings.NewReader(content))
+		if err != nil {
+			t.Fatal(err)
+		}
+		when := time.Now().Add(-age)
+		os.Chtimes(store.Path(hash), when, when)
+		return hash
+	}
+	live := put("live", 2*time.Hour)
+	deleted := put("deleted", 2*time.Hour)
+	orphan := put("orphan", 2*time.Hour)
+	fresh := put("fresh", time.Minute)
+	avatar := put("avatar", 2*time.Hour)
+
+	docs := []document.Document{
+		{UserID: 1, LocalID: 1, FileName: "a.pdf", FilePath: "x", Hash: live},
+		{UserID: 1, LocalID: 2, FileName: "b.pdf", FilePath: "x", Hash: deleted},
+	}
+	if err := db.Omit("ParentDoc", "Versions").Create(&docs).Error; err != nil {
+		t.Fatal(err)
+	}
+	db.Delete(&docs[1])
+	if err := db.Create(&user.User{Username: "alice", Email: "alice@example.com", PasswordHash: "x", Avatar: avatar}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := collectUnreferencedBlobs(db, store, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("deleted %d blobs, want 2", n)
+	}
+	for hash, want := range map[string]bool{live: true, fresh: true, avatar: true, deleted: false, orphan: false} {
+		if store.Has(hash) != want {
+			t.Errorf("blob %s kept = %v, want %v", hash[:8], store.Has(hash), want)
+		}
+	}
+}
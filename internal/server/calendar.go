@@ -0,0 +1,202 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/user"
+	"unipilot/internal/services/calendar"
+
+	"gorm.io/gorm"
+)
+
+// calendarFeedPath is the route calendar apps subscribe to, with the token of the user
+const calendarFeedPath = "/calendar.ics"
+
+// CalendarFeedHandler manages the calendar feed of the session user. GET tells whether
+// it is enabled, POST creates a new token, replacing the previous one, and returns
+// the URL to subscribe to. The token is only ever shown then.
+func CalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		var feed user.CalendarToken
+		err := db.Where("user_id = ?", userID).First(&feed).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load calendar feed: %v", err))
+			return
+		}
+
+		status := map[string]interface{}{"enabled": err == nil, "created_at": "", "last_used_at": ""}
+		if err == nil {
+			status["created_at"] = feed.CreatedAt.Format(time.RFC3339)
+			if feed.LastUsedAt != nil {
+				status["last_used_at"] = feed.LastUsedAt.Format(time.RFC3339)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, "Failed to create calendar token")
+		return
+	}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&user.CalendarToken{}).Error; err != nil {
+			return err
+		}
+		return tx.Create(&user.CalendarToken{UserID: userID, TokenHash: hash}).Error
+	})
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create calendar feed: %v", err))
+		return
+	}
+
+	PrintLog(fmt.Sprintf("Calendar feed created for user %d", userID))
+
+	// Without a public URL the client builds the link from the path
+	path := calendarFeedPath + "?token=" + url.QueryEscape(token)
+	link := ""
+	if accountLinkBase != "" {
+		link = accountLinkBase + path
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token, "path": path, "url": link})
+}
+
+// RevokeCalendarFeedHandler deletes the calendar token of the session user, the
+// calendar apps subscribed to it get no more updates
+func RevokeCalendarFeedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	if err := db.Where("user_id = ?", userID).Delete(&user.CalendarToken{}).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to revoke calendar feed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Calendar feed revoked successfully"})
+}
+
+// CalendarICSHandler serves the deadlines and class meetings of the user whose token
+// is in the query, built from the current rows on every request. Calendar apps
+// polling it get 304 Not Modified while nothing changed.
+func CalendarICSHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db := r.Context().Value("db").(*gorm.DB)
+
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		PrintERROR(w, http.StatusUnauthorized, "Missing calendar token")
+		return
+	}
+	var feed user.CalendarToken
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&feed).Error; err != nil {
+		PrintERROR(w, http.StatusNotFound, "Calendar not found")
+		return
+	}
+
+	cal, err := userCalendar(db, feed.UserID)
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to build calendar: %v", err))
+		return
+	}
+	var buf bytes.Buffer
+	if err := calendar.Write(&buf, cal); err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to write calendar: %v", err))
+		return
+	}
+
+	now := time.Now()
+	db.Model(&feed).Update("last_used_at", &now)
+
+	sum := sha256.Sum256(buf.Bytes())
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "private, no-cache")
+	w.Header().Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+	http.ServeContent(w, r, "calendar.ics", time.Time{}, bytes.NewReader(buf.Bytes()))
+}
+
+// userCalendar returns the calendar of the assignments and courses of a user. Events
+// are stamped with the time their row changed, so the file only changes with them.
+func userCalendar(db *gorm.DB, userID uint) (*calendar.Calendar, error) {
+	var u user.User
+	if err := db.First(&u, userID).Error; err != nil {
+		return nil, err
+	}
+	var assignments []assignment.Assignment
+	if err := db.Where("user_id = ?", userID).Order("deadline, id").Find(&assignments).Error; err != nil {
+		return nil, err
+	}
+	var courses []course.Course
+	if err := db.Where("user_id = ?", userID).Order("code").Find(&courses).Error; err != nil {
+		return nil, err
+	}
+
+	cal := &calendar.Calendar{Name: "UniPilot - " + u.Username, Stamp: u.CreatedAt}
+	for _, a := range assignments {
+		cal.Deadlines = append(cal.Deadlines, calendar.Deadline{
+			UID:         calendar.UID("assignment", a.ID),
+			Title:       a.Title,
+			Description: a.Todo,
+			CourseCode:  a.CourseCode,
+			Type:        a.TypeName,
+			Link:        a.Link,
+			At:          a.Deadline,
+			Completed:   a.Completed,
+			Modified:    a.UpdatedAt,
+		})
+	}
+	for _, c := range courses {
+		cal.Courses = append(cal.Courses, calendar.Course{
+			UID:        calendar.UID("course", c.ID),
+			Code:       c.Code,
+			Name:       c.Name,
+			Room:       c.RoomNumber,
+			Instructor: c.Instructor,
+			Schedule:   c.Schedule,
+			Start:      c.StartDate,
+			End:        c.EndDate,
+			Modified:   c.UpdatedAt,
+		})
+	}
+	return cal, nil
+}
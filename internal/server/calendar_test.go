@@ -0,0 +1,129 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+)
+
+// createFeed enables the calendar feed of the session user and returns its path
+func (s *testServer) createFeed(t *testing.T, token string) string {
+	t.Helper()
+
+	res := s.do(t, token, http.MethodPost, "/calendar/feed", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("create feed: status %d", res.StatusCode)
+	}
+	var body map[string]string
+	json.NewDecoder(res.Body).Decode(&body)
+	if body["token"] == "" || !strings.HasPrefix(body["path"], calendarFeedPath+"?token=") {
+		t.Fatalf("create feed = %v", body)
+	}
+	return body["path"]
+}
+
+// fetchFeed gets the calendar at path without a session, as calendar apps do
+func (s *testServer) fetchFeed(t *testing.T, path, etag string) (*http.Response, string) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	res := send(t, req)
+	body, _ := io.ReadAll(res.Body)
+	return res, strings.ReplaceAll(string(body), "\r\n ", "")
+}
+
+func TestCalendarFeed(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	bob := s.addUser(t, "bob")
+	token := s.login(t, "alice")
+
+	res := s.do(t, token, http.MethodGet, "/calendar/feed", nil)
+	var status map[string]interface{}
+	json.NewDecoder(res.Body).Decode(&status)
+	if status["enabled"] != false {
+		t.Fatalf("feed status before creation = %v", status)
+	}
+
+	cs := &course.Course{UserID: alice.ID, LocalID: 1, Code: "CS101", Name: "Intro", Schedule: "M, W 9:00 AM - 10:30 AM",
+		StartDate: time.Date(2025, 8, 25, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2025, 12, 12, 0, 0, 0, 0, time.UTC)}
+	s.db.Omit("User").Create(cs)
+	essay := &assignment.Assignment{UserID: alice.ID, LocalID: 1, Title: "Essay", Deadline: time.Date(2025, 9, 12, 0, 0, 0, 0, time.UTC),
+		CourseCode: "CS101", TypeName: "HW", StatusName: "Not started"}
+	s.db.Omit("User", "Course", "Type", "Status", "Documents").Create(essay)
+	s.db.Omit("User", "Course", "Type", "Status", "Documents").Create(&assignment.Assignment{UserID: bob.ID, LocalID: 2,
+		Title: "Bob's lab", Deadline: time.Now(), CourseCode: "BIO101", TypeName: "HW", StatusName: "Not started"})
+
+	path := s.createFeed(t, token)
+	res, ics := s.fetchFeed(t, path, "")
+	if res.StatusCode != http.StatusOK || !strings.HasPrefix(res.Header.Get("Content-Type"), "text/calendar") {
+		t.Fatalf("feed: status %d, type %s", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\n",
+		"SUMMARY:CS101: Essay\r\n",
+		"DTSTART;VALUE=DATE:20250912\r\n",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20251212T235959\r\n",
+	} {
+		if !strings.Contains(ics, want) {
+			t.Errorf("feed lacks %q:\n%s", want, ics)
+		}
+	}
+	if strings.Contains(ics, "Bob's lab") {
+		t.Errorf("feed of alice shows the assignments of bob:\n%s", ics)
+	}
+
+	// Nothing changed, the calendar app keeps its copy
+	etag := res.Header.Get("ETag")
+	if res, _ := s.fetchFeed(t, path, etag); res.StatusCode != http.StatusNotModified {
+		t.Errorf("unchanged feed: status %d, want 304", res.StatusCode)
+	}
+
+	// The feed follows the changes of the assignments
+	s.db.Model(essay).Updates(map[string]interface{}{"title": "Final essay", "updated_at": time.Now().Add(time.Minute)})
+	res, ics = s.fetchFeed(t, path, etag)
+	if res.StatusCode != http.StatusOK || !strings.Contains(ics, "SUMMARY:CS101: Final essay\r\n") {
+		t.Errorf("feed after a change: status %d\n%s", res.StatusCode, ics)
+	}
+
+	if res, _ := s.fetchFeed(t, calendarFeedPath+"?token=wrong", ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("wrong token: status %d, want 404", res.StatusCode)
+	}
+	if res, _ := s.fetchFeed(t, calendarFeedPath, ""); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("no token: status %d, want 401", res.StatusCode)
+	}
+
+	// A new token replaces the previous one
+	newPath := s.createFeed(t, token)
+	if res, _ := s.fetchFeed(t, path, ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("replaced token: status %d, want 404", res.StatusCode)
+	}
+	if res, _ := s.fetchFeed(t, newPath, ""); res.StatusCode != http.StatusOK {
+		t.Errorf("new token: status %d", res.StatusCode)
+	}
+
+	res = s.do(t, token, http.MethodGet, "/calendar/feed", nil)
+	json.NewDecoder(res.Body).Decode(&status)
+	if status["enabled"] != true || status["last_used_at"] == "" {
+		t.Errorf("feed status = %v", status)
+	}
+
+	if res := s.do(t, token, http.MethodPost, "/calendar/feed/revoke", nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("revoke: status %d", res.StatusCode)
+	}
+	if res, _ := s.fetchFeed(t, newPath, ""); res.StatusCode != http.StatusNotFound {
+		t.Errorf("revoked token: status %d, want 404", res.StatusCode)
+	}
+}
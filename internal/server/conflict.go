@@ -0,0 +1,46 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"unipilot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// checkBase compares the client's base value with what the server has for the column.
+// A change is only rejected when the server value moved away from the base and is not
+// already the value being written. Clients that send no base keep last-writer-wins.
+func checkBase(tx *gorm.DB, entity models.Entity, table string, id uint, column string, base *string, value string) (*models.FieldConflict, error) {
+	current, err := models.ColumnValue(tx, table, id, column)
+	if err != nil {
+		return nil, err
+	}
+
+	if base == nil || models.SameValue(current, *base) || models.SameValue(current, value) {
+		return nil, nil
+	}
+
+	return &models.FieldConflict{
+		Entity: entity,
+		Column: column,
+		Base:   *base,
+		Mine:   value,
+		Theirs: current,
+	}, nil
+}
+
+// PrintConflict rejects an update with 409 and both values so the client can resolve it
+func PrintConflict(w http.ResponseWriter, conflict *models.FieldConflict) {
+	// The values are user content, only the client gets them
+	PrintLog(fmt.Sprintf("conflict on %s %s column %s", conflict.Entity, conflict.EntityID, conflict.Column))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusConflict)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Field was changed on the server",
+		"conflict": conflict,
+	})
+}
@@ -2,11 +2,14 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
 	"unipilot/internal/models/course"
 
 	"gorm.io/gorm"
@@ -145,9 +148,25 @@ func CreateCourseHandler(w http.ResponseWriter, r *http.Request) {
 	
 
 
+	// Clients retry until they get an answer, a row this device already created is returned as is
+	deviceID := r.Header.Get(DeviceHeader)
+	if deviceID != "" {
+		var existing course.Course
+		if err := authz.LoadByLocalID(tx, userID, deviceID, uint(local_id), &existing); err == nil {
+			tx.Rollback()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": "Course created successfully",
+				"course":  existing.ToMap(),
+			})
+			return
+		}
+	}
+
 	cVal := course.Course{
 		UserID:			userID,
 		LocalID:		uint(local_id),
+		DeviceID:		deviceID,
 		Name:			input.Name,
 		Code:			input.Code,
 		Color:			input.Color,
@@ -163,7 +182,7 @@ func CreateCourseHandler(w http.ResponseWriter, r *http.Request) {
 
 	result := tx.Create(&cVal)
 	if result.Error != nil {
-		PrintERROR(w, http.StatusConflict, fmt.Sprintf("Error creating assignment in database", err))
+		PrintERROR(w, http.StatusConflict, fmt.Sprintf("Error creating course in database: %v", result.Error))
 		return
 	}
 
@@ -199,6 +218,8 @@ func CreateCourseHandler(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	notifyChange(r, userID, models.OperationCreate, models.EntityCourse, courseRow(c), fmt.Sprintf("%s added", c.Code))
+
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -227,11 +248,11 @@ func UpdateCourseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	/*userID, ok := userIDVal.(uint)
+	userID, ok := userIDVal.(uint)
 	if !ok {
 		PrintERROR(w, http.StatusUnauthorized, "Invalid user ID format")
 		return
-	}*/
+	}
 
 	tx := db.Begin()
 	defer func() {
@@ -241,9 +262,11 @@ func UpdateCourseHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	var updateData struct {
-		ID     string `json:"id"`
-		Value  string `json:"value"`
-		Column string `json:"column`
+		ID       string  `json:"id"` // local ID sent by baseline clients
+		RemoteID string  `json:"remote_id"`
+		Value    string  `json:"value"`
+		Column   string  `json:"column"`
+		Base     *string `json:"base"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&updateData)
@@ -252,20 +275,45 @@ func UpdateCourseHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	int_id, err := strconv.Atoi(updateData.ID)
-	if err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("failed to convert assignment ID to int: %s", err))
+	if updateData.Column == "deleted_at" {
+		tx.Rollback()
+		removeLegacy(w, r, db, userID, models.EntityCourse, updateData.RemoteID, updateData.ID, deleteCourse)
 		return
 	}
 
-	a, err := course.Get_Course_byLocalId(uint(int_id), tx)
-	if err != nil {
+	var a course.Course
+	if err := loadLegacy(r, tx, userID, models.EntityCourse, updateData.RemoteID, updateData.ID, authz.Write, &a); err != nil {
+		tx.Rollback()
+		if errors.Is(err, authz.ErrNotFound) {
+			PrintERROR(w, http.StatusNotFound, "Course not found")
+			return
+		}
 		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("failed to getting course: %s", err))
 		return
 	}
 
+	if !writableColumn(courseResource.fields, updateData.Column) {
+		tx.Rollback()
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid update: column %q can't be changed", updateData.Column))
+		return
+	}
+
+	conflict, err := checkBase(tx, models.EntityCourse, "courses", a.ID, updateData.Column, updateData.Base, updateData.Value)
+	if err != nil {
+		tx.Rollback()
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid update: %s", err))
+		return
+	}
+	if conflict != nil {
+		tx.Rollback()
+		conflict.EntityID = strconv.Itoa(int(a.ID))
+		PrintConflict(w, conflict)
+		return
+	}
+
 	if err := tx.Exec(fmt.Sprintf("UPDATE courses SET %s = ?, updated_at = ? WHERE id = ?", updateData.Column),
-		updateData.Value, time.Now().Format(time.RFC3339), a.ID).Error; err != nil {
+		updateData.Value, time.Now(), a.ID).Error; err != nil {
+		tx.Rollback()
 		PrintERROR(w, http.StatusInternalServerError,
 			fmt.Sprintf("Error updating assignment in database: %s", err))
 		return
@@ -310,4 +358,6 @@ func UpdateCourseHandler(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	notifyRow(r, db, userID, models.EntityCourse, a.ID)
+
 }
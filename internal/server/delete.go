@@ -0,0 +1,224 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+
+	"gorm.io/gorm"
+)
+
+// deletedRow is a row soft-deleted by a delete or its cascade, notified once committed
+type deletedRow struct {
+	entity models.Entity
+	row    map[string]string
+	label  string
+}
+
+// notifyDeleted sends the delete notifications of committed deletions
+func notifyDeleted(r *http.Request, userID uint, rows []deletedRow) {
+	for _, d := range rows {
+		notifyChange(r, userID, models.OperationDelete, d.entity, d.row, fmt.Sprintf("%s deleted", d.label))
+	}
+}
+
+// refreshStorageInfo recounts the user's storage when deleted rows include documents
+func refreshStorageInfo(db *gorm.DB, userID uint, deleted []deletedRow) {
+	for _, d := range deleted {
+		if d.entity != models.EntityDocument {
+			continue
+		}
+		if err := document.UpdateStorageInfo(userID, db); err != nil {
+			PrintLog(fmt.Sprintf("Failed to update storage info for user %d: %v", userID, err))
+		}
+		return
+	}
+}
+
+// deleteDocuments soft-deletes the document metadata of an assignment. Documents keep
+// the uploader's local assignment ID. The files stay in the blob store, other documents
+// may share them.
+func deleteDocuments(tx *gorm.DB, a *assignment.Assignment) ([]deletedRow, error) {
+	var documents []document.Document
+	if err := tx.Where("assignment_id = ? AND user_id = ?", a.LocalID, a.UserID).Find(&documents).Error; err != nil {
+		return nil, fmt.Errorf("failed to get documents of assignment %d: %w", a.ID, err)
+	}
+
+	var deleted []deletedRow
+	for i := range documents {
+		rows, err := deleteDocument(tx, &documents[i])
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, rows...)
+	}
+	return deleted, nil
+}
+
+func deleteDocument(tx *gorm.DB, d *document.Document) ([]deletedRow, error) {
+	if err := tx.Delete(d).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete document %d: %w", d.ID, err)
+	}
+	if err := tx.Unscoped().First(d, d.ID).Error; err != nil {
+		return nil, err
+	}
+	return []deletedRow{{models.EntityDocument, documentRow(tx, d), d.FileName}}, nil
+}
+
+// deleteAssignment soft-deletes an assignment with its document metadata
+func deleteAssignment(tx *gorm.DB, a *assignment.Assignment) ([]deletedRow, error) {
+	deleted, err := deleteDocuments(tx, a)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Delete(a).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete assignment %d: %w", a.ID, err)
+	}
+	if err := tx.Unscoped().First(a, a.ID).Error; err != nil {
+		return nil, err
+	}
+	return append(deleted, deletedRow{models.Assignment, assignmentRow(a), a.Title}), nil
+}
+
+func deleteNote(tx *gorm.DB, n *note.Note) ([]deletedRow, error) {
+	if err := tx.Delete(n).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete note %d: %w", n.ID, err)
+	}
+	if err := tx.Unscoped().First(n, n.ID).Error; err != nil {
+		return nil, err
+	}
+	return []deletedRow{{models.EntityNote, noteRow(n), n.Title}}, nil
+}
+
+// deleteCourse soft-deletes a course with its assignments, their document metadata and its notes
+func deleteCourse(tx *gorm.DB, c *course.Course) ([]deletedRow, error) {
+	var deleted []deletedRow
+
+	var assignments []assignment.Assignment
+	if err := tx.Where("course_code = ? AND user_id = ?", c.Code, c.UserID).Find(&assignments).Error; err != nil {
+		return nil, fmt.Errorf("failed to get assignments of course %s: %w", c.Code, err)
+	}
+	for i := range assignments {
+		rows, err := deleteAssignment(tx, &assignments[i])
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, rows...)
+	}
+
+	var notes []note.Note
+	if err := tx.Where("course_code = ? AND user_id = ?", c.Code, c.UserID).Find(&notes).Error; err != nil {
+		return nil, fmt.Errorf("failed to get notes of course %s: %w", c.Code, err)
+	}
+	for i := range notes {
+		rows, err := deleteNote(tx, &notes[i])
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, rows...)
+	}
+
+	if err := tx.Delete(c).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete course %d: %w", c.ID, err)
+	}
+	if err := tx.Unscoped().First(c, c.ID).Error; err != nil {
+		return nil, err
+	}
+	return append(deleted, deletedRow{models.EntityCourse, courseRow(c), c.Code}), nil
+}
+
+// deleteLegacy serves the legacy delete routes. Like the update routes they take the
+// server ID in the remote_id query parameter, or the local ID of baseline clients in id.
+// Deleting a row already deleted succeeds so clients can retry.
+func deleteLegacy[T any](w http.ResponseWriter, r *http.Request, entity models.Entity, remove func(tx *gorm.DB, item *T) ([]deletedRow, error)) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	db, ok := r.Context().Value("db").(*gorm.DB)
+	if !ok {
+		PrintERROR(w, http.StatusInternalServerError, "Database connection not found")
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	remoteID := r.URL.Query().Get("remote_id")
+	localID := r.URL.Query().Get("id")
+	if remoteID == "" && localID == "" {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("%s ID required", entity))
+		return
+	}
+
+	removeLegacy(w, r, db, userID, entity, remoteID, localID, remove)
+}
+
+// removeLegacy deletes the record a legacy route addresses with the rows depending on it.
+// Baseline clients also delete through the update routes, by setting deleted_at.
+func removeLegacy[T any](w http.ResponseWriter, r *http.Request, db *gorm.DB, userID uint, entity models.Entity, remoteID, localID string, remove func(tx *gorm.DB, item *T) ([]deletedRow, error)) {
+	var deleted []deletedRow
+	err := db.Transaction(func(tx *gorm.DB) error {
+		item := new(T)
+		if err := loadLegacy(r, tx.Unscoped(), userID, entity, remoteID, localID, authz.Delete, item); err != nil {
+			return err
+		}
+
+		var deletedAt gorm.DeletedAt
+		if err := tx.Unscoped().Model(item).Select("deleted_at").Scan(&deletedAt).Error; err != nil {
+			return err
+		}
+		if deletedAt.Valid {
+			return nil
+		}
+
+		var err error
+		deleted, err = remove(tx, item)
+		return err
+	})
+	if errors.Is(err, authz.ErrNotFound) {
+		PrintERROR(w, http.StatusNotFound, fmt.Sprintf("%s not found", entity))
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error deleting %s: %v", entity, err))
+		return
+	}
+
+	refreshStorageInfo(db, userID, deleted)
+	notifyDeleted(r, userID, deleted)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": fmt.Sprintf("%s deleted successfully", entity),
+		"deleted": len(deleted),
+	})
+}
+
+// DeleteAssignmentHandler soft-deletes an assignment and its document metadata
+func DeleteAssignmentHandler(w http.ResponseWriter, r *http.Request) {
+	deleteLegacy(w, r, models.Assignment, deleteAssignment)
+}
+
+// DeleteCourseHandler soft-deletes a course with its assignments, documents and notes
+func DeleteCourseHandler(w http.ResponseWriter, r *http.Request) {
+	deleteLegacy(w, r, models.EntityCourse, deleteCourse)
+}
+
+// DeleteNoteHandler soft-deletes a note
+func DeleteNoteHandler(w http.ResponseWriter, r *http.Request) {
+	deleteLegacy(w, r, models.EntityNote, deleteNote)
+}
@@ -2,10 +2,13 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
 	"unipilot/internal/models/document"
 
 	"gorm.io/gorm"
@@ -24,6 +27,7 @@ type DocumentMetadata struct {
 	Version      int    `json:"version"`
 	IsOriginal   bool   `json:"is_original"`
 	HasLocalFile bool   `json:"has_local_file"`
+	Hash         string `json:"hash"`
 	CreatedAt    string `json:"created_at"`
 }
 
@@ -45,12 +49,14 @@ func CreateDocumentMetadataHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		AssignmentID uint   `json:"assignment_id"`
-		LocalID      uint   `json:"local_id"`
-		Type         string `json:"type"`
-		FileName     string `json:"file_name"`
-		FileType     string `json:"file_type"`
-		FileSize     int64  `json:"file_size"`
+		AssignmentID  uint   `json:"assignment_id"`
+		LocalID       uint   `json:"local_id"`
+		Type          string `json:"type"`
+		FileName      string `json:"file_name"`
+		FileType      string `json:"file_type"`
+		FileSize      int64  `json:"file_size"`
+		Version       int    `json:"version"`
+		ParentLocalID uint   `json:"parent_local_id"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -58,30 +64,59 @@ func CreateDocumentMetadataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create document metadata record (FilePath empty for local-only files)
-	// Create document metadata record (FilePath empty for local-only files)
-	doc := &document.Document{
-		AssignmentID: req.AssignmentID,
-		LocalID:      req.LocalID,
-		UserID:       userID,
-		Type:         document.DocumentType(req.Type),
-		FileName:     req.FileName,
-		FileType:     req.FileType,
-		FileSize:     req.FileSize,
-		Version:      1,
-		IsOriginal:   true,
-		FilePath:     "", // Empty since file is local only
+	// Clients retry until they get an answer, a document this device already stored is returned as is
+	var doc *document.Document
+	deviceID := r.Header.Get(DeviceHeader)
+	if deviceID != "" {
+		var existing document.Document
+		err := authz.LoadByLocalID(db, userID, deviceID, req.LocalID, &existing)
+		if err == nil {
+			doc = &existing
+		} else if !errors.Is(err, authz.ErrNotFound) {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to look up document: %v", err))
+			return
+		}
 	}
 
-	if err := db.Create(doc).Error; err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save document metadata: %v", err))
-		return
-	}
-
-	// Update remote storage info for the user
-	if err := document.UpdateStorageInfo(userID, db); err != nil {
-		// Log warning but don't fail the request
-		fmt.Printf("Warning: Failed to update remote storage info for user %d: %v\n", userID, err)
+	if doc == nil {
+		// Create document metadata record, the file is uploaded separately to the blob endpoint
+		doc = &document.Document{
+			AssignmentID: req.AssignmentID,
+			LocalID:      req.LocalID,
+			DeviceID:     deviceID,
+			UserID:       userID,
+			Type:         document.DocumentType(req.Type),
+			FileName:     req.FileName,
+			FileType:     req.FileType,
+			FileSize:     req.FileSize,
+			Version:      1,
+			IsOriginal:   true,
+			FilePath:     "", // Set once the file is uploaded
+		}
+
+		if req.ParentLocalID != 0 {
+			var parent document.Document
+			if err := authz.LoadByLocalID(db, userID, deviceID, req.ParentLocalID, &parent); err == nil {
+				doc.ParentDocID = &parent.ID
+				doc.IsOriginal = false
+			}
+		}
+		if req.Version > 0 {
+			doc.Version = req.Version
+		}
+
+		if err := db.Create(doc).Error; err != nil {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to save document metadata: %v", err))
+			return
+		}
+
+		// Update remote storage info for the user
+		if err := document.UpdateStorageInfo(userID, db); err != nil {
+			// Log warning but don't fail the request
+			fmt.Printf("Warning: Failed to update remote storage info for user %d: %v\n", userID, err)
+		}
+
+		notifyChange(r, userID, models.OperationCreate, models.EntityDocument, documentRow(db, doc), fmt.Sprintf("%s uploaded", doc.FileName))
 	}
 
 	response := DocumentMetadata{
@@ -96,6 +131,7 @@ func CreateDocumentMetadataHandler(w http.ResponseWriter, r *http.Request) {
 		Version:      doc.Version,
 		IsOriginal:   doc.IsOriginal,
 		HasLocalFile: true,
+		Hash:         doc.Hash,
 		CreatedAt:    doc.CreatedAt.Format("2006-01-02 15:04:05"),
 	}
 
@@ -136,7 +172,8 @@ func GetAssignmentDocumentsHandler(w http.ResponseWriter, r *http.Request) {
 
 	var documents []document.Document
 	err = db.Preload("User").
-		Where("assignment_id = ?", assignmentID).
+		Scopes(authz.Scope(currentUserID, models.EntityDocument, authz.Read)).
+		Where("documents.assignment_id = ?", assignmentID).
 		Order("created_at DESC").
 		Find(&documents).Error
 
@@ -160,6 +197,7 @@ func GetAssignmentDocumentsHandler(w http.ResponseWriter, r *http.Request) {
 			Version:      doc.Version,
 			IsOriginal:   doc.IsOriginal,
 			HasLocalFile: hasLocalFile,
+			Hash:         doc.Hash,
 			CreatedAt:    doc.CreatedAt.Format("2006-01-02 15:04:05"),
 		})
 	}
@@ -188,14 +226,16 @@ func DeleteDocumentMetadataHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Current clients send the server ID, baseline clients their local ID
+	remoteID := r.URL.Query().Get("remote_id")
 	docID := r.URL.Query().Get("document_id")
-	if docID == "" {
+	if remoteID == "" && docID == "" {
 		PrintERROR(w, http.StatusBadRequest, "Document ID required")
 		return
 	}
 
 	var doc document.Document
-	if err := db.Where("local_id = ? AND user_id = ?", docID, userID).First(&doc).Error; err != nil {
+	if err := loadLegacy(r, db, userID, models.EntityDocument, remoteID, docID, authz.Delete, &doc); err != nil {
 		PrintERROR(w, http.StatusNotFound, "Document not found")
 		return
 	}
@@ -211,6 +251,10 @@ func DeleteDocumentMetadataHandler(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("Warning: Failed to update remote storage info for user %d: %v\n", userID, err)
 	}
 
+	if err := db.Unscoped().First(&doc, doc.ID).Error; err == nil {
+		notifyChange(r, userID, models.OperationDelete, models.EntityDocument, documentRow(db, &doc), fmt.Sprintf("%s deleted", doc.FileName))
+	}
+
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
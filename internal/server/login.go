@@ -5,15 +5,20 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"sync"
 	"unipilot/internal/models/user"
 
-	"github.com/spf13/viper"
-
-	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
 
+// dummyPasswordHash is compared against when the username is unknown, at the cost new
+// passwords are hashed with
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("unipilot-unknown-user"), bcrypt.DefaultCost)
+	return hash
+})
+
 func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -21,8 +26,9 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var credentials struct {
-		Username string `json:"username"`
-		Password string `json:"password"`
+		Username   string `json:"username"`
+		Password   string `json:"password"`
+		DeviceName string `json:"device_name"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&credentials); err != nil {
@@ -34,6 +40,9 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 
 	var user user.User
 	if err := db.Where("username = ?", credentials.Username).First(&user).Error; err != nil {
+		// Take as long as a wrong password so response times don't tell which
+		// usernames exist
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(credentials.Password))
 		PrintERROR(w, http.StatusUnauthorized, "Invalid credentials")
 
 		return
@@ -47,32 +56,27 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create session
-	viper.SetConfigFile(".env")
-	err := viper.ReadInConfig()
+	// Devices authenticate with the tokens, browsers with the cookie of the same session
+	tokens, err := tokenAuth.issue(r, user.ID, credentials.DeviceName)
 	if err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("error reading config file: %w", err))
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
+		return
 	}
 
-	SESSION_KEY := viper.GetString("SESSION_KEY")
-
-	var store = sessions.NewCookieStore([]byte(SESSION_KEY))
-
-	session, _ := store.Get(r, "session-auth")
-	session.Values["user_id"] = user.ID
-	session.Values["authenticated"] = true
-	if err := session.Save(r, w); err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %w", err))
+	// Create session
+	if err := startSession(w, r, user.ID, tokens.sessionID); err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
 		return
 	}
 
 	id := strconv.Itoa(int(user.ID))
 
+	response := tokens.toMap()
+	response["message"] = "Login successful"
+	response["username"] = user.Username
+	response["user_id"] = id
+	response["error"] = ""
+
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{
-		"message":  "Login successful",
-		"username": user.Username,
-		"user_id":  id,
-		"error":    "",
-	})
+	json.NewEncoder(w).Encode(response)
 }
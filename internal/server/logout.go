@@ -4,42 +4,31 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-
-	"github.com/spf13/viper"
-
-	"github.com/gorilla/sessions"
 )
 
+// LogoutHandler ends the device session of the request, its tokens and its cookie
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
-	viper.SetConfigFile(".env")
-	err := viper.ReadInConfig()
-	if err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("error reading config file: %w", err))
-		return
+	userID, _ := r.Context().Value("user_id").(uint)
+	if sessionID, ok := r.Context().Value("session_id").(uint); ok {
+		if err := tokenAuth.revoke(userID, sessionID); err != nil {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to end session: %v", err))
+			return
+		}
 	}
 
-	SESSION_KEY := viper.GetString("SESSION_KEY")
-
-	var store = sessions.NewCookieStore([]byte(SESSION_KEY))
-
-	session, _ := store.Get(r, "session-auth")
-
-	// Check if user was actually logged in
-	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
-		PrintERROR(w, http.StatusUnauthorized, "Not logged in")
-		return
-	}
+	session, _ := getSession(r)
 
 	// Clear session values
 	session.Values["authenticated"] = false
 	delete(session.Values, "user_id")
+	delete(session.Values, "session_id")
 
 	// Optionally, expire the session cookie immediately
 	session.Options.MaxAge = -1
 
 	if err := session.Save(r, w); err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %w", err))
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
 		return
 	}
 
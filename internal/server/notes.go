@@ -2,19 +2,24 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
 	"unipilot/internal/models/note"
-	"unipilot/internal/models/course"
 	"unipilot/internal/services/gemini"
 	"unipilot/internal/services/markdown"
 
 	"gorm.io/gorm"
 )
 
+// noteGenerator writes generated notes, nil when the config has no Gemini key
+var noteGenerator *gemini.Client
+
 func GetNoteHandler(w http.ResponseWriter, r *http.Request) {
 	userIDVal := r.Context().Value("user_id")
 	if userIDVal == nil {
@@ -112,29 +117,34 @@ func CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate content and keywords using Gemini
+	if noteGenerator == nil {
+		tx.Rollback()
+		PrintERROR(w, http.StatusServiceUnavailable, "Note generation is not configured")
+		return
+	}
+
 	geminiRequest := &gemini.GeminiRequest{
 		Title:      input.Title,
 		Subject:    input.Subject,
 		CourseName: input.CourseCode,
 	}
 
-	geminiResponse, err := gemini.GenerateNote(geminiRequest)
+	geminiResponse, err := noteGenerator.GenerateNote(r.Context(), geminiRequest)
 	if err != nil {
+		tx.Rollback()
 		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to generate note content: %v", err))
 		return
 	}
 
 	// Parse markdown content to HTML for storage
 	markdownService := markdown.NewMarkdownService()
-	htmlContent, err := markdownService.ParseToHTML(geminiResponse["content"])
+	htmlContent, err := markdownService.ParseToHTML(geminiResponse.Content)
 	if err != nil {
 		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to parse markdown content: %v", err))
 		return
 	}
 
 
-	PrintLog(response.Content)
-	
 	local_id, err := strconv.Atoi(input.LocalID)
 	if err != nil {
 		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Error formating local_id : %s", err))
@@ -143,12 +153,29 @@ func CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 
+	// Clients retry until they get an answer, a row this device already created is returned as is
+	deviceID := r.Header.Get(DeviceHeader)
+	if deviceID != "" {
+		var existing note.Note
+		if err := authz.LoadByLocalID(tx, userID, deviceID, uint(local_id), &existing); err == nil {
+			tx.Rollback()
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"message": "Note created successfully",
+				"note":    existing.ToMap(),
+			})
+			return
+		}
+	}
+
 	nVal := note.Note{
 		UserID:     userID,
+		LocalID:    uint(local_id),
+		DeviceID:   deviceID,
 		CourseCode: input.CourseCode,
 		Title:      input.Title,
 		Subject:    input.Subject,
-		Keywords:   geminiResponse["keywords"],
+		Keywords:   geminiResponse.Keywords,
 		Content:    htmlContent,
 	}
 
@@ -156,8 +183,6 @@ func CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
 	if result.Error != nil {
 		tx.Rollback()
 		PrintERROR(w, http.StatusConflict, fmt.Sprintf("Error creating note in database: %v", result.Error))
-
-		PrintERROR(w, http.StatusConflict, fmt.Sprintf("Error creating assignment in database", err))
 		return
 	}
 
@@ -170,7 +195,7 @@ func CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Convert to map safely
-	noteMap := nVal.ToMap()
+	noteMap := n.ToMap()
 	if noteMap == nil {
 		tx.Rollback()
 		PrintERROR(w, http.StatusInternalServerError, "Failed to process note data")
@@ -179,6 +204,8 @@ func CreateNoteHandler(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	notifyChange(r, userID, models.OperationCreate, models.EntityNote, noteRow(n), fmt.Sprintf("%s added", n.Title))
+
 	// Return response
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -221,9 +248,11 @@ func UpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
 	}()
 
 	var updateData struct {
-		ID     string `json:"id"`
-		Value  string `json:"value"`
-		Column string `json:"column`
+		ID       string  `json:"id"` // local ID sent by baseline clients
+		RemoteID string  `json:"remote_id"`
+		Value    string  `json:"value"`
+		Column   string  `json:"column"`
+		Base     *string `json:"base"`
 	}
 
 	err := json.NewDecoder(r.Body).Decode(&updateData)
@@ -232,22 +261,45 @@ func UpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	int_id, err := strconv.Atoi(updateData.ID)
-	if err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("failed to convert note ID to int: %s", err))
+	if updateData.Column == "deleted_at" {
+		tx.Rollback()
+		removeLegacy(w, r, db, userID, models.EntityNote, updateData.RemoteID, updateData.ID, deleteNote)
 		return
 	}
 
-
 	var n note.Note
-	if err := tx.Where("id = ? AND user_id = ?", uint(int_id), userID).First(&n).Error; err != nil {
+	if err := loadLegacy(r, tx, userID, models.EntityNote, updateData.RemoteID, updateData.ID, authz.Write, &n); err != nil {
+		tx.Rollback()
+		if errors.Is(err, authz.ErrNotFound) {
+			PrintERROR(w, http.StatusNotFound, "Note not found")
+			return
+		}
 		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("failed to get note: %s", err))
 		return
 	}
 
-	if err := tx.Exec(fmt.Sprintf("UPDATE notes SET %s = ?, updated_at = ? WHERE id = ?", updateData.Column),
-		updateData.Value, time.Now().Format(time.RFC3339), n.ID).Error; err != nil {
+	if !writableColumn(noteResource.fields, updateData.Column) {
+		tx.Rollback()
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid update: column %q can't be changed", updateData.Column))
+		return
+	}
+
+	conflict, err := checkBase(tx, models.EntityNote, "notes", n.ID, updateData.Column, updateData.Base, updateData.Value)
+	if err != nil {
+		tx.Rollback()
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid update: %s", err))
+		return
+	}
+	if conflict != nil {
+		tx.Rollback()
+		conflict.EntityID = strconv.Itoa(int(n.ID))
+		PrintConflict(w, conflict)
+		return
+	}
 
+	if err := tx.Exec(fmt.Sprintf("UPDATE notes SET %s = ?, updated_at = ? WHERE id = ?", updateData.Column),
+		updateData.Value, time.Now(), n.ID).Error; err != nil {
+		tx.Rollback()
 		PrintERROR(w, http.StatusInternalServerError,
 			fmt.Sprintf("Error updating note in database: %s", err))
 		return
@@ -258,4 +310,6 @@ func UpdateNoteHandler(w http.ResponseWriter, r *http.Request) {
 
 	tx.Commit()
 
+	notifyRow(r, db, userID, models.EntityNote, n.ID)
+
 }
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+
+	"gorm.io/gorm"
+)
+
+// notifyChange sends a committed change to the user's other devices. The row is
+// sent in the same shape as the delta sync endpoint so clients apply both alike.
+func notifyChange(r *http.Request, userID uint, op models.Operation, entity models.Entity, row map[string]string, message string) {
+	if sseServer == nil {
+		return
+	}
+	sseServer.SendNotification(userID, r.Header.Get(DeviceHeader), entity, op, row["id"], message, row)
+	if notionSync != nil {
+		notionSync.schedule(userID, entity, row)
+	}
+}
+
+// rowOwner returns the user owning a row, whose devices keep it in sync
+func rowOwner(row map[string]string, fallback uint) uint {
+	if id, err := strconv.ParseUint(row["user_id"], 10, 64); err == nil {
+		return uint(id)
+	}
+	return fallback
+}
+
+// withTombstone adds the deleted_at value clients use to tell deletions apart
+func withTombstone(row map[string]string, deletedAt gorm.DeletedAt) map[string]string {
+	row["deleted_at"] = ""
+	if deletedAt.Valid {
+		row["deleted_at"] = deletedAt.Time.Format(time.RFC3339)
+	}
+	return row
+}
+
+func assignmentRow(a *assignment.Assignment) map[string]string {
+	return withTombstone(a.ToMap(), a.DeletedAt)
+}
+
+func courseRow(c *course.Course) map[string]string {
+	return withTombstone(c.ToMap(), c.DeletedAt)
+}
+
+func noteRow(n *note.Note) map[string]string {
+	return withTombstone(n.ToMap(), n.DeletedAt)
+}
+
+// documentRow resolves the uploader's local assignment ID to the server ID
+// other devices know the assignment by
+func documentRow(db *gorm.DB, d *document.Document) map[string]string {
+	row := withTombstone(d.ToMap(), d.DeletedAt)
+	row["assignment_remote_id"] = ""
+
+	var a assignment.Assignment
+	if err := db.Unscoped().Select("id").Where("local_id = ? AND user_id = ?", d.AssignmentID, d.UserID).First(&a).Error; err == nil {
+		row["assignment_remote_id"] = strconv.Itoa(int(a.ID))
+	}
+	return row
+}
+
+// notifyRow reloads a row after an update and notifies the change to the owner's devices
+func notifyRow(r *http.Request, db *gorm.DB, userID uint, entity models.Entity, id uint) {
+	var row map[string]string
+	var label string
+
+	switch entity {
+	case models.Assignment:
+		var a assignment.Assignment
+		if err := db.Unscoped().First(&a, id).Error; err != nil {
+			PrintLog(fmt.Sprintf("Failed to reload assignment %d for notification: %v", id, err))
+			return
+		}
+		row, label = assignmentRow(&a), a.Title
+	case models.EntityCourse:
+		var c course.Course
+		if err := db.Unscoped().First(&c, id).Error; err != nil {
+			PrintLog(fmt.Sprintf("Failed to reload course %d for notification: %v", id, err))
+			return
+		}
+		row, label = courseRow(&c), c.Code
+	case models.EntityNote:
+		var n note.Note
+		if err := db.Unscoped().First(&n, id).Error; err != nil {
+			PrintLog(fmt.Sprintf("Failed to reload note %d for notification: %v", id, err))
+			return
+		}
+		row, label = noteRow(&n), n.Title
+	default:
+		return
+	}
+
+	notifyChange(r, rowOwner(row, userID), models.OperationUpdate, entity, row, fmt.Sprintf("%s updated", label))
+}
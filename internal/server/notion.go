@@ -0,0 +1,948 @@
+package server
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/services/notion"
+
+	"gorm.io/gorm"
+)
+
+// notionSync keeps the assignments and courses of users in sync with their Notion
+// workspace, set at boot
+var notionSync *notionService
+
+// Limits of the Notion sync
+const (
+	notionWebhookMaxBody = 1 << 20
+	notionTimeout        = time.Minute
+	// notionPullTimeout bounds the first sync of a workspace, which reads every page
+	notionPullTimeout = 15 * time.Minute
+
+	// notionLocalIDBase starts the local IDs of rows created from Notion pages, far
+	// above the IDs devices give their own rows so they never adopt one by mistake
+	notionLocalIDBase = 1 << 31
+)
+
+// Values of assignments created in Notion without them
+const (
+	notionDefaultType   = "HW"
+	notionDefaultStatus = "Not started"
+)
+
+// assignmentColumns are the columns of the assignment row fields the sync maps
+var assignmentColumns = map[string]string{
+	"title":       "title",
+	"todo":        "todo",
+	"deadline":    "deadline",
+	"course_code": "course_code",
+	"type":        "type_name",
+	"status":      "status_name",
+	"link":        "link",
+}
+
+// courseColumns are the columns of the course row fields the sync maps
+var courseColumns = map[string]string{
+	"name":        "name",
+	"code":        "code",
+	"room_number": "room_number",
+	"duration":    "duration",
+	"instructor":  "instructor",
+	"schedule":    "schedule",
+	"semester":    "semester",
+}
+
+// errNotionDisabled is returned when the server has no key to encrypt Notion tokens with
+var errNotionDisabled = errors.New("Notion sync is not configured on this server")
+
+// notionService applies webhook deliveries to the database and pushes the changes
+// made through the API back to Notion
+type notionService struct {
+	db     *gorm.DB
+	apiURL string
+
+	// tokens encrypts the Notion tokens of users, nil when no key is configured
+	tokens cipher.AEAD
+	// clients holds the client of each user, its rate limit covers all their syncs
+	clients sync.Map
+
+	// locks holds a mutex per user, their pushes run one at a time so a row is
+	// created in Notion once
+	locks sync.Map
+	// pending counts the pushes running in the background
+	pending sync.WaitGroup
+}
+
+func newNotionService(db *gorm.DB, apiURL string, tokenKey []byte) (*notionService, error) {
+	s := &notionService{db: db, apiURL: apiURL}
+	if len(tokenKey) == 0 {
+		return s, nil
+	}
+
+	key := sha256.Sum256(tokenKey)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	s.tokens, err = cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// seal encrypts a Notion token to be stored, the nonce is kept in front of it
+func (s *notionService) seal(token string) (string, error) {
+	if s.tokens == nil {
+		return "", errNotionDisabled
+	}
+	nonce := make([]byte, s.tokens.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(s.tokens.Seal(nonce, nonce, []byte(token), nil)), nil
+}
+
+// open decrypts a token stored by seal
+func (s *notionService) open(sealed string) (string, error) {
+	if s.tokens == nil {
+		return "", errNotionDisabled
+	}
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(data) < s.tokens.NonceSize() {
+		return "", errors.New("invalid stored Notion token")
+	}
+	size := s.tokens.NonceSize()
+	token, err := s.tokens.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt Notion token: %w", err)
+	}
+	return string(token), nil
+}
+
+// lock serializes the syncs of a user and returns the unlock function
+func (s *notionService) lock(userID uint) func() {
+	mu, _ := s.locks.LoadOrStore(userID, &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// client returns the Notion client of the user of an integration
+func (s *notionService) client(integration *models.NotionIntegration) (*notion.Client, error) {
+	token, err := s.open(integration.Token)
+	if err != nil {
+		return nil, err
+	}
+	if c, ok := s.clients.Load(integration.UserID); ok && c.(*notion.Client).Token == token {
+		return c.(*notion.Client), nil
+	}
+	c := notion.NewClient(s.apiURL, token)
+	s.clients.Store(integration.UserID, c)
+	return c, nil
+}
+
+// integration loads the integration of a user with its schema, gorm.ErrRecordNotFound
+// when the user didn't connect Notion
+func (s *notionService) integration(userID uint) (*models.NotionIntegration, *notion.Schema, error) {
+	var integration models.NotionIntegration
+	if err := s.db.Where("user_id = ?", userID).First(&integration).Error; err != nil {
+		return nil, nil, err
+	}
+	var schema notion.Schema
+	if err := json.Unmarshal([]byte(integration.Schema), &schema); err != nil {
+		return nil, nil, fmt.Errorf("invalid Notion schema of user %d: %w", userID, err)
+	}
+	return &integration, &schema, nil
+}
+
+// connect discovers the databases of the workspace token gives access to and stores
+// them with the token. An empty token discovers again with the stored one. The
+// webhook of an integration connected before is kept.
+func (s *notionService) connect(ctx context.Context, userID uint, token string) (*models.NotionIntegration, *notion.Schema, error) {
+	if s.tokens == nil {
+		return nil, nil, errNotionDisabled
+	}
+
+	var integration models.NotionIntegration
+	err := s.db.Where("user_id = ?", userID).First(&integration).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if token == "" {
+			return nil, nil, err
+		}
+		key, _, err := newOpaqueToken()
+		if err != nil {
+			return nil, nil, err
+		}
+		integration = models.NotionIntegration{UserID: userID, WebhookKey: key}
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	if token != "" {
+		if integration.Token, err = s.seal(token); err != nil {
+			return nil, nil, err
+		}
+	}
+	c, err := s.client(&integration)
+	if err != nil {
+		return nil, nil, err
+	}
+	schema, err := notion.Discover(ctx, c)
+	if err != nil {
+		return nil, nil, err
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	integration.AssignmentsDBID = schema.AssignmentsDB
+	integration.CoursesDBID = schema.CoursesDB
+	integration.Schema = string(data)
+	if err := s.db.Save(&integration).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to save Notion integration: %w", err)
+	}
+	return &integration, schema, nil
+}
+
+// disconnect forgets the integration of a user. The rows lose their pages, they are
+// created again in the workspace connected next.
+func (s *notionService) disconnect(userID uint) error {
+	defer s.lock(userID)()
+	s.clients.Delete(userID)
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.NotionIntegration{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&assignment.Assignment{}).Where("user_id = ? AND notion_id <> ''", userID).Update("notion_id", "").Error; err != nil {
+			return err
+		}
+		return tx.Model(&course.Course{}).Where("user_id = ? AND notion_id <> ''", userID).Update("notion_id", "").Error
+	})
+}
+
+// recordSync stores the outcome of the last sync of a user
+func (s *notionService) recordSync(userID uint, syncErr error) {
+	updates := map[string]interface{}{"last_sync_error": ""}
+	if syncErr != nil {
+		updates["last_sync_error"] = syncErr.Error()
+	} else {
+		updates["last_synced_at"] = time.Now()
+	}
+	s.db.Model(&models.NotionIntegration{}).Where("user_id = ?", userID).UpdateColumns(updates)
+}
+
+// schedule pushes a committed change of an assignment or course to Notion in the
+// background
+func (s *notionService) schedule(userID uint, entity models.Entity, row map[string]string) {
+	if entity != models.Assignment && entity != models.EntityCourse {
+		return
+	}
+	id, err := strconv.ParseUint(row["id"], 10, 64)
+	if err != nil {
+		return
+	}
+
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		err := s.push(userID, entity, uint(id))
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return
+		}
+		if err != nil {
+			PrintLog(fmt.Sprintf("Failed to push %s %d to Notion: %v", entity, id, err))
+		}
+		s.recordSync(userID, err)
+	}()
+}
+
+// schedulePull runs the first sync of a newly connected workspace in the background
+func (s *notionService) schedulePull(userID uint) {
+	s.pending.Add(1)
+	go func() {
+		defer s.pending.Done()
+		err := s.pull(userID)
+		if err != nil {
+			PrintLog(fmt.Sprintf("Failed to sync the Notion workspace of user %d: %v", userID, err))
+		}
+		s.recordSync(userID, err)
+	}()
+}
+
+// pull applies every page of the linked databases, then gives a page to the rows
+// that have none. Webhooks only carry the changes made after the subscription.
+func (s *notionService) pull(userID uint) error {
+	integration, schema, err := s.integration(userID)
+	if err != nil {
+		return err
+	}
+	c, err := s.client(integration)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), notionPullTimeout)
+	defer cancel()
+
+	err = func() error {
+		defer s.lock(userID)()
+		if integration.CoursesDBID != "" {
+			err := c.QueryDatabase(ctx, integration.CoursesDBID, nil, func(page *notion.Page) error {
+				_, err := s.applyCourse(userID, schema, page)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return c.QueryDatabase(ctx, integration.AssignmentsDBID, nil, func(page *notion.Page) error {
+			return s.applyAssignment(ctx, c, userID, schema, page)
+		})
+	}()
+	if err != nil {
+		return err
+	}
+
+	for _, entity := range []models.Entity{models.EntityCourse, models.Assignment} {
+		var ids []uint
+		table := "courses"
+		if entity == models.Assignment {
+			table = "assignments"
+		}
+		if err := s.db.Table(table).Where("user_id = ? AND notion_id = '' AND deleted_at IS NULL", userID).Pluck("id", &ids).Error; err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if err := s.push(userID, entity, id); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// push writes the current state of a row to its Notion page, creating the page for
+// rows that have none and archiving it for deleted rows
+func (s *notionService) push(userID uint, entity models.Entity, id uint) error {
+	integration, schema, err := s.integration(userID)
+	if err != nil {
+		return err
+	}
+	c, err := s.client(integration)
+	if err != nil {
+		return err
+	}
+
+	defer s.lock(userID)()
+	ctx, cancel := context.WithTimeout(context.Background(), notionTimeout)
+	defer cancel()
+
+	if entity == models.EntityCourse {
+		var cr course.Course
+		if err := s.db.Unscoped().First(&cr, id).Error; err != nil {
+			return err
+		}
+		return s.pushCourse(ctx, c, integration, schema, &cr)
+	}
+
+	var a assignment.Assignment
+	if err := s.db.Unscoped().First(&a, id).Error; err != nil {
+		return err
+	}
+	if a.DeletedAt.Valid {
+		if a.NotionID == "" {
+			return nil
+		}
+		return c.ArchivePage(ctx, a.NotionID)
+	}
+
+	values := pushValues(assignmentRow(&a), schema.Assignment)
+	if prop, ok := schema.Assignment["course_code"]; ok && prop.Type == "relation" {
+		values["course_code"] = ""
+		var cr course.Course
+		if err := s.db.Where("user_id = ? AND code = ?", userID, a.CourseCode).First(&cr).Error; err == nil {
+			// The course page has to exist to be related to
+			if cr.NotionID == "" {
+				if err := s.pushCourse(ctx, c, integration, schema, &cr); err != nil {
+					return err
+				}
+			}
+			values["course_code"] = cr.NotionID
+		}
+	}
+
+	properties := schema.Assignment.Write(values)
+	if a.NotionID != "" {
+		return c.UpdatePage(ctx, a.NotionID, properties)
+	}
+	page, err := c.CreatePage(ctx, integration.AssignmentsDBID, properties)
+	if err != nil {
+		return err
+	}
+	return s.db.Model(&a).Update("notion_id", page.ID).Error
+}
+
+// pushCourse writes a course to its Notion page, when a courses database is linked
+func (s *notionService) pushCourse(ctx context.Context, c *notion.Client, integration *models.NotionIntegration, schema *notion.Schema, cr *course.Course) error {
+	if integration.CoursesDBID == "" {
+		return nil
+	}
+	if cr.DeletedAt.Valid {
+		if cr.NotionID == "" {
+			return nil
+		}
+		return c.ArchivePage(ctx, cr.NotionID)
+	}
+
+	properties := schema.Course.Write(pushValues(courseRow(cr), schema.Course))
+	if cr.NotionID != "" {
+		return c.UpdatePage(ctx, cr.NotionID, properties)
+	}
+	page, err := c.CreatePage(ctx, integration.CoursesDBID, properties)
+	if err != nil {
+		return err
+	}
+	cr.NotionID = page.ID
+	return s.db.Model(cr).Update("notion_id", page.ID).Error
+}
+
+// pushValues keeps the fields of a row the mapping has a column for
+func pushValues(row map[string]string, mapping notion.Mapping) map[string]string {
+	values := map[string]string{}
+	for field := range mapping {
+		values[field] = row[field]
+	}
+	return values
+}
+
+// applyEvent applies a webhook delivery of the integration
+func (s *notionService) applyEvent(ctx context.Context, integration *models.NotionIntegration, event *notion.Event) error {
+	switch event.Type {
+	case notion.EventDatabaseSchemaUpdated:
+		_, _, err := s.connect(ctx, integration.UserID, "")
+		return err
+	case notion.EventPageCreated, notion.EventPagePropertiesUpdated, notion.EventPageUndeleted, notion.EventPageDeleted:
+	default:
+		return nil
+	}
+
+	integration, schema, err := s.integration(integration.UserID)
+	if err != nil {
+		return err
+	}
+	c, err := s.client(integration)
+	if err != nil {
+		return err
+	}
+
+	defer s.lock(integration.UserID)()
+
+	if event.Type == notion.EventPageDeleted {
+		return s.deletePage(integration.UserID, event.Entity.Id)
+	}
+
+	page, err := c.GetPage(ctx, event.Entity.Id)
+	if err != nil {
+		return err
+	}
+	if page.Deleted() {
+		return s.deletePage(integration.UserID, page.ID)
+	}
+
+	switch {
+	case notion.SameID(page.Parent.DatabaseID, schema.AssignmentsDB):
+		return s.applyAssignment(ctx, c, integration.UserID, schema, page)
+	case notion.SameID(page.Parent.DatabaseID, schema.CoursesDB):
+		_, err := s.applyCourse(integration.UserID, schema, page)
+		return err
+	}
+	return nil
+}
+
+// applyAssignment creates or updates the assignment of a page of the assignments database
+func (s *notionService) applyAssignment(ctx context.Context, c *notion.Client, userID uint, schema *notion.Schema, page *notion.Page) error {
+	values := schema.Assignment.Read(page)
+
+	// A relation holds the course page, known here by its code
+	if prop, ok := schema.Assignment["course_code"]; ok && prop.Type == "relation" {
+		pageID, _, _ := strings.Cut(values["course_code"], ",")
+		values["course_code"] = ""
+		if pageID != "" {
+			code, err := s.courseCode(ctx, c, userID, schema, pageID)
+			if err != nil {
+				return err
+			}
+			values["course_code"] = code
+		}
+	}
+
+	var a assignment.Assignment
+	err := s.db.Unscoped().Where("user_id = ? AND notion_id = ?", userID, page.ID).First(&a).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return s.createAssignment(userID, page.ID, values)
+	}
+	if err != nil {
+		return err
+	}
+
+	updates := changedColumns(assignmentRow(&a), values, assignmentColumns)
+	if a.DeletedAt.Valid {
+		updates["deleted_at"] = nil
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	if err := s.db.Unscoped().Model(&a).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update assignment %d: %w", a.ID, err)
+	}
+	if err := s.db.First(&a, a.ID).Error; err != nil {
+		return err
+	}
+
+	notifyFromNotion(userID, models.OperationUpdate, models.Assignment, assignmentRow(&a), fmt.Sprintf("%s updated in Notion", a.Title))
+	return nil
+}
+
+// createAssignment adds the assignment of a new page, it needs a title and a deadline
+func (s *notionService) createAssignment(userID uint, pageID string, values map[string]string) error {
+	deadline, err := time.Parse(time.DateOnly, values["deadline"])
+	if values["title"] == "" || err != nil {
+		PrintLog(fmt.Sprintf("Skipping Notion page %s of user %d without title or deadline", pageID, userID))
+		return nil
+	}
+
+	a := &assignment.Assignment{
+		UserID:     userID,
+		NotionID:   pageID,
+		Title:      values["title"],
+		Todo:       values["todo"],
+		Deadline:   deadline,
+		CourseCode: values["course_code"],
+		TypeName:   values["type"],
+		StatusName: values["status"],
+		Link:       values["link"],
+	}
+	if a.TypeName == "" {
+		a.TypeName = notionDefaultType
+	}
+	if a.StatusName == "" {
+		a.StatusName = notionDefaultStatus
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		localID, err := nextNotionLocalID(tx, "assignments")
+		if err != nil {
+			return err
+		}
+		a.LocalID = localID
+		return tx.Create(a).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create assignment of page %s: %w", pageID, err)
+	}
+
+	notifyFromNotion(userID, models.OperationCreate, models.Assignment, assignmentRow(a), fmt.Sprintf("%s added in Notion", a.Title))
+	return nil
+}
+
+// courseCode returns the code of the course of a page, applying the page first when
+// the course isn't known yet
+func (s *notionService) courseCode(ctx context.Context, c *notion.Client, userID uint, schema *notion.Schema, pageID string) (string, error) {
+	var cr course.Course
+	err := s.db.Where("user_id = ? AND notion_id = ?", userID, pageID).First(&cr).Error
+	if err == nil {
+		return cr.Code, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	page, err := c.GetPage(ctx, pageID)
+	if err != nil {
+		return "", err
+	}
+	if page.Deleted() || !notion.SameID(page.Parent.DatabaseID, schema.CoursesDB) {
+		return "", nil
+	}
+	applied, err := s.applyCourse(userID, schema, page)
+	if err != nil || applied == nil {
+		return "", err
+	}
+	return applied.Code, nil
+}
+
+// applyCourse creates or updates the course of a page of the courses database and
+// returns it, nil when the page can't be a course
+func (s *notionService) applyCourse(userID uint, schema *notion.Schema, page *notion.Page) (*course.Course, error) {
+	values := schema.Course.Read(page)
+
+	var cr course.Course
+	err := s.db.Where("user_id = ? AND notion_id = ?", userID, page.ID).First(&cr).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// The course may exist already, created in the app before it was linked
+		if values["code"] != "" {
+			err = s.db.Where("user_id = ? AND code = ?", userID, values["code"]).First(&cr).Error
+		}
+	}
+
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		if values["code"] == "" || values["name"] == "" {
+			PrintLog(fmt.Sprintf("Skipping Notion page %s of user %d without course code or name", page.ID, userID))
+			return nil, nil
+		}
+		cr = course.Course{
+			UserID:     userID,
+			NotionID:   page.ID,
+			Code:       values["code"],
+			Name:       values["name"],
+			RoomNumber: values["room_number"],
+			Duration:   values["duration"],
+			Instructor: values["instructor"],
+			Schedule:   values["schedule"],
+			Semester:   values["semester"],
+		}
+		err := s.db.Transaction(func(tx *gorm.DB) error {
+			localID, err := nextNotionLocalID(tx, "courses")
+			if err != nil {
+				return err
+			}
+			cr.LocalID = localID
+			return tx.Create(&cr).Error
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create course of page %s: %w", page.ID, err)
+		}
+		notifyFromNotion(userID, models.OperationCreate, models.EntityCourse, courseRow(&cr), fmt.Sprintf("%s added in Notion", cr.Code))
+		return &cr, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	updates := changedColumns(courseRow(&cr), values, courseColumns)
+	if cr.NotionID != page.ID {
+		updates["notion_id"] = page.ID
+	}
+	// Assignments refer to their course by code
+	if code, ok := updates["code"]; ok && (code == "" || code != cr.Code && s.db.Where("user_id = ? AND code = ?", userID, code).First(&course.Course{}).Error == nil) {
+		delete(updates, "code")
+	}
+	if len(updates) == 0 {
+		return &cr, nil
+	}
+
+	oldCode := cr.Code
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&cr).Updates(updates).Error; err != nil {
+			return err
+		}
+		if cr.Code != oldCode {
+			return tx.Model(&assignment.Assignment{}).Where("user_id = ? AND course_code = ?", userID, oldCode).Update("course_code", cr.Code).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to update course %d: %w", cr.ID, err)
+	}
+
+	notifyFromNotion(userID, models.OperationUpdate, models.EntityCourse, courseRow(&cr), fmt.Sprintf("%s updated in Notion", cr.Code))
+	return &cr, nil
+}
+
+// deletePage deletes the assignment or course of a page moved to the trash
+func (s *notionService) deletePage(userID uint, pageID string) error {
+	var deleted []deletedRow
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var a assignment.Assignment
+		err := tx.Where("user_id = ? AND notion_id = ?", userID, pageID).First(&a).Error
+		if err == nil {
+			deleted, err = deleteAssignment(tx, &a)
+			return err
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		var cr course.Course
+		err = tx.Where("user_id = ? AND notion_id = ?", userID, pageID).First(&cr).Error
+		if err == nil {
+			deleted, err = deleteCourse(tx, &cr)
+			return err
+		}
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	refreshStorageInfo(s.db, userID, deleted)
+	for _, d := range deleted {
+		notifyFromNotion(userID, models.OperationDelete, d.entity, d.row, fmt.Sprintf("%s deleted in Notion", d.label))
+	}
+	return nil
+}
+
+// changedColumns returns the columns whose value in values differs from the row
+func changedColumns(row, values map[string]string, columns map[string]string) map[string]interface{} {
+	updates := map[string]interface{}{}
+	for field, value := range values {
+		column, ok := columns[field]
+		if !ok || row[field] == value {
+			continue
+		}
+		if column == "deadline" {
+			deadline, err := time.Parse(time.DateOnly, value)
+			if err != nil {
+				continue
+			}
+			updates[column] = deadline
+			continue
+		}
+		updates[column] = value
+	}
+	return updates
+}
+
+// nextNotionLocalID returns an unused local ID for a row created from Notion
+func nextNotionLocalID(tx *gorm.DB, table string) (uint, error) {
+	var last uint
+	err := tx.Table(table).Unscoped().Where("local_id >= ?", uint(notionLocalIDBase)).
+		Select("COALESCE(MAX(local_id), 0)").Scan(&last).Error
+	if err != nil {
+		return 0, err
+	}
+	if last == 0 {
+		return notionLocalIDBase, nil
+	}
+	return last + 1, nil
+}
+
+// notifyFromNotion sends a change made in Notion to every device of the user
+func notifyFromNotion(userID uint, op models.Operation, entity models.Entity, row map[string]string, message string) {
+	if sseServer == nil {
+		return
+	}
+	sseServer.SendNotification(userID, "", entity, op, row["id"], message, row)
+}
+
+// ConnectNotionHandler stores the Notion token of the user, finds the assignments and
+// courses databases of their workspace and returns the webhook URL to subscribe
+// with. Without a token the databases are found again with the stored one.
+func ConnectNotionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	var input struct {
+		Token string `json:"token"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body: %v", err))
+			return
+		}
+	}
+
+	integration, schema, err := notionSync.connect(r.Context(), userID, strings.TrimSpace(input.Token))
+	switch {
+	case errors.Is(err, errNotionDisabled):
+		PrintERROR(w, http.StatusServiceUnavailable, err.Error())
+		return
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		PrintERROR(w, http.StatusBadRequest, "Notion token required")
+		return
+	case notion.IsCode(err, notion.CodeUnauthorized):
+		PrintERROR(w, http.StatusUnprocessableEntity, "Notion rejected the token")
+		return
+	case errors.Is(err, notion.ErrNoDatabase):
+		PrintERROR(w, http.StatusUnprocessableEntity, "Share an assignments database with the integration first")
+		return
+	case err != nil:
+		PrintERROR(w, http.StatusBadGateway, fmt.Sprintf("Failed to connect Notion: %v", err))
+		return
+	}
+
+	notionSync.schedulePull(userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Notion connected successfully",
+		"webhook_url": accountLinkBase + "/notion/webhook?key=" + integration.WebhookKey,
+		"schema":      schema,
+	})
+}
+
+// NotionStatusHandler checks that the stored token still reaches the linked
+// assignments database and returns the state of the sync
+func NotionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	integration, _, err := notionSync.integration(userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"connected": false})
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to load Notion integration: %v", err))
+		return
+	}
+
+	status := map[string]interface{}{
+		"connected":        true,
+		"ok":               true,
+		"error":            "",
+		"assignments_db":   integration.AssignmentsDBID,
+		"courses_db":       integration.CoursesDBID,
+		"webhook_verified": integration.VerificationToken != "",
+		"last_sync_error":  integration.LastSyncError,
+		"last_synced_at":   "",
+	}
+	if integration.LastSyncedAt != nil {
+		status["last_synced_at"] = integration.LastSyncedAt.Format(time.RFC3339)
+	}
+
+	c, err := notionSync.client(integration)
+	if err == nil {
+		_, err = c.GetDatabase(r.Context(), integration.AssignmentsDBID)
+	}
+	if err != nil {
+		status["ok"] = false
+		status["error"] = err.Error()
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// DisconnectNotionHandler forgets the Notion integration of the user
+func DisconnectNotionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	if err := notionSync.disconnect(userID); err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to disconnect Notion: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"message": "Notion disconnected successfully"})
+}
+
+// NotionWebhookHandler receives the webhook deliveries of an integration. The first
+// request of a subscription carries the token that signs the others.
+func NotionWebhookHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, notionWebhookMaxBody))
+	if err != nil {
+		PrintERROR(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var integration models.NotionIntegration
+	key := r.URL.Query().Get("key")
+	if key == "" || notionSync.db.Where("webhook_key = ?", key).First(&integration).Error != nil {
+		PrintERROR(w, http.StatusNotFound, "Unknown webhook")
+		return
+	}
+
+	signature := r.Header.Get(notion.SignatureHeader)
+	if signature == "" {
+		var verification notion.Verification
+		if json.Unmarshal(body, &verification) != nil || verification.VerificationToken == "" {
+			PrintERROR(w, http.StatusUnauthorized, "Missing signature")
+			return
+		}
+		// Only the first subscription of the URL gets to set the token
+		result := notionSync.db.Model(&integration).Where("verification_token = '' OR verification_token IS NULL").
+			Update("verification_token", verification.VerificationToken)
+		if result.Error != nil {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to store verification token: %v", result.Error))
+			return
+		}
+		if result.RowsAffected == 0 {
+			PrintERROR(w, http.StatusConflict, "Webhook already verified")
+			return
+		}
+		PrintLog(fmt.Sprintf("Verified Notion webhook of user %d", integration.UserID))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if !notion.VerifySignature(integration.VerificationToken, body, signature) {
+		PrintERROR(w, http.StatusUnauthorized, "Invalid signature")
+		return
+	}
+
+	var event notion.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid event: %v", err))
+		return
+	}
+
+	// Our own writes come back as events authored by the integration
+	if notion.ByBot(&event) {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), notionTimeout)
+	defer cancel()
+	err = notionSync.applyEvent(ctx, &integration, &event)
+	notionSync.recordSync(integration.UserID, err)
+	if err != nil {
+		// Notion delivers the event again
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to apply %s: %v", event.Type, err))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
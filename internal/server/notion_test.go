@@ -0,0 +1,437 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/services/notion"
+	"unipilot/internal/services/notion/notiontest"
+)
+
+const testNotionToken = "secret_notion"
+
+// notionWorkspace is a fake Notion workspace with an assignments database related
+// to a courses database, its columns named as in the original template
+type notionWorkspace struct {
+	*notiontest.Server
+	courses     string
+	assignments string
+}
+
+func newNotionWorkspace(t *testing.T) *notionWorkspace {
+	t.Helper()
+
+	fake := notiontest.NewServer(testNotionToken)
+	t.Cleanup(fake.Close)
+
+	ws := &notionWorkspace{Server: fake}
+	ws.courses = fake.AddDatabase("Courses", map[string]notion.Property{
+		"Name": {ID: "title", Type: "title"},
+		"Code": {Type: "rich_text"},
+	})
+	ws.assignments = fake.AddDatabase("Assignments", map[string]notion.Property{
+		"Assignment name": {ID: "title", Type: "title"},
+		"Deadline":        {ID: "_UjC", Type: "date"},
+		"Courses":         {ID: "w%3FC%3B", Type: "relation", Relation: &notion.RelationConfig{DatabaseID: ws.courses}},
+		"Type":            {ID: "S~Ce", Type: "select"},
+		"Status":          {ID: "%5Bm%5Cs", Type: "status"},
+		"TODO":            {ID: "%5DJfC", Type: "rich_text"},
+	})
+	return ws
+}
+
+// connectNotion points the sync at ws and connects it with the token of the user, it
+// returns the integration with its webhook verified with "verify"
+func (s *testServer) connectNotion(t *testing.T, ws *notionWorkspace, token string) *models.NotionIntegration {
+	t.Helper()
+
+	notionSync.apiURL = ws.URL
+
+	res := s.do(t, token, http.MethodPost, "/notion/connect", map[string]string{"token": testNotionToken})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("connect: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+
+	var integration models.NotionIntegration
+	if err := s.db.First(&integration).Error; err != nil {
+		t.Fatal(err)
+	}
+	res = s.webhook(t, integration.WebhookKey, map[string]string{"verification_token": "verify"}, "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("verification: status %d", res.StatusCode)
+	}
+	integration.VerificationToken = "verify"
+	return &integration
+}
+
+// webhook delivers body to the webhook of key, signed with token when set
+func (s *testServer) webhook(t *testing.T, key string, body interface{}, token string) *http.Response {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL+"/notion/webhook?key="+key, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set(notion.SignatureHeader, notion.Sign(token, data))
+	}
+	return send(t, req)
+}
+
+// pageEvent is a webhook event of a page edited by a person
+func pageEvent(eventType, pageID string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    eventType,
+		"authors": []map[string]string{{"id": "u1", "type": "person"}},
+		"entity":  map[string]string{"id": pageID, "type": "page"},
+	}
+}
+
+func TestConnectNotionDiscoversSchema(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+	token := s.login(t, "alice")
+	ws := newNotionWorkspace(t)
+	notionSync.apiURL = ws.URL
+
+	res := s.do(t, token, http.MethodPost, "/notion/connect", map[string]string{"token": "wrong"})
+	if res.StatusCode != http.StatusUnprocessableEntity {
+		t.Fatalf("connect with a token Notion rejects: status %d, want 422", res.StatusCode)
+	}
+	var count int64
+	s.db.Model(&models.NotionIntegration{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d integrations after a rejected token, want 0", count)
+	}
+
+	res = s.do(t, token, http.MethodPost, "/notion/connect", map[string]string{"token": testNotionToken})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("connect: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+	var body struct {
+		WebhookURL string        `json:"webhook_url"`
+		Schema     notion.Schema `json:"schema"`
+	}
+	json.NewDecoder(res.Body).Decode(&body)
+
+	if body.Schema.AssignmentsDB != ws.assignments || body.Schema.CoursesDB != ws.courses {
+		t.Fatalf("databases = %s, %s, want %s, %s", body.Schema.AssignmentsDB, body.Schema.CoursesDB, ws.assignments, ws.courses)
+	}
+	for field, id := range map[string]string{"title": "title", "deadline": "_UjC", "course_code": "w%3FC%3B", "type": "S~Ce", "status": "%5Bm%5Cs", "todo": "%5DJfC"} {
+		if got := body.Schema.Assignment[field].ID; got != id {
+			t.Errorf("%s mapped to %q, want %q", field, got, id)
+		}
+	}
+	if body.Schema.Course["code"].Name != "Code" || body.Schema.Course["name"].Name != "Name" {
+		t.Errorf("course mapping = %+v", body.Schema.Course)
+	}
+
+	// The token is only stored encrypted
+	var before models.NotionIntegration
+	s.db.First(&before)
+	if before.Token == "" || strings.Contains(before.Token, testNotionToken) {
+		t.Errorf("stored token %q", before.Token)
+	}
+
+	// Connecting again with the stored token keeps the webhook
+	res = s.do(t, token, http.MethodPost, "/notion/connect", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("connect again: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+	var after models.NotionIntegration
+	s.db.First(&after)
+	if before.WebhookKey == "" || after.WebhookKey != before.WebhookKey {
+		t.Errorf("webhook key %q became %q", before.WebhookKey, after.WebhookKey)
+	}
+}
+
+func TestNotionWebhookVerification(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+	token := s.login(t, "alice")
+	ws := newNotionWorkspace(t)
+	integration := s.connectNotion(t, ws, token)
+
+	// The token is set by the first subscription only
+	res := s.webhook(t, integration.WebhookKey, map[string]string{"verification_token": "other"}, "")
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("second verification: status %d, want 409", res.StatusCode)
+	}
+
+	page := ws.AddPage(ws.assignments, map[string]notion.PropertyValue{"Assignment name": notiontest.Title("Essay"), "Deadline": notiontest.Date("2025-06-05")})
+	event := pageEvent(notion.EventPageCreated, page)
+
+	res = s.webhook(t, "unknown", event, "verify")
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown key: status %d, want 404", res.StatusCode)
+	}
+	res = s.webhook(t, integration.WebhookKey, event, "")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("unsigned event: status %d, want 401", res.StatusCode)
+	}
+	res = s.webhook(t, integration.WebhookKey, event, "other")
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("event signed with another token: status %d, want 401", res.StatusCode)
+	}
+
+	var count int64
+	s.db.Model(&assignment.Assignment{}).Count(&count)
+	if count != 0 {
+		t.Fatalf("%d assignments after rejected events, want 0", count)
+	}
+}
+
+func TestNotionWebhookAppliesPages(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token := s.login(t, "alice")
+	ws := newNotionWorkspace(t)
+	integration := s.connectNotion(t, ws, token)
+
+	coursePage := ws.AddPage(ws.courses, map[string]notion.PropertyValue{"Name": notiontest.Title("Intro"), "Code": notiontest.Text("CS101")})
+	page := ws.AddPage(ws.assignments, map[string]notion.PropertyValue{
+		"Assignment name": notiontest.Title("Essay"),
+		"Deadline":        notiontest.Date("2025-06-05T00:00:00.000Z"),
+		"Courses":         notiontest.Relation(coursePage),
+	})
+
+	res := s.webhook(t, integration.WebhookKey, pageEvent(notion.EventPageCreated, page), "verify")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("page created: status %d", res.StatusCode)
+	}
+
+	var a assignment.Assignment
+	if err := s.db.Where("notion_id = ?", page).First(&a).Error; err != nil {
+		t.Fatalf("assignment of the page: %v", err)
+	}
+	if a.UserID != alice.ID || a.Title != "Essay" || a.Deadline.Format(time.DateOnly) != "2025-06-05" || a.CourseCode != "CS101" {
+		t.Errorf("assignment = %s due %s in %q of user %d", a.Title, a.Deadline.Format(time.DateOnly), a.CourseCode, a.UserID)
+	}
+	if a.TypeName != notionDefaultType || a.StatusName != notionDefaultStatus || a.LocalID < notionLocalIDBase {
+		t.Errorf("assignment type %q status %q local ID %d", a.TypeName, a.StatusName, a.LocalID)
+	}
+	var cr course.Course
+	if err := s.db.Where("notion_id = ?", coursePage).First(&cr).Error; err != nil || cr.Code != "CS101" || cr.Name != "Intro" {
+		t.Errorf("course of the relation = %+v, %v", cr, err)
+	}
+
+	// An edit only writes the columns that changed
+	ws.SetValues(page, map[string]notion.PropertyValue{"Assignment name": notiontest.Title("Final essay"), "Status": notiontest.Status("In progress")})
+	res = s.webhook(t, integration.WebhookKey, pageEvent(notion.EventPagePropertiesUpdated, page), "verify")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("page updated: status %d", res.StatusCode)
+	}
+	s.db.First(&a, a.ID)
+	if a.Title != "Final essay" || a.StatusName != "In progress" || a.CourseCode != "CS101" {
+		t.Errorf("assignment after edit = %s %s %s", a.Title, a.StatusName, a.CourseCode)
+	}
+
+	// Edits of the integration itself are echoes of pushed changes
+	ws.SetValues(page, map[string]notion.PropertyValue{"Assignment name": notiontest.Title("Echo")})
+	event := pageEvent(notion.EventPagePropertiesUpdated, page)
+	event["authors"] = []map[string]string{{"id": "b1", "type": "bot"}}
+	s.webhook(t, integration.WebhookKey, event, "verify")
+	s.db.First(&a, a.ID)
+	if a.Title != "Final essay" {
+		t.Errorf("bot edit applied, title %q", a.Title)
+	}
+
+	ws.Archive(page)
+	res = s.webhook(t, integration.WebhookKey, pageEvent(notion.EventPageDeleted, page), "verify")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("page deleted: status %d", res.StatusCode)
+	}
+	if err := s.db.First(&assignment.Assignment{}, a.ID).Error; err == nil {
+		t.Error("assignment of the archived page not deleted")
+	}
+
+	// Restoring the page brings the row back
+	ws.Restore(page)
+	res = s.webhook(t, integration.WebhookKey, pageEvent(notion.EventPageUndeleted, page), "verify")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("page undeleted: status %d", res.StatusCode)
+	}
+	if err := s.db.First(&a, a.ID).Error; err != nil || a.Title != "Echo" {
+		t.Errorf("assignment of the restored page = %q, %v", a.Title, err)
+	}
+}
+
+func TestNotionPushesLocalChanges(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token := s.login(t, "alice")
+	ws := newNotionWorkspace(t)
+	s.connectNotion(t, ws, token)
+
+	cr := &course.Course{UserID: alice.ID, LocalID: 1, Code: "CS101", Name: "Intro"}
+	if err := s.db.Omit("User").Create(cr).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	res := s.do(t, token, http.MethodPost, "/assignment", map[string]string{
+		"local_id": "1", "title": "Essay", "deadline": "2025-06-05", "course_code": "CS101", "type": "HW", "status": "Not started",
+	})
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+
+	var a assignment.Assignment
+	s.db.Where("user_id = ? AND local_id = ?", alice.ID, 1).First(&a)
+	if a.NotionID == "" {
+		t.Fatal("notion_id of the pushed assignment not saved")
+	}
+	page, ok := ws.Page(a.NotionID)
+	if !ok || !notion.SameID(page.Parent.DatabaseID, ws.assignments) {
+		t.Fatalf("page %s not in the assignments database", a.NotionID)
+	}
+	if got := page.Properties["Assignment name"].String(); got != "Essay" {
+		t.Errorf("page title %q, want Essay", got)
+	}
+	if got := page.Properties["Deadline"].String(); got != "2025-06-05" {
+		t.Errorf("page deadline %q, want 2025-06-05", got)
+	}
+
+	// The course got a page to be related to
+	s.db.First(cr, cr.ID)
+	if cr.NotionID == "" || page.Properties["Courses"].String() != cr.NotionID {
+		t.Errorf("page relation %q, course page %q", page.Properties["Courses"].String(), cr.NotionID)
+	}
+
+	res = s.do(t, token, http.MethodPost, "/assignment/update", map[string]string{"id": "1", "column": "title", "value": "Final essay"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+	page, _ = ws.Page(a.NotionID)
+	if got := page.Properties["Assignment name"].String(); got != "Final essay" {
+		t.Errorf("page title after update %q, want Final essay", got)
+	}
+
+	res = s.do(t, token, http.MethodPost, "/assignment/delete?id=1", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("delete: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+	if page, _ = ws.Page(a.NotionID); !page.Archived {
+		t.Error("page of the deleted assignment not archived")
+	}
+}
+
+func TestNotionStatusAndDisconnect(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token := s.login(t, "alice")
+	ws := newNotionWorkspace(t)
+
+	var status map[string]interface{}
+	json.NewDecoder(s.do(t, token, http.MethodGet, "/notion/status", nil).Body).Decode(&status)
+	if status["connected"] != false {
+		t.Fatalf("status before connecting = %v", status)
+	}
+
+	s.connectNotion(t, ws, token)
+	res := s.do(t, token, http.MethodPost, "/assignment", map[string]string{
+		"local_id": "1", "title": "Essay", "deadline": "2025-06-05", "course_code": "CS101", "type": "HW", "status": "Not started",
+	})
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		t.Fatalf("create: status %d", res.StatusCode)
+	}
+	notionSync.pending.Wait()
+
+	status = nil
+	json.NewDecoder(s.do(t, token, http.MethodGet, "/notion/status", nil).Body).Decode(&status)
+	if status["connected"] != true || status["ok"] != true || status["webhook_verified"] != true || status["assignments_db"] != ws.assignments {
+		t.Fatalf("status = %v", status)
+	}
+	if status["last_synced_at"] == "" || status["last_sync_error"] != "" {
+		t.Errorf("sync state = %v, %v", status["last_synced_at"], status["last_sync_error"])
+	}
+
+	// The token stops working, e.g. the integration was removed from the workspace
+	ws.Token = "revoked"
+	status = nil
+	json.NewDecoder(s.do(t, token, http.MethodGet, "/notion/status", nil).Body).Decode(&status)
+	if status["ok"] != false || status["error"] == "" {
+		t.Errorf("status with a revoked token = %v", status)
+	}
+
+	res = s.do(t, token, http.MethodPost, "/notion/disconnect", nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("disconnect: status %d", res.StatusCode)
+	}
+	var count int64
+	s.db.Model(&models.NotionIntegration{}).Where("user_id = ?", alice.ID).Count(&count)
+	if count != 0 {
+		t.Errorf("%d integrations after disconnecting", count)
+	}
+	var a assignment.Assignment
+	s.db.Where("user_id = ?", alice.ID).First(&a)
+	if a.NotionID != "" {
+		t.Errorf("assignment still on page %s", a.NotionID)
+	}
+
+	// Changes are no longer pushed
+	requests := len(ws.Requests())
+	s.do(t, token, http.MethodPost, "/assignment/update", map[string]string{"id": "1", "column": "title", "value": "Final essay"})
+	notionSync.pending.Wait()
+	if len(ws.Requests()) != requests {
+		t.Error("change pushed after disconnecting")
+	}
+}
+
+func TestConnectNotionSyncsExistingRows(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token := s.login(t, "alice")
+	ws := newNotionWorkspace(t)
+
+	// A workspace bigger than a page of query results
+	coursePage := ws.AddPage(ws.courses, map[string]notion.PropertyValue{"Name": notiontest.Title("Intro"), "Code": notiontest.Text("CS101")})
+	for i := 0; i < 120; i++ {
+		ws.AddPage(ws.assignments, map[string]notion.PropertyValue{
+			"Assignment name": notiontest.Title(fmt.Sprintf("Reading %d", i)),
+			"Deadline":        notiontest.Date("2025-06-05"),
+			"Courses":         notiontest.Relation(coursePage),
+		})
+	}
+	local := &assignment.Assignment{UserID: alice.ID, LocalID: 1, Title: "Essay", Deadline: time.Now(), CourseCode: "CS101", TypeName: "HW", StatusName: "Not started"}
+	if err := s.db.Omit("User", "Course", "Type", "Status", "Documents").Create(local).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	// The first requests are rate limited
+	ws.Fail(2, http.StatusTooManyRequests, "rate_limited", "0")
+	s.connectNotion(t, ws, token)
+
+	var count int64
+	s.db.Model(&assignment.Assignment{}).Where("user_id = ? AND notion_id <> ''", alice.ID).Count(&count)
+	if count != 121 {
+		t.Errorf("%d assignments linked to a page, want 121", count)
+	}
+	var cr course.Course
+	if err := s.db.Where("user_id = ? AND notion_id = ?", alice.ID, coursePage).First(&cr).Error; err != nil {
+		t.Errorf("course of the workspace not imported: %v", err)
+	}
+
+	s.db.First(local, local.ID)
+	page, ok := ws.Page(local.NotionID)
+	if !ok || page.Properties["Assignment name"].String() != "Essay" || page.Properties["Courses"].String() != coursePage {
+		t.Errorf("page of the local assignment = %+v", page)
+	}
+}
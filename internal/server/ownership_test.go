@@ -0,0 +1,310 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"unipilot/internal/authz"
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// aliceData is what alice owns, everything bob tries to reach
+type aliceData struct {
+	course     *course.Course
+	assignment *assignment.Assignment
+	note       *note.Note
+	document   *document.Document
+}
+
+func addAliceData(t *testing.T, s *testServer, userID uint) *aliceData {
+	t.Helper()
+
+	d := &aliceData{
+		course:     &course.Course{UserID: userID, LocalID: 1, Code: "CS101", Name: "Intro"},
+		assignment: &assignment.Assignment{UserID: userID, LocalID: 1, Title: "Essay", Deadline: time.Now(), CourseCode: "CS101", TypeName: "Homework", StatusName: "Not started"},
+		note:       &note.Note{UserID: userID, LocalID: 1, CourseCode: "CS101", Title: "Lecture 1"},
+	}
+	for _, row := range []interface{}{d.course, d.assignment, d.note} {
+		if err := s.db.Omit("User", "Course", "Type", "Status", "Documents").Create(row).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.document = s.addDocument(t, userID, 1, d.assignment.LocalID)
+	return d
+}
+
+func TestOtherUsersRowsAreNotFound(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	s.addUser(t, "bob")
+	data := addAliceData(t, s, alice.ID)
+	bob := s.login(t, "bob")
+
+	resources := map[string]uint{
+		"assignments": data.assignment.ID,
+		"courses":     data.course.ID,
+		"notes":       data.note.ID,
+		"documents":   data.document.ID,
+	}
+	patches := map[string]string{
+		"assignments": "/title",
+		"courses":     "/name",
+		"notes":       "/title",
+		"documents":   "/file_name",
+	}
+	for resource, id := range resources {
+		path := fmt.Sprintf("%s/%s/%d", APIPrefix, resource, id)
+		for _, method := range []string{http.MethodGet, http.MethodPatch, http.MethodDelete} {
+			var body interface{}
+			if method == http.MethodPatch {
+				body = []map[string]string{{"op": "replace", "path": patches[resource], "value": "Mine now"}}
+			}
+			if res := s.do(t, bob, method, path, body); res.StatusCode != http.StatusNotFound {
+				t.Errorf("%s %s: status %d, want 404", method, path, res.StatusCode)
+			}
+		}
+	}
+
+	// Bob has no rows, the local IDs of the legacy routes only reach his own and the
+	// server IDs only the rows shared with him
+	legacy := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodPost, "/assignment/update", map[string]string{"id": "1", "column": "title", "value": "Mine now"}},
+		{http.MethodPost, "/course/update", map[string]string{"id": "1", "column": "name", "value": "Mine now"}},
+		{http.MethodPost, "/note/update", map[string]string{"id": "1", "column": "title", "value": "Mine now"}},
+		{http.MethodPost, "/assignment/delete?id=1", nil},
+		{http.MethodPost, "/course/delete?id=1", nil},
+		{http.MethodPost, "/note/delete?id=1", nil},
+		{http.MethodPost, "/document/metadata/delete?document_id=1", nil},
+		{http.MethodPost, "/assignment/update", map[string]string{"remote_id": fmt.Sprint(data.assignment.ID), "column": "title", "value": "Mine now"}},
+		{http.MethodPost, "/course/update", map[string]string{"remote_id": fmt.Sprint(data.course.ID), "column": "name", "value": "Mine now"}},
+		{http.MethodPost, "/note/update", map[string]string{"remote_id": fmt.Sprint(data.note.ID), "column": "title", "value": "Mine now"}},
+		{http.MethodPost, fmt.Sprintf("/assignment/delete?remote_id=%d", data.assignment.ID), nil},
+		{http.MethodPost, fmt.Sprintf("/course/delete?remote_id=%d", data.course.ID), nil},
+		{http.MethodPost, fmt.Sprintf("/note/delete?remote_id=%d", data.note.ID), nil},
+		{http.MethodPost, fmt.Sprintf("/document/metadata/delete?remote_id=%d", data.document.ID), nil},
+		{http.MethodGet, fmt.Sprintf("/document/blob/get?document_id=%d", data.document.ID), nil},
+		{http.MethodPost, fmt.Sprintf("/document/blob?document_id=%d", data.document.ID), "not mine"},
+	}
+	for _, req := range legacy {
+		if res := s.do(t, bob, req.method, req.path, req.body); res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s %s: status %d, want 404", req.method, req.path, res.StatusCode)
+		}
+	}
+
+	// Alice's rows are untouched
+	var a assignment.Assignment
+	var c course.Course
+	var n note.Note
+	var d document.Document
+	s.db.First(&a, data.assignment.ID)
+	s.db.First(&c, data.course.ID)
+	s.db.First(&n, data.note.ID)
+	s.db.First(&d, data.document.ID)
+	if a.Title != "Essay" || c.Name != "Intro" || n.Title != "Lecture 1" || d.FileName != "notes.pdf" || d.Hash != "h" {
+		t.Fatalf("alice's rows changed: %q %q %q %q %q", a.Title, c.Name, n.Title, d.FileName, d.Hash)
+	}
+}
+
+func TestLegacyUpdateOnlyWritesAPIColumns(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	bob := s.addUser(t, "bob")
+	data := addAliceData(t, s, alice.ID)
+	token := s.login(t, "alice")
+
+	for _, column := range []string{"user_id", "id", "local_id", "created_at", "title = 'x', user_id"} {
+		res := s.do(t, token, http.MethodPost, "/assignment/update", map[string]string{"id": "1", "column": column, "value": fmt.Sprint(bob.ID)})
+		if res.StatusCode != http.StatusBadRequest {
+			t.Errorf("update of %q: status %d, want 400", column, res.StatusCode)
+		}
+	}
+	var a assignment.Assignment
+	s.db.First(&a, data.assignment.ID)
+	if a.UserID != alice.ID || a.LocalID != 1 || a.Title != "Essay" {
+		t.Fatalf("assignment changed by refused updates: %+v", a)
+	}
+
+	for path, column := range map[string]string{"/course/update": "user_id", "/note/update": "user_id"} {
+		if res := s.do(t, token, http.MethodPost, path, map[string]string{"id": "1", "column": column, "value": fmt.Sprint(bob.ID)}); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%s of %q: status %d, want 400", path, column, res.StatusCode)
+		}
+	}
+
+	res := s.do(t, token, http.MethodPost, "/assignment/update", map[string]string{"id": "1", "column": "status_name", "value": "Done"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update of status_name: status %d, want 200", res.StatusCode)
+	}
+	s.db.First(&a, data.assignment.ID)
+	if a.StatusName != "Done" {
+		t.Fatalf("status = %q, want Done", a.StatusName)
+	}
+}
+
+func TestLegacyDeletedAtUpdateDeletes(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	data := addAliceData(t, s, alice.ID)
+	token := s.login(t, "alice")
+
+	// Baseline clients delete a course by setting its deleted_at, its rows go with it
+	res := s.do(t, token, http.MethodPost, "/course/update", map[string]string{"id": "1", "column": "deleted_at", "value": time.Now().Format(time.RFC3339)})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update of deleted_at: status %d, want 200", res.StatusCode)
+	}
+
+	for name, row := range map[string]interface{}{"course": data.course, "assignment": data.assignment, "note": data.note, "document": data.document} {
+		var deletedAt gorm.DeletedAt
+		s.db.Unscoped().Model(row).Select("deleted_at").Scan(&deletedAt)
+		if !deletedAt.Valid {
+			t.Errorf("%s not deleted", name)
+		}
+	}
+}
+
+func TestLegacyRoutesAddressServerIDs(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	bob := s.addUser(t, "bob")
+	data := addAliceData(t, s, alice.ID)
+	id := fmt.Sprint(data.assignment.ID)
+
+	// A grantee with write access changes the row by its server ID, deleting stays with the owner
+	if _, err := authz.Share(s.db, alice.ID, models.Assignment, data.assignment.ID, bob.ID, models.PermissionWrite); err != nil {
+		t.Fatal(err)
+	}
+	token := s.login(t, "bob")
+	if res := s.do(t, token, http.MethodPost, "/assignment/update", map[string]string{"remote_id": id, "column": "title", "value": "Essay v2"}); res.StatusCode != http.StatusOK {
+		t.Fatalf("update by grantee: status %d, want 200", res.StatusCode)
+	}
+	if res := s.do(t, token, http.MethodPost, "/assignment/delete?remote_id="+id, nil); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("delete by grantee: status %d, want 404", res.StatusCode)
+	}
+
+	var a assignment.Assignment
+	s.db.First(&a, data.assignment.ID)
+	if a.Title != "Essay v2" {
+		t.Fatalf("title = %q, want Essay v2", a.Title)
+	}
+
+	// Deleting again succeeds so clients can retry
+	token = s.login(t, "alice")
+	for i := 0; i < 2; i++ {
+		if res := s.do(t, token, http.MethodPost, "/assignment/delete?remote_id="+id, nil); res.StatusCode != http.StatusOK {
+			t.Fatalf("delete %d: status %d, want 200", i, res.StatusCode)
+		}
+	}
+	if err := s.db.First(&a, data.assignment.ID).Error; err == nil {
+		t.Fatal("assignment not deleted")
+	}
+}
+
+func TestCreatesAreDeduplicatedPerDevice(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+	token := s.login(t, "alice")
+
+	create := func(deviceID string) (int, map[string]string) {
+		body, _ := json.Marshal(map[string]interface{}{"local_id": 1, "title": "Essay", "deadline": "2025-03-01",
+			"course_code": "CS101", "type": "Homework"})
+		req, _ := http.NewRequest(http.MethodPost, s.URL+APIPrefix+"/assignments", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set(DeviceHeader, deviceID)
+		res := send(t, req)
+
+		var created struct {
+			Data map[string]string `json:"data"`
+		}
+		json.NewDecoder(res.Body).Decode(&created)
+		return res.StatusCode, created.Data
+	}
+
+	// Both devices numbered their assignment 1, a retry returns the row already created
+	status, laptop := create("laptop")
+	if status != http.StatusCreated || laptop["device_id"] != "laptop" {
+		t.Fatalf("create from laptop: status %d, row %v", status, laptop)
+	}
+	status, phone := create("phone")
+	if status != http.StatusCreated || phone["id"] == laptop["id"] {
+		t.Fatalf("create from phone: status %d, row %v, want a new row", status, phone)
+	}
+	status, retried := create("laptop")
+	if status != http.StatusOK || retried["id"] != laptop["id"] {
+		t.Fatalf("retry from laptop: status %d, row %v, want row %s", status, retried, laptop["id"])
+	}
+}
+
+// legacyAssignment is the assignments table of the baseline, with a unique local_id
+type legacyAssignment struct {
+	gorm.Model
+	UserID  uint
+	LocalID uint `gorm:"unique"`
+	Title   string
+}
+
+func (legacyAssignment) TableName() string { return "assignments" }
+
+func TestMigrateDeviceIDs(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&legacyAssignment{}); err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&legacyAssignment{UserID: 1, LocalID: 1, Title: "Essay"})
+
+	if err := migrateDeviceIDs(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&legacyAssignment{UserID: 1, LocalID: 1, Title: "Lab"}).Error; err != nil {
+		t.Fatalf("local ID used by another device: %v", err)
+	}
+	if !db.Migrator().HasColumn(&assignment.Assignment{}, "DeviceID") {
+		t.Fatal("device_id column missing")
+	}
+}
+
+func TestShareDoesNotRevealUsernames(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	bob := s.addUser(t, "bob")
+	data := addAliceData(t, s, alice.ID)
+	token := s.login(t, "alice")
+
+	share := func(grantee string) (int, string) {
+		res := s.do(t, token, http.MethodPost, APIPrefix+"/shares", map[string]interface{}{
+			"entity": "note", "entity_id": data.note.ID, "grantee": grantee, "permission": "read"})
+		var body struct {
+			Data map[string]string `json:"data"`
+		}
+		json.NewDecoder(res.Body).Decode(&body)
+		delete(body.Data, "grantee")
+		return res.StatusCode, fmt.Sprint(body.Data)
+	}
+
+	status, known := share("bob")
+	unknownStatus, unknown := share("nobody")
+	if status != http.StatusAccepted || unknownStatus != status || unknown != known {
+		t.Fatalf("share with bob: %d %s, with nobody: %d %s, want the same answer", status, known, unknownStatus, unknown)
+	}
+
+	var n note.Note
+	if err := authz.Load(s.db, bob.ID, models.EntityNote, data.note.ID, authz.Read, &n); err != nil {
+		t.Fatalf("bob can't read the shared note: %v", err)
+	}
+}
@@ -0,0 +1,320 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/user"
+	"unipilot/internal/services/blobstore"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+// maxAvatarSize is the largest avatar image accepted
+const maxAvatarSize = 2 * 1024 * 1024
+
+// avatarTypes are the image formats accepted as avatars
+var avatarTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// errWrongPassword is returned when the current password given to confirm a change is wrong
+var errWrongPassword = errors.New("current password is incorrect")
+
+// profileRow is the user as sent to their devices, which refresh their header with it
+func profileRow(u *user.User) map[string]string {
+	return map[string]string{
+		"id":          strconv.Itoa(int(u.ID)),
+		"username":    u.Username,
+		"email":       u.Email,
+		"avatar":      u.Avatar,
+		"university":  u.University,
+		"language":    u.Language,
+		"is_verified": strconv.FormatBool(u.IsVerified),
+	}
+}
+
+// validLanguage accepts language tags such as "en" or "pt-BR"
+func validLanguage(tag string) bool {
+	if tag == "" || len(tag) > 16 {
+		return false
+	}
+	for _, c := range tag {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// checkPassword confirms the current password of the user
+func checkPassword(u *user.User, password string) error {
+	if password == "" || bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) != nil {
+		return errWrongPassword
+	}
+	return nil
+}
+
+// sessionUser loads the user of the request
+func sessionUser(w http.ResponseWriter, r *http.Request) (*gorm.DB, *user.User, bool) {
+	db := r.Context().Value("db").(*gorm.DB)
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return nil, nil, false
+	}
+
+	var u user.User
+	if err := db.First(&u, userID).Error; err != nil {
+		PrintERROR(w, http.StatusNotFound, "User not found")
+		return nil, nil, false
+	}
+	return db, &u, true
+}
+
+// UpdateProfileHandler changes the email, university or language of the user. A new
+// email needs the current password, is unverified until confirmed and gets a new
+// verification email.
+func UpdateProfileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		Email           *string `json:"email"`
+		University      *string `json:"university"`
+		Language        *string `json:"language"`
+		CurrentPassword string  `json:"current_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body %s", err))
+		return
+	}
+
+	db, u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	updates := map[string]interface{}{}
+	emailChanged := false
+
+	if body.Email != nil {
+		email := strings.TrimSpace(*body.Email)
+		if addr, err := mail.ParseAddress(email); err != nil || addr.Address != email {
+			PrintERROR(w, http.StatusBadRequest, "Invalid email address")
+			return
+		}
+		if !strings.EqualFold(email, u.Email) {
+			if err := checkPassword(u, body.CurrentPassword); err != nil {
+				PrintERROR(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			updates["email"] = email
+			updates["is_verified"] = false
+			emailChanged = true
+		}
+	}
+	if body.University != nil {
+		updates["university"] = strings.TrimSpace(*body.University)
+	}
+	if body.Language != nil {
+		if !validLanguage(*body.Language) {
+			PrintERROR(w, http.StatusBadRequest, "Invalid language")
+			return
+		}
+		updates["language"] = *body.Language
+	}
+
+	if len(updates) > 0 {
+		if emailChanged {
+			var taken int64
+			if err := db.Model(&user.User{}).Where("email = ? AND id <> ?", updates["email"], u.ID).Count(&taken).Error; err != nil {
+				PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to check email: %v", err))
+				return
+			}
+			if taken > 0 {
+				PrintERROR(w, http.StatusConflict, "Email already in use")
+				return
+			}
+		}
+
+		if err := db.Model(u).Updates(updates).Error; err != nil {
+			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update profile: %v", err))
+			return
+		}
+
+		if emailChanged {
+			if err := sendVerification(db, u); err != nil {
+				PrintLog(fmt.Sprintf("Failed to send verification email to user %d: %v", u.ID, err))
+			}
+		}
+
+		notifyChange(r, u.ID, models.OperationUpdate, models.EntityUser, profileRow(u), "Profile updated")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Profile updated successfully",
+		"user":    u.ToMap(),
+	})
+}
+
+// ChangePasswordHandler sets a new password after checking the current one. Every
+// device is logged out, this one gets new tokens.
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Invalid request body %s", err))
+		return
+	}
+	if len(body.NewPassword) < minPasswordLength {
+		PrintERROR(w, http.StatusBadRequest, fmt.Sprintf("Password must have at least %d characters", minPasswordLength))
+		return
+	}
+
+	db, u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+	if err := checkPassword(u, body.CurrentPassword); err != nil {
+		PrintERROR(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(body.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, "Could not process password")
+		return
+	}
+
+	// The new session of this device keeps its name in the device list
+	var deviceName string
+	if sessionID, ok := r.Context().Value("session_id").(uint); ok {
+		var current models.DeviceSession
+		if err := db.Select("device_name").First(&current, sessionID).Error; err == nil {
+			deviceName = current.DeviceName
+		}
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(u).Update("password_hash", string(hashedPassword)).Error; err != nil {
+			return err
+		}
+		// Ends the tokens and the cookies of every device
+		return tokenAuth.revokeAll(tx, u.ID)
+	})
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to change password: %v", err))
+		return
+	}
+
+	tokens, err := tokenAuth.issue(r, u.ID, deviceName)
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
+		return
+	}
+	if err := startSession(w, r, u.ID, tokens.sessionID); err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
+		return
+	}
+
+	response := tokens.toMap()
+	response["message"] = "Password changed successfully"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// UploadAvatarHandler stores the raw image body as the avatar of the user. Avatars are
+// kept in the blob store with the document files, User.Avatar holds the hash.
+func UploadAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		PrintERROR(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+
+	db, u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	content := bufio.NewReaderSize(http.MaxBytesReader(w, r.Body, maxAvatarSize), 512)
+	head, _ := content.Peek(512)
+	if contentType := http.DetectContentType(head); !avatarTypes[contentType] {
+		PrintERROR(w, http.StatusUnsupportedMediaType, fmt.Sprintf("Avatar must be a PNG, JPEG, GIF or WebP image, not %s", contentType))
+		return
+	}
+
+	hash, _, err := blobStore.Put(content)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			PrintERROR(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Avatar exceeds limit of %d MB", maxAvatarSize/(1024*1024)))
+			return
+		}
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to store avatar: %v", err))
+		return
+	}
+
+	if err := db.Model(u).Update("avatar", hash).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to update avatar: %v", err))
+		return
+	}
+
+	notifyChange(r, u.ID, models.OperationUpdate, models.EntityUser, profileRow(u), "Avatar updated")
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message": "Avatar updated successfully",
+		"avatar":  hash,
+	})
+}
+
+// GetAvatarHandler streams the avatar image of the user
+func GetAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	_, u, ok := sessionUser(w, r)
+	if !ok {
+		return
+	}
+
+	if u.Avatar == "" {
+		PrintERROR(w, http.StatusNotFound, "No avatar")
+		return
+	}
+
+	file, err := blobStore.Open(u.Avatar)
+	if errors.Is(err, blobstore.ErrNotFound) {
+		PrintERROR(w, http.StatusNotFound, "Avatar missing from storage")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to open avatar: %v", err))
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set(ContentHashHeader, u.Avatar)
+	http.ServeContent(w, r, "", u.UpdatedAt, file)
+}
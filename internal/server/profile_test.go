@@ -0,0 +1,179 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/user"
+)
+
+func TestUpdateProfileEmail(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	s.db.Model(alice).Update("is_verified", true)
+	token := s.login(t, "alice")
+
+	// A new address needs the password
+	res := s.do(t, token, http.MethodPost, "/user/profile", map[string]string{"email": "new@example.com"})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("email change without password: status %d, want 401", res.StatusCode)
+	}
+	res = s.do(t, token, http.MethodPost, "/user/profile", map[string]string{"email": "new@example.com", "current_password": "wrong"})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("email change with wrong password: status %d, want 401", res.StatusCode)
+	}
+
+	s.addUser(t, "bob")
+	res = s.do(t, token, http.MethodPost, "/user/profile", map[string]string{"email": "bob@example.com", "current_password": testPassword})
+	if res.StatusCode != http.StatusConflict {
+		t.Fatalf("email of another user: status %d, want 409", res.StatusCode)
+	}
+
+	res = s.do(t, token, http.MethodPost, "/user/profile", map[string]string{"email": "new@example.com", "language": "fr", "current_password": testPassword})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("email change: status %d", res.StatusCode)
+	}
+
+	var u user.User
+	s.db.First(&u, alice.ID)
+	if u.Email != "new@example.com" || u.IsVerified || u.Language != "fr" {
+		t.Fatalf("user after change = %s verified %v language %s, want new@example.com unverified fr", u.Email, u.IsVerified, u.Language)
+	}
+
+	var pending []user.EmailVerificationToken
+	s.db.Where("user_id = ? AND used_at IS NULL", alice.ID).Find(&pending)
+	if len(pending) != 1 || pending[0].Email != "new@example.com" {
+		t.Fatalf("pending verifications = %+v, want one for the new address", pending)
+	}
+
+	var events int64
+	s.db.Model(&models.UserEvent{}).Where("user_id = ? AND name = ?", alice.ID, models.EventName(models.EntityUser, models.OperationUpdate)).Count(&events)
+	if events != 1 {
+		t.Errorf("%d profile events, want 1", events)
+	}
+}
+
+func TestUpdateProfileWithoutEmailNeedsNoPassword(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token := s.login(t, "alice")
+
+	res := s.do(t, token, http.MethodPost, "/user/profile", map[string]string{"university": "UT", "email": "alice@example.com"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update: status %d", res.StatusCode)
+	}
+	if res := s.do(t, token, http.MethodPost, "/user/profile", map[string]string{"language": "not a language"}); res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid language: status %d, want 400", res.StatusCode)
+	}
+
+	var u user.User
+	s.db.First(&u, alice.ID)
+	if u.University != "UT" || u.Email != "alice@example.com" {
+		t.Fatalf("user = %s %s, want UT with the same email", u.University, u.Email)
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+	token, _ := s.deviceLogin(t, "alice", "laptop")
+	phoneToken, phoneCookie := s.deviceLogin(t, "alice", "phone")
+
+	res := s.do(t, token, http.MethodPost, "/user/password", map[string]string{"current_password": "wrong", "new_password": "a brand new password"})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong current password: status %d, want 401", res.StatusCode)
+	}
+	res = s.do(t, token, http.MethodPost, "/user/password", map[string]string{"current_password": testPassword, "new_password": "short"})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("short password: status %d, want 400", res.StatusCode)
+	}
+
+	res = s.do(t, token, http.MethodPost, "/user/password", map[string]string{"current_password": testPassword, "new_password": "a brand new password"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("change: status %d", res.StatusCode)
+	}
+	var tokens map[string]string
+	json.NewDecoder(res.Body).Decode(&tokens)
+
+	// Every device is logged out, this one continues with its new tokens
+	for name, tok := range map[string]string{"laptop": token, "phone": phoneToken} {
+		if res := s.do(t, tok, http.MethodGet, "/user", nil); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("old %s token: status %d, want 401", name, res.StatusCode)
+		}
+	}
+	if code := s.withCookie(t, "/user", phoneCookie); code != http.StatusUnauthorized {
+		t.Errorf("phone cookie: status %d, want 401", code)
+	}
+	if res := s.do(t, tokens["access_token"], http.MethodGet, "/user", nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("new token: status %d, want 200", res.StatusCode)
+	}
+
+	if res := s.loginAs(t, "alice", testPassword); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("login with the old password: status %d, want 401", res.StatusCode)
+	}
+	if res := s.loginAs(t, "alice", "a brand new password"); res.StatusCode != http.StatusOK {
+		t.Errorf("login with the new password: status %d, want 200", res.StatusCode)
+	}
+}
+
+// upload posts a raw body to path
+func (s *testServer) upload(t *testing.T, token, path string, body []byte) *http.Response {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodPost, s.URL+path, bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	return send(t, req)
+}
+
+func TestUploadAvatar(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token := s.login(t, "alice")
+
+	if res := s.upload(t, token, "/user/avatar", []byte("not an image")); res.StatusCode != http.StatusUnsupportedMediaType {
+		t.Fatalf("text avatar: status %d, want 415", res.StatusCode)
+	}
+	if res := s.upload(t, token, "/user/avatar", make([]byte, maxAvatarSize+1)); res.StatusCode != http.StatusUnsupportedMediaType && res.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized avatar: status %d, want it refused", res.StatusCode)
+	}
+
+	var img bytes.Buffer
+	pixel := image.NewRGBA(image.Rect(0, 0, 1, 1))
+	pixel.Set(0, 0, color.White)
+	png.Encode(&img, pixel)
+
+	res := s.upload(t, token, "/user/avatar", img.Bytes())
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("upload: status %d", res.StatusCode)
+	}
+
+	var u user.User
+	s.db.First(&u, alice.ID)
+	if u.Avatar == "" || !blobStore.Has(u.Avatar) {
+		t.Fatalf("avatar %q not in the blob store", u.Avatar)
+	}
+
+	res = s.do(t, token, http.MethodGet, "/user/avatar/get", nil)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "image/png" {
+		t.Fatalf("get: status %d type %q, want 200 image/png", res.StatusCode, res.Header.Get("Content-Type"))
+	}
+	if got, _ := io.ReadAll(res.Body); !bytes.Equal(got, img.Bytes()) {
+		t.Fatal("downloaded avatar differs from the upload")
+	}
+
+	// Only documents and avatars keep blobs
+	if _, err := collectUnreferencedBlobs(s.db, blobStore, -time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if !blobStore.Has(u.Avatar) {
+		t.Fatal("garbage collection deleted the avatar")
+	}
+}
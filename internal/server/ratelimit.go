@@ -0,0 +1,148 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/services/ratelimit"
+
+	"gorm.io/gorm"
+)
+
+// Limits of the unauthenticated routes. Failed logins lock the username and the IP
+// for a while, signups and account emails are capped per IP.
+var (
+	loginUserPolicy = ratelimit.Policy{Limit: 5, Window: 15 * time.Minute, Lockout: 15 * time.Minute}
+	loginIPPolicy   = ratelimit.Policy{Limit: 20, Window: 15 * time.Minute, Lockout: 30 * time.Minute}
+	requestPolicy   = ratelimit.Policy{Limit: 5, Window: time.Hour}
+)
+
+// LoginLimitMiddleware refuses logins from a locked IP or for a locked username, and
+// counts the failed ones answered by next. A successful login clears the failures of
+// the username but not of the IP, so logging into an own account doesn't reset a
+// guessing run against others.
+func LoginLimitMiddleware(ipLimiter, userLimiter ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next(w, r)
+			return
+		}
+
+		// The handler decodes the body again
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			PrintERROR(w, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+
+		var body struct {
+			Username string `json:"username"`
+		}
+		json.Unmarshal(data, &body)
+		username := strings.ToLower(strings.TrimSpace(body.Username))
+
+		ipKey := "ip:" + clientIP(r)
+		userKey := "user:" + username
+
+		wait := max(ipLimiter.Check(ipKey), userLimiter.Check(userKey))
+		if wait > 0 {
+			auditAuth(r, models.AuthLoginLocked, username)
+			tooManyRequests(w, wait, "Too many failed login attempts")
+			return
+		}
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next(rec, r)
+
+		switch rec.status {
+		case http.StatusOK:
+			userLimiter.Reset(userKey)
+		case http.StatusUnauthorized:
+			auditAuth(r, models.AuthLoginFailed, username)
+			ipLocked := ipLimiter.Record(ipKey) > 0
+			userLocked := username != "" && userLimiter.Record(userKey) > 0
+			if ipLocked || userLocked {
+				auditAuth(r, models.AuthLockoutStarts, username)
+			}
+		}
+	}
+}
+
+// RateLimitMiddleware caps the requests each IP makes to the route, whatever their
+// outcome
+func RateLimitMiddleware(limiter ratelimit.Limiter, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodOptions {
+			next(w, r)
+			return
+		}
+
+		key := r.URL.Path + ":" + clientIP(r)
+		if wait := limiter.Check(key); wait > 0 {
+			auditAuth(r, models.AuthRateLimited, "")
+			tooManyRequests(w, wait, "Too many requests")
+			return
+		}
+		limiter.Record(key)
+
+		next(w, r)
+	}
+}
+
+// tooManyRequests answers 429 with the seconds to wait in Retry-After
+func tooManyRequests(w http.ResponseWriter, wait time.Duration, message string) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	PrintERROR(w, http.StatusTooManyRequests, fmt.Sprintf("%s, try again in %d minutes", message, (seconds+59)/60))
+}
+
+// auditAuth logs a failed or refused attempt and stores it when the route has a
+// database
+func auditAuth(r *http.Request, kind models.AuthEventKind, username string) {
+	event := models.AuthEvent{
+		Kind:      kind,
+		Path:      r.URL.Path,
+		Username:  username,
+		IP:        clientIP(r),
+		UserAgent: r.UserAgent(),
+	}
+	log.Printf("[AUDIT] %s path=%s username=%q ip=%s", event.Kind, event.Path, event.Username, event.IP)
+
+	db, ok := r.Context().Value("db").(*gorm.DB)
+	if !ok {
+		return
+	}
+	if err := db.Create(&event).Error; err != nil {
+		PrintLog(fmt.Sprintf("Failed to store auth event: %v", err))
+	}
+}
+
+// statusRecorder keeps the status a handler answered with
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	if !s.wroteHeader {
+		s.status = code
+		s.wroteHeader = true
+	}
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *statusRecorder) Write(b []byte) (int, error) {
+	s.wroteHeader = true
+	return s.ResponseWriter.Write(b)
+}
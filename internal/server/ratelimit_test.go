@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strconv"
+	"testing"
+
+	"unipilot/internal/models"
+)
+
+// loginAs posts a login with password and returns the response
+func (s *testServer) loginAs(t *testing.T, username, password string) *http.Response {
+	t.Helper()
+	return s.do(t, "", http.MethodPost, "/login", map[string]string{"username": username, "password": password})
+}
+
+// auditCount returns how many audit events of kind were stored
+func (s *testServer) auditCount(t *testing.T, kind models.AuthEventKind) int64 {
+	t.Helper()
+
+	var n int64
+	if err := s.db.Model(&models.AuthEvent{}).Where("kind = ?", kind).Count(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+
+	for i := 0; i < loginUserPolicy.Limit; i++ {
+		if res := s.loginAs(t, "alice", "wrong"); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("failed login %d: status %d, want 401", i+1, res.StatusCode)
+		}
+	}
+
+	// Locked, even with the right password
+	res := s.loginAs(t, "alice", testPassword)
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("login while locked: status %d, want 429", res.StatusCode)
+	}
+	retry, err := strconv.Atoi(res.Header.Get("Retry-After"))
+	if err != nil || retry <= 0 || retry > int(loginUserPolicy.Lockout.Seconds()) {
+		t.Fatalf("Retry-After = %q, want seconds up to the lockout", res.Header.Get("Retry-After"))
+	}
+
+	// The lock is on the username, not the whole IP
+	s.addUser(t, "bob")
+	if res := s.loginAs(t, "bob", testPassword); res.StatusCode != http.StatusOK {
+		t.Fatalf("login of another user: status %d, want 200", res.StatusCode)
+	}
+
+	if n := s.auditCount(t, models.AuthLoginFailed); n != int64(loginUserPolicy.Limit) {
+		t.Errorf("%d login_failed events, want %d", n, loginUserPolicy.Limit)
+	}
+	if n := s.auditCount(t, models.AuthLockoutStarts); n != 1 {
+		t.Errorf("%d lockout_started events, want 1", n)
+	}
+	if n := s.auditCount(t, models.AuthLoginLocked); n != 1 {
+		t.Errorf("%d login_locked events, want 1", n)
+	}
+}
+
+func TestLoginSuccessResetsUsername(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+
+	for round := 0; round < 2; round++ {
+		for i := 0; i < loginUserPolicy.Limit-1; i++ {
+			if res := s.loginAs(t, "alice", "wrong"); res.StatusCode != http.StatusUnauthorized {
+				t.Fatalf("round %d, failed login %d: status %d, want 401", round+1, i+1, res.StatusCode)
+			}
+		}
+		if res := s.loginAs(t, "alice", testPassword); res.StatusCode != http.StatusOK {
+			t.Fatalf("round %d, login: status %d, want 200", round+1, res.StatusCode)
+		}
+	}
+	if n := s.auditCount(t, models.AuthLockoutStarts); n != 0 {
+		t.Errorf("%d lockout_started events, want none", n)
+	}
+}
+
+func TestUnknownUsernamesCountAgainstIP(t *testing.T) {
+	s := newTestServer(t)
+
+	for i := 0; i < loginIPPolicy.Limit; i++ {
+		if res := s.loginAs(t, fmt.Sprintf("nobody%d", i), "guess"); res.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("login %d: status %d, want 401", i+1, res.StatusCode)
+		}
+	}
+	res := s.loginAs(t, "nobody-else", "guess")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Fatalf("login from locked IP: status %d Retry-After %q, want 429 with Retry-After", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+}
+
+func TestSignupRateLimit(t *testing.T) {
+	s := newTestServer(t)
+
+	register := func(i int) *http.Response {
+		return s.do(t, "", http.MethodPost, "/register", map[string]string{
+			"username": fmt.Sprintf("user%d", i), "email": fmt.Sprintf("user%d@example.com", i),
+			"password": testPassword, "university": "ACC", "language": "en",
+		})
+	}
+	for i := 0; i < requestPolicy.Limit; i++ {
+		if res := register(i); res.StatusCode != http.StatusCreated {
+			t.Fatalf("signup %d: status %d, want 201", i+1, res.StatusCode)
+		}
+	}
+
+	res := register(requestPolicy.Limit)
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") == "" {
+		t.Fatalf("signup over the limit: status %d Retry-After %q, want 429 with Retry-After", res.StatusCode, res.Header.Get("Retry-After"))
+	}
+	if n := s.auditCount(t, models.AuthRateLimited); n != 1 {
+		t.Errorf("%d rate_limited events, want 1", n)
+	}
+
+	// Other routes keep their own count
+	if res := s.do(t, "", http.MethodPost, "/password/reset/request", map[string]string{"email": "user0@example.com"}); res.StatusCode == http.StatusTooManyRequests {
+		t.Fatal("password reset limited by signups")
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	defer func(saved []netip.Prefix) { trustedProxies = saved }(trustedProxies)
+	trustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("127.0.0.1/32")}
+
+	tests := []struct {
+		remote, forwarded, want string
+	}{
+		{"203.0.113.7:4000", "", "203.0.113.7"},
+		{"203.0.113.7:4000", "1.2.3.4", "203.0.113.7"},               // not a proxy, header ignored
+		{"127.0.0.1:4000", "198.51.100.1", "198.51.100.1"},           // from the proxy
+		{"127.0.0.1:4000", "1.2.3.4, 198.51.100.1", "198.51.100.1"},  // spoofed first hop
+		{"127.0.0.1:4000", "198.51.100.1, 10.0.0.2", "198.51.100.1"}, // chained proxies
+		{"127.0.0.1:4000", "", "127.0.0.1"},
+		{"127.0.0.1:4000", "garbage", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remote, tt.forwarded, got, tt.want)
+		}
+	}
+}
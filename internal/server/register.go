@@ -8,8 +8,6 @@ import (
 
 	"unipilot/internal/models/user"
 
-	"github.com/spf13/viper"
-	"github.com/gorilla/sessions"
 	"golang.org/x/crypto/bcrypt"
 
 	"gorm.io/gorm"
@@ -27,6 +25,7 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		Password     string `json:"password"`
 		University   string `json:"university"`
 		Language     string `json:"language"`
+		DeviceName   string `json:"device_name"`
 
 	}
 
@@ -66,22 +65,19 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create session
-	viper.SetConfigFile(".env")
-	err = viper.ReadInConfig()
-	if err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("error reading config file: %w", err))
+	if err := sendVerification(db, &user); err != nil {
+		PrintLog(fmt.Sprintf("Failed to send verification email to user %d: %v", user.ID, err))
 	}
 
-	SESSION_KEY := viper.GetString("SESSION_KEY")
-
-	var store = sessions.NewCookieStore([]byte(SESSION_KEY))
+	tokens, err := tokenAuth.issue(r, user.ID, registrationData.DeviceName)
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
+		return
+	}
 
-	session, _ := store.Get(r, "session-auth")
-	session.Values["user_id"] = user.ID
-	session.Values["authenticated"] = true
-	if err := session.Save(r, w); err != nil {
-		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %w", err))
+	// Create session
+	if err := startSession(w, r, user.ID, tokens.sessionID); err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %v", err))
 		return
 	}
 
@@ -95,5 +91,6 @@ func RegisterHandler(w http.ResponseWriter, r *http.Request) {
 		"message": "User registered successfully",
 		"id" : id,
 		"user": user.ToMap(),
+		"tokens": tokens,
 	})
 }
@@ -6,11 +6,14 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/spf13/viper"
-
+	"unipilot/internal/config"
+	"unipilot/internal/models"
+	"unipilot/internal/models/user"
+	"unipilot/internal/services/blobstore"
+	"unipilot/internal/services/gemini"
+	"unipilot/internal/services/mailer"
+	"unipilot/internal/services/ratelimit"
 	"unipilot/internal/storage"
-	
-	"github.com/gorilla/sessions"
 
 	"gorm.io/gorm"
 )
@@ -30,75 +33,198 @@ func DBMiddleware(db *gorm.DB, next http.HandlerFunc) http.HandlerFunc {
 func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
-		viper.SetConfigFile(".env")
-		err := viper.ReadInConfig()
-		if err != nil {
-			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("error reading config file: %w", err))
+		userID, sessionID, ok := requestAuth(r)
+		if !ok {
+			PrintERROR(w, http.StatusUnauthorized, "Unauthorized - please login")
 			return
 		}
 
-		SESSION_KEY := viper.GetString("SESSION_KEY")
+		next.ServeHTTP(w, r.WithContext(withAuth(r.Context(), userID, sessionID)))
+	}
+}
 
-		var store = sessions.NewCookieStore([]byte(SESSION_KEY))
+// CORSMiddleware lets the configured browser origins call the API with their cookies.
+// A "*" lets any other origin call it, without credentials so its pages can't act
+// with the session of a user visiting them.
+func CORSMiddleware(origins []string, next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(origins))
+	for _, origin := range origins {
+		allowed[origin] = true
+	}
 
-		session, err := store.Get(r, "session-auth")
-		if err != nil {
-			PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to create session: %w", err))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || (!allowed["*"] && !allowed[origin]) {
+			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check if user is authenticated
-		auth, ok := session.Values["authenticated"].(bool)
-		if !ok || !auth {
-			PrintERROR(w, http.StatusUnauthorized, "Unauthorized - please login")
-			return
+		w.Header().Add("Vary", "Origin")
+		if allowed[origin] {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		} else {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
 		}
 
-		// You can also add the user ID to the request context if needed
-		userID, ok := session.Values["user_id"].(uint)
-		if ok {
-			ctx := context.WithValue(r.Context(), "user_id", userID)
-			r = r.WithContext(ctx)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", r.Header.Get("Access-Control-Request-Headers"))
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
 		}
 
 		next.ServeHTTP(w, r)
-	}
+	})
 }
 
-func StartServer() {
+// StartServer serves the API with a config loaded and validated by config.LoadServer.
+// It only returns when the server fails to start or stops.
+func StartServer(cfg *config.Server) error {
 
-	db, err := storage.GetRemoteDB()
+	db, err := storage.OpenRemoteDB(cfg.DatabaseDSN)
 	if err != nil {
-		log.Println("Error getting database", err)
-		return
+		return fmt.Errorf("error getting database: %w", err)
+	}
+
+	handler, err := newHandler(cfg, db)
+	if err != nil {
+		return err
+	}
+	go collectBlobs(db)
+
+	server := &http.Server{
+		Addr:    cfg.Addr,
+		Handler: handler,
+	}
+
+	if cfg.TLS() {
+		log.Printf("Server listening on %s (TLS)...", cfg.Addr)
+		return server.ListenAndServeTLS(cfg.TLSCertFile, cfg.TLSKeyFile)
+	}
+
+	log.Printf("Server listening on %s...", cfg.Addr)
+	return server.ListenAndServe()
+}
+
+// newHandler sets up the services of the server on db and returns its routes
+func newHandler(cfg *config.Server, db *gorm.DB) (http.Handler, error) {
+	var err error
+
+	sessionStore = newSessionStore(cfg)
+	trustedProxies = cfg.TrustedProxies
+	if cfg.GeminiKey != "" {
+		noteGenerator = gemini.New(cfg.GeminiKey)
 	}
 
 	sseServer = NewSSEServer(db)
 
-	http.HandleFunc("/acc-homework/events", AuthMiddleware(sseServer.SSEHandler))
-
-	http.HandleFunc("/acc-homework/register", DBMiddleware(db, RegisterHandler))
-	http.HandleFunc("/acc-homework/login", DBMiddleware(db, LoginHandler))
-	http.HandleFunc("/acc-homework/logout", AuthMiddleware(LogoutHandler))
-	http.HandleFunc("/acc-homework/user", DBMiddleware(db, AuthMiddleware(GetUserHandler)))
-
-	http.HandleFunc("/acc-homework/assignment", DBMiddleware(db, AuthMiddleware(CreateAssignmentHandler)))
-	http.HandleFunc("/acc-homework/assignment/get", DBMiddleware(db, AuthMiddleware(GetAssignmentHandler)))
-	http.HandleFunc("/acc-homework/assignment/update", DBMiddleware(db, AuthMiddleware(UpdateAssignmentHandler)))
-
-	http.HandleFunc("/acc-homework/course", DBMiddleware(db, AuthMiddleware(CreateCourseHandler)))
-	http.HandleFunc("/acc-homework/course/get", DBMiddleware(db, AuthMiddleware(GetCourseHandler)))
-	http.HandleFunc("/acc-homework/course/update", DBMiddleware(db, AuthMiddleware(UpdateCourseHandler)))
-	
-	http.HandleFunc("/acc-homework/document/metadata", DBMiddleware(db, AuthMiddleware(CreateDocumentMetadataHandler)))
-	http.HandleFunc("/acc-homework/document/metadata/delete", DBMiddleware(db, AuthMiddleware(DeleteDocumentMetadataHandler)))
-
-	http.HandleFunc("/acc-homework/note", DBMiddleware(db, AuthMiddleware(CreateNoteHandler)))
-	http.HandleFunc("/acc-homework/note/get", DBMiddleware(db, AuthMiddleware(GetNoteHandler)))
-	http.HandleFunc("/acc-homework/note/update", DBMiddleware(db, AuthMiddleware(UpdateNoteHandler)))
-	
-	log.Println("Server listening on :3000...")
-	log.Fatal(http.ListenAndServe(":3000", nil))
+	if err := models.MigrateDocuments(db); err != nil {
+		return nil, fmt.Errorf("error migrating documents: %w", err)
+	}
+	if err := migrateDeviceIDs(db); err != nil {
+		return nil, fmt.Errorf("error migrating device IDs: %w", err)
+	}
+	if err := models.MigrateShares(db); err != nil {
+		return nil, fmt.Errorf("error migrating shares: %w", err)
+	}
+	if err := models.MigrateDeviceSessions(db); err != nil {
+		return nil, fmt.Errorf("error migrating device sessions: %w", err)
+	}
+	tokenAuth = newTokenService(db, cfg.SessionKeys)
+
+	if err := models.MigrateAuthEvents(db); err != nil {
+		return nil, fmt.Errorf("error migrating auth events: %w", err)
+	}
+	loginIPLimiter := ratelimit.NewMemory(loginIPPolicy)
+	loginUserLimiter := ratelimit.NewMemory(loginUserPolicy)
+	requestLimiter := ratelimit.NewMemory(requestPolicy)
+
+	if err := user.MigrateTokens(db); err != nil {
+		return nil, fmt.Errorf("error migrating account tokens: %w", err)
+	}
+	if cfg.SMTPHost != "" {
+		accountMailer = mailer.NewSMTP(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.MailFrom)
+	} else {
+		log.Printf("No SMTP_HOST, account emails are written to %s", cfg.MailDir)
+		accountMailer = mailer.NewFile(cfg.MailDir, cfg.MailFrom)
+	}
+	if cfg.PublicURL != "" {
+		accountLinkBase = cfg.PublicURL + cfg.PathPrefix
+	}
+
+	if err := models.MigrateNotionIntegrations(db); err != nil {
+		return nil, fmt.Errorf("error migrating Notion integrations: %w", err)
+	}
+	notionSync, err = newNotionService(db, cfg.NotionAPIURL, cfg.NotionTokenKey)
+	if err != nil {
+		return nil, fmt.Errorf("error setting up Notion sync: %w", err)
+	}
+
+	blobStore, err = blobstore.New(cfg.BlobDir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening blob store: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	handle := func(path string, handler http.HandlerFunc) {
+		mux.HandleFunc(cfg.PathPrefix+path, handler)
+	}
+
+	handle("/events", AuthMiddleware(sseServer.SSEHandler))
+
+	handle("/register", DBMiddleware(db, RateLimitMiddleware(requestLimiter, RegisterHandler)))
+	handle("/login", DBMiddleware(db, LoginLimitMiddleware(loginIPLimiter, loginUserLimiter, LoginHandler)))
+	handle("/logout", AuthMiddleware(LogoutHandler))
+	handle("/token/refresh", RefreshTokenHandler)
+	handle("/sessions", AuthMiddleware(ListSessionsHandler))
+	handle("/sessions/revoke", AuthMiddleware(RevokeSessionHandler))
+
+	handle("/verify/request", DBMiddleware(db, AuthMiddleware(RequestVerificationHandler)))
+	handle("/verify/confirm", DBMiddleware(db, ConfirmVerificationHandler))
+	handle("/password/reset/request", DBMiddleware(db, RateLimitMiddleware(requestLimiter, RequestPasswordResetHandler)))
+	handle("/password/reset/confirm", DBMiddleware(db, RateLimitMiddleware(requestLimiter, ConfirmPasswordResetHandler)))
+	handle("/user", DBMiddleware(db, AuthMiddleware(GetUserHandler)))
+	handle("/user/profile", DBMiddleware(db, AuthMiddleware(UpdateProfileHandler)))
+	handle("/user/password", DBMiddleware(db, AuthMiddleware(ChangePasswordHandler)))
+	handle("/user/avatar", DBMiddleware(db, AuthMiddleware(UploadAvatarHandler)))
+	handle("/user/avatar/get", DBMiddleware(db, AuthMiddleware(GetAvatarHandler)))
+
+	handle("/assignment", DBMiddleware(db, AuthMiddleware(CreateAssignmentHandler)))
+	handle("/assignment/get", DBMiddleware(db, AuthMiddleware(GetAssignmentHandler)))
+	handle("/assignment/update", DBMiddleware(db, AuthMiddleware(UpdateAssignmentHandler)))
+	handle("/assignment/delete", DBMiddleware(db, AuthMiddleware(DeleteAssignmentHandler)))
+
+	handle("/course", DBMiddleware(db, AuthMiddleware(CreateCourseHandler)))
+	handle("/course/get", DBMiddleware(db, AuthMiddleware(GetCourseHandler)))
+	handle("/course/update", DBMiddleware(db, AuthMiddleware(UpdateCourseHandler)))
+	handle("/course/delete", DBMiddleware(db, AuthMiddleware(DeleteCourseHandler)))
+
+	handle("/document/metadata", DBMiddleware(db, AuthMiddleware(CreateDocumentMetadataHandler)))
+	handle("/document/metadata/delete", DBMiddleware(db, AuthMiddleware(DeleteDocumentMetadataHandler)))
+	handle("/document/blob", DBMiddleware(db, AuthMiddleware(UploadDocumentBlobHandler)))
+	handle("/document/blob/get", DBMiddleware(db, AuthMiddleware(DownloadDocumentBlobHandler)))
+
+	handle("/note", DBMiddleware(db, AuthMiddleware(CreateNoteHandler)))
+	handle("/note/get", DBMiddleware(db, AuthMiddleware(GetNoteHandler)))
+	handle("/note/update", DBMiddleware(db, AuthMiddleware(UpdateNoteHandler)))
+	handle("/note/delete", DBMiddleware(db, AuthMiddleware(DeleteNoteHandler)))
+
+	handle("/sync/changes", DBMiddleware(db, AuthMiddleware(GetChangesHandler)))
+
+	handle("/notion/connect", DBMiddleware(db, AuthMiddleware(ConnectNotionHandler)))
+	handle("/notion/status", DBMiddleware(db, AuthMiddleware(NotionStatusHandler)))
+	handle("/notion/disconnect", DBMiddleware(db, AuthMiddleware(DisconnectNotionHandler)))
+	handle("/notion/webhook", NotionWebhookHandler)
+
+	handle("/calendar/feed", DBMiddleware(db, AuthMiddleware(CalendarFeedHandler)))
+	handle("/calendar/feed/revoke", DBMiddleware(db, AuthMiddleware(RevokeCalendarFeedHandler)))
+	handle(calendarFeedPath, DBMiddleware(db, CalendarICSHandler))
+
+	registerAPI(mux, cfg.PathPrefix, db)
+
+	return CORSMiddleware(cfg.CORSOrigins, mux), nil
 }
 
 
@@ -0,0 +1,164 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"unipilot/internal/config"
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+	"unipilot/internal/models/user"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testPassword = "correct horse battery staple"
+
+// testServer is the server's routes over a fresh database
+type testServer struct {
+	*httptest.Server
+	db *gorm.DB
+}
+
+// newTestServer serves the real routes over an in-memory database holding the tables
+// the production database already has
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AutoMigrate(&user.User{}, &models.AssignmentType{}, &models.AssignmentStatus{},
+		&course.Course{}, &assignment.Assignment{}, &note.Note{}); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Server{
+		SessionKeys: [][]byte{bytes.Repeat([]byte("k"), 32)},
+		BlobDir:     t.TempDir(),
+		MailDir:     t.TempDir(),
+		MailFrom:    "unipilot@example.com",
+
+		NotionTokenKey: bytes.Repeat([]byte("n"), 32),
+	}
+	handler, err := newHandler(cfg, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &testServer{Server: httptest.NewServer(handler), db: db}
+	t.Cleanup(s.Close)
+	return s
+}
+
+// addUser creates an account with testPassword
+func (s *testServer) addUser(t *testing.T, username string) *user.User {
+	t.Helper()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(testPassword), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &user.User{Username: username, Email: username + "@example.com", PasswordHash: string(hash)}
+	if err := s.db.Create(u).Error; err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+// login signs username in and returns its access token
+func (s *testServer) login(t *testing.T, username string) string {
+	t.Helper()
+
+	res := s.do(t, "", http.MethodPost, "/login", map[string]string{"username": username, "password": testPassword, "device_name": "test"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login %s: status %d", username, res.StatusCode)
+	}
+	var body map[string]string
+	json.NewDecoder(res.Body).Decode(&body)
+	return body["access_token"]
+}
+
+// do sends a request with body encoded as JSON, authenticated with token when set
+func (s *testServer) do(t *testing.T, token, method, path string, body interface{}) *http.Response {
+	t.Helper()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, s.URL+path, reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return send(t, req)
+}
+
+// send sends req, its response body is closed when the test ends
+func send(t *testing.T, req *http.Request) *http.Response {
+	t.Helper()
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	return res
+}
+
+// addDocument stores the metadata of a document of the user
+func (s *testServer) addDocument(t *testing.T, userID, localID, assignmentLocalID uint) *document.Document {
+	t.Helper()
+
+	d := &document.Document{UserID: userID, LocalID: localID, AssignmentID: assignmentLocalID,
+		Type: "attachment", FileName: "notes.pdf", FileType: "application/pdf", FilePath: "x", Hash: "h", Version: 1, IsOriginal: true}
+	if err := s.db.Omit("ParentDoc", "Versions").Create(d).Error; err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestCORSMiddleware(t *testing.T) {
+	handler := CORSMiddleware([]string{"https://app.example.com", "*"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for origin, want := range map[string][2]string{
+		"https://app.example.com":  {"https://app.example.com", "true"},
+		"https://evil.example.com": {"*", ""},
+		"":                         {"", ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/profile", nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+
+		got := [2]string{rec.Header().Get("Access-Control-Allow-Origin"), rec.Header().Get("Access-Control-Allow-Credentials")}
+		if got != want {
+			t.Errorf("origin %q: allow origin and credentials = %q, want %q", origin, got, want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"unipilot/internal/config"
+
+	"github.com/gorilla/sessions"
+)
+
+// sessionName is the cookie holding the session
+const sessionName = "session-auth"
+
+// sessionStore is built once from the config at boot
+var sessionStore sessions.Store
+
+// newSessionStore returns a cookie store signing new sessions with the first key and
+// accepting sessions signed with any of the others, so a key can be rotated without
+// logging every user out
+func newSessionStore(cfg *config.Server) sessions.Store {
+	keyPairs := make([][]byte, 0, 2*len(cfg.SessionKeys))
+	for _, key := range cfg.SessionKeys {
+		keyPairs = append(keyPairs, key, nil)
+	}
+
+	store := sessions.NewCookieStore(keyPairs...)
+	store.Options.HttpOnly = true
+	store.Options.Secure = cfg.TLS()
+	return store
+}
+
+// getSession returns the session of the request, a new one if the cookie is missing
+// or no longer valid
+func getSession(r *http.Request) (*sessions.Session, error) {
+	return sessionStore.Get(r, sessionName)
+}
+
+// startSession saves the cookie session of a login. It is tied to the device session
+// of the same login, so revoking the device or a password reset also ends the cookie.
+func startSession(w http.ResponseWriter, r *http.Request, userID, sessionID uint) error {
+	session, _ := getSession(r)
+	session.Values["user_id"] = userID
+	session.Values["session_id"] = sessionID
+	session.Values["authenticated"] = true
+	return session.Save(r, w)
+}
+
+// sessionAuth returns the user and device session of an authenticated cookie. A cookie
+// that no longer verifies, e.g. signed with a retired key, is a new empty session. One
+// without a device session predates them and can't be revoked, it is refused.
+func sessionAuth(r *http.Request) (uint, uint, bool) {
+	session, _ := getSession(r)
+
+	if auth, ok := session.Values["authenticated"].(bool); !ok || !auth {
+		return 0, 0, false
+	}
+
+	userID, ok := session.Values["user_id"].(uint)
+	if !ok {
+		return 0, 0, false
+	}
+	sessionID, ok := session.Values["session_id"].(uint)
+	if !ok {
+		return 0, 0, false
+	}
+	if err := tokenAuth.check(r, userID, sessionID); err != nil {
+		return 0, 0, false
+	}
+	return userID, sessionID, true
+}
+
+// requestAuth returns the user and device session of a request. Devices send a bearer
+// access token, browsers the session cookie. Both end with their device session. A bearer
+// token that doesn't verify fails without looking at the cookie, so the device refreshes.
+func requestAuth(r *http.Request) (userID, sessionID uint, ok bool) {
+	if token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); found {
+		userID, sessionID, err := tokenAuth.authenticate(r, token)
+		if err != nil {
+			return 0, 0, false
+		}
+		return userID, sessionID, true
+	}
+
+	return sessionAuth(r)
+}
+
+// withAuth adds the user and the device session, if any, to the request context
+func withAuth(ctx context.Context, userID, sessionID uint) context.Context {
+	ctx = withUserID(ctx, userID)
+	if sessionID != 0 {
+		ctx = context.WithValue(ctx, "session_id", sessionID)
+	}
+	return ctx
+}
+
+// withUserID adds the authenticated user to the request context, handlers read it
+// with r.Context().Value("user_id")
+func withUserID(ctx context.Context, userID uint) context.Context {
+	return context.WithValue(ctx, "user_id", userID)
+}
@@ -0,0 +1,173 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"unipilot/internal/models/user"
+)
+
+// deviceLogin logs username in from a device and returns its access token and cookie
+func (s *testServer) deviceLogin(t *testing.T, username, deviceID string) (string, *http.Cookie) {
+	t.Helper()
+
+	body, _ := json.Marshal(map[string]string{"username": username, "password": testPassword, "device_name": deviceID})
+	req, _ := http.NewRequest(http.MethodPost, s.URL+"/login", bytes.NewReader(body))
+	req.Header.Set(DeviceHeader, deviceID)
+	res := send(t, req)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login from %s: status %d", deviceID, res.StatusCode)
+	}
+
+	var tokens map[string]string
+	json.NewDecoder(res.Body).Decode(&tokens)
+	for _, cookie := range res.Cookies() {
+		if cookie.Name == sessionName {
+			return tokens["access_token"], cookie
+		}
+	}
+	t.Fatal("login set no session cookie")
+	return "", nil
+}
+
+// withCookie requests path with only the session cookie
+func (s *testServer) withCookie(t *testing.T, path string, cookie *http.Cookie) int {
+	t.Helper()
+
+	req, _ := http.NewRequest(http.MethodGet, s.URL+path, nil)
+	req.AddCookie(cookie)
+	return send(t, req).StatusCode
+}
+
+// currentSession returns the ID of the device session of token
+func (s *testServer) currentSession(t *testing.T, token string) string {
+	t.Helper()
+
+	var body struct {
+		Sessions []map[string]string `json:"sessions"`
+	}
+	json.NewDecoder(s.do(t, token, http.MethodGet, "/sessions", nil).Body).Decode(&body)
+	for _, session := range body.Sessions {
+		if session["current"] == "true" {
+			return session["id"]
+		}
+	}
+	t.Fatal("no current session")
+	return ""
+}
+
+func TestRevokedDeviceCookieIsRefused(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+
+	laptopToken, laptopCookie := s.deviceLogin(t, "alice", "laptop")
+	phoneToken, phoneCookie := s.deviceLogin(t, "alice", "phone")
+
+	if code := s.withCookie(t, "/user", laptopCookie); code != http.StatusOK {
+		t.Fatalf("cookie before revoke: status %d, want 200", code)
+	}
+
+	// The cookie request is the laptop's session too
+	var body struct {
+		Sessions []map[string]string `json:"sessions"`
+	}
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/sessions", nil)
+	req.AddCookie(laptopCookie)
+	json.NewDecoder(send(t, req).Body).Decode(&body)
+	laptop := s.currentSession(t, laptopToken)
+	for _, session := range body.Sessions {
+		if (session["current"] == "true") != (session["id"] == laptop) {
+			t.Fatalf("cookie request current session = %+v, want the laptop's %s", session, laptop)
+		}
+	}
+
+	if res := s.do(t, phoneToken, http.MethodPost, "/sessions/revoke?id="+laptop, nil); res.StatusCode != http.StatusOK {
+		t.Fatalf("revoke: status %d", res.StatusCode)
+	}
+
+	if code := s.withCookie(t, "/user", laptopCookie); code != http.StatusUnauthorized {
+		t.Fatalf("cookie of revoked device: status %d, want 401", code)
+	}
+	if res := s.do(t, laptopToken, http.MethodGet, "/user", nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("token of revoked device: status %d, want 401", res.StatusCode)
+	}
+	if code := s.withCookie(t, "/user", phoneCookie); code != http.StatusOK {
+		t.Fatalf("cookie of another device: status %d, want 200", code)
+	}
+}
+
+func TestLogoutEndsCookieDevice(t *testing.T) {
+	s := newTestServer(t)
+	s.addUser(t, "alice")
+	token, cookie := s.deviceLogin(t, "alice", "browser")
+
+	req, _ := http.NewRequest(http.MethodPost, s.URL+"/logout", nil)
+	req.AddCookie(cookie)
+	if res := send(t, req); res.StatusCode != http.StatusOK {
+		t.Fatalf("logout: status %d", res.StatusCode)
+	}
+
+	// A copy of the cookie kept elsewhere no longer works either
+	if code := s.withCookie(t, "/user", cookie); code != http.StatusUnauthorized {
+		t.Fatalf("cookie after logout: status %d, want 401", code)
+	}
+	if res := s.do(t, token, http.MethodGet, "/user", nil); res.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("token after logout: status %d, want 401", res.StatusCode)
+	}
+}
+
+func TestCookieWithoutDeviceSessionIsRefused(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+
+	// A cookie issued before sessions were tied to devices
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, _ := getSession(req)
+	session.Values["user_id"] = alice.ID
+	session.Values["authenticated"] = true
+	if err := session.Save(req, rec); err != nil {
+		t.Fatal(err)
+	}
+	cookie := rec.Result().Cookies()[0]
+	if !strings.HasPrefix(cookie.Name, sessionName) {
+		t.Fatalf("cookie %q, want the session cookie", cookie.Name)
+	}
+
+	if code := s.withCookie(t, "/user", cookie); code != http.StatusUnauthorized {
+		t.Fatalf("cookie without device session: status %d, want 401", code)
+	}
+}
+
+func TestPasswordResetEndsCookieSessions(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	token, cookie := s.deviceLogin(t, "alice", "laptop")
+	_, browserCookie := s.deviceLogin(t, "alice", "browser")
+
+	resetToken, hash, err := newOpaqueToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.db.Create(&user.PasswordResetToken{UserID: alice.ID, TokenHash: hash, ExpiresAt: time.Now().Add(time.Hour)}).Error; err != nil {
+		t.Fatal(err)
+	}
+	res := s.do(t, "", http.MethodPost, "/password/reset/confirm", map[string]string{"token": resetToken, "password": "a brand new password"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("reset: status %d", res.StatusCode)
+	}
+
+	for name, c := range map[string]*http.Cookie{"laptop": cookie, "browser": browserCookie} {
+		if code := s.withCookie(t, "/user", c); code != http.StatusUnauthorized {
+			t.Errorf("%s cookie after reset: status %d, want 401", name, code)
+		}
+	}
+	if res := s.do(t, token, http.MethodGet, "/user", nil); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("token after reset: status %d, want 401", res.StatusCode)
+	}
+}
@@ -1,87 +1,177 @@
 package server
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
+	"unipilot/internal/models"
+
 	"gorm.io/gorm"
 )
 
+// eventRetention is how long events stay in the log for reconnecting clients
+const eventRetention = 7 * 24 * time.Hour
+
+// maxReplay is how many missed events a reconnecting client is sent, past it the
+// client is told to resync instead
+const maxReplay = 500
+
+// SSEMessage is an event ready to be written to a stream, ID is its position in the user's event log
+type SSEMessage struct {
+	ID   uint
+	Name string
+	Data []byte
+}
+
+// DeviceHeader identifies the device a request comes from, see client.DeviceHeader
+const DeviceHeader = "X-Device-ID"
+
+// SSEClient is one open event stream. A user has one per connected device.
 type SSEClient struct {
+	ID         string
 	UserID     uint
-	Messages   chan []byte
+	DeviceID   string
+	Messages   chan SSEMessage
 	Connected  bool
 	LastActive time.Time
+	// Lagging is closed when a message could not be queued, the stream is then
+	// closed so the client reconnects and replays from the event log
+	Lagging     chan struct{}
+	laggingOnce sync.Once
+}
+
+func (c *SSEClient) markLagging() {
+	c.laggingOnce.Do(func() {
+		close(c.Lagging)
+	})
 }
 
 type SSEServer struct {
-	clients map[uint]*SSEClient
+	// clients holds the open streams of each user by connection ID
+	clients map[uint]map[string]*SSEClient
 	mu      sync.RWMutex
 	db      *gorm.DB
+	// logMu serializes event log writes, an event committing after one with a
+	// higher ID would be missed by clients resuming from that ID
+	logMu sync.Mutex
 }
 
 func NewSSEServer(db *gorm.DB) *SSEServer {
-	return &SSEServer{
-		clients: make(map[uint]*SSEClient),
+	if err := models.MigrateEvents(db); err != nil {
+		PrintLog(fmt.Sprintf("Failed to migrate event log: %v", err))
+	}
+
+	s := &SSEServer{
+		clients: make(map[uint]map[string]*SSEClient),
 		db:      db,
 	}
+	go s.pruneEvents()
+	return s
+}
+
+// pruneEvents drops old events from the log once an hour
+func (s *SSEServer) pruneEvents() {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := models.PruneEvents(s.db, eventRetention); err != nil {
+			PrintLog(fmt.Sprintf("Failed to prune event log: %v", err))
+		}
+	}
 }
 
-func (s *SSEServer) AddClient(userID uint) *SSEClient {
+// newConnectionID returns a random ID for a stream
+func newConnectionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *SSEServer) AddClient(userID uint, deviceID string) *SSEClient {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	client := &SSEClient{
+		ID:        newConnectionID(),
 		UserID:    userID,
-		Messages:  make(chan []byte, 100),
+		DeviceID:  deviceID,
+		Messages:  make(chan SSEMessage, 100),
 		Connected: true,
+		Lagging:   make(chan struct{}),
+	}
+
+	if s.clients[userID] == nil {
+		s.clients[userID] = make(map[string]*SSEClient)
 	}
+	s.clients[userID][client.ID] = client
 
-	PrintLog(fmt.Sprintf("New SSE user id : %d\n", userID))
-	s.clients[userID] = client
+	PrintLog(fmt.Sprintf("New SSE connection %s for user id : %d (device %q, %d open)", client.ID, userID, deviceID, len(s.clients[userID])))
 	return client
 }
 
-func (s *SSEServer) RemoveClient(userID uint) {
+// RemoveClient closes one stream, the user's other connections are left untouched
+func (s *SSEServer) RemoveClient(client *SSEClient) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if client, ok := s.clients[userID]; ok {
-		close(client.Messages)
-		delete(s.clients, userID)
+	conns, ok := s.clients[client.UserID]
+	if !ok {
+		return
+	}
+	if _, ok := conns[client.ID]; !ok {
+		return
+	}
+
+	close(client.Messages)
+	delete(conns, client.ID)
+	if len(conns) == 0 {
+		delete(s.clients, client.UserID)
 	}
 }
 
-func (s *SSEServer) SendToUser(userID uint, message []byte) bool {
+// SendToUser fans a message out to every open stream of the user, except the ones of
+// excludeDevice when set. It reports whether at least one stream queued the message.
+func (s *SSEServer) SendToUser(userID uint, message SSEMessage, excludeDevice string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	s.logActiveClients()
-	if client, ok := s.clients[userID]; ok {
+	sent := false
+	for _, client := range s.clients[userID] {
+		if excludeDevice != "" && client.DeviceID == excludeDevice {
+			continue
+		}
+
 		select {
 		case client.Messages <- message:
-			PrintLog(fmt.Sprintf("new SSE message for user id : %d", userID))
-			return true
+			PrintLog(fmt.Sprintf("new SSE message for user id : %d on connection %s", userID, client.ID))
+			sent = true
 		default:
-			// Channel full, client might be slow
-			return false
+			// Channel full, client might be slow. The message is in the event
+			// log, drop the stream so the client reconnects and replays it.
+			client.markLagging()
 		}
 	}
-	return false
+	return sent
 }
 
-func (s *SSEServer) Broadcast(message []byte) {
+func (s *SSEServer) Broadcast(message SSEMessage) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	for _, client := range s.clients {
-		select {
-		case client.Messages <- message:
-		default:
-			// Skip if channel is full
+	for _, conns := range s.clients {
+		for _, client := range conns {
+			select {
+			case client.Messages <- message:
+			default:
+				// Skip if channel is full
+			}
 		}
 	}
 }
@@ -89,7 +179,12 @@ func (s *SSEServer) Broadcast(message []byte) {
 func (s *SSEServer) logActiveClients() {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
-	PrintLog(fmt.Sprintf("Active Clients: %v", s.clients))
+
+	active := make(map[uint]int, len(s.clients))
+	for userID, conns := range s.clients {
+		active[userID] = len(conns)
+	}
+	PrintLog(fmt.Sprintf("Active Clients: %v", active))
 }
 
 type noTimeoutWriter struct {
@@ -105,6 +200,17 @@ func (w *noTimeoutWriter) Write(p []byte) (int, error) {
 	return w.ResponseWriter.Write(p)
 }
 
+// writeEvent writes one SSE frame, with its log ID and event name when it has them
+func writeEvent(w http.ResponseWriter, msg SSEMessage) {
+	if msg.ID != 0 {
+		fmt.Fprintf(w, "id: %d\n", msg.ID)
+	}
+	if msg.Name != "" {
+		fmt.Fprintf(w, "event: %s\n", msg.Name)
+	}
+	fmt.Fprintf(w, "data: %s\n\n", msg.Data)
+}
+
 func (s *SSEServer) SSEHandler(w http.ResponseWriter, r *http.Request) {
 
 	PrintLog(fmt.Sprintf("SSE connection attempt from %s", r.RemoteAddr))
@@ -136,13 +242,25 @@ func (s *SSEServer) SSEHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Add client to server
-	client := s.AddClient(userID)
+	// Add client to server before reading the log so no event falls in between
+	deviceID := r.Header.Get(DeviceHeader)
+	client := s.AddClient(userID, deviceID)
 	s.logActiveClients()
 
+	// Replay what the client missed since the last event it applied. Live messages
+	// queued meanwhile may include replayed events, those are skipped.
+	replayed := make(map[uint]bool)
+	var resynced uint
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		if id, err := strconv.ParseUint(lastEventID, 10, 64); err == nil {
+			replayed, resynced = s.replay(w, userID, deviceID, uint(id))
+		}
+	}
+	flusher.Flush()
+
 	defer func() {
-		PrintLog(fmt.Sprintf("Removing client %d (reason: connection closing)", int(userID)))
-		s.RemoveClient(userID)
+		PrintLog(fmt.Sprintf("Removing client %d connection %s (reason: connection closing)", int(userID), client.ID))
+		s.RemoveClient(client)
 	}()
 
 	// Send initial connection message
@@ -156,8 +274,15 @@ func (s *SSEServer) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	for {
 		select {
 		case msg := <-client.Messages:
-			fmt.Fprintf(w, "data: %s\n\n", msg)
+			// Already sent during the replay, or covered by the resync
+			if replayed[msg.ID] || (msg.ID != 0 && msg.ID <= resynced) {
+				continue
+			}
+			writeEvent(w, msg)
 			flusher.Flush()
+		case <-client.Lagging:
+			PrintLog(fmt.Sprintf("Client %d fell behind, closing stream", userID))
+			return
 		case <-heartbeatTicker.C:
 			// Send heartbeat to keep connection alive
 			// Verify client is still active
@@ -175,27 +300,90 @@ func (s *SSEServer) SSEHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-type NotificationMessage struct {
-	Type    string      `json:"type"`    // "create", "update", "delete"
-	Entity  string      `json:"entity"`  // "assignment", "course"
-	ID      string      `json:"id"`      // Entity ID
-	Message string      `json:"message"` // Human-readable message
-	Data    interface{} `json:"data"`    // The actual data
-}
+// replay writes the user's events after lastID and returns the IDs it sent. A client
+// too far behind gets a resync event carrying the newest ID instead, every event up
+// to that ID is then covered.
+func (s *SSEServer) replay(w http.ResponseWriter, userID uint, deviceID string, lastID uint) (map[uint]bool, uint) {
+	replayed := make(map[uint]bool)
+
+	missed, err := models.EventsAfter(s.db, userID, lastID, maxReplay+1)
+	if err != nil {
+		PrintLog(fmt.Sprintf("Failed to read event log for user %d: %v", userID, err))
+		return replayed, 0
+	}
+
+	if len(missed) > maxReplay {
+		latest, err := models.LatestEventID(s.db, userID)
+		if err != nil {
+			PrintLog(fmt.Sprintf("Failed to read event log for user %d: %v", userID, err))
+			return replayed, 0
+		}
+		writeEvent(w, SSEMessage{ID: latest, Name: models.EventResync, Data: []byte(`{"version":1}`)})
+		PrintLog(fmt.Sprintf("User %d missed more than %d events after id %d, asked for a resync", userID, maxReplay, lastID))
+		return replayed, latest
+	}
 
-func (s *SSEServer) SendNotification(userID uint, msgType, entity, id, message string, data interface{}) {
-	notification := NotificationMessage{
-		Type:    msgType,
-		Entity:  entity,
-		ID:      id,
-		Message: message,
-		Data:    data,
+	for _, event := range missed {
+		replayed[event.ID] = true
+		// The device already applied its own changes
+		if deviceID != "" && event.OriginDevice == deviceID {
+			continue
+		}
+		writeEvent(w, SSEMessage{ID: event.ID, Name: event.Name, Data: []byte(event.Payload)})
 	}
+	PrintLog(fmt.Sprintf("Replayed %d events to user %d after id %d", len(missed), userID, lastID))
+	return replayed, 0
+}
 
-	jsonData, err := json.Marshal(notification)
+// SendNotification records an event in the user's event log and sends it to every
+// connected device. originDevice, when known, is the device that made the change,
+// it is skipped since it already applied the change locally.
+func (s *SSEServer) SendNotification(userID uint, originDevice string, entity models.Entity, op models.Operation, id, message string, data interface{}) {
+	payload, err := json.Marshal(data)
 	if err != nil {
+		PrintLog(fmt.Sprintf("Failed to encode %s %s event data: %v", entity, op, err))
 		return
 	}
 
-	s.SendToUser(userID, jsonData)
+	envelope := models.EventEnvelope{
+		Version:      models.EventVersion,
+		Entity:       entity,
+		Operation:    op,
+		EntityID:     id,
+		OriginDevice: originDevice,
+		Timestamp:    time.Now().UTC(),
+		Message:      message,
+		Data:         payload,
+	}
+
+	// Persist first so the event can be replayed if the user is offline or reconnecting.
+	// The sequence number is the log ID, known once the row exists.
+	event := models.UserEvent{
+		UserID:       userID,
+		Name:         models.EventName(entity, op),
+		OriginDevice: originDevice,
+	}
+	var jsonData []byte
+	s.logMu.Lock()
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&event).Error; err != nil {
+			return err
+		}
+
+		envelope.Seq = event.ID
+		if jsonData, err = json.Marshal(envelope); err != nil {
+			return err
+		}
+		return tx.Model(&event).Update("payload", string(jsonData)).Error
+	})
+	s.logMu.Unlock()
+	if err != nil {
+		PrintLog(fmt.Sprintf("Failed to store event for user %d: %v", userID, err))
+		if jsonData, err = json.Marshal(envelope); err != nil {
+			return
+		}
+		event.ID = 0
+	}
+
+	s.SendToUser(userID, SSEMessage{ID: event.ID, Name: event.Name, Data: jsonData}, originDevice)
 }
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"unipilot/internal/models"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func newSSETestServer(t *testing.T) *SSEServer {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file:"+t.Name()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSSEServer(db)
+}
+
+// stream opens an event stream whose request is already over, so the handler returns
+// after the replay and the body holds what it replayed
+func stream(t *testing.T, s *SSEServer, userID uint, deviceID, lastEventID string) string {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), "user_id", userID))
+	cancel()
+	req := httptest.NewRequest(http.MethodGet, "/events", nil).WithContext(ctx)
+	req.Header.Set(DeviceHeader, deviceID)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	rec := httptest.NewRecorder()
+	s.SSEHandler(rec, req)
+	return rec.Body.String()
+}
+
+func TestReplayAfterLastEventID(t *testing.T) {
+	s := newSSETestServer(t)
+
+	for i := 1; i <= 3; i++ {
+		s.SendNotification(1, "", models.EntityCourse, models.OperationUpdate, fmt.Sprint(i), "", nil)
+	}
+	s.SendNotification(2, "", models.EntityCourse, models.OperationUpdate, "4", "", nil)
+
+	body := stream(t, s, 1, "", "1")
+	for _, want := range []string{"id: 2\nevent: course.update\n", "id: 3\nevent: course.update\n"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("replay is missing %q:\n%s", want, body)
+		}
+	}
+	for _, unwanted := range []string{"id: 1\n", "id: 4\n"} {
+		if strings.Contains(body, unwanted) {
+			t.Errorf("replay has %q:\n%s", unwanted, body)
+		}
+	}
+
+	if body := stream(t, s, 1, "", ""); body != "" {
+		t.Errorf("stream without Last-Event-ID replayed:\n%s", body)
+	}
+}
+
+func TestReplayPastMaxReplayAsksForResync(t *testing.T) {
+	s := newSSETestServer(t)
+
+	events := make([]models.UserEvent, maxReplay+2)
+	for i := range events {
+		events[i] = models.UserEvent{UserID: 1, Name: "course.update", Payload: "{}"}
+	}
+	if err := s.db.CreateInBatches(events, 100).Error; err != nil {
+		t.Fatal(err)
+	}
+	latest := events[len(events)-1].ID
+
+	body := stream(t, s, 1, "", "1")
+	expected := fmt.Sprintf("id: %d\nevent: %s\ndata: {\"version\":1}\n\n", latest, models.EventResync)
+	if body != expected {
+		t.Errorf("body =\n%s\nwant\n%s", body, expected)
+	}
+
+	// Exactly maxReplay missed events are still replayed
+	body = stream(t, s, 1, "", "2")
+	if strings.Contains(body, models.EventResync) || strings.Count(body, "event: course.update") != maxReplay {
+		t.Errorf("replay of %d events sent %d of them", maxReplay, strings.Count(body, "event: course.update"))
+	}
+}
+
+func TestNotificationsSkipTheOriginDevice(t *testing.T) {
+	s := newSSETestServer(t)
+
+	laptop := s.AddClient(1, "laptop")
+	phone := s.AddClient(1, "phone")
+	other := s.AddClient(2, "laptop")
+
+	s.SendNotification(1, "laptop", models.EntityNote, models.OperationCreate, "7", "", nil)
+
+	select {
+	case msg := <-phone.Messages:
+		if msg.Name != "note.create" {
+			t.Errorf("phone got %q", msg.Name)
+		}
+	default:
+		t.Error("phone got nothing")
+	}
+	if len(laptop.Messages) != 0 || len(other.Messages) != 0 {
+		t.Errorf("laptop got %d messages and the other user %d, want none", len(laptop.Messages), len(other.Messages))
+	}
+
+	// The replay leaves it out as well
+	if body := stream(t, s, 1, "laptop", "0"); body != "" {
+		t.Errorf("origin device replayed:\n%s", body)
+	}
+	if body := stream(t, s, 1, "phone", "0"); !strings.Contains(body, "event: note.create") {
+		t.Errorf("phone replay is missing the event:\n%s", body)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+
+	"gorm.io/gorm"
+)
+
+// changeSet accumulates the rows returned by GetChangesHandler and the newest change time seen
+type changeSet struct {
+	since  *time.Time
+	latest time.Time
+}
+
+// encodeCursor turns a change time into the opaque cursor handed to clients
+func encodeCursor(t time.Time) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(t.UTC().Format(time.RFC3339Nano)))
+}
+
+// decodeCursor reads a cursor produced by encodeCursor, an empty cursor means "from the beginning"
+func decodeCursor(cursor string) (*time.Time, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, string(raw))
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	return &t, nil
+}
+
+// scope selects the user's rows changed since the cursor. Soft-deleted rows are
+// included as tombstones, except on the first sync where there is nothing to delete.
+func (c *changeSet) scope(db *gorm.DB, userID uint) *gorm.DB {
+	if c.since == nil {
+		return db.Where("user_id = ?", userID)
+	}
+	return db.Unscoped().
+		Where("user_id = ?", userID).
+		Where("(updated_at >= ? OR deleted_at >= ?)", *c.since, *c.since)
+}
+
+// track records the row change time so the next cursor starts after it
+func (c *changeSet) track(updatedAt time.Time, deletedAt gorm.DeletedAt) {
+	if updatedAt.After(c.latest) {
+		c.latest = updatedAt
+	}
+	if deletedAt.Valid && deletedAt.Time.After(c.latest) {
+		c.latest = deletedAt.Time
+	}
+}
+
+// GetChangesHandler returns the assignments, courses, notes and document metadata
+// changed since the cursor given in the query string, along with the next cursor.
+// The cursor is inclusive so rows sharing the boundary timestamp may be sent twice,
+// clients apply changes idempotently.
+func GetChangesHandler(w http.ResponseWriter, r *http.Request) {
+	userIDVal := r.Context().Value("user_id")
+	if userIDVal == nil {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	userID, ok := userIDVal.(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "Invalid user ID format")
+		return
+	}
+
+	dbVal := r.Context().Value("db")
+	if dbVal == nil {
+		PrintERROR(w, http.StatusInternalServerError, "Database connection not found")
+		return
+	}
+
+	db, ok := dbVal.(*gorm.DB)
+	if !ok {
+		PrintERROR(w, http.StatusInternalServerError, "Invalid database connection")
+		return
+	}
+
+	cursor := r.URL.Query().Get("cursor")
+	since, err := decodeCursor(cursor)
+	if err != nil {
+		PrintERROR(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	changes := &changeSet{since: since}
+	if since != nil {
+		changes.latest = *since
+	}
+
+	var assignments []assignment.Assignment
+	if err := changes.scope(db, userID).Find(&assignments).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error getting assignment changes for user id = %d : %s", userID, err))
+		return
+	}
+
+	assignmentsMap := make([]map[string]string, 0, len(assignments))
+	for _, a := range assignments {
+		changes.track(a.UpdatedAt, a.DeletedAt)
+		m := assignmentRow(&a)
+		assignmentsMap = append(assignmentsMap, m)
+	}
+
+	var courses []course.Course
+	if err := changes.scope(db, userID).Find(&courses).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error getting course changes for user id = %d : %s", userID, err))
+		return
+	}
+
+	coursesMap := make([]map[string]string, 0, len(courses))
+	for _, c := range courses {
+		changes.track(c.UpdatedAt, c.DeletedAt)
+		m := courseRow(&c)
+		coursesMap = append(coursesMap, m)
+	}
+
+	var notes []note.Note
+	if err := changes.scope(db, userID).Find(&notes).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error getting note changes for user id = %d : %s", userID, err))
+		return
+	}
+
+	notesMap := make([]map[string]string, 0, len(notes))
+	for _, n := range notes {
+		changes.track(n.UpdatedAt, n.DeletedAt)
+		m := noteRow(&n)
+		notesMap = append(notesMap, m)
+	}
+
+	var documents []document.Document
+	if err := changes.scope(db, userID).Find(&documents).Error; err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error getting document changes for user id = %d : %s", userID, err))
+		return
+	}
+
+	documentsMap := make([]map[string]string, 0, len(documents))
+	for _, d := range documents {
+		changes.track(d.UpdatedAt, d.DeletedAt)
+		documentsMap = append(documentsMap, documentRow(db, &d))
+	}
+
+	nextCursor := cursor
+	if !changes.latest.IsZero() {
+		nextCursor = encodeCursor(changes.latest)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":     "Changes retrieved successfully",
+		"cursor":      nextCursor,
+		"assignments": assignmentsMap,
+		"courses":     coursesMap,
+		"notes":       notesMap,
+		"documents":   documentsMap,
+	})
+}
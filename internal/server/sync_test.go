@@ -0,0 +1,43 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestChangesIncludeLegacyUpdates(t *testing.T) {
+	s := newTestServer(t)
+	alice := s.addUser(t, "alice")
+	data := addAliceData(t, s, alice.ID)
+	token := s.login(t, "alice")
+
+	changes := func(cursor string) (string, []map[string]string) {
+		var body struct {
+			Cursor      string              `json:"cursor"`
+			Assignments []map[string]string `json:"assignments"`
+		}
+		res := s.do(t, token, http.MethodGet, "/sync/changes?cursor="+cursor, nil)
+		if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		return body.Cursor, body.Assignments
+	}
+	cursor, _ := changes("")
+
+	// Updated within the second the cursor was taken, the change still comes after it
+	res := s.do(t, token, http.MethodPost, "/assignment/update",
+		map[string]string{"remote_id": fmt.Sprint(data.assignment.ID), "column": "title", "value": "Essay v2"})
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("update: status %d", res.StatusCode)
+	}
+
+	next, assignments := changes(cursor)
+	if len(assignments) != 1 || assignments[0]["title"] != "Essay v2" {
+		t.Fatalf("changes since cursor = %v, want the updated assignment", assignments)
+	}
+	if next == cursor {
+		t.Fatal("cursor didn't move past the update")
+	}
+}
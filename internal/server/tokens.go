@@ -0,0 +1,445 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"unipilot/internal/models"
+
+	"gorm.io/gorm"
+)
+
+// Lifetimes of device tokens. Access tokens are short-lived, devices renew them with
+// their refresh token, which is replaced on every refresh.
+const (
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 30 * 24 * time.Hour
+
+	// lastSeenInterval limits how often a session's last-seen time is written
+	lastSeenInterval = time.Minute
+)
+
+// errInvalidToken is returned for tokens that are malformed, expired or revoked
+var errInvalidToken = errors.New("invalid or expired token")
+
+// tokenAuth issues and verifies device tokens, built once from the config at boot
+var tokenAuth *tokenService
+
+type tokenService struct {
+	db *gorm.DB
+	// keys sign access tokens with the first key and accept any of them, like the
+	// session cookies
+	keys [][]byte
+}
+
+func newTokenService(db *gorm.DB, keys [][]byte) *tokenService {
+	return &tokenService{db: db, keys: keys}
+}
+
+// accessClaims is the payload of an access token
+type accessClaims struct {
+	UserID    uint  `json:"uid"`
+	SessionID uint  `json:"sid"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+// tokenPair is returned on login and refresh
+type tokenPair struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresAt    string `json:"expires_at"`
+	TokenType    string `json:"token_type"`
+
+	sessionID uint // the device session, the login's cookie is tied to it
+}
+
+func (p *tokenPair) toMap() map[string]string {
+	return map[string]string{
+		"access_token":  p.AccessToken,
+		"refresh_token": p.RefreshToken,
+		"expires_at":    p.ExpiresAt,
+		"token_type":    p.TokenType,
+	}
+}
+
+func (s *tokenService) mac(key []byte, payload string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
+
+// sign returns an access token: the base64 JSON claims and their HMAC, dot separated
+func (s *tokenService) sign(claims accessClaims) (string, error) {
+	data, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(data)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(s.mac(s.keys[0], payload)), nil
+}
+
+// verify checks the signature and expiry of an access token
+func (s *tokenService) verify(token string, now time.Time) (*accessClaims, error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, errInvalidToken
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(sig)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+
+	valid := false
+	for _, key := range s.keys {
+		if hmac.Equal(mac, s.mac(key, payload)) {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return nil, errInvalidToken
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, errInvalidToken
+	}
+	var claims accessClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return nil, errInvalidToken
+	}
+	if now.Unix() >= claims.ExpiresAt {
+		return nil, errInvalidToken
+	}
+	return &claims, nil
+}
+
+// newOpaqueToken returns a random token and the hash stored for it, for refresh tokens
+// and the tokens sent by email
+func newOpaqueToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, hashToken(token), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// trustedProxies are the reverse proxies allowed to set X-Forwarded-For, set at boot
+var trustedProxies []netip.Prefix
+
+// trustedProxy reports whether addr is one of the configured reverse proxies
+func trustedProxy(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the address of the request. X-Forwarded-For is only believed when
+// the request comes from a trusted proxy, the client is then the last address the
+// proxies didn't add themselves. Anyone else could send any address in it.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !trustedProxy(peer) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		if !trustedProxy(hop) {
+			return hop.Unmap().String()
+		}
+		host = hop.Unmap().String()
+	}
+	return host
+}
+
+// pair signs an access token for the session and returns it with the refresh token
+func (s *tokenService) pair(session *models.DeviceSession, refreshToken string, now time.Time) (*tokenPair, error) {
+	expiresAt := now.Add(accessTokenTTL)
+	access, err := s.sign(accessClaims{UserID: session.UserID, SessionID: session.ID, ExpiresAt: expiresAt.Unix()})
+	if err != nil {
+		return nil, err
+	}
+	return &tokenPair{
+		AccessToken:  access,
+		RefreshToken: refreshToken,
+		ExpiresAt:    expiresAt.Format(time.RFC3339),
+		TokenType:    "Bearer",
+		sessionID:    session.ID,
+	}, nil
+}
+
+// issue starts a session for the device of the request. Logging in again from the same
+// device replaces its session's refresh token rather than adding a session.
+func (s *tokenService) issue(r *http.Request, userID uint, deviceName string) (*tokenPair, error) {
+	refreshToken, refreshHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	deviceID := r.Header.Get(DeviceHeader)
+
+	var session models.DeviceSession
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if deviceID != "" {
+			err := tx.Where("user_id = ? AND device_id = ? AND revoked_at IS NULL", userID, deviceID).First(&session).Error
+			if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+				return err
+			}
+		}
+
+		session.UserID = userID
+		session.DeviceID = deviceID
+		if deviceName != "" || session.DeviceName == "" {
+			session.DeviceName = deviceName
+		}
+		session.RefreshHash = refreshHash
+		session.IP = clientIP(r)
+		session.UserAgent = r.UserAgent()
+		session.LastSeenAt = now
+		session.ExpiresAt = now.Add(refreshTokenTTL)
+		return tx.Save(&session).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start session: %w", err)
+	}
+
+	return s.pair(&session, refreshToken, now)
+}
+
+// refresh exchanges a refresh token for a new pair. The old refresh token stops working.
+func (s *tokenService) refresh(r *http.Request, refreshToken string) (*tokenPair, error) {
+	newToken, newHash, err := newOpaqueToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	var session models.DeviceSession
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("refresh_hash = ?", hashToken(refreshToken)).First(&session).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return errInvalidToken
+			}
+			return err
+		}
+		if !session.Active(now) {
+			return errInvalidToken
+		}
+
+		// Only the request holding the current hash rotates it, a concurrent refresh
+		// with the same token loses
+		result := tx.Model(&session).Where("refresh_hash = ?", session.RefreshHash).Updates(map[string]interface{}{
+			"refresh_hash": newHash,
+			"ip":           clientIP(r),
+			"last_seen_at": now,
+			"expires_at":   now.Add(refreshTokenTTL),
+		})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errInvalidToken
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.pair(&session, newToken, now)
+}
+
+// authenticate returns the user and session of a bearer access token. The session is
+// checked on every request so revoking it takes effect at once.
+func (s *tokenService) authenticate(r *http.Request, token string) (uint, uint, error) {
+	claims, err := s.verify(token, time.Now())
+	if err != nil {
+		return 0, 0, err
+	}
+	if err := s.check(r, claims.UserID, claims.SessionID); err != nil {
+		return 0, 0, err
+	}
+	return claims.UserID, claims.SessionID, nil
+}
+
+// check fails with errInvalidToken unless the session of the user is active, and
+// records that it was seen
+func (s *tokenService) check(r *http.Request, userID, sessionID uint) error {
+	now := time.Now()
+
+	var session models.DeviceSession
+	if err := s.db.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errInvalidToken
+		}
+		return err
+	}
+	if !session.Active(now) {
+		return errInvalidToken
+	}
+
+	if now.Sub(session.LastSeenAt) >= lastSeenInterval {
+		if err := s.db.Model(&session).Updates(map[string]interface{}{
+			"last_seen_at": now,
+			"ip":           clientIP(r),
+		}).Error; err != nil {
+			PrintLog(fmt.Sprintf("Failed to update last seen of session %d: %v", session.ID, err))
+		}
+	}
+	return nil
+}
+
+// revoke ends a session of the user, revoking one already revoked succeeds
+func (s *tokenService) revoke(userID, sessionID uint) error {
+	result := s.db.Model(&models.DeviceSession{}).
+		Where("id = ? AND user_id = ?", sessionID, userID).
+		Update("revoked_at", gorm.Expr("COALESCE(revoked_at, ?)", time.Now()))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// revokeAll ends every session of the user, e.g. after a password reset. The cookies
+// are tied to the sessions, so browsers are logged out as well as devices.
+func (s *tokenService) revokeAll(tx *gorm.DB, userID uint) error {
+	return tx.Model(&models.DeviceSession{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// sessions returns the active sessions of the user, most recently seen first
+func (s *tokenService) sessions(userID uint) ([]models.DeviceSession, error) {
+	var sessions []models.DeviceSession
+	err := s.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_seen_at DESC").Find(&sessions).Error
+	return sessions, err
+}
+
+// RefreshTokenHandler exchanges a refresh token for a new access and refresh token
+func RefreshTokenHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.RefreshToken == "" {
+		PrintERROR(w, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	pair, err := tokenAuth.refresh(r, body.RefreshToken)
+	if errors.Is(err, errInvalidToken) {
+		PrintERROR(w, http.StatusUnauthorized, "Invalid or expired refresh token")
+		return
+	}
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Failed to refresh token: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pair)
+}
+
+// ListSessionsHandler returns the devices logged in to the user's account
+func ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+	current, _ := r.Context().Value("session_id").(uint)
+
+	sessions, err := tokenAuth.sessions(userID)
+	if err != nil {
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error getting sessions: %v", err))
+		return
+	}
+
+	sessionsMap := make([]map[string]string, 0, len(sessions))
+	for i := range sessions {
+		row := sessions[i].ToMap()
+		row["current"] = strconv.FormatBool(sessions[i].ID == current)
+		sessionsMap = append(sessionsMap, row)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"message":  "Sessions retrieved successfully",
+		"sessions": sessionsMap,
+	})
+}
+
+// RevokeSessionHandler logs a device of the user out by its session ID
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := r.Context().Value("user_id").(uint)
+	if !ok {
+		PrintERROR(w, http.StatusUnauthorized, "User ID not found in context")
+		return
+	}
+
+	sessionID, err := strconv.ParseUint(r.URL.Query().Get("id"), 10, 64)
+	if err != nil {
+		PrintERROR(w, http.StatusBadRequest, "Session ID required")
+		return
+	}
+
+	if err := tokenAuth.revoke(userID, uint(sessionID)); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			PrintERROR(w, http.StatusNotFound, "Session not found")
+			return
+		}
+		PrintERROR(w, http.StatusInternalServerError, fmt.Sprintf("Error revoking session: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Session revoked successfully",
+	})
+}
@@ -19,6 +19,7 @@ type AudioRecorder struct {
 	isRecording bool
 	outputFile  *os.File
 	stopChan    chan struct{}
+	dir         string
 }
 
 // RecordingMetadata contains information about the recording
@@ -45,9 +46,15 @@ func NewAudioRecorder() (*AudioRecorder, error) {
 	return &AudioRecorder{
 		context:  context,
 		stopChan: make(chan struct{}),
+		dir:      "recordings",
 	}, nil
 }
 
+// SetRecordingsDir changes the directory new recordings are written to
+func (ar *AudioRecorder) SetRecordingsDir(dir string) {
+	ar.dir = dir
+}
+
 // GetAudioDevices returns available audio input devices
 func (ar *AudioRecorder) GetAudioDevices() ([]map[string]interface{}, error) {
 	devices, err := ar.context.Devices(malgo.Capture)
@@ -86,7 +93,7 @@ func (ar *AudioRecorder) StartRecording(metadata RecordingMetadata) error {
 	}
 
 	// Create recordings directory if it doesn't exist
-	recordingsDir := filepath.Join("recordings")
+	recordingsDir := ar.dir
 	if err := os.MkdirAll(recordingsDir, 0755); err != nil {
 		return fmt.Errorf("failed to create recordings directory: %w", err)
 	}
@@ -118,12 +125,14 @@ func (ar *AudioRecorder) StartRecording(metadata RecordingMetadata) error {
 	device, err := malgo.InitDevice(ar.context.Context, config, callbacks)
 	if err != nil {
 		outputFile.Close()
+		os.Remove(filePath)
 		return fmt.Errorf("failed to create audio device: %w", err)
 	}
 
 	// Start the device
 	if err := device.Start(); err != nil {
 		outputFile.Close()
+		os.Remove(filePath)
 		return fmt.Errorf("failed to start audio device: %w", err)
 	}
 
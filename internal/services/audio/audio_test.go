@@ -13,6 +13,7 @@ func TestAudioRecorder(t *testing.T) {
 		t.Fatalf("Failed to create audio recorder: %v", err)
 	}
 	defer recorder.Close()
+	recorder.SetRecordingsDir(t.TempDir())
 
 	// Test getting audio devices
 	devices, err := recorder.GetAudioDevices()
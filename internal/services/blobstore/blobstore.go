@@ -0,0 +1,163 @@
+package blobstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNotFound is returned when no blob is stored under a hash
+var ErrNotFound = errors.New("blob not found")
+
+// Store keeps file contents on disk under their SHA-256, so identical
+// files are stored once whatever their name or how often they are uploaded
+type Store struct {
+	root string
+}
+
+// New returns a store rooted at dir, creating it if needed
+func New(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &Store{root: dir}, nil
+}
+
+// Root returns the directory holding the blobs
+func (s *Store) Root() string {
+	return s.root
+}
+
+// ValidHash reports whether hash is a hex encoded SHA-256
+func ValidHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// Hash returns the hex encoded SHA-256 of everything read from r and its size
+func Hash(r io.Reader) (string, int64, error) {
+	hasher := sha256.New()
+	size, err := io.Copy(hasher, r)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// Path returns where the blob with the given hash lives. Blobs are spread
+// over subdirectories named after the first two characters of the hash.
+func (s *Store) Path(hash string) string {
+	return filepath.Join(s.root, hash[:2], hash)
+}
+
+// Has reports whether a blob is stored under hash
+func (s *Store) Has(hash string) bool {
+	if !ValidHash(hash) {
+		return false
+	}
+	_, err := os.Stat(s.Path(hash))
+	return err == nil
+}
+
+// Put stores the content of r and returns its hash and size. The content is
+// written to a temporary file first so a failed write never leaves a partial blob.
+func (s *Store) Put(r io.Reader) (string, int64, error) {
+	tmp, err := os.CreateTemp(s.root, "upload-*")
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	if s.Has(hash) {
+		// A fresh modification time keeps the blob out of garbage collection until the
+		// upload records its hash
+		now := time.Now()
+		os.Chtimes(s.Path(hash), now, now)
+		return hash, size, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.Path(hash)), 0755); err != nil {
+		return "", 0, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.Path(hash)); err != nil {
+		return "", 0, fmt.Errorf("failed to store blob: %w", err)
+	}
+
+	return hash, size, nil
+}
+
+// Open returns the content of a blob
+func (s *Store) Open(hash string) (*os.File, error) {
+	if !ValidHash(hash) {
+		return nil, ErrNotFound
+	}
+
+	file, err := os.Open(s.Path(hash))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes a blob, deleting a missing blob is not an error
+func (s *Store) Delete(hash string) error {
+	if !ValidHash(hash) {
+		return ErrNotFound
+	}
+	if err := os.Remove(s.Path(hash)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return nil
+}
+
+// Verify hashes a stored blob again and reports whether it still matches its name
+func (s *Store) Verify(hash string) (bool, error) {
+	file, err := s.Open(hash)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	sum, _, err := Hash(file)
+	if err != nil {
+		return false, fmt.Errorf("failed to read blob: %w", err)
+	}
+	return sum == hash, nil
+}
+
+// Walk calls fn with the hash and modification time of every stored blob.
+// Temporary files of uploads in progress are skipped.
+func (s *Store) Walk(fn func(hash string, modTime time.Time) error) error {
+	return filepath.WalkDir(s.root, func(path string, entry os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || !ValidHash(entry.Name()) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return fn(entry.Name(), info.ModTime())
+	})
+}
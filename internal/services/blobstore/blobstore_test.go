@@ -0,0 +1,144 @@
+package blobstore
+
+import (
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPutDeduplicates(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, size, err := store.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != 5 {
+		t.Errorf("size = %d, want 5", size)
+	}
+	if first != "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824" {
+		t.Errorf("hash = %s", first)
+	}
+
+	second, _, err := store.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Errorf("same content stored under %s and %s", first, second)
+	}
+
+	entries, err := os.ReadDir(store.Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("root holds %d entries, want the single blob directory", len(entries))
+	}
+
+	file, err := store.Open(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	content, _ := io.ReadAll(file)
+	if string(content) != "hello" {
+		t.Errorf("content = %q", content)
+	}
+}
+
+func TestVerifyDetectsCorruption(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, _, err := store.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := store.Verify(hash); err != nil || !ok {
+		t.Fatalf("Verify() = %v, %v before corruption", ok, err)
+	}
+
+	if err := os.WriteFile(store.Path(hash), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := store.Verify(hash); ok {
+		t.Error("Verify() accepted a corrupted blob")
+	}
+}
+
+func TestOpenMissing(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Open(strings.Repeat("0", 64)); err != ErrNotFound {
+		t.Errorf("Open() error = %v, want ErrNotFound", err)
+	}
+	if _, err := store.Open("../../etc/passwd"); err != ErrNotFound {
+		t.Errorf("Open() error = %v for an invalid hash, want ErrNotFound", err)
+	}
+}
+
+func TestWalkSkipsTemporaryFiles(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, _, err := store.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp, err := os.CreateTemp(store.Root(), "upload-*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.Close()
+
+	var seen []string
+	err = store.Walk(func(h string, modTime time.Time) error {
+		seen = append(seen, h)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 1 || seen[0] != hash {
+		t.Errorf("walked %v, want only %s", seen, hash)
+	}
+}
+
+func TestPutRefreshesExistingBlob(t *testing.T) {
+	store, err := New(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, _, err := store.Put(strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(store.Path(hash), old, old)
+
+	if _, _, err := store.Put(strings.NewReader("hello")); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(store.Path(hash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().After(old.Add(time.Hour)) {
+		t.Errorf("modification time %v not refreshed by a deduplicated upload", info.ModTime())
+	}
+}
@@ -0,0 +1,234 @@
+// Package calendar writes assignment deadlines and the weekly meetings of courses as
+// an iCalendar file (RFC 5545), for calendar apps to import or subscribe to.
+package calendar
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ProductID identifies the app in the calendars it writes
+const ProductID = "-//UniPilot//Calendar//EN"
+
+// Deadline is an assignment shown as an event at its deadline
+type Deadline struct {
+	UID         string
+	Title       string
+	Description string
+	CourseCode  string
+	Type        string
+	Link        string
+	At          time.Time
+	Completed   bool
+	Modified    time.Time
+}
+
+// Course is a course whose Schedule, e.g. "M, W 9:00 AM - 10:30 AM", is shown as a
+// weekly event from Start to End
+type Course struct {
+	UID        string
+	Code       string
+	Name       string
+	Room       string
+	Instructor string
+	Schedule   string
+	Start      time.Time
+	End        time.Time
+	Modified   time.Time
+}
+
+// Calendar is the content of an iCalendar file
+type Calendar struct {
+	Name      string
+	Deadlines []Deadline
+	Courses   []Course
+	// Stamp is the DTSTAMP of events without a modification time, the current time
+	// when zero
+	Stamp time.Time
+}
+
+// UID returns the unique identifier of the event of a row. kind tells the rows of
+// different tables apart.
+func UID(kind string, id uint) string {
+	return fmt.Sprintf("%s-%d@unipilot", kind, id)
+}
+
+// Write writes c to w. Deadlines at midnight are all-day events, others are instants.
+// Class meetings are written in floating time, at the same hour wherever the
+// calendar is read. Courses without a schedule, e.g. "Async", or without dates are
+// left out.
+func Write(w io.Writer, c *Calendar) error {
+	stamp := c.Stamp
+	if stamp.IsZero() {
+		stamp = time.Now()
+	}
+
+	e := &encoder{w: w}
+	e.line("BEGIN:VCALENDAR")
+	e.line("VERSION:2.0")
+	e.line("PRODID:" + ProductID)
+	e.line("CALSCALE:GREGORIAN")
+	if c.Name != "" {
+		e.line("X-WR-CALNAME:" + text(c.Name))
+	}
+	// Subscribed calendars are fetched again every hour
+	e.line("REFRESH-INTERVAL;VALUE=DURATION:PT1H")
+	e.line("X-PUBLISHED-TTL:PT1H")
+
+	for i := range c.Deadlines {
+		e.deadline(&c.Deadlines[i], stamp)
+	}
+	for i := range c.Courses {
+		e.meetings(&c.Courses[i], stamp)
+	}
+
+	e.line("END:VCALENDAR")
+	return e.err
+}
+
+// encoder writes content lines, folded to 75 octets and ended by CRLF
+type encoder struct {
+	w   io.Writer
+	err error
+}
+
+func (e *encoder) line(s string) {
+	if e.err != nil {
+		return
+	}
+	var b strings.Builder
+	for len(s) > 75 {
+		// Fold before a whole rune, continuation lines start with a space
+		n := 75
+		if b.Len() > 0 {
+			n = 74
+		}
+		for n > 0 && !utf8.RuneStart(s[n]) {
+			n--
+		}
+		b.WriteString(s[:n])
+		b.WriteString("\r\n ")
+		s = s[n:]
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+	_, e.err = io.WriteString(e.w, b.String())
+}
+
+func (e *encoder) deadline(d *Deadline, stamp time.Time) {
+	summary := d.Title
+	if d.CourseCode != "" {
+		summary = d.CourseCode + ": " + summary
+	}
+	if d.Completed {
+		summary = "✓ " + summary
+	}
+	description := d.Description
+	if d.Link != "" {
+		description = strings.TrimSpace(description + "\n\n" + d.Link)
+	}
+
+	e.line("BEGIN:VEVENT")
+	e.line("UID:" + d.UID)
+	e.line("DTSTAMP:" + utc(modified(d.Modified, stamp)))
+	if isDate(d.At) {
+		e.line("DTSTART;VALUE=DATE:" + date(d.At))
+		e.line("DTEND;VALUE=DATE:" + date(d.At.AddDate(0, 0, 1)))
+	} else {
+		e.line("DTSTART:" + utc(d.At))
+	}
+	e.line("SUMMARY:" + text(summary))
+	if description != "" {
+		e.line("DESCRIPTION:" + text(description))
+	}
+	if d.Type != "" {
+		e.line("CATEGORIES:" + text(d.Type))
+	}
+	if u, err := url.Parse(d.Link); err == nil && u.Scheme != "" && u.Host != "" {
+		e.line("URL:" + u.String())
+	}
+	e.line("TRANSP:TRANSPARENT")
+	if !d.Modified.IsZero() {
+		e.line("LAST-MODIFIED:" + utc(d.Modified))
+	}
+	e.line("END:VEVENT")
+}
+
+func (e *encoder) meetings(c *Course, stamp time.Time) {
+	m, ok := ParseSchedule(c.Schedule)
+	if !ok || c.Start.IsZero() || c.End.IsZero() {
+		return
+	}
+	start := dateOf(c.Start)
+	end := dateOf(c.End)
+	// The first meeting is on the first day of the course with a class
+	for !m.meetsOn(start.Weekday()) {
+		start = start.AddDate(0, 0, 1)
+	}
+	if start.After(end) {
+		return
+	}
+
+	days := make([]string, len(m.Days))
+	for i, day := range m.Days {
+		days[i] = strings.ToUpper(day.String()[:2])
+	}
+	summary := strings.TrimSpace(c.Code + " " + c.Name)
+
+	e.line("BEGIN:VEVENT")
+	e.line("UID:" + c.UID)
+	e.line("DTSTAMP:" + utc(modified(c.Modified, stamp)))
+	e.line("DTSTART:" + local(start.Add(m.Start)))
+	e.line("DTEND:" + local(start.Add(m.End)))
+	e.line(fmt.Sprintf("RRULE:FREQ=WEEKLY;BYDAY=%s;UNTIL=%s", strings.Join(days, ","), local(end.Add(24*time.Hour-time.Second))))
+	e.line("SUMMARY:" + text(summary))
+	if c.Room != "" {
+		e.line("LOCATION:" + text(c.Room))
+	}
+	if c.Instructor != "" {
+		e.line("DESCRIPTION:" + text("Instructor: "+c.Instructor))
+	}
+	if !c.Modified.IsZero() {
+		e.line("LAST-MODIFIED:" + utc(c.Modified))
+	}
+	e.line("END:VEVENT")
+}
+
+func modified(t, stamp time.Time) time.Time {
+	if t.IsZero() {
+		return stamp
+	}
+	return t
+}
+
+// isDate reports whether t is midnight, a deadline set as a day
+func isDate(t time.Time) bool {
+	return t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0
+}
+
+// dateOf returns the day of t, at midnight in floating time
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func date(t time.Time) string {
+	return t.Format("20060102")
+}
+
+func utc(t time.Time) string {
+	return t.UTC().Format("20060102T150405Z")
+}
+
+// local formats a floating time, read in the time zone of the calendar
+func local(t time.Time) string {
+	return t.Format("20060102T150405")
+}
+
+// text escapes a TEXT value
+func text(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
@@ -0,0 +1,129 @@
+package calendar
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     string
+	}{
+		{"M, W 9:00 AM - 10:30 AM", "[Monday Wednesday] 9h0m0s-10h30m0s"},
+		{"Tu, Th 1:00 PM - 2:15 PM", "[Tuesday Thursday] 13h0m0s-14h15m0s"},
+		{"MWF 11:00 - 11:50 AM", "[Monday Wednesday Friday] 11h0m0s-11h50m0s"},
+		{"TTh 11:00 - 12:15 PM", "[Tuesday Thursday] 11h0m0s-12h15m0s"},
+		{"Monday/Wednesday 14:00-15:15", "[Monday Wednesday] 14h0m0s-15h15m0s"},
+		{"Sat 12:00 AM - 1:00 AM", "[Saturday] 0s-1h0m0s"},
+		{"Async", "none"},
+		{"Asynchronous", "none"},
+		{"9:00 AM - 10:30 AM", "none"},
+		{"M 10:00 AM - 9:00 AM", "none"},
+	}
+	for _, tt := range tests {
+		got := "none"
+		if m, ok := ParseSchedule(tt.schedule); ok {
+			got = fmt.Sprintf("%v %v-%v", m.Days, m.Start, m.End)
+		}
+		if got != tt.want {
+			t.Errorf("ParseSchedule(%q) = %s, want %s", tt.schedule, got, tt.want)
+		}
+	}
+}
+
+func write(t *testing.T, c *Calendar) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := Write(&buf, c); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+		if strings.Contains(line, "\n") {
+			t.Errorf("line not ended by CRLF: %q", line)
+		}
+	}
+	// Unfolded, for the tests to look for whole lines
+	return strings.ReplaceAll(out, "\r\n ", "")
+}
+
+func TestWriteDeadlines(t *testing.T) {
+	stamp := time.Date(2025, 9, 1, 8, 0, 0, 0, time.UTC)
+	chicago := time.FixedZone("CDT", -5*3600)
+	out := write(t, &Calendar{
+		Name:  "Fall, 2025",
+		Stamp: stamp,
+		Deadlines: []Deadline{
+			{
+				UID: UID("assignment", 1), Title: "Essay; draft", CourseCode: "ENG101", Type: "HW",
+				Description: "Read chapters 1, 2\nthen write", Link: "https://example.com/essay",
+				At: time.Date(2025, 9, 12, 0, 0, 0, 0, chicago), Modified: time.Date(2025, 8, 30, 10, 0, 0, 0, time.UTC),
+			},
+			{
+				UID: UID("assignment", 2), Title: "Midterm " + strings.Repeat("é", 60), CourseCode: "CS101", Type: "Exam",
+				At: time.Date(2025, 10, 14, 14, 30, 0, 0, chicago), Completed: true,
+			},
+		},
+	})
+
+	for _, want := range []string{
+		"BEGIN:VCALENDAR\r\nVERSION:2.0\r\nPRODID:" + ProductID + "\r\n",
+		"X-WR-CALNAME:Fall\\, 2025\r\n",
+		"UID:assignment-1@unipilot\r\nDTSTAMP:20250830T100000Z\r\n",
+		"DTSTART;VALUE=DATE:20250912\r\nDTEND;VALUE=DATE:20250913\r\n",
+		"SUMMARY:ENG101: Essay\\; draft\r\n",
+		"DESCRIPTION:Read chapters 1\\, 2\\nthen write\\n\\nhttps://example.com/essay\r\n",
+		"CATEGORIES:HW\r\n",
+		"URL:https://example.com/essay\r\n",
+		"UID:assignment-2@unipilot\r\nDTSTAMP:20250901T080000Z\r\nDTSTART:20251014T193000Z\r\n",
+		"SUMMARY:✓ CS101: Midterm " + strings.Repeat("é", 60) + "\r\n",
+		"END:VEVENT\r\nEND:VCALENDAR\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("calendar lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Count(out, "BEGIN:VEVENT") != 2 {
+		t.Errorf("calendar:\n%s", out)
+	}
+}
+
+func TestWriteMeetings(t *testing.T) {
+	out := write(t, &Calendar{
+		Courses: []Course{
+			{
+				UID: UID("course", 7), Code: "CS101", Name: "Intro to programming", Room: "B-204", Instructor: "Ada",
+				Schedule: "Tu, Th 1:00 PM - 2:15 PM",
+				// The course starts on a Monday, the first class is the day after
+				Start: time.Date(2025, 8, 25, 0, 0, 0, 0, time.UTC),
+				End:   time.Date(2025, 12, 12, 0, 0, 0, 0, time.UTC),
+			},
+			{UID: UID("course", 8), Code: "HIST110", Schedule: "Async",
+				Start: time.Date(2025, 8, 25, 0, 0, 0, 0, time.UTC), End: time.Date(2025, 12, 12, 0, 0, 0, 0, time.UTC)},
+			{UID: UID("course", 9), Code: "ART100", Schedule: "M 9:00 AM - 10:00 AM"},
+		},
+	})
+
+	for _, want := range []string{
+		"UID:course-7@unipilot\r\n",
+		"DTSTART:20250826T130000\r\nDTEND:20250826T141500\r\n",
+		"RRULE:FREQ=WEEKLY;BYDAY=TU,TH;UNTIL=20251212T235959\r\n",
+		"SUMMARY:CS101 Intro to programming\r\nLOCATION:B-204\r\nDESCRIPTION:Instructor: Ada\r\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("calendar lacks %q:\n%s", want, out)
+		}
+	}
+	// Courses without meetings or dates have no events
+	if strings.Count(out, "BEGIN:VEVENT") != 1 {
+		t.Errorf("calendar:\n%s", out)
+	}
+}
@@ -0,0 +1,140 @@
+package calendar
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Meeting is when a course meets every week. Start and End are the times of day,
+// as durations since midnight.
+type Meeting struct {
+	Days  []time.Weekday
+	Start time.Duration
+	End   time.Duration
+}
+
+func (m Meeting) meetsOn(day time.Weekday) bool {
+	for _, d := range m.Days {
+		if d == day {
+			return true
+		}
+	}
+	return false
+}
+
+// meetingTimes matches the times of a schedule, "9:00 AM - 10:30 AM" or "14:00-15:15"
+var meetingTimes = regexp.MustCompile(`(?i)(\d{1,2}):(\d{2})\s*([AP]M)?\s*[-–]\s*(\d{1,2}):(\d{2})\s*([AP]M)?`)
+
+// dayNames are the ways schedules write the days of the week
+var dayNames = map[string]time.Weekday{
+	"m": time.Monday, "mo": time.Monday, "mon": time.Monday, "monday": time.Monday,
+	"t": time.Tuesday, "tu": time.Tuesday, "tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"w": time.Wednesday, "we": time.Wednesday, "wed": time.Wednesday, "wednesday": time.Wednesday,
+	"r": time.Thursday, "th": time.Thursday, "thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"f": time.Friday, "fr": time.Friday, "fri": time.Friday, "friday": time.Friday,
+	"s": time.Saturday, "sa": time.Saturday, "sat": time.Saturday, "saturday": time.Saturday,
+	"su": time.Sunday, "sun": time.Sunday, "sunday": time.Sunday,
+}
+
+// dayLetters split days written together, as in "MWF" or "TTh", longest first
+var dayLetters = []string{"th", "tu", "sa", "su", "m", "t", "w", "r", "f", "s"}
+
+// ParseSchedule reads the schedule of a course as the app writes it, days then
+// times: "M, W 9:00 AM - 10:30 AM", "TTh 14:00-15:15". It reports false for
+// schedules without meetings, such as "Async".
+func ParseSchedule(schedule string) (Meeting, bool) {
+	match := meetingTimes.FindStringSubmatchIndex(schedule)
+	if match == nil {
+		return Meeting{}, false
+	}
+	group := func(i int) string {
+		if match[2*i] < 0 {
+			return ""
+		}
+		return schedule[match[2*i]:match[2*i+1]]
+	}
+
+	// "1:00 - 2:15 PM" gives the period once, for both times
+	startPeriod := group(3)
+	if startPeriod == "" {
+		startPeriod = group(6)
+	}
+
+	m := Meeting{}
+	var ok bool
+	if m.Start, ok = clock(group(1), group(2), startPeriod); !ok {
+		return Meeting{}, false
+	}
+	if m.End, ok = clock(group(4), group(5), group(6)); !ok {
+		return Meeting{}, false
+	}
+	if m.End <= m.Start && group(3) == "" && strings.EqualFold(group(6), "PM") {
+		// "11:00 - 12:15 PM" starts in the morning
+		m.Start -= 12 * time.Hour
+	}
+	if m.Start < 0 || m.End <= m.Start {
+		return Meeting{}, false
+	}
+
+	seen := map[time.Weekday]bool{}
+	for _, token := range strings.FieldsFunc(schedule[:match[0]], func(r rune) bool {
+		return r == ',' || r == '/' || r == '&' || r == ' ' || r == '\t'
+	}) {
+		for _, day := range weekdays(strings.ToLower(strings.TrimSuffix(token, "."))) {
+			if !seen[day] {
+				seen[day] = true
+				m.Days = append(m.Days, day)
+			}
+		}
+	}
+	if len(m.Days) == 0 {
+		return Meeting{}, false
+	}
+	return m, true
+}
+
+// weekdays returns the days of a token, a day name or days written together
+func weekdays(token string) []time.Weekday {
+	if day, ok := dayNames[token]; ok {
+		return []time.Weekday{day}
+	}
+	var days []time.Weekday
+	for token != "" {
+		found := false
+		for _, letters := range dayLetters {
+			if strings.HasPrefix(token, letters) {
+				days = append(days, dayNames[letters])
+				token = token[len(letters):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil
+		}
+	}
+	return days
+}
+
+// clock returns the time of day of hour:minute, in 12 hour format when period is
+// AM or PM
+func clock(hour, minute, period string) (time.Duration, bool) {
+	h, _ := strconv.Atoi(hour)
+	min, _ := strconv.Atoi(minute)
+	switch strings.ToUpper(period) {
+	case "AM", "PM":
+		if h < 1 || h > 12 {
+			return 0, false
+		}
+		h %= 12
+		if strings.ToUpper(period) == "PM" {
+			h += 12
+		}
+	}
+	if h > 23 || min > 59 {
+		return 0, false
+	}
+	return time.Duration(h)*time.Hour + time.Duration(min)*time.Minute, true
+}
@@ -86,32 +86,6 @@ func UploadDocument(req FileUploadRequest, db *gorm.DB) (*FileUploadResponse, er
 		}, fmt.Errorf("file too large")
 	}
 
-	// Create LocalDocument record
-	localDoc := document.LocalDocument{
-		AssignmentID: req.AssignmentID,
-		UserID:       req.UserID,
-		Type:         req.Type,
-		FileName:     req.FileName,
-		FileType:     GetMimeType(req.FileName),
-		FileSize:     req.FileSize,
-		Version:      1,
-		HasLocalFile: false, // Will be set to true after successful file write
-	}
-
-	// Generate file path
-	appDataPath, err := document.GetAppDataPath()
-	if err != nil {
-		return &FileUploadResponse{
-			Success: false,
-			Message: "Failed to get app data path",
-		}, err
-	}
-
-	// Create unique filename with assignment and user info
-	fileName := fmt.Sprintf("doc_%d_%d_%s", req.AssignmentID, req.UserID, req.FileName)
-	filePath := filepath.Join(appDataPath, "documents", fileName)
-	localDoc.FilePath = filePath
-
 	// Check storage quota
 	var totalSize int64
 	db.Model(&document.LocalDocument{}).
@@ -127,39 +101,41 @@ func UploadDocument(req FileUploadRequest, db *gorm.DB) (*FileUploadResponse, er
 		}, fmt.Errorf("storage quota exceeded")
 	}
 
-	// Save to database first
-	if err := db.Create(&localDoc).Error; err != nil {
+	// Store the content by hash, a file already stored for another document is reused
+	hash, size, filePath, err := StoreFile(db, req.FileContent)
+	if err != nil {
 		return &FileUploadResponse{
 			Success: false,
-			Message: "Failed to save document record",
+			Message: "Failed to write file",
 		}, err
 	}
 
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		// Clean up database record
-		db.Delete(&localDoc)
-		return &FileUploadResponse{
-			Success: false,
-			Message: "Failed to create directory",
-		}, err
+	// Create LocalDocument record
+	localDoc := document.LocalDocument{
+		AssignmentID: req.AssignmentID,
+		UserID:       req.UserID,
+		Type:         req.Type,
+		FileName:     req.FileName,
+		FileType:     GetMimeType(req.FileName),
+		FilePath:     filePath,
+		Hash:         hash,
+		FileSize:     size,
+		Version:      1,
+		HasLocalFile: true,
 	}
 
-	// Write file to disk
-	if err := writeFile(filePath, req.FileContent); err != nil {
-		// Clean up database record
-		db.Delete(&localDoc)
+	if err := db.Create(&localDoc).Error; err != nil {
+		// Drop the reference taken for this document
+		ReleaseBlob(db, hash)
 		return &FileUploadResponse{
 			Success: false,
-			Message: "Failed to write file",
+			Message: "Failed to save document record",
 		}, err
 	}
 
-	// Update HasLocalFile to true after successful write
-	localDoc.HasLocalFile = true
-	db.Save(&localDoc)
-
-	// Storage info is now calculated on-demand, no need to update cache
+	if err := document.UpdateLocalStorageInfo(req.UserID, db); err != nil {
+		fmt.Printf("Warning: Failed to update storage info: %v\n", err)
+	}
 
 	return &FileUploadResponse{
 		LocalDocument: &localDoc,
@@ -198,54 +174,35 @@ func UploadNewVersion(existingDocumentID uint, req FileUploadRequest, db *gorm.D
 		Version:      existingDoc.Version + 1,
 		ParentDocID:  &existingDoc.ID,
 		IsOriginal:   false,
-		HasLocalFile: false,
 	}
 
-	// Generate file path
-	appDataPath, err := document.GetAppDataPath()
+	// Store the content by hash, a file already stored for another document is reused
+	hash, size, filePath, err := StoreFile(db, req.FileContent)
 	if err != nil {
 		return &FileUploadResponse{
 			Success: false,
-			Message: "Failed to get app data path",
+			Message: "Failed to write file",
 		}, err
 	}
 
-	fileName := fmt.Sprintf("doc_%d_%d_v%d_%s", req.AssignmentID, req.UserID, newVersion.Version, req.FileName)
-	filePath := filepath.Join(appDataPath, "documents", fileName)
 	newVersion.FilePath = filePath
+	newVersion.Hash = hash
+	newVersion.FileSize = size
+	newVersion.HasLocalFile = true
 
 	// Save to database
 	if err := db.Create(&newVersion).Error; err != nil {
+		ReleaseBlob(db, hash)
 		return &FileUploadResponse{
 			Success: false,
 			Message: "Failed to save new version",
 		}, err
 	}
 
-	// Create directory if needed
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		db.Delete(&newVersion)
-		return &FileUploadResponse{
-			Success: false,
-			Message: "Failed to create directory",
-		}, err
-	}
-
-	// Write file
-	if err := writeFile(filePath, req.FileContent); err != nil {
-		db.Delete(&newVersion)
-		return &FileUploadResponse{
-			Success: false,
-			Message: "Failed to write file",
-		}, err
+	if err := document.UpdateLocalStorageInfo(req.UserID, db); err != nil {
+		fmt.Printf("Warning: Failed to update storage info: %v\n", err)
 	}
 
-	// Update HasLocalFile after successful write
-	newVersion.HasLocalFile = true
-	db.Save(&newVersion)
-
-	// Storage info is now calculated on-demand, no need to update cache
-
 	return &FileUploadResponse{
 		LocalDocument: &newVersion,
 		Success:       true,
@@ -253,27 +210,24 @@ func UploadNewVersion(existingDocumentID uint, req FileUploadRequest, db *gorm.D
 	}, nil
 }
 
-// DownloadDocument retrieves a document file for download
-func DownloadDocument(docID uint, userID uint, db *gorm.DB) (*os.File, *document.Document, error) {
+// DownloadDocument opens the local file of a document after checking it is intact
+func DownloadDocument(docID uint, userID uint, db *gorm.DB) (*os.File, *document.LocalDocument, error) {
 	// Get document record
-	var doc document.Document
+	var doc document.LocalDocument
 	if err := db.Where("id = ? AND user_id = ?", docID, userID).First(&doc).Error; err != nil {
 		return nil, nil, fmt.Errorf("document not found or access denied")
 	}
 
-	// Check if file exists
-	if !doc.FileExists() {
-		return nil, nil, fmt.Errorf("file not found on disk")
+	if !doc.HasLocalFile {
+		return nil, nil, fmt.Errorf("file not available locally")
 	}
 
-	// Get full path
-	fullPath, err := doc.GetFullPath()
-	if err != nil {
-		return nil, nil, fmt.Errorf("failed to get file path: %w", err)
+	if err := VerifyDocument(&doc); err != nil {
+		return nil, nil, err
 	}
 
 	// Open file for reading
-	file, err := os.Open(fullPath)
+	file, err := os.Open(doc.FilePath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to open file: %w", err)
 	}
@@ -281,63 +235,47 @@ func DownloadDocument(docID uint, userID uint, db *gorm.DB) (*os.File, *document
 	return file, &doc, nil
 }
 
-// DeleteDocument removes a document and its file
+// DeleteDocument removes a document and its versions. Their files are only deleted
+// once no other document references the same content.
 func DeleteDocument(docID uint, userID uint, db *gorm.DB) error {
 	// Get document record
-	var doc document.Document
+	var doc document.LocalDocument
 	if err := db.Where("id = ? AND user_id = ?", docID, userID).First(&doc).Error; err != nil {
 		return fmt.Errorf("document not found or access denied")
 	}
 
-	// Get full path
-	fullPath, err := doc.GetFullPath()
-	if err != nil {
-		return fmt.Errorf("failed to get file path: %w", err)
-	}
-
-	// Delete file from disk
-	if doc.FileExists() {
-		if err := os.Remove(fullPath); err != nil {
-			return fmt.Errorf("failed to delete file: %w", err)
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Delete all versions if this is the parent document
+		if doc.ParentDocID == nil {
+			var versions []document.LocalDocument
+			if err := tx.Where("parent_doc_id = ?", doc.ID).Find(&versions).Error; err != nil {
+				return fmt.Errorf("failed to load document versions: %w", err)
+			}
+			for i := range versions {
+				if err := ReleaseDocumentFile(tx, &versions[i]); err != nil {
+					return err
+				}
+				if err := tx.Delete(&versions[i]).Error; err != nil {
+					return fmt.Errorf("failed to delete document versions: %w", err)
+				}
+			}
 		}
-	}
 
-	// Delete all versions if this is the parent document
-	if doc.ParentDocID == nil {
-		if err := db.Where("parent_doc_id = ?", doc.ID).Delete(&document.Document{}).Error; err != nil {
-			return fmt.Errorf("failed to delete document versions: %w", err)
+		if err := ReleaseDocumentFile(tx, &doc); err != nil {
+			return err
 		}
-	}
-
-	// Delete document record
-	if err := db.Delete(&doc).Error; err != nil {
-		return fmt.Errorf("failed to delete document record: %w", err)
-	}
 
-	// Update user storage info
-	if err := document.UpdateStorageInfo(userID, db); err != nil {
-		fmt.Printf("Warning: Failed to update storage info: %v\n", err)
-	}
-
-	return nil
-}
-
-// writeFile writes content to a file path
-func writeFile(filePath string, content io.Reader) error {
-	// Create the file
-	file, err := os.Create(filePath)
-	if err != nil {
-		return fmt.Errorf("failed to create file: %w", err)
-	}
-	defer file.Close()
-
-	// Copy content to file
-	_, err = io.Copy(file, content)
-	if err != nil {
-		return fmt.Errorf("failed to write file content: %w", err)
-	}
+		// Delete document record
+		if err := tx.Delete(&doc).Error; err != nil {
+			return fmt.Errorf("failed to delete document record: %w", err)
+		}
 
-	return nil
+		// Update user storage info
+		if err := document.UpdateLocalStorageInfo(userID, tx); err != nil {
+			fmt.Printf("Warning: Failed to update storage info: %v\n", err)
+		}
+		return nil
+	})
 }
 
 // GetUserStorageInfo returns storage statistics for a user
@@ -0,0 +1,524 @@
+package fileops
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"unipilot/internal/models/document"
+	"unipilot/internal/services/blobstore"
+
+	"gorm.io/gorm"
+)
+
+// IssueKind is the kind of inconsistency found between the documents table and the disk
+type IssueKind string
+
+const (
+	IssueMissingFile      IssueKind = "missing_file"       // row says the file is here but it is not
+	IssueOrphanFile       IssueKind = "orphan_file"        // file that no row references
+	IssueSizeMismatch     IssueKind = "size_mismatch"      // file size differs from FileSize
+	IssueRefCount         IssueKind = "ref_count"          // blob reference count differs from its documents
+	IssueStaleStorageInfo IssueKind = "stale_storage_info" // cached storage totals differ from the disk
+)
+
+// Repair actions recorded on issues
+const (
+	ActionRedownloaded = "redownloaded"
+	ActionReindexed    = "reindexed"
+	ActionQuarantined  = "quarantined"
+	ActionUnavailable  = "marked_unavailable"
+)
+
+// StorageIssue is one inconsistency. Recorded is what the database says, Found what is on disk:
+// sizes for files, reference counts for blobs and total bytes for storage info.
+type StorageIssue struct {
+	Kind       IssueKind `json:"kind"`
+	DocumentID uint      `json:"document_id,omitempty"`
+	Hash       string    `json:"hash,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Recorded   int64     `json:"recorded"`
+	Found      int64     `json:"found"`
+	Actions    []string  `json:"actions,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// StorageReport is the result of a storage scan, with the actions taken when repairing
+type StorageReport struct {
+	Documents     int            `json:"documents"`
+	Files         int            `json:"files"`
+	Issues        []StorageIssue `json:"issues"`
+	Repaired      bool           `json:"repaired"`
+	QuarantineDir string         `json:"quarantine_dir,omitempty"`
+	ScannedAt     time.Time      `json:"scanned_at"`
+}
+
+// RepairOptions configures RepairStorage
+type RepairOptions struct {
+	// Redownload fetches the file of a document from the server again,
+	// nil when the server can't be reached
+	Redownload func(doc *document.LocalDocument) error
+}
+
+// quarantineDir is the directory under the user root receiving the files a repair
+// set aside
+const quarantineDir = "quarantine"
+
+// ScanStorage compares the documents table with the files under the user's directory
+// without changing anything. Files of other accounts, uploads in progress and the
+// quarantine are not looked at.
+func ScanStorage(db *gorm.DB, userID uint) (*StorageReport, error) {
+	root, err := UserRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &StorageReport{Issues: []StorageIssue{}, ScannedAt: time.Now()}
+
+	var docs []document.LocalDocument
+	if err := db.Where("has_local_file = ?", true).Order("id ASC").Find(&docs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load documents: %w", err)
+	}
+	report.Documents = len(docs)
+
+	referenced := make(map[string]bool)
+	var totalSize int64
+	var totalCount int64
+
+	for _, doc := range docs {
+		path := filepath.Clean(doc.FilePath)
+		referenced[path] = true
+
+		info, err := os.Stat(path)
+		if err != nil || doc.FilePath == "" {
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:       IssueMissingFile,
+				DocumentID: doc.ID,
+				Hash:       doc.Hash,
+				Path:       doc.FilePath,
+				Recorded:   doc.FileSize,
+			})
+			continue
+		}
+
+		if doc.UserID == userID {
+			totalSize += info.Size()
+			totalCount++
+		}
+
+		if info.Size() != doc.FileSize {
+			report.Issues = append(report.Issues, StorageIssue{
+				Kind:       IssueSizeMismatch,
+				DocumentID: doc.ID,
+				Hash:       doc.Hash,
+				Path:       doc.FilePath,
+				Recorded:   doc.FileSize,
+				Found:      info.Size(),
+			})
+		}
+	}
+
+	refIssues, err := scanRefCounts(db)
+	if err != nil {
+		return nil, err
+	}
+	report.Issues = append(report.Issues, refIssues...)
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == root {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			if path == filepath.Join(root, quarantineDir) {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		// Written by blobstore.Put, renamed to its hash once complete
+		if strings.HasPrefix(entry.Name(), "upload-") {
+			return nil
+		}
+
+		report.Files++
+		if referenced[filepath.Clean(path)] {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		report.Issues = append(report.Issues, StorageIssue{
+			Kind:  IssueOrphanFile,
+			Path:  path,
+			Found: info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to walk %s: %w", root, err)
+	}
+
+	var cached document.DocumentStorageInfo
+	cachedErr := db.Where("user_id = ?", userID).First(&cached).Error
+	if (cachedErr == nil || totalCount > 0) &&
+		(cached.TotalSize != totalSize || int64(cached.DocumentCount) != totalCount) {
+		report.Issues = append(report.Issues, StorageIssue{
+			Kind:     IssueStaleStorageInfo,
+			Recorded: cached.TotalSize,
+			Found:    totalSize,
+		})
+	}
+
+	return report, nil
+}
+
+// scanRefCounts compares each blob reference count with the documents holding its file
+func scanRefCounts(db *gorm.DB) ([]StorageIssue, error) {
+	refs, err := countBlobRefs(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var blobs []document.LocalBlob
+	if err := db.Find(&blobs).Error; err != nil {
+		return nil, fmt.Errorf("failed to load blobs: %w", err)
+	}
+
+	var issues []StorageIssue
+	for _, blob := range blobs {
+		if int64(blob.RefCount) != refs[blob.Hash] {
+			issues = append(issues, StorageIssue{Kind: IssueRefCount, Hash: blob.Hash, Recorded: int64(blob.RefCount), Found: refs[blob.Hash]})
+		}
+		delete(refs, blob.Hash)
+	}
+
+	// Documents referencing a blob nobody counted
+	for hash, count := range refs {
+		issues = append(issues, StorageIssue{Kind: IssueRefCount, Hash: hash, Found: count})
+	}
+
+	return issues, nil
+}
+
+// countBlobRefs returns how many documents hold each stored file
+func countBlobRefs(db *gorm.DB) (map[string]int64, error) {
+	var rows []struct {
+		Hash  string
+		Count int64
+	}
+	if err := db.Model(&document.LocalDocument{}).
+		Select("hash, COUNT(*) AS count").
+		Where("has_local_file = ? AND hash <> ''", true).
+		Group("hash").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count blob references: %w", err)
+	}
+
+	refs := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		refs[row.Hash] = row.Count
+	}
+	return refs, nil
+}
+
+// RepairStorage scans the storage then fixes what it found. Files found elsewhere on disk
+// are re-indexed, missing ones re-downloaded when possible, corrupted and orphan files are
+// moved to a quarantine directory instead of being deleted, counts and totals are recalculated.
+func RepairStorage(db *gorm.DB, userID uint, opts RepairOptions) (*StorageReport, error) {
+	report, err := ScanStorage(db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	store, err := LocalStore()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := UserRoot()
+	if err != nil {
+		return nil, err
+	}
+	report.QuarantineDir = filepath.Join(root, quarantineDir, report.ScannedAt.Format("20060102-150405"))
+
+	r := &repairer{db: db, store: store, root: root, report: report, opts: opts}
+
+	// Orphans first, a missing file may just have been moved
+	r.indexOrphans()
+
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		var err error
+		switch issue.Kind {
+		case IssueMissingFile:
+			err = r.repairMissing(issue)
+		case IssueSizeMismatch:
+			err = r.repairSize(issue)
+		}
+		if err != nil {
+			issue.Error = err.Error()
+		}
+	}
+
+	for i := range report.Issues {
+		issue := &report.Issues[i]
+		if issue.Kind == IssueOrphanFile && len(issue.Actions) == 0 {
+			if err := r.quarantine(issue.Path); err != nil {
+				issue.Error = err.Error()
+				continue
+			}
+			issue.Actions = append(issue.Actions, ActionQuarantined)
+		}
+	}
+
+	if err := r.recountRefs(); err != nil {
+		return nil, err
+	}
+
+	if err := document.UpdateLocalStorageInfo(userID, db); err != nil {
+		return nil, err
+	}
+	r.markDone(IssueStaleStorageInfo, ActionReindexed)
+
+	report.Repaired = true
+	return report, nil
+}
+
+type repairer struct {
+	db      *gorm.DB
+	store   *blobstore.Store
+	root    string
+	report  *StorageReport
+	opts    RepairOptions
+	orphans map[string]*StorageIssue // orphan files by content hash
+}
+
+// indexOrphans hashes the orphan files, only needed when some document lost its file
+func (r *repairer) indexOrphans() {
+	r.orphans = make(map[string]*StorageIssue)
+
+	missing := false
+	for _, issue := range r.report.Issues {
+		missing = missing || issue.Kind == IssueMissingFile
+	}
+	if !missing {
+		return
+	}
+
+	for i := range r.report.Issues {
+		issue := &r.report.Issues[i]
+		if issue.Kind != IssueOrphanFile {
+			continue
+		}
+		file, err := os.Open(issue.Path)
+		if err != nil {
+			continue
+		}
+		hash, _, err := blobstore.Hash(file)
+		file.Close()
+		if err == nil {
+			issue.Hash = hash
+			r.orphans[hash] = issue
+		}
+	}
+}
+
+// repairMissing re-indexes a file found under another name, otherwise re-downloads it
+func (r *repairer) repairMissing(issue *StorageIssue) error {
+	var doc document.LocalDocument
+	if err := r.db.First(&doc, issue.DocumentID).Error; err != nil {
+		return err
+	}
+
+	if doc.Hash != "" {
+		if orphan, ok := r.orphans[doc.Hash]; ok && !r.store.Has(doc.Hash) {
+			if err := os.MkdirAll(filepath.Dir(r.store.Path(doc.Hash)), 0755); err != nil {
+				return err
+			}
+			if err := os.Rename(orphan.Path, r.store.Path(doc.Hash)); err != nil {
+				return fmt.Errorf("failed to move %s back into the store: %w", orphan.Path, err)
+			}
+			orphan.Actions = append(orphan.Actions, ActionReindexed)
+		}
+
+		if r.store.Has(doc.Hash) {
+			if err := r.db.Model(&doc).Update("file_path", r.store.Path(doc.Hash)).Error; err != nil {
+				return err
+			}
+			issue.Actions = append(issue.Actions, ActionReindexed)
+			return nil
+		}
+	}
+
+	// Gone for good on this device, the reference counts are recalculated afterwards
+	if err := r.db.Model(&doc).Update("has_local_file", false).Error; err != nil {
+		return err
+	}
+	issue.Actions = append(issue.Actions, ActionUnavailable)
+
+	return r.redownload(issue, &doc)
+}
+
+// repairSize re-indexes a file whose recorded size is wrong. A hashed file that no
+// longer matches its hash is corrupted and quarantined. Files stored before documents
+// were hashed are moved into the content-addressed store.
+func (r *repairer) repairSize(issue *StorageIssue) error {
+	var doc document.LocalDocument
+	if err := r.db.First(&doc, issue.DocumentID).Error; err != nil {
+		return err
+	}
+
+	// Another document sharing the same corrupted file already discarded it
+	if !doc.HasLocalFile {
+		return r.redownload(issue, &doc)
+	}
+
+	if doc.Hash == "" {
+		return r.reindexLegacy(issue, &doc)
+	}
+
+	if err := VerifyDocument(&doc); err == nil {
+		if err := r.db.Model(&doc).Update("file_size", issue.Found).Error; err != nil {
+			return err
+		}
+		issue.Actions = append(issue.Actions, ActionReindexed)
+		return nil
+	} else if err != ErrCorrupted {
+		return err
+	}
+
+	if err := r.quarantine(doc.FilePath); err != nil {
+		return err
+	}
+	issue.Actions = append(issue.Actions, ActionQuarantined)
+
+	if err := DiscardCorrupted(r.db, doc.Hash); err != nil {
+		return err
+	}
+	doc.HasLocalFile = false
+
+	return r.redownload(issue, &doc)
+}
+
+// reindexLegacy moves a file stored under its name into the content-addressed store
+func (r *repairer) reindexLegacy(issue *StorageIssue, doc *document.LocalDocument) error {
+	file, err := os.Open(doc.FilePath)
+	if err != nil {
+		return err
+	}
+	hash, size, path, err := PutFile(file)
+	file.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.Model(doc).Updates(map[string]interface{}{
+		"hash":      hash,
+		"file_size": size,
+		"file_path": path,
+	}).Error; err != nil {
+		return err
+	}
+
+	if err := os.Remove(filepath.Clean(issue.Path)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	issue.Actions = append(issue.Actions, ActionReindexed)
+	return nil
+}
+
+// redownload fetches the file again when the document is on the server and it is reachable
+func (r *repairer) redownload(issue *StorageIssue, doc *document.LocalDocument) error {
+	if r.opts.Redownload == nil || doc.RemoteID == 0 {
+		return nil
+	}
+
+	if err := r.opts.Redownload(doc); err != nil {
+		return err
+	}
+	issue.Actions = append(issue.Actions, ActionRedownloaded)
+	return nil
+}
+
+// quarantine moves a file out of the store, keeping its path relative to the user root
+func (r *repairer) quarantine(path string) error {
+	rel, err := filepath.Rel(r.root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		rel = filepath.Base(path)
+	}
+
+	dest := filepath.Join(r.report.QuarantineDir, rel)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+	if err := os.Rename(path, dest); err != nil {
+		return fmt.Errorf("failed to quarantine %s: %w", path, err)
+	}
+	return nil
+}
+
+// recountRefs sets every blob reference count to the documents holding its file. Blobs
+// nobody holds anymore are dropped and their file quarantined.
+func (r *repairer) recountRefs() error {
+	refs, err := countBlobRefs(r.db)
+	if err != nil {
+		return err
+	}
+
+	var blobs []document.LocalBlob
+	if err := r.db.Find(&blobs).Error; err != nil {
+		return fmt.Errorf("failed to load blobs: %w", err)
+	}
+
+	for _, blob := range blobs {
+		count, held := refs[blob.Hash]
+		delete(refs, blob.Hash)
+
+		if !held {
+			if err := r.db.Delete(&blob).Error; err != nil {
+				return fmt.Errorf("failed to delete blob %s: %w", blob.Hash, err)
+			}
+			if r.store.Has(blob.Hash) {
+				if err := r.quarantine(r.store.Path(blob.Hash)); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if int64(blob.RefCount) != count {
+			if err := r.db.Model(&blob).Update("ref_count", count).Error; err != nil {
+				return fmt.Errorf("failed to update blob %s: %w", blob.Hash, err)
+			}
+		}
+	}
+
+	for hash, count := range refs {
+		var size int64
+		if info, err := os.Stat(r.store.Path(hash)); err == nil {
+			size = info.Size()
+		}
+		if err := r.db.Create(&document.LocalBlob{Hash: hash, Size: size, RefCount: int(count)}).Error; err != nil {
+			return fmt.Errorf("failed to create blob %s: %w", hash, err)
+		}
+	}
+
+	r.markDone(IssueRefCount, ActionReindexed)
+	return nil
+}
+
+// markDone records an action on every issue of a kind
+func (r *repairer) markDone(kind IssueKind, action string) {
+	for i := range r.report.Issues {
+		if r.report.Issues[i].Kind == kind {
+			r.report.Issues[i].Actions = append(r.report.Issues[i].Actions, action)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package fileops
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"unipilot/internal/models/document"
+	"unipilot/internal/secrets"
+	"unipilot/internal/storage"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const userID uint = 1
+
+// TestMain keeps the document files in a temporary home, logged in as userID
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "unipilot-fileops-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", home)
+	secrets.SetDefault(secrets.NewMemory())
+
+	creds, _ := json.Marshal(map[string]interface{}{"is_authenticated": true, "user": map[string]interface{}{"user_id": userID}})
+	secrets.Default().Set("credentials", creds)
+
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// newStore returns a database and an empty user directory
+func newStore(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	root, err := UserRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(root); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitializeSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// addDocument stores content for a new document the way an upload does
+func addDocument(t *testing.T, db *gorm.DB, name, content string) *document.LocalDocument {
+	t.Helper()
+
+	hash, size, path, err := StoreFile(db, strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := &document.LocalDocument{AssignmentID: 1, UserID: userID, Type: "attachment", FileName: name,
+		FileType: "text/plain", FilePath: path, Hash: hash, FileSize: size, HasLocalFile: true}
+	if err := db.Omit("ParentDoc", "Versions").Create(doc).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err := document.UpdateLocalStorageInfo(userID, db); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func issuesOf(report *StorageReport, kind IssueKind) []StorageIssue {
+	var issues []StorageIssue
+	for _, issue := range report.Issues {
+		if issue.Kind == kind {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+func TestScanHealthyStorage(t *testing.T) {
+	db := newStore(t)
+	addDocument(t, db, "a.txt", "alpha")
+	addDocument(t, db, "b.txt", "alpha")
+
+	report, err := ScanStorage(db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Issues) != 0 {
+		t.Fatalf("issues = %+v, want none", report.Issues)
+	}
+	if report.Documents != 2 || report.Files != 1 {
+		t.Fatalf("documents = %d files = %d, want 2 documents sharing 1 file", report.Documents, report.Files)
+	}
+}
+
+func TestScanIgnoresOtherAccountsAndUploads(t *testing.T) {
+	db := newStore(t)
+	doc := addDocument(t, db, "a.txt", "alpha")
+
+	// Another account on the machine and the store shared before per-user stores
+	appData, _ := document.GetAppDataPath()
+	for _, path := range []string{
+		filepath.Join(appData, "users", "user_2", "blobs", "ab", strings.Repeat("ab", 32)),
+		filepath.Join(appData, document.BlobsDir, "cd", strings.Repeat("cd", 32)),
+	} {
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte("theirs"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// An upload in progress
+	store, _ := LocalStore()
+	if err := os.WriteFile(filepath.Join(store.Root(), "upload-123"), []byte("partial"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RepairStorage(db, userID, RepairOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if orphans := issuesOf(report, IssueOrphanFile); len(orphans) != 0 {
+		t.Fatalf("orphans = %+v, want none", orphans)
+	}
+	if _, err := os.Stat(filepath.Join(appData, "users", "user_2", "blobs", "ab", strings.Repeat("ab", 32))); err != nil {
+		t.Fatalf("file of another account touched: %v", err)
+	}
+	if _, err := os.Stat(doc.FilePath); err != nil {
+		t.Fatalf("own file touched: %v", err)
+	}
+}
+
+func TestDryRunChangesNothing(t *testing.T) {
+	db := newStore(t)
+	doc := addDocument(t, db, "a.txt", "alpha")
+	os.Remove(doc.FilePath)
+
+	store, _ := LocalStore()
+	orphan := filepath.Join(store.Root(), "stray.pdf")
+	os.WriteFile(orphan, []byte("stray"), 0644)
+
+	report, err := ScanStorage(db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repaired || len(issuesOf(report, IssueMissingFile)) != 1 || len(issuesOf(report, IssueOrphanFile)) != 1 {
+		t.Fatalf("report = %+v, want one missing and one orphan, not repaired", report)
+	}
+	for _, issue := range report.Issues {
+		if len(issue.Actions) != 0 {
+			t.Fatalf("scan took actions: %+v", issue)
+		}
+	}
+
+	if _, err := os.Stat(orphan); err != nil {
+		t.Fatalf("scan moved the orphan: %v", err)
+	}
+	var stored document.LocalDocument
+	db.First(&stored, doc.ID)
+	if !stored.HasLocalFile {
+		t.Fatal("scan changed the document")
+	}
+}
+
+func TestRepairMissingFile(t *testing.T) {
+	db := newStore(t)
+	doc := addDocument(t, db, "a.txt", "alpha")
+	db.Model(doc).Update("remote_id", 9)
+	os.Remove(doc.FilePath)
+
+	var redownloaded []uint
+	report, err := RepairStorage(db, userID, RepairOptions{Redownload: func(d *document.LocalDocument) error {
+		redownloaded = append(redownloaded, d.ID)
+		return nil
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	missing := issuesOf(report, IssueMissingFile)
+	if len(missing) != 1 || strings.Join(missing[0].Actions, ",") != ActionUnavailable+","+ActionRedownloaded {
+		t.Fatalf("missing issues = %+v", missing)
+	}
+	if len(redownloaded) != 1 || redownloaded[0] != doc.ID {
+		t.Fatalf("redownloaded %v, want document %d", redownloaded, doc.ID)
+	}
+
+	var stored document.LocalDocument
+	db.First(&stored, doc.ID)
+	if stored.HasLocalFile {
+		t.Fatal("document still claims its file")
+	}
+	var blobs int64
+	db.Model(&document.LocalBlob{}).Count(&blobs)
+	if blobs != 0 {
+		t.Fatalf("%d blob rows left, want the reference dropped", blobs)
+	}
+}
+
+func TestRepairMovedFile(t *testing.T) {
+	db := newStore(t)
+	doc := addDocument(t, db, "a.txt", "alpha")
+
+	// The blob was moved under another name inside the user directory
+	store, _ := LocalStore()
+	moved := filepath.Join(store.Root(), "moved.txt")
+	if err := os.Rename(doc.FilePath, moved); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RepairStorage(db, userID, RepairOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	missing := issuesOf(report, IssueMissingFile)
+	if len(missing) != 1 || missing[0].Actions[0] != ActionReindexed {
+		t.Fatalf("missing issues = %+v, want reindexed", missing)
+	}
+	if !store.Has(doc.Hash) {
+		t.Fatal("file not moved back into the store")
+	}
+}
+
+func TestRepairCorruptedFile(t *testing.T) {
+	db := newStore(t)
+	doc := addDocument(t, db, "a.txt", "alpha")
+	if err := os.WriteFile(doc.FilePath, []byte("alpha, damaged"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := RepairStorage(db, userID, RepairOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mismatched := issuesOf(report, IssueSizeMismatch)
+	if len(mismatched) != 1 || mismatched[0].Actions[0] != ActionQuarantined {
+		t.Fatalf("size issues = %+v, want quarantined", mismatched)
+	}
+	if _, err := os.Stat(doc.FilePath); !os.IsNotExist(err) {
+		t.Fatalf("corrupted file still in the store: %v", err)
+	}
+	rel, _ := filepath.Rel(mustRoot(t), doc.FilePath)
+	if _, err := os.Stat(filepath.Join(report.QuarantineDir, rel)); err != nil {
+		t.Fatalf("corrupted file not in quarantine: %v", err)
+	}
+
+	var stored document.LocalDocument
+	db.First(&stored, doc.ID)
+	if stored.HasLocalFile {
+		t.Fatal("document still claims its corrupted file")
+	}
+}
+
+func TestRepairQuarantinesOrphans(t *testing.T) {
+	db := newStore(t)
+	addDocument(t, db, "a.txt", "alpha")
+
+	store, _ := LocalStore()
+	orphan := filepath.Join(store.Root(), "stray.pdf")
+	os.WriteFile(orphan, []byte("stray"), 0644)
+
+	report, err := RepairStorage(db, userID, RepairOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	orphans := issuesOf(report, IssueOrphanFile)
+	if len(orphans) != 1 || orphans[0].Actions[0] != ActionQuarantined {
+		t.Fatalf("orphans = %+v, want quarantined", orphans)
+	}
+	if _, err := os.Stat(orphan); !os.IsNotExist(err) {
+		t.Fatalf("orphan still in the store: %v", err)
+	}
+
+	// The quarantine is not scanned again
+	again, err := ScanStorage(db, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again.Issues) != 0 {
+		t.Fatalf("issues after repair = %+v, want none", again.Issues)
+	}
+}
+
+func mustRoot(t *testing.T) string {
+	t.Helper()
+	root, err := UserRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func TestMigrateUserStoreRemovesSharedFile(t *testing.T) {
+	db := newStore(t)
+	doc := addDocument(t, db, "a.txt", "alpha")
+
+	// Put the file back in the store shared before per-user stores
+	appData, _ := document.GetAppDataPath()
+	shared := filepath.Join(appData, document.BlobsDir, doc.Hash[:2], doc.Hash)
+	os.MkdirAll(filepath.Dir(shared), 0755)
+	if err := os.Rename(doc.FilePath, shared); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Model(doc).Update("file_path", shared).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MigrateUserStore(db); err != nil {
+		t.Fatal(err)
+	}
+
+	var moved document.LocalDocument
+	db.First(&moved, doc.ID)
+	if moved.FilePath == shared {
+		t.Fatal("document still points at the shared file")
+	}
+	if err := VerifyDocument(&moved); err != nil {
+		t.Fatalf("migrated file: %v", err)
+	}
+	if _, err := os.Stat(shared); !os.IsNotExist(err) {
+		t.Fatalf("shared file left behind: %v", err)
+	}
+}
@@ -0,0 +1,236 @@
+package fileops
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"unipilot/internal/config"
+	"unipilot/internal/models/document"
+	"unipilot/internal/services/blobstore"
+	"unipilot/internal/storage"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrCorrupted is returned when a document file no longer matches its hash
+var ErrCorrupted = errors.New("document file is corrupted")
+
+// UserRoot returns the directory holding the document files of the logged in user.
+// Each user and profile has its own local database, and so its own reference counts,
+// the files they count must not be shared with other accounts on the machine.
+func UserRoot() (string, error) {
+	appDataPath, err := document.GetAppDataPath()
+	if err != nil {
+		return "", err
+	}
+	userID, err := storage.GetCurrentUserID()
+	if err != nil {
+		return "", fmt.Errorf("failed to get current user ID: %w", err)
+	}
+
+	root := filepath.Join(appDataPath, "users")
+	if profile := config.Active().Name; profile != config.ProfileProd {
+		root = filepath.Join(root, profile)
+	}
+	return filepath.Join(root, fmt.Sprintf("user_%d", userID)), nil
+}
+
+// LocalStore returns the content-addressed store holding the document files of the
+// logged in user
+func LocalStore() (*blobstore.Store, error) {
+	root, err := UserRoot()
+	if err != nil {
+		return nil, err
+	}
+	return blobstore.New(filepath.Join(root, document.BlobsDir))
+}
+
+// MigrateUserStore moves the files of the user's documents out of the store that used
+// to be shared by every account into the user's own one. A shared file is only removed
+// once the user's copy matches the document hash.
+func MigrateUserStore(db *gorm.DB) error {
+	store, err := LocalStore()
+	if err != nil {
+		return err
+	}
+
+	var docs []document.LocalDocument
+	if err := db.Where("has_local_file = ? AND hash <> ''", true).Find(&docs).Error; err != nil {
+		return fmt.Errorf("failed to load documents: %w", err)
+	}
+
+	for _, doc := range docs {
+		if filepath.Clean(doc.FilePath) == store.Path(doc.Hash) {
+			continue
+		}
+
+		if !store.Has(doc.Hash) {
+			file, err := os.Open(doc.FilePath)
+			if err != nil {
+				// The integrity scan reports it as missing
+				continue
+			}
+			hash, _, err := store.Put(file)
+			file.Close()
+			if err != nil {
+				return err
+			}
+			if hash != doc.Hash {
+				store.Delete(hash)
+				continue
+			}
+		}
+
+		shared := doc.FilePath
+		moved := doc
+		moved.FilePath = store.Path(doc.Hash)
+		if err := VerifyDocument(&moved); err != nil {
+			// Leave the document on the shared file rather than lose it
+			continue
+		}
+
+		if err := db.Model(&doc).Update("file_path", moved.FilePath).Error; err != nil {
+			return fmt.Errorf("failed to move document %d: %w", doc.ID, err)
+		}
+		if err := os.Remove(shared); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove shared file of document %d: %w", doc.ID, err)
+		}
+	}
+	return nil
+}
+
+// StoreFile writes content to the local store and takes a reference on it for one
+// document. Identical content is only stored once. Returns the hash, size and path.
+func StoreFile(db *gorm.DB, content io.Reader) (string, int64, string, error) {
+	hash, size, path, err := PutFile(content)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	if err := AcquireBlob(db, hash, size); err != nil {
+		return "", 0, "", err
+	}
+
+	return hash, size, path, nil
+}
+
+// PutFile writes content to the local store without taking a reference, for callers
+// that write slowly and take the reference in a short transaction afterwards
+func PutFile(content io.Reader) (string, int64, string, error) {
+	store, err := LocalStore()
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	hash, size, err := store.Put(content)
+	if err != nil {
+		return "", 0, "", err
+	}
+
+	return hash, size, store.Path(hash), nil
+}
+
+// AcquireBlob adds a reference to a stored file
+func AcquireBlob(db *gorm.DB, hash string, size int64) error {
+	err := db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "hash"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{"ref_count": gorm.Expr("ref_count + 1")}),
+	}).Create(&document.LocalBlob{Hash: hash, Size: size, RefCount: 1}).Error
+	if err != nil {
+		return fmt.Errorf("failed to reference blob %s: %w", hash, err)
+	}
+	return nil
+}
+
+// ReleaseBlob drops a reference to a stored file and deletes the file with the last one
+func ReleaseBlob(db *gorm.DB, hash string) error {
+	var blob document.LocalBlob
+	err := db.Where("hash = ?", hash).First(&blob).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load blob %s: %w", hash, err)
+	}
+
+	if blob.RefCount > 1 {
+		return db.Model(&blob).Update("ref_count", gorm.Expr("ref_count - 1")).Error
+	}
+
+	if err := db.Delete(&blob).Error; err != nil {
+		return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+	}
+
+	store, err := LocalStore()
+	if err != nil {
+		return err
+	}
+	return store.Delete(hash)
+}
+
+// ReleaseDocumentFile drops the document's hold on its file. Files stored before
+// documents were content-addressed belong to a single document and are removed.
+func ReleaseDocumentFile(db *gorm.DB, doc *document.LocalDocument) error {
+	if !doc.HasLocalFile {
+		return nil
+	}
+
+	if doc.Hash != "" {
+		return ReleaseBlob(db, doc.Hash)
+	}
+
+	if doc.FilePath != "" {
+		if err := os.Remove(doc.FilePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to delete file: %w", err)
+		}
+	}
+	return nil
+}
+
+// VerifyDocument checks the document file still matches the hash recorded when it was
+// stored. Files stored before documents were hashed can't be checked and are accepted.
+func VerifyDocument(doc *document.LocalDocument) error {
+	if doc.Hash == "" {
+		return nil
+	}
+
+	file, err := os.Open(doc.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	hash, _, err := blobstore.Hash(file)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	if hash != doc.Hash {
+		return ErrCorrupted
+	}
+	return nil
+}
+
+// DiscardCorrupted removes a corrupted file. Every document sharing it loses its local
+// copy and its reference, so the file can be downloaded again.
+func DiscardCorrupted(db *gorm.DB, hash string) error {
+	store, err := LocalStore()
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&document.LocalDocument{}).Where("hash = ? AND has_local_file = ?", hash, true).
+			Update("has_local_file", false).Error; err != nil {
+			return fmt.Errorf("failed to update documents of blob %s: %w", hash, err)
+		}
+		if err := tx.Where("hash = ?", hash).Delete(&document.LocalBlob{}).Error; err != nil {
+			return fmt.Errorf("failed to delete blob %s: %w", hash, err)
+		}
+		return store.Delete(hash)
+	})
+}
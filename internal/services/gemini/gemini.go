@@ -5,9 +5,6 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
-	
-	"github.com/spf13/viper"
 
 	"google.golang.org/genai"
 )
@@ -23,25 +20,25 @@ type GeminiResponse struct {
 	Content		string
 }
 
-func GenerateNote(request *GeminiRequest) (*GeminiResponse, error) {
-	ctx := context.Background()
-	
-
-	viper.SetConfigFile(".env")
-	err := viper.ReadInConfig()
-	if err != nil {
-		log.Fatal(err)
-	}
+// Client generates notes with the Gemini API
+type Client struct {
+	apiKey string
+}
 
-	GEMINI_API_KEY := viper.GetString("GEMINI_API_KEY")
+// New returns a client using the API key of the server config
+func New(apiKey string) *Client {
+	return &Client{apiKey: apiKey}
+}
 
+// GenerateNote asks Gemini for the keywords and content of a lecture note
+func (c *Client) GenerateNote(ctx context.Context, request *GeminiRequest) (*GeminiResponse, error) {
 
 	client, err := genai.NewClient(ctx, &genai.ClientConfig{
-        	APIKey:  GEMINI_API_KEY,
-        	Backend: genai.BackendGeminiAPI,
-    	})
+		APIKey:  c.apiKey,
+		Backend: genai.BackendGeminiAPI,
+	})
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to create gemini client: %w", err)
 	}
 
 	config := &genai.GenerateContentConfig{
@@ -68,18 +65,15 @@ func GenerateNote(request *GeminiRequest) (*GeminiResponse, error) {
 		config,
 	)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to generate content: %w", err)
 	}
 
-
 	var response *GeminiResponse
 	err = json.Unmarshal([]byte(result.Text()), &response)
 	if err != nil {
 		return nil, errors.New("failed to unmarshal response")
 	}
 
-
-
 	return response, nil
 }
 
@@ -0,0 +1,131 @@
+// Package mailer sends the account emails of the server: address verification and
+// password resets. SMTP sends them for real, File writes them to a directory for
+// local testing.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// errHeaderInjection is returned for header values smuggling in more headers
+var errHeaderInjection = errors.New("header value contains a line break")
+
+// format renders the message with its headers, ready to send
+func format(from string, msg Message, now time.Time) ([]byte, error) {
+	for _, value := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(value, "\r\n") {
+			return nil, errHeaderInjection
+		}
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return b.Bytes(), nil
+}
+
+// SMTP sends messages through an SMTP server, upgrading to TLS when it offers STARTTLS
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+}
+
+// NewSMTP returns an SMTP mailer, without a username it sends unauthenticated
+func NewSMTP(host string, port int, username, password, from string) *SMTP {
+	m := &SMTP{addr: net.JoinHostPort(host, strconv.Itoa(port)), from: from}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	data, err := format(m.from, msg, time.Now())
+	if err != nil {
+		return err
+	}
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("failed to send mail to %s: %w", msg.To, err)
+	}
+	return nil
+}
+
+// File writes every message to a .eml file in a directory and logs it, for local
+// servers without an SMTP server
+type File struct {
+	dir  string
+	from string
+}
+
+func NewFile(dir, from string) *File {
+	if from == "" {
+		from = "unipilot@localhost"
+	}
+	return &File{dir: dir, from: from}
+}
+
+func (m *File) Send(ctx context.Context, msg Message) error {
+	now := time.Now()
+	data, err := format(m.from, msg, now)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(m.dir, 0700); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", now.Format("20060102-150405.000000000"), sanitize(msg.To))
+	path := filepath.Join(m.dir, name)
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+
+	log.Printf("[Mailer] %q to %s written to %s", msg.Subject, msg.To, path)
+	return nil
+}
+
+// sanitize keeps an address usable in a file name
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, address)
+}
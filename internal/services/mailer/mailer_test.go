@@ -0,0 +1,65 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormat(t *testing.T) {
+	now := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	data, err := format("noreply@example.com", Message{To: "ada@example.com", Subject: "Reset", Body: "line 1\nline 2"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(data)
+	for _, want := range []string{
+		"From: noreply@example.com\r\n",
+		"To: ada@example.com\r\n",
+		"Subject: Reset\r\n",
+		"Date: Thu, 02 Jan 2025 03:04:05 +0000\r\n",
+		"\r\n\r\nline 1\r\nline 2",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("message missing %q:\n%s", want, got)
+		}
+	}
+}
+
+func TestFormatRejectsHeaderInjection(t *testing.T) {
+	tests := []Message{
+		{To: "ada@example.com\r\nBcc: eve@example.com", Subject: "Reset"},
+		{To: "ada@example.com", Subject: "Reset\nBcc: eve@example.com"},
+	}
+	for _, msg := range tests {
+		if _, err := format("noreply@example.com", msg, time.Now()); !errors.Is(err, errHeaderInjection) {
+			t.Errorf("format(%q, %q) error = %v, want errHeaderInjection", msg.To, msg.Subject, err)
+		}
+	}
+}
+
+func TestFileSend(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	m := NewFile(dir, "")
+
+	if err := m.Send(context.Background(), Message{To: "ada@example.com", Subject: "Verify", Body: "token"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*ada@example.com.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("mail files = %v, %v, want one", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Subject: Verify") || !strings.HasSuffix(string(data), "token") {
+		t.Fatalf("unexpected mail:\n%s", data)
+	}
+}
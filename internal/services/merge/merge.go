@@ -0,0 +1,140 @@
+package merge
+
+import "strings"
+
+const (
+	markerMine   = "<<<<<<< mine\n"
+	markerSep    = "=======\n"
+	markerTheirs = ">>>>>>> theirs\n"
+)
+
+// Text performs a three-way line merge of two edits of the same base text.
+// Changes to different lines are combined, and when both sides changed the same
+// lines differently the result holds conflict markers and ok is false.
+func Text(base, mine, theirs string) (merged string, ok bool) {
+	switch {
+	case mine == theirs, base == theirs:
+		return mine, true
+	case base == mine:
+		return theirs, true
+	}
+
+	o, a, b := splitLines(base), splitLines(mine), splitLines(theirs)
+	ma, mb := match(o, a), match(o, b)
+
+	var out strings.Builder
+	ok = true
+	i, ia, ib := 0, 0, 0
+
+	for {
+		// Lines unchanged on both sides
+		for i < len(o) && ma[i] == ia && mb[i] == ib {
+			out.WriteString(o[i])
+			i, ia, ib = i+1, ia+1, ib+1
+		}
+		if i == len(o) && ia == len(a) && ib == len(b) {
+			break
+		}
+
+		// Next base line both sides kept, or the end of all three texts
+		j, ja, jb := i, len(a), len(b)
+		for ; j < len(o); j++ {
+			if ma[j] >= 0 && mb[j] >= 0 {
+				ja, jb = ma[j], mb[j]
+				break
+			}
+		}
+
+		chunkO, chunkA, chunkB := o[i:j], a[ia:ja], b[ib:jb]
+		switch {
+		case equal(chunkA, chunkO):
+			writeLines(&out, chunkB)
+		case equal(chunkB, chunkO), equal(chunkA, chunkB):
+			writeLines(&out, chunkA)
+		default:
+			ok = false
+			out.WriteString(markerMine)
+			writeBlock(&out, chunkA)
+			out.WriteString(markerSep)
+			writeBlock(&out, chunkB)
+			out.WriteString(markerTheirs)
+		}
+
+		i, ia, ib = j, ja, jb
+	}
+
+	return out.String(), ok
+}
+
+// splitLines splits s into lines, each keeping its trailing newline
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// match aligns other against base with a longest common subsequence and returns,
+// for every base line, the index of the matching line in other or -1
+func match(base, other []string) []int {
+	n, m := len(base), len(other)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if base[i] == other[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	matches := make([]int, n)
+	i, j := 0, 0
+	for i < n {
+		switch {
+		case j < m && base[i] == other[j]:
+			matches[i] = j
+			i, j = i+1, j+1
+		case j < m && lcs[i][j+1] >= lcs[i+1][j]:
+			j++
+		default:
+			matches[i] = -1
+			i++
+		}
+	}
+	return matches
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func writeLines(out *strings.Builder, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// writeBlock writes lines inside conflict markers, which must start on their own line
+func writeBlock(out *strings.Builder, lines []string) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteString("\n")
+	}
+}
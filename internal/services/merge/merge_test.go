@@ -0,0 +1,91 @@
+package merge
+
+import "testing"
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		name   string
+		base   string
+		mine   string
+		theirs string
+		want   string
+		ok     bool
+	}{
+		{
+			name:   "only mine changed",
+			base:   "a\nb\nc\n",
+			mine:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+			ok:     true,
+		},
+		{
+			name:   "only theirs changed",
+			base:   "a\nb\nc\n",
+			mine:   "a\nb\nc\n",
+			theirs: "a\nb\nC\n",
+			want:   "a\nb\nC\n",
+			ok:     true,
+		},
+		{
+			name:   "different lines changed",
+			base:   "a\nb\nc\nd\n",
+			mine:   "A\nb\nc\nd\n",
+			theirs: "a\nb\nc\nD\n",
+			want:   "A\nb\nc\nD\n",
+			ok:     true,
+		},
+		{
+			name:   "insertions on both sides",
+			base:   "a\nb\n",
+			mine:   "first\na\nb\n",
+			theirs: "a\nb\nlast\n",
+			want:   "first\na\nb\nlast\n",
+			ok:     true,
+		},
+		{
+			name:   "same change on both sides",
+			base:   "a\nb\n",
+			mine:   "a\nx\nb\n",
+			theirs: "a\nx\nb\n",
+			want:   "a\nx\nb\n",
+			ok:     true,
+		},
+		{
+			name:   "deletion and unrelated edit",
+			base:   "a\nb\nc\nd\n",
+			mine:   "a\nc\nd\n",
+			theirs: "a\nb\nc\nD\n",
+			want:   "a\nc\nD\n",
+			ok:     true,
+		},
+		{
+			name:   "same line changed differently",
+			base:   "a\nb\nc\n",
+			mine:   "a\nmine\nc\n",
+			theirs: "a\ntheirs\nc\n",
+			want:   "a\n<<<<<<< mine\nmine\n=======\ntheirs\n>>>>>>> theirs\nc\n",
+			ok:     false,
+		},
+		{
+			name:   "single line values",
+			base:   "Essay",
+			mine:   "Essay draft",
+			theirs: "Final essay",
+			want:   "<<<<<<< mine\nEssay draft\n=======\nFinal essay\n>>>>>>> theirs\n",
+			ok:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Text(tt.base, tt.mine, tt.theirs)
+			if ok != tt.ok {
+				t.Errorf("ok = %v, want %v", ok, tt.ok)
+			}
+			if got != tt.want {
+				t.Errorf("merged = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
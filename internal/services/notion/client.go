@@ -0,0 +1,347 @@
+package notion
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"unipilot/internal/types"
+)
+
+// DefaultBaseURL is the public Notion API
+const DefaultBaseURL = "https://api.notion.com/v1"
+
+// DefaultVersion is the API version the types of this package follow. Later versions
+// moved database queries to data sources.
+const DefaultVersion = "2022-06-28"
+
+// Retries of failed requests: rate limiting, conflicts, server errors and network
+// errors are sent again after a backoff with full jitter
+const (
+	DefaultMaxRetries = 4
+	retryBaseDelay    = 500 * time.Millisecond
+	retryMaxDelay     = 30 * time.Second
+)
+
+// pageSize is the number of results asked per request of a paginated endpoint
+const pageSize = 100
+
+// defaultHTTPClient is shared by the clients so their connections are reused
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Client calls the Notion API with the token of one integration. Its requests are
+// limited to RequestsPerSecond, a client must be shared by everything using the token.
+type Client struct {
+	BaseURL    string
+	Token      string
+	Version    string
+	MaxRetries int
+	HTTP       *http.Client
+
+	limiter *bucket
+	// sleep waits between retries, replaced in tests
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewClient returns a client of the API at baseURL, DefaultBaseURL when empty
+func NewClient(baseURL, token string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		BaseURL:    strings.TrimRight(baseURL, "/"),
+		Token:      token,
+		Version:    DefaultVersion,
+		MaxRetries: DefaultMaxRetries,
+		HTTP:       defaultHTTPClient,
+		limiter:    newBucket(RequestsPerSecond, requestBurst),
+		sleep:      sleep,
+	}
+}
+
+// do sends a request with body encoded as JSON and decodes the response into out,
+// retrying while the error is temporary. API errors are returned as *Error.
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var data []byte
+	if body != nil {
+		var err error
+		if data, err = json.Marshal(body); err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+	}
+
+	// A page may have been created by a request that failed on the way back, creates
+	// are only sent again when the API says it didn't handle them
+	creates := method == http.MethodPost && path == "pages"
+
+	for attempt := 0; ; attempt++ {
+		err := c.send(ctx, method, path, data, out)
+		if err == nil || attempt >= c.MaxRetries || ctx.Err() != nil {
+			return err
+		}
+
+		var apiErr *Error
+		var netErr net.Error
+		wait := backoff(attempt)
+		switch {
+		case errors.As(err, &apiErr) && apiErr.Temporary():
+			if creates && apiErr.Status != http.StatusTooManyRequests && apiErr.Status != http.StatusConflict {
+				return err
+			}
+			if apiErr.RetryAfter > 0 {
+				wait = apiErr.RetryAfter + jitter(retryBaseDelay)
+			}
+		case !creates && (errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)):
+		default:
+			return err
+		}
+
+		if err := c.sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// send sends one request once the limiter allows it
+func (c *Client) send(ctx context.Context, method, path string, data []byte, out interface{}) error {
+	if err := c.limiter.wait(ctx); err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if data != nil {
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+"/"+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Notion-Version", c.Version)
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return decodeError(resp, respBody)
+	}
+
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(respBody, out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+	return nil
+}
+
+// backoff returns the wait before retry attempt+1: a random duration up to an
+// exponentially growing cap
+func backoff(attempt int) time.Duration {
+	limit := retryBaseDelay << attempt
+	if limit <= 0 || limit > retryMaxDelay {
+		limit = retryMaxDelay
+	}
+	return jitter(limit)
+}
+
+// jitter returns a random duration in [0, d)
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int64N(int64(d)))
+}
+
+// Property is a column of a database
+type Property struct {
+	ID       string          `json:"id"`
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Relation *RelationConfig `json:"relation,omitempty"`
+}
+
+// RelationConfig is the database a relation column points to
+type RelationConfig struct {
+	DatabaseID string `json:"database_id"`
+}
+
+// Database is a Notion database with its columns by name
+type Database struct {
+	ID         string              `json:"id"`
+	Title      []types.RichText    `json:"title"`
+	Properties map[string]Property `json:"properties"`
+}
+
+// Name returns the title of the database as plain text
+func (d *Database) Name() string {
+	return plainText(d.Title)
+}
+
+// Option is the value of a select or status property
+type Option struct {
+	Name string `json:"name"`
+}
+
+// PropertyValue is the value of a property on a page, the field matching Type is set
+type PropertyValue struct {
+	ID       string            `json:"id"`
+	Type     string            `json:"type"`
+	Title    []types.RichText  `json:"title,omitempty"`
+	RichText []types.RichText  `json:"rich_text,omitempty"`
+	Date     *types.DateObject `json:"date,omitempty"`
+	Select   *Option           `json:"select,omitempty"`
+	Status   *Option           `json:"status,omitempty"`
+	Relation []types.Relation  `json:"relation,omitempty"`
+	URL      *string           `json:"url,omitempty"`
+	Number   *float64          `json:"number,omitempty"`
+}
+
+// Page is a row of a database
+type Page struct {
+	ID     string `json:"id"`
+	Parent struct {
+		Type       string `json:"type"`
+		DatabaseID string `json:"database_id"`
+	} `json:"parent"`
+	Archived       bool                     `json:"archived"`
+	InTrash        bool                     `json:"in_trash"`
+	LastEditedTime time.Time                `json:"last_edited_time"`
+	Properties     map[string]PropertyValue `json:"properties"`
+}
+
+// Deleted reports whether the page was archived or moved to the trash
+func (p *Page) Deleted() bool {
+	return p.Archived || p.InTrash
+}
+
+// list is a page of results of a paginated endpoint
+type list[T any] struct {
+	Results    []T    `json:"results"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor"`
+}
+
+// paginate posts body to path for each page of results, following the cursors, and
+// calls fn with every result until it returns an error
+func paginate[T any](ctx context.Context, c *Client, path string, body map[string]interface{}, fn func(*T) error) error {
+	request := map[string]interface{}{"page_size": pageSize}
+	for key, value := range body {
+		request[key] = value
+	}
+
+	for {
+		var page list[T]
+		if err := c.do(ctx, http.MethodPost, path, request, &page); err != nil {
+			return err
+		}
+		for i := range page.Results {
+			if err := fn(&page.Results[i]); err != nil {
+				return err
+			}
+		}
+		if !page.HasMore || page.NextCursor == "" {
+			return nil
+		}
+		request["start_cursor"] = page.NextCursor
+	}
+}
+
+// SearchDatabases returns the databases shared with the integration
+func (c *Client) SearchDatabases(ctx context.Context) ([]Database, error) {
+	var databases []Database
+	body := map[string]interface{}{
+		"filter": map[string]string{"property": "object", "value": "database"},
+	}
+	err := paginate(ctx, c, "search", body, func(db *Database) error {
+		databases = append(databases, *db)
+		return nil
+	})
+	return databases, err
+}
+
+// QueryDatabase calls fn with every page of a database matching filter, all of them
+// when filter is nil, fetching them a batch at a time. It stops at the first error
+// of fn and returns it.
+func (c *Client) QueryDatabase(ctx context.Context, databaseID string, filter interface{}, fn func(*Page) error) error {
+	body := map[string]interface{}{}
+	if filter != nil {
+		body["filter"] = filter
+	}
+	return paginate(ctx, c, "databases/"+databaseID+"/query", body, fn)
+}
+
+// GetDatabase returns a database with its columns
+func (c *Client) GetDatabase(ctx context.Context, id string) (*Database, error) {
+	var db Database
+	if err := c.do(ctx, http.MethodGet, "databases/"+id, nil, &db); err != nil {
+		return nil, err
+	}
+	return &db, nil
+}
+
+// GetPage returns a page with its property values
+func (c *Client) GetPage(ctx context.Context, id string) (*Page, error) {
+	var page Page
+	if err := c.do(ctx, http.MethodGet, "pages/"+id, nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// CreatePage adds a page to a database and returns it
+func (c *Client) CreatePage(ctx context.Context, databaseID string, properties map[string]interface{}) (*Page, error) {
+	body := map[string]interface{}{
+		"parent":     map[string]string{"database_id": databaseID},
+		"properties": properties,
+	}
+	var page Page
+	if err := c.do(ctx, http.MethodPost, "pages", body, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// UpdatePage sets property values of a page
+func (c *Client) UpdatePage(ctx context.Context, id string, properties map[string]interface{}) error {
+	return c.do(ctx, http.MethodPatch, "pages/"+id, map[string]interface{}{"properties": properties}, nil)
+}
+
+// ArchivePage moves a page to the trash
+func (c *Client) ArchivePage(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodPatch, "pages/"+id, map[string]interface{}{"archived": true}, nil)
+}
+
+// SameID reports whether two Notion IDs name the same object, they are written with
+// or without dashes
+func SameID(a, b string) bool {
+	return a != "" && strings.EqualFold(strings.ReplaceAll(a, "-", ""), strings.ReplaceAll(b, "-", ""))
+}
+
+func plainText(texts []types.RichText) string {
+	var b strings.Builder
+	for _, t := range texts {
+		if t.PlainText != "" {
+			b.WriteString(t.PlainText)
+		} else if t.Text != nil {
+			b.WriteString(t.Text.Content)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,206 @@
+package notion
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestClient returns a client of handler that records its waits between retries
+// instead of sleeping, and isn't rate limited
+func newTestClient(t *testing.T, handler http.HandlerFunc) (*Client, *[]time.Duration) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	var waits []time.Duration
+	c := NewClient(server.URL, "token")
+	c.limiter = newBucket(1000, 1000)
+	c.sleep = func(ctx context.Context, d time.Duration) error {
+		waits = append(waits, d)
+		return ctx.Err()
+	}
+	return c, &waits
+}
+
+func writeError(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"object":"error","status":%d,"code":%q,"message":"failed"}`, status, code)
+}
+
+func TestRetryHonoursRetryAfter(t *testing.T) {
+	calls := 0
+	c, waits := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.Header.Get("Notion-Version") != DefaultVersion || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("headers = %v", r.Header)
+		}
+		if calls == 1 {
+			w.Header().Set("Retry-After", "2")
+			writeError(w, http.StatusTooManyRequests, CodeRateLimited)
+			return
+		}
+		if calls == 2 {
+			writeError(w, http.StatusBadGateway, "")
+			return
+		}
+		fmt.Fprint(w, `{"id":"p1","properties":{}}`)
+	})
+
+	page, err := c.GetPage(context.Background(), "p1")
+	if err != nil || page.ID != "p1" {
+		t.Fatalf("GetPage = %v, %v", page, err)
+	}
+	if calls != 3 || len(*waits) != 2 {
+		t.Fatalf("%d calls, waits %v", calls, *waits)
+	}
+	if w := (*waits)[0]; w < 2*time.Second || w >= 2*time.Second+retryBaseDelay {
+		t.Errorf("wait after 429 = %v, want Retry-After plus jitter", w)
+	}
+	if w := (*waits)[1]; w < 0 || w >= retryBaseDelay<<1 {
+		t.Errorf("wait after 502 = %v, want below %v", w, retryBaseDelay<<1)
+	}
+}
+
+func TestTypedErrorsAreNotRetried(t *testing.T) {
+	calls := 0
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeError(w, http.StatusNotFound, CodeObjectNotFound)
+	})
+
+	_, err := c.GetPage(context.Background(), "missing")
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound || apiErr.Code != CodeObjectNotFound {
+		t.Fatalf("error = %#v, want object_not_found", err)
+	}
+	if !IsCode(err, CodeObjectNotFound) || calls != 1 {
+		t.Errorf("IsCode %v after %d calls", IsCode(err, CodeObjectNotFound), calls)
+	}
+}
+
+func TestRetriesGiveUp(t *testing.T) {
+	calls := 0
+	c, waits := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeError(w, http.StatusServiceUnavailable, CodeServiceUnavailable)
+	})
+
+	err := c.ArchivePage(context.Background(), "p1")
+	if !IsCode(err, CodeServiceUnavailable) {
+		t.Fatalf("error = %v", err)
+	}
+	if calls != DefaultMaxRetries+1 || len(*waits) != DefaultMaxRetries {
+		t.Errorf("%d calls and %d waits, want %d and %d", calls, len(*waits), DefaultMaxRetries+1, DefaultMaxRetries)
+	}
+}
+
+func TestCreatesAreNotResentAfterServerErrors(t *testing.T) {
+	calls := 0
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		writeError(w, http.StatusInternalServerError, CodeInternalServerError)
+	})
+
+	if _, err := c.CreatePage(context.Background(), "db", nil); err == nil || calls != 1 {
+		t.Fatalf("CreatePage = %v after %d calls, want an error after 1", err, calls)
+	}
+}
+
+func TestCanceledContextStopsRetries(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		cancel()
+		writeError(w, http.StatusTooManyRequests, CodeRateLimited)
+	})
+
+	if _, err := c.GetPage(ctx, "p1"); err == nil || calls != 1 {
+		t.Fatalf("GetPage = %v after %d calls, want an error after 1", err, calls)
+	}
+}
+
+func TestQueryDatabasePaginates(t *testing.T) {
+	const total = 250
+	var cursors []string
+	c, _ := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/databases/db1/query" {
+			t.Errorf("request %s %s", r.Method, r.URL.Path)
+		}
+		var body struct {
+			PageSize    int    `json:"page_size"`
+			StartCursor string `json:"start_cursor"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		cursors = append(cursors, body.StartCursor)
+
+		start := 0
+		fmt.Sscan(body.StartCursor, &start)
+		end := min(start+body.PageSize, total)
+		page := list[Page]{HasMore: end < total}
+		for i := start; i < end; i++ {
+			page.Results = append(page.Results, Page{ID: fmt.Sprint(i)})
+		}
+		if page.HasMore {
+			page.NextCursor = fmt.Sprint(end)
+		}
+		json.NewEncoder(w).Encode(page)
+	})
+
+	var ids []string
+	err := c.QueryDatabase(context.Background(), "db1", nil, func(p *Page) error {
+		ids = append(ids, p.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != total || ids[total-1] != fmt.Sprint(total-1) {
+		t.Fatalf("%d pages, last %s", len(ids), ids[len(ids)-1])
+	}
+	if fmt.Sprint(cursors) != "[ 100 200]" {
+		t.Errorf("cursors = %q", cursors)
+	}
+
+	// An error of fn stops the iteration
+	stop := errors.New("stop")
+	requests := len(cursors)
+	err = c.QueryDatabase(context.Background(), "db1", nil, func(p *Page) error { return stop })
+	if err != stop || len(cursors) != requests+1 {
+		t.Errorf("QueryDatabase = %v after %d requests, want stop after 1", err, len(cursors)-requests)
+	}
+}
+
+func TestBucketSpacesRequests(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newBucket(RequestsPerSecond, requestBurst)
+	b.now = func() time.Time { return now }
+
+	for i := 0; i < requestBurst; i++ {
+		if d := b.reserve(); d != 0 {
+			t.Fatalf("request %d of the burst waits %v", i+1, d)
+		}
+	}
+	third := time.Second / RequestsPerSecond
+	if d := b.reserve(); d < third-time.Millisecond || d > third+time.Millisecond {
+		t.Errorf("request after the burst waits %v, want %v", d, third)
+	}
+	if d := b.reserve(); d < 2*third-time.Millisecond || d > 2*third+time.Millisecond {
+		t.Errorf("next request waits %v, want %v", d, 2*third)
+	}
+
+	// Idle time refills the bucket up to the burst
+	now = now.Add(10 * time.Second)
+	for i := 0; i < requestBurst; i++ {
+		if d := b.reserve(); d != 0 {
+			t.Fatalf("request %d after idling waits %v", i+1, d)
+		}
+	}
+}
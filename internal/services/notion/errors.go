@@ -0,0 +1,77 @@
+package notion
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Error codes of the API, see https://developers.notion.com/reference/status-codes
+const (
+	CodeUnauthorized        = "unauthorized"
+	CodeRestrictedResource  = "restricted_resource"
+	CodeObjectNotFound      = "object_not_found"
+	CodeValidationError     = "validation_error"
+	CodeConflictError       = "conflict_error"
+	CodeRateLimited         = "rate_limited"
+	CodeInternalServerError = "internal_server_error"
+	CodeServiceUnavailable  = "service_unavailable"
+)
+
+// Error is an error answered by the API
+type Error struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+
+	// RetryAfter is how long the API asked to wait before retrying, zero when it didn't
+	RetryAfter time.Duration `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("notion API error %s (status %d): %s", e.Code, e.Status, e.Message)
+}
+
+// Temporary reports whether the request may succeed when sent again
+func (e *Error) Temporary() bool {
+	switch e.Status {
+	case http.StatusTooManyRequests, http.StatusConflict, http.StatusInternalServerError,
+		http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsCode reports whether err is an API error with code
+func IsCode(err error, code string) bool {
+	var apiErr *Error
+	return errors.As(err, &apiErr) && apiErr.Code == code
+}
+
+// decodeError builds the error of a response that isn't a success. Bodies that aren't
+// the API's error object, e.g. from a proxy, keep their text as message.
+func decodeError(resp *http.Response, body []byte) *Error {
+	apiErr := &Error{}
+	if json.Unmarshal(body, apiErr) != nil || apiErr.Code == "" {
+		apiErr.Code = ""
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	apiErr.Status = resp.StatusCode
+	if apiErr.Message == "" {
+		apiErr.Message = http.StatusText(resp.StatusCode)
+	}
+
+	// Notion sends the seconds to wait, an HTTP date is accepted as well
+	if value := resp.Header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+			apiErr.RetryAfter = time.Duration(seconds) * time.Second
+		} else if at, err := http.ParseTime(value); err == nil && time.Until(at) > 0 {
+			apiErr.RetryAfter = time.Until(at)
+		}
+	}
+	return apiErr
+}
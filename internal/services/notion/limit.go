@@ -0,0 +1,71 @@
+package notion
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Notion allows an integration an average of three requests per second, with short
+// bursts above it
+const (
+	RequestsPerSecond = 3
+	requestBurst      = 3
+)
+
+// bucket is a token bucket spacing the requests of a client. Tokens are taken in
+// advance, so waiting callers queue up in the order they asked.
+type bucket struct {
+	rate  float64 // tokens added per second
+	burst float64
+	now   func() time.Time
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+func newBucket(rate, burst float64) *bucket {
+	return &bucket{rate: rate, burst: burst, now: time.Now, tokens: burst}
+}
+
+// reserve takes a token and returns how long to wait before using it
+func (b *bucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := b.now()
+	if !b.last.IsZero() {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// wait blocks until the caller may send a request, or ctx is done
+func (b *bucket) wait(ctx context.Context) error {
+	return sleep(ctx, b.reserve())
+}
+
+// sleep waits d, returning early with the error of ctx when it is done
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
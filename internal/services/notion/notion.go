@@ -1,67 +0,0 @@
-package notion
-
-import (
-	"bytes"
-	"context"
-	"encoding/json"
-	"fmt"
-	"io"
-	"net/http"
-	"time"
-)
-
-const BASE_URL = "https://api.notion.com/v1"
-
-func SendNotionRequest(req interface{}, method, url, user_api_key string) (respBody []byte, err error) {
-
-	var jsonData []byte
-	if req != nil {
-		jsonData, err = json.Marshal(req)
-		if err != nil {
-			return nil, fmt.Errorf("failed to marshal request: %w", err)
-		}
-	}
-
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	final_url := fmt.Sprintf("%s/%s", BASE_URL, url)
-
-	var httpReq *http.Request
-
-	httpReq, err = http.NewRequestWithContext(ctx, method, final_url, bytes.NewBuffer(jsonData))
-
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-
-	// Set headers
-	httpReq.Header.Set("Authorization", "Bearer "+user_api_key)
-	httpReq.Header.Set("Content-Type", "application/json")
-	httpReq.Header.Set("Notion-Version", "2022-06-28")
-
-	// Send request
-	resp, err := client.Do(httpReq)
-	if err != nil {
-		return nil, fmt.Errorf("failed to send request: %w", err)
-	}
-	defer resp.Body.Close()
-
-	// Read response
-	respBody, err = io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %w", err)
-	}
-
-	// Check for errors
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("notion API error (status %d): %s", resp.StatusCode, string(respBody))
-
-	}
-
-	return respBody, nil
-}
@@ -0,0 +1,413 @@
+// Package notiontest provides a fake Notion API for tests. It keeps databases and
+// pages in memory and answers the requests of notion.Client.
+package notiontest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"unipilot/internal/services/notion"
+	"unipilot/internal/types"
+)
+
+// Request is a request the server received
+type Request struct {
+	Method string
+	Path   string
+	Body   map[string]interface{}
+}
+
+// Server is a fake Notion API. Requests must carry Token as bearer token.
+type Server struct {
+	*httptest.Server
+	Token string
+
+	mu        sync.Mutex
+	nextID    int
+	databases map[string]*notion.Database
+	pages     map[string]*notion.Page
+	order     []string // page IDs in creation order
+	requests  []Request
+	failures  []failure
+}
+
+// failure is an error the server answers instead of serving a request
+type failure struct {
+	status     int
+	code       string
+	retryAfter string
+}
+
+// NewServer starts a fake Notion API accepting token, the caller closes it
+func NewServer(token string) *Server {
+	s := &Server{
+		Token:     token,
+		databases: map[string]*notion.Database{},
+		pages:     map[string]*notion.Page{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+func (s *Server) newID() string {
+	s.nextID++
+	return fmt.Sprintf("%08x-0000-4000-8000-%012x", s.nextID, s.nextID)
+}
+
+// AddDatabase creates a database titled title with the columns by name, their IDs
+// and names are set from the keys when empty. It returns the database ID.
+func (s *Server) AddDatabase(title string, properties map[string]notion.Property) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	db := &notion.Database{ID: s.newID(), Properties: map[string]notion.Property{}}
+	db.Title = Title(title).Title
+	for name, p := range properties {
+		if p.Name == "" {
+			p.Name = name
+		}
+		if p.ID == "" {
+			p.ID = name
+		}
+		db.Properties[name] = p
+	}
+	s.databases[db.ID] = db
+	return db.ID
+}
+
+// AddPage creates a page in a database with values by column name, as if a user
+// wrote it in Notion. It returns the page ID.
+func (s *Server) AddPage(databaseID string, values map[string]notion.PropertyValue) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page := &notion.Page{ID: s.newID(), Properties: map[string]notion.PropertyValue{}}
+	page.Parent.Type = "database_id"
+	page.Parent.DatabaseID = databaseID
+	s.setValues(page, values, true)
+	s.addPage(page)
+	return page.ID
+}
+
+// Fail makes the next n requests fail with status and code, with a Retry-After header
+// when retryAfter is set
+func (s *Server) Fail(n, status int, code, retryAfter string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; i < n; i++ {
+		s.failures = append(s.failures, failure{status, code, retryAfter})
+	}
+}
+
+// addPage stores a new page. The caller holds mu.
+func (s *Server) addPage(page *notion.Page) {
+	s.pages[page.ID] = page
+	s.order = append(s.order, page.ID)
+}
+
+// SetValues changes values of a page by column name, as if a user edited it in Notion
+func (s *Server) SetValues(pageID string, values map[string]notion.PropertyValue) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if page, ok := s.pages[pageID]; ok {
+		s.setValues(page, values, true)
+	}
+}
+
+// Archive moves a page to the trash, as if a user deleted it in Notion
+func (s *Server) Archive(pageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if page, ok := s.pages[pageID]; ok {
+		page.Archived = true
+		page.LastEditedTime = time.Now()
+	}
+}
+
+// Restore takes a page out of the trash
+func (s *Server) Restore(pageID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if page, ok := s.pages[pageID]; ok {
+		page.Archived = false
+		page.InTrash = false
+		page.LastEditedTime = time.Now()
+	}
+}
+
+// Page returns a copy of a page, false when it doesn't exist
+func (s *Server) Page(id string) (notion.Page, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	page, ok := s.pages[id]
+	if !ok {
+		return notion.Page{}, false
+	}
+	copied := *page
+	copied.Properties = map[string]notion.PropertyValue{}
+	for name, v := range page.Properties {
+		copied.Properties[name] = v
+	}
+	return copied, true
+}
+
+// Pages returns the IDs of the pages of a database
+func (s *Server) Pages(databaseID string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var ids []string
+	for id, page := range s.pages {
+		if notion.SameID(page.Parent.DatabaseID, databaseID) {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Requests returns the requests received so far
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// setValues stores values on a page. byName keys are column names, otherwise
+// column IDs or names as Notion accepts in requests. The caller holds mu.
+func (s *Server) setValues(page *notion.Page, values map[string]notion.PropertyValue, byName bool) error {
+	db, ok := s.databases[page.Parent.DatabaseID]
+	if !ok {
+		return fmt.Errorf("database %s not found", page.Parent.DatabaseID)
+	}
+
+	for key, value := range values {
+		var prop *notion.Property
+		for name, p := range db.Properties {
+			if name == key || !byName && p.ID == key {
+				p := p
+				prop = &p
+				break
+			}
+		}
+		if prop == nil {
+			return fmt.Errorf("property %s does not exist", key)
+		}
+		value.ID = prop.ID
+		value.Type = prop.Type
+		for i := range value.Title {
+			fillPlainText(&value.Title[i].PlainText, value.Title[i].Text)
+		}
+		for i := range value.RichText {
+			fillPlainText(&value.RichText[i].PlainText, value.RichText[i].Text)
+		}
+		page.Properties[prop.Name] = value
+	}
+	page.LastEditedTime = time.Now()
+	return nil
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	var raw json.RawMessage
+	json.NewDecoder(r.Body).Decode(&raw)
+	json.Unmarshal(raw, &body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, Request{Method: r.Method, Path: r.URL.Path, Body: body})
+
+	if r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "unauthorized", "API token is invalid.")
+		return
+	}
+
+	if len(s.failures) > 0 {
+		f := s.failures[0]
+		s.failures = s.failures[1:]
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		writeError(w, f.status, f.code, "Injected failure.")
+		return
+	}
+
+	path := strings.Trim(r.URL.Path, "/")
+	switch {
+	case r.Method == http.MethodPost && path == "search":
+		var results []interface{}
+		for _, db := range s.databases {
+			results = append(results, *db)
+		}
+		writePage(w, results, raw)
+
+	case r.Method == http.MethodPost && strings.HasPrefix(path, "databases/") && strings.HasSuffix(path, "/query"):
+		id := strings.TrimSuffix(strings.TrimPrefix(path, "databases/"), "/query")
+		if _, ok := s.databases[id]; !ok {
+			writeError(w, http.StatusNotFound, "object_not_found", "Could not find database.")
+			return
+		}
+		var results []interface{}
+		for _, pageID := range s.order {
+			page := s.pages[pageID]
+			if notion.SameID(page.Parent.DatabaseID, id) && !page.Deleted() {
+				results = append(results, page)
+			}
+		}
+		writePage(w, results, raw)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "databases/"):
+		db, ok := s.databases[strings.TrimPrefix(path, "databases/")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "object_not_found", "Could not find database.")
+			return
+		}
+		writeJSON(w, db)
+
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "pages/"):
+		page, ok := s.pages[strings.TrimPrefix(path, "pages/")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "object_not_found", "Could not find page.")
+			return
+		}
+		writeJSON(w, page)
+
+	case r.Method == http.MethodPost && path == "pages":
+		var req struct {
+			Parent struct {
+				DatabaseID string `json:"database_id"`
+			} `json:"parent"`
+			Properties map[string]notion.PropertyValue `json:"properties"`
+		}
+		json.Unmarshal(raw, &req)
+
+		page := &notion.Page{ID: s.newID(), Properties: map[string]notion.PropertyValue{}}
+		page.Parent.Type = "database_id"
+		page.Parent.DatabaseID = req.Parent.DatabaseID
+		if err := s.setValues(page, req.Properties, false); err != nil {
+			writeError(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
+		s.addPage(page)
+		writeJSON(w, page)
+
+	case r.Method == http.MethodPatch && strings.HasPrefix(path, "pages/"):
+		page, ok := s.pages[strings.TrimPrefix(path, "pages/")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "object_not_found", "Could not find page.")
+			return
+		}
+		var req struct {
+			Archived   *bool                           `json:"archived"`
+			Properties map[string]notion.PropertyValue `json:"properties"`
+		}
+		json.Unmarshal(raw, &req)
+
+		if req.Archived != nil {
+			page.Archived = *req.Archived
+		}
+		if err := s.setValues(page, req.Properties, false); err != nil {
+			writeError(w, http.StatusBadRequest, "validation_error", err.Error())
+			return
+		}
+		writeJSON(w, page)
+
+	default:
+		writeError(w, http.StatusBadRequest, "invalid_request_url", "Invalid request URL.")
+	}
+}
+
+// fillPlainText sets the plain text of a rich text written in a request
+func fillPlainText(plain *string, text *types.TextContent) {
+	if *plain == "" && text != nil {
+		*plain = text.Content
+	}
+}
+
+// Title returns a title value
+func Title(text string) notion.PropertyValue {
+	return notion.PropertyValue{Title: []types.RichText{{Type: "text", Text: &types.TextContent{Content: text}, PlainText: text}}}
+}
+
+// Text returns a rich text value
+func Text(text string) notion.PropertyValue {
+	return notion.PropertyValue{RichText: []types.RichText{{Type: "text", Text: &types.TextContent{Content: text}, PlainText: text}}}
+}
+
+// Date returns a date value
+func Date(start string) notion.PropertyValue {
+	return notion.PropertyValue{Date: &types.DateObject{Start: start}}
+}
+
+// Select returns a select value
+func Select(name string) notion.PropertyValue {
+	return notion.PropertyValue{Select: &notion.Option{Name: name}}
+}
+
+// Status returns a status value
+func Status(name string) notion.PropertyValue {
+	return notion.PropertyValue{Status: &notion.Option{Name: name}}
+}
+
+// Relation returns a relation to pages
+func Relation(pageIDs ...string) notion.PropertyValue {
+	v := notion.PropertyValue{Relation: []types.Relation{}}
+	for _, id := range pageIDs {
+		v.Relation = append(v.Relation, types.Relation{ID: id})
+	}
+	return v
+}
+
+// URL returns a URL value
+func URL(url string) notion.PropertyValue {
+	return notion.PropertyValue{URL: &url}
+}
+
+// writePage answers a paginated request with the results its cursor and page size
+// select, the cursor being the index of the first result
+func writePage(w http.ResponseWriter, results []interface{}, raw json.RawMessage) {
+	var req struct {
+		PageSize    int    `json:"page_size"`
+		StartCursor string `json:"start_cursor"`
+	}
+	json.Unmarshal(raw, &req)
+	if req.PageSize <= 0 || req.PageSize > 100 {
+		req.PageSize = 100
+	}
+
+	start, _ := strconv.Atoi(req.StartCursor)
+	start = min(start, len(results))
+	end := min(start+req.PageSize, len(results))
+	page := map[string]interface{}{"object": "list", "results": results[start:end], "has_more": end < len(results), "next_cursor": nil}
+	if end < len(results) {
+		page["next_cursor"] = strconv.Itoa(end)
+	}
+	writeJSON(w, page)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError answers with an error object in the format of the Notion API
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"object":  "error",
+		"status":  status,
+		"code":    code,
+		"message": message,
+	})
+}
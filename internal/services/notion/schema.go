@@ -0,0 +1,190 @@
+package notion
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+)
+
+// ErrNoDatabase is returned by Discover when no database shared with the integration
+// looks like an assignment list
+var ErrNoDatabase = errors.New("no assignments database shared with the integration")
+
+// Mapping maps the row fields of the app, e.g. "deadline", to database columns
+type Mapping map[string]Property
+
+// Schema is what Discover found in a workspace: the databases holding the assignments
+// and the courses, and the columns each field of the app is stored in
+type Schema struct {
+	AssignmentsDB string  `json:"assignments_db"`
+	CoursesDB     string  `json:"courses_db"`
+	Assignment    Mapping `json:"assignment"`
+	Course        Mapping `json:"course"`
+}
+
+// fieldRule finds the column of a field: one of types named with a hint. With
+// anyName, the only column of the first type is used whatever its name, e.g. the
+// title or the one date of the database.
+type fieldRule struct {
+	field   string
+	types   []string
+	hints   []string
+	anyName bool
+}
+
+// assignmentRules are the fields of an assignment row, see assignment.Assignment.ToMap
+var assignmentRules = []fieldRule{
+	{"title", []string{"title"}, nil, true},
+	{"deadline", []string{"date"}, []string{"deadline", "due", "date"}, true},
+	{"course_code", []string{"relation", "select", "rich_text"}, []string{"course", "class"}, true},
+	{"type", []string{"select"}, []string{"type", "kind", "category"}, false},
+	{"status", []string{"status", "select"}, []string{"status", "state"}, true},
+	{"todo", []string{"rich_text"}, []string{"todo", "to do", "note", "description"}, false},
+	{"link", []string{"url"}, []string{"link", "url"}, true},
+}
+
+// courseRules are the fields of a course row, see course.Course.ToMap
+var courseRules = []fieldRule{
+	{"name", []string{"title"}, nil, true},
+	{"code", []string{"rich_text", "select"}, []string{"code"}, false},
+	{"room_number", []string{"rich_text"}, []string{"room"}, false},
+	{"duration", []string{"rich_text"}, []string{"duration", "hours"}, false},
+	{"instructor", []string{"rich_text"}, []string{"instructor", "professor", "teacher"}, false},
+	{"schedule", []string{"rich_text"}, []string{"schedule", "time"}, false},
+	{"semester", []string{"select", "rich_text"}, []string{"semester", "term"}, false},
+}
+
+// Discover finds the assignments and courses databases shared with the integration
+// and maps their columns. Databases are recognized by their title, or for
+// assignments by a date column, the courses database also by being the target of
+// the course relation of the assignments.
+func Discover(ctx context.Context, c *Client) (*Schema, error) {
+	databases, err := c.SearchDatabases(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	assignments := pickDatabase(databases, []string{"assignment", "homework", "task"}, "date")
+	if assignments == nil {
+		return nil, ErrNoDatabase
+	}
+	schema := &Schema{AssignmentsDB: assignments.ID}
+
+	var courses *Database
+	for _, p := range assignments.sortedProperties() {
+		if p.Type == "relation" && p.Relation != nil {
+			for i := range databases {
+				if SameID(databases[i].ID, p.Relation.DatabaseID) {
+					courses = &databases[i]
+				}
+			}
+		}
+	}
+	if courses == nil {
+		courses = pickDatabase(databases, []string{"course", "class"}, "")
+	}
+	if courses != nil && !SameID(courses.ID, assignments.ID) {
+		schema.CoursesDB = courses.ID
+		schema.Course = mapColumns(courses, courseRules, "")
+	}
+	schema.Assignment = mapColumns(assignments, assignmentRules, schema.CoursesDB)
+
+	return schema, nil
+}
+
+// pickDatabase returns the first database whose title has one of hints, or else the
+// first with a column of fallbackType
+func pickDatabase(databases []Database, hints []string, fallbackType string) *Database {
+	for i := range databases {
+		if hasHint(databases[i].Name(), hints) {
+			return &databases[i]
+		}
+	}
+	if fallbackType == "" {
+		return nil
+	}
+	for i := range databases {
+		for _, p := range databases[i].sortedProperties() {
+			if p.Type == fallbackType {
+				return &databases[i]
+			}
+		}
+	}
+	return nil
+}
+
+// mapColumns maps each field of rules to a column of db. A relation only maps to
+// the courses database, a column is used for one field at most.
+func mapColumns(db *Database, rules []fieldRule, coursesDB string) Mapping {
+	mapping := Mapping{}
+	used := map[string]bool{}
+
+	for _, rule := range rules {
+		var candidates []Property
+		for _, p := range db.sortedProperties() {
+			if used[p.ID] || !contains(rule.types, p.Type) {
+				continue
+			}
+			if p.Type == "relation" && (p.Relation == nil || !SameID(p.Relation.DatabaseID, coursesDB)) {
+				continue
+			}
+			candidates = append(candidates, p)
+		}
+
+		var match *Property
+		for i := range candidates {
+			if hasHint(candidates[i].Name, rule.hints) {
+				match = &candidates[i]
+				break
+			}
+		}
+		if match == nil && rule.anyName {
+			var ofType []Property
+			for _, p := range candidates {
+				if p.Type == rule.types[0] {
+					ofType = append(ofType, p)
+				}
+			}
+			if len(ofType) == 1 {
+				match = &ofType[0]
+			}
+		}
+
+		if match != nil {
+			mapping[rule.field] = *match
+			used[match.ID] = true
+		}
+	}
+	return mapping
+}
+
+// sortedProperties returns the columns of the database by name, so the same schema
+// always maps the same way
+func (d *Database) sortedProperties() []Property {
+	properties := make([]Property, 0, len(d.Properties))
+	for _, p := range d.Properties {
+		properties = append(properties, p)
+	}
+	sort.Slice(properties, func(i, j int) bool { return properties[i].Name < properties[j].Name })
+	return properties
+}
+
+func hasHint(name string, hints []string) bool {
+	name = strings.ToLower(name)
+	for _, hint := range hints {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+package notion
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Read returns the mapped fields of a page as row values. Dates are read as
+// YYYY-MM-DD, a relation as the IDs of the related pages separated by commas.
+func (m Mapping) Read(page *Page) map[string]string {
+	values := map[string]string{}
+	for field, prop := range m {
+		for _, v := range page.Properties {
+			if v.ID == prop.ID {
+				values[field] = v.String()
+				break
+			}
+		}
+	}
+	return values
+}
+
+// String returns the value as plain text
+func (v PropertyValue) String() string {
+	switch v.Type {
+	case "title":
+		return plainText(v.Title)
+	case "rich_text":
+		return plainText(v.RichText)
+	case "date":
+		if v.Date == nil {
+			return ""
+		}
+		if len(v.Date.Start) > 10 {
+			return v.Date.Start[:10]
+		}
+		return v.Date.Start
+	case "select":
+		if v.Select != nil {
+			return v.Select.Name
+		}
+	case "status":
+		if v.Status != nil {
+			return v.Status.Name
+		}
+	case "relation":
+		ids := make([]string, len(v.Relation))
+		for i, r := range v.Relation {
+			ids[i] = r.ID
+		}
+		return strings.Join(ids, ",")
+	case "url":
+		if v.URL != nil {
+			return *v.URL
+		}
+	case "number":
+		if v.Number != nil {
+			return strconv.FormatFloat(*v.Number, 'f', -1, 64)
+		}
+	}
+	return ""
+}
+
+// Write returns the property values of a create or update request setting the
+// mapped fields in values, by column ID. A relation is set to the page ID given.
+func (m Mapping) Write(values map[string]string) map[string]interface{} {
+	properties := map[string]interface{}{}
+	for field, value := range values {
+		prop, ok := m[field]
+		if !ok {
+			continue
+		}
+		if value, ok := propertyValue(prop.Type, value); ok {
+			properties[prop.ID] = value
+		}
+	}
+	return properties
+}
+
+// propertyValue returns the request value of a column of type typ, false for types
+// the app doesn't write
+func propertyValue(typ, value string) (interface{}, bool) {
+	text := []map[string]interface{}{{"type": "text", "text": map[string]string{"content": value}}}
+	if value == "" {
+		text = []map[string]interface{}{}
+	}
+
+	switch typ {
+	case "title":
+		return map[string]interface{}{"title": text}, true
+	case "rich_text":
+		return map[string]interface{}{"rich_text": text}, true
+	case "date":
+		if value == "" {
+			return map[string]interface{}{"date": nil}, true
+		}
+		return map[string]interface{}{"date": map[string]string{"start": value}}, true
+	case "select":
+		if value == "" {
+			return map[string]interface{}{"select": nil}, true
+		}
+		return map[string]interface{}{"select": map[string]string{"name": value}}, true
+	case "status":
+		// A status can't be cleared
+		if value == "" {
+			return nil, false
+		}
+		return map[string]interface{}{"status": map[string]string{"name": value}}, true
+	case "relation":
+		relation := []map[string]string{}
+		if value != "" {
+			relation = append(relation, map[string]string{"id": value})
+		}
+		return map[string]interface{}{"relation": relation}, true
+	case "url":
+		if value == "" {
+			return map[string]interface{}{"url": nil}, true
+		}
+		return map[string]interface{}{"url": value}, true
+	}
+	return nil, false
+}
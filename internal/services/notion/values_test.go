@@ -0,0 +1,82 @@
+package notion
+
+import (
+	"encoding/json"
+	"testing"
+
+	"unipilot/internal/types"
+)
+
+func TestMappingWriteThenRead(t *testing.T) {
+	mapping := Mapping{
+		"title":    {ID: "title", Type: "title"},
+		"deadline": {ID: "_UjC", Type: "date"},
+		"type":     {ID: "S~Ce", Type: "select"},
+		"status":   {ID: "st", Type: "status"},
+	}
+	values := map[string]string{"title": "Essay", "deadline": "2025-06-05", "type": "HW", "status": "", "unmapped": "x"}
+
+	data, err := json.Marshal(mapping.Write(values))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var properties map[string]PropertyValue
+	if err := json.Unmarshal(data, &properties); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := properties["st"]; ok {
+		t.Error("empty status written, Notion rejects clearing a status")
+	}
+
+	// Notion answers with the type of each value and the plain text of texts
+	page := &Page{Properties: map[string]PropertyValue{}}
+	for id, v := range properties {
+		v.ID = id
+		v.Type = mapping.typeOf(id)
+		for i := range v.Title {
+			v.Title[i].PlainText = v.Title[i].Text.Content
+		}
+		page.Properties["col "+id] = v
+	}
+	got := mapping.Read(page)
+	for _, field := range []string{"title", "deadline", "type"} {
+		if got[field] != values[field] {
+			t.Errorf("%s read back as %q, want %q", field, got[field], values[field])
+		}
+	}
+}
+
+func TestReadTrimsDateTimes(t *testing.T) {
+	v := PropertyValue{Type: "date", Date: &types.DateObject{Start: "2025-06-05T00:00:00.000Z"}}
+	if got := v.String(); got != "2025-06-05" {
+		t.Errorf("date = %q, want 2025-06-05", got)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	body := []byte(`{"type":"page.created"}`)
+	signature := Sign("token", body)
+
+	if !VerifySignature("token", body, signature) {
+		t.Error("valid signature rejected")
+	}
+	if VerifySignature("other", body, signature) {
+		t.Error("signature of another token accepted")
+	}
+	if VerifySignature("token", []byte(`{"type":"page.deleted"}`), signature) {
+		t.Error("signature of another body accepted")
+	}
+	if VerifySignature("", body, Sign("", body)) {
+		t.Error("signature accepted before the webhook was verified")
+	}
+}
+
+// typeOf returns the type of the column with id
+func (m Mapping) typeOf(id string) string {
+	for _, p := range m {
+		if p.ID == id {
+			return p.Type
+		}
+	}
+	return ""
+}
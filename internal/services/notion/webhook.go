@@ -0,0 +1,61 @@
+package notion
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"unipilot/internal/types"
+)
+
+// SignatureHeader carries the HMAC-SHA256 of a webhook body, keyed with the
+// verification token of the subscription
+const SignatureHeader = "X-Notion-Signature"
+
+// Webhook event types the sync handles
+const (
+	EventPageCreated           = "page.created"
+	EventPagePropertiesUpdated = "page.properties_updated"
+	EventPageDeleted           = "page.deleted"
+	EventPageUndeleted         = "page.undeleted"
+	EventDatabaseSchemaUpdated = "database.schema_updated"
+)
+
+// Event is a webhook delivery
+type Event = types.NotionWebhookPayload
+
+// Verification is the first request of a new subscription, the token it carries
+// signs every later delivery
+type Verification struct {
+	VerificationToken string `json:"verification_token"`
+}
+
+// Sign returns the signature header value of body
+func Sign(token string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifySignature reports whether signature is the signature of body with token
+func VerifySignature(token string, body []byte, signature string) bool {
+	if token == "" || !strings.HasPrefix(signature, "sha256=") {
+		return false
+	}
+	return hmac.Equal([]byte(Sign(token, body)), []byte(signature))
+}
+
+// ByBot reports whether only integrations authored the change, such as the sync
+// writing local edits, whose echo must not be applied again
+func ByBot(e *Event) bool {
+	if len(e.Authors) == 0 {
+		return false
+	}
+	for _, author := range e.Authors {
+		if author.Type != "bot" {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,125 @@
+// Package ratelimit counts attempts per key in sliding windows and blocks keys that
+// make too many, e.g. failed logins per IP address or per username.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy is how many attempts a key may make in a window
+type Policy struct {
+	Limit  int
+	Window time.Duration
+	// Lockout blocks a key this long once it reaches Limit, and forgets its attempts.
+	// Without a lockout the key is blocked until its oldest attempt leaves the window.
+	Lockout time.Duration
+}
+
+// Limiter holds the attempts of keys. Memory keeps them in the process, a shared
+// store can implement the same interface for several servers.
+type Limiter interface {
+	// Check returns how long key is still blocked, zero when it may try
+	Check(key string) time.Duration
+	// Record adds an attempt of key and returns how long key is blocked after it
+	Record(key string) time.Duration
+	// Reset forgets the attempts of key, e.g. after a successful login
+	Reset(key string)
+}
+
+type entry struct {
+	attempts    []time.Time
+	lockedUntil time.Time
+}
+
+// Memory is a Limiter keeping attempts in memory
+type Memory struct {
+	policy Policy
+	now    func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func NewMemory(policy Policy) *Memory {
+	return &Memory{policy: policy, now: time.Now, entries: make(map[string]*entry)}
+}
+
+// prune drops the attempts of e that left the window
+func (m *Memory) prune(e *entry, now time.Time) {
+	cutoff := now.Add(-m.policy.Window)
+	i := 0
+	for i < len(e.attempts) && !e.attempts[i].After(cutoff) {
+		i++
+	}
+	e.attempts = e.attempts[i:]
+}
+
+// blocked returns how long e stays blocked at now
+func (m *Memory) blocked(e *entry, now time.Time) time.Duration {
+	if now.Before(e.lockedUntil) {
+		return e.lockedUntil.Sub(now)
+	}
+	if m.policy.Lockout == 0 && len(e.attempts) >= m.policy.Limit {
+		return e.attempts[len(e.attempts)-m.policy.Limit].Add(m.policy.Window).Sub(now)
+	}
+	return 0
+}
+
+func (m *Memory) Check(key string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[key]
+	if !ok {
+		return 0
+	}
+	now := m.now()
+	m.prune(e, now)
+	return m.blocked(e, now)
+}
+
+func (m *Memory) Record(key string) time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	e, ok := m.entries[key]
+	if !ok {
+		e = &entry{}
+		m.entries[key] = e
+	}
+	m.prune(e, now)
+	e.attempts = append(e.attempts, now)
+
+	if m.policy.Lockout > 0 && len(e.attempts) >= m.policy.Limit {
+		e.lockedUntil = now.Add(m.policy.Lockout)
+		e.attempts = nil
+	}
+	return m.blocked(e, now)
+}
+
+func (m *Memory) Reset(key string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, key)
+}
+
+// sweep drops the keys without attempts in the window nor lockout, at most once per
+// window. The caller holds mu.
+func (m *Memory) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < m.policy.Window {
+		return
+	}
+	m.lastSweep = now
+
+	for key, e := range m.entries {
+		m.prune(e, now)
+		if len(e.attempts) == 0 && !now.Before(e.lockedUntil) {
+			delete(m.entries, key)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a settable time source for the limiter
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time            { return c.t }
+func (c *clock) advance(d time.Duration)   { c.t = c.t.Add(d) }
+func newMemory(p Policy, c *clock) *Memory { m := NewMemory(p); m.now = c.now; return m }
+
+func TestSlidingWindow(t *testing.T) {
+	c := &clock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newMemory(Policy{Limit: 3, Window: time.Hour}, c)
+
+	for i := 0; i < 2; i++ {
+		if d := m.Record("ip:1.2.3.4"); d != 0 {
+			t.Fatalf("attempt %d blocked for %v", i+1, d)
+		}
+		c.advance(10 * time.Minute)
+	}
+	// The third attempt reaches the limit, the key waits for the first to leave the window
+	if d := m.Record("ip:1.2.3.4"); d != 40*time.Minute {
+		t.Fatalf("Record() at limit = %v, want 40m", d)
+	}
+	if d := m.Check("ip:1.2.3.4"); d != 40*time.Minute {
+		t.Fatalf("Check() at limit = %v, want 40m", d)
+	}
+	if d := m.Check("ip:5.6.7.8"); d != 0 {
+		t.Fatalf("Check() of another key = %v, want 0", d)
+	}
+
+	c.advance(40 * time.Minute)
+	if d := m.Check("ip:1.2.3.4"); d != 0 {
+		t.Fatalf("Check() after the oldest attempt left the window = %v, want 0", d)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	c := &clock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newMemory(Policy{Limit: 3, Window: 15 * time.Minute, Lockout: 30 * time.Minute}, c)
+
+	m.Record("user:ada")
+	m.Record("user:ada")
+	if d := m.Record("user:ada"); d != 30*time.Minute {
+		t.Fatalf("Record() reaching the limit = %v, want 30m lockout", d)
+	}
+
+	c.advance(29 * time.Minute)
+	if d := m.Check("user:ada"); d != time.Minute {
+		t.Fatalf("Check() during lockout = %v, want 1m", d)
+	}
+
+	// After the lockout the key starts over
+	c.advance(time.Minute)
+	if d := m.Check("user:ada"); d != 0 {
+		t.Fatalf("Check() after lockout = %v, want 0", d)
+	}
+	if d := m.Record("user:ada"); d != 0 {
+		t.Fatalf("Record() after lockout = %v, want 0", d)
+	}
+}
+
+func TestFailuresOutsideWindowDontCount(t *testing.T) {
+	c := &clock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newMemory(Policy{Limit: 3, Window: 15 * time.Minute, Lockout: 30 * time.Minute}, c)
+
+	for i := 0; i < 10; i++ {
+		if d := m.Record("user:ada"); d != 0 {
+			t.Fatalf("attempt %d blocked for %v, attempts 10m apart stay under the limit", i+1, d)
+		}
+		c.advance(10 * time.Minute)
+	}
+}
+
+func TestReset(t *testing.T) {
+	c := &clock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newMemory(Policy{Limit: 2, Window: time.Hour, Lockout: time.Hour}, c)
+
+	m.Record("user:ada")
+	m.Reset("user:ada")
+	if d := m.Record("user:ada"); d != 0 {
+		t.Fatalf("Record() after Reset() = %v, want 0", d)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	c := &clock{t: time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)}
+	m := newMemory(Policy{Limit: 5, Window: time.Minute}, c)
+
+	m.Record("ip:1.2.3.4")
+	c.advance(2 * time.Minute)
+	m.Record("ip:5.6.7.8")
+
+	if _, ok := m.entries["ip:1.2.3.4"]; ok {
+		t.Fatal("stale key kept after sweep")
+	}
+	if len(m.entries) != 1 {
+		t.Fatalf("entries = %d, want 1", len(m.entries))
+	}
+}
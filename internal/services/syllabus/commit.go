@@ -0,0 +1,130 @@
+package syllabus
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/sync"
+
+	"gorm.io/gorm"
+)
+
+// DefaultStatus is the status of imported assignments
+const DefaultStatus = "Not started"
+
+// ErrInvalidRows is returned by Commit when rows have errors, nothing is imported then
+var ErrInvalidRows = errors.New("rows have errors")
+
+// Check adds the errors found against the local database to the rows: unknown
+// courses and types, rows repeated in the file and assignments imported already.
+// Codes and types differing only in case are corrected to the stored ones.
+func (p *Preview) Check(db *gorm.DB) error {
+	var courses []course.LocalCourse
+	if err := db.Select("code").Find(&courses).Error; err != nil {
+		return fmt.Errorf("failed to load courses: %w", err)
+	}
+	var types []models.LocalAssignmentType
+	if err := db.Select("name").Order("id").Find(&types).Error; err != nil {
+		return fmt.Errorf("failed to load assignment types: %w", err)
+	}
+	typeNames := make([]string, len(types))
+	for i, t := range types {
+		typeNames[i] = t.Name
+	}
+
+	seen := map[string]int{}
+	for i := range p.Rows {
+		row := &p.Rows[i]
+		if row.CourseCode != "" {
+			code := ""
+			for _, c := range courses {
+				if strings.EqualFold(c.Code, row.CourseCode) {
+					code = c.Code
+				}
+			}
+			if code == "" {
+				row.addError("unknown course %q", row.CourseCode)
+			} else {
+				row.CourseCode = code
+			}
+		}
+
+		name := ""
+		for _, t := range typeNames {
+			if strings.EqualFold(t, row.Type) {
+				name = t
+			}
+		}
+		if name == "" {
+			row.addError("unknown type %q (known: %s)", row.Type, strings.Join(typeNames, ", "))
+		} else {
+			row.Type = name
+		}
+
+		if !row.Valid() {
+			continue
+		}
+		key := strings.ToLower(row.CourseCode + "\x00" + row.Title + "\x00" + row.Deadline)
+		if line, ok := seen[key]; ok {
+			row.addError("duplicate of line %d", line)
+			continue
+		}
+		seen[key] = row.Line
+
+		var count int64
+		err := db.Model(&assignment.LocalAssignment{}).
+			Where("course_code = ? AND LOWER(title) = LOWER(?) AND deadline >= ? AND deadline < ?",
+				row.CourseCode, row.Title, row.deadline, row.deadline.Add(24*time.Hour)).
+			Count(&count).Error
+		if err != nil {
+			return fmt.Errorf("failed to look for imported assignments: %w", err)
+		}
+		if count > 0 {
+			row.addError("already imported")
+		}
+	}
+	return nil
+}
+
+// Commit checks the rows again and creates their assignments in one transaction,
+// each queued in the outbox for the server. It returns the number of assignments
+// created, and imports nothing when a row is invalid. Errors found when the rows
+// were parsed are kept.
+func Commit(db *gorm.DB, p *Preview) (int, error) {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := p.Check(tx); err != nil {
+			return err
+		}
+		if n := p.Invalid(); n > 0 {
+			return fmt.Errorf("%d of %d %w", n, len(p.Rows), ErrInvalidRows)
+		}
+
+		for _, row := range p.Rows {
+			la := &assignment.LocalAssignment{
+				Title:      row.Title,
+				Todo:       row.Todo,
+				Deadline:   row.deadline,
+				CourseCode: row.CourseCode,
+				TypeName:   row.Type,
+				StatusName: DefaultStatus,
+				SyncStatus: assignment.SyncStatusPending,
+			}
+			if err := tx.Omit("Course", "Type", "Status", "Documents").Create(la).Error; err != nil {
+				return fmt.Errorf("failed to create assignment %q: %w", row.Title, err)
+			}
+			if err := sync.Enqueue(tx, models.Assignment, models.OperationCreate, la.ID, "", ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(p.Rows), nil
+}
@@ -0,0 +1,182 @@
+package syllabus
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// bom starts files saved as UTF-8 by some spreadsheet apps
+const bom = "\ufeff"
+
+// readCSV reads a CSV file whose first row names the columns. Files separated by
+// semicolons, as spreadsheets write them in some locales, are read as well.
+func readCSV(data []byte) (*table, error) {
+	data = bytes.TrimPrefix(data, []byte(bom))
+
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	firstLine, _, _ := bytes.Cut(data, []byte("\n"))
+	if bytes.Count(firstLine, []byte(";")) > bytes.Count(firstLine, []byte(",")) {
+		r.Comma = ';'
+	}
+
+	t := &table{}
+	for {
+		record, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid CSV: %w", err)
+		}
+		line, _ := r.FieldPos(0)
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		if t.columns == nil {
+			t.columns = record
+			continue
+		}
+		t.records = append(t.records, record)
+		t.lines = append(t.lines, line)
+	}
+	return t, nil
+}
+
+// tableSeparator matches the line under the header of a markdown table
+var tableSeparator = regexp.MustCompile(`^\|?(\s*:?-+:?\s*\|)*\s*:?-+:?\s*\|?$`)
+
+// columnGap separates the columns of a plain text table aligned with spaces
+var columnGap = regexp.MustCompile(`\t|\s{2,}`)
+
+// readTable reads a markdown table, or a plain text one with columns separated by
+// tabs or aligned with spaces. The first line with several cells names the columns,
+// lines with a single cell around the table, such as headings, are skipped.
+func readTable(data []byte) (*table, error) {
+	text := strings.ReplaceAll(strings.TrimPrefix(string(data), bom), "\r\n", "\n")
+
+	t := &table{}
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || tableSeparator.MatchString(line) {
+			continue
+		}
+
+		var cells []string
+		if strings.Contains(line, "|") {
+			cells = strings.Split(strings.Trim(line, "|"), "|")
+		} else {
+			cells = columnGap.Split(line, -1)
+		}
+		for j := range cells {
+			cells[j] = cleanCell(cells[j])
+		}
+		if len(cells) < 2 {
+			continue
+		}
+
+		if t.columns == nil {
+			t.columns = cells
+			continue
+		}
+		t.records = append(t.records, cells)
+		t.lines = append(t.lines, i+1)
+	}
+	return t, nil
+}
+
+// cleanCell drops the markdown emphasis and code marks around the text of a cell
+func cleanCell(cell string) string {
+	cell = strings.TrimSpace(cell)
+	for _, mark := range []string{"**", "__", "`", "*", "_"} {
+		if len(cell) > 2*len(mark) && strings.HasPrefix(cell, mark) && strings.HasSuffix(cell, mark) {
+			cell = strings.TrimSpace(cell[len(mark) : len(cell)-len(mark)])
+		}
+	}
+	return cell
+}
+
+// icsColumns are the columns an ICS file is read as, one row per event or to-do
+var icsColumns = []string{"Summary", "Due", "Description", "Categories"}
+
+// readICS reads the events and to-dos of a calendar. The deadline of a to-do is its
+// DUE, of an event its DTSTART.
+func readICS(data []byte) (*table, error) {
+	text := strings.ReplaceAll(strings.TrimPrefix(string(data), bom), "\r\n", "\n")
+	// Long lines are folded, continued on lines starting with a space or a tab
+	text = strings.NewReplacer("\n ", "", "\n\t", "").Replace(text)
+
+	t := &table{columns: icsColumns}
+	var record map[string]string
+	var start int
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		switch strings.ToUpper(line) {
+		case "BEGIN:VEVENT", "BEGIN:VTODO":
+			record, start = map[string]string{}, i+1
+			continue
+		case "END:VEVENT", "END:VTODO":
+			if record != nil {
+				due := record["DUE"]
+				if due == "" {
+					due = record["DTSTART"]
+				}
+				t.records = append(t.records, []string{record["SUMMARY"], due, record["DESCRIPTION"], record["CATEGORIES"]})
+				t.lines = append(t.lines, start)
+			}
+			record = nil
+			continue
+		}
+		if record == nil {
+			continue
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(strings.ToUpper(name), ";")
+		switch name {
+		case "DTSTART", "DUE":
+			record[name] = icsDate(value, params)
+		case "SUMMARY", "DESCRIPTION", "CATEGORIES":
+			record[name] = icsText(value)
+		}
+	}
+	if len(t.records) == 0 {
+		return nil, nil
+	}
+	return t, nil
+}
+
+// icsDate returns the date of a DATE or DATE-TIME value as YYYY-MM-DD, in the time
+// zone of its TZID parameter
+func icsDate(value, params string) string {
+	value = strings.TrimSpace(value)
+	for _, param := range strings.Split(params, ";") {
+		key, tzid, _ := strings.Cut(param, "=")
+		if key != "TZID" {
+			continue
+		}
+		loc, err := time.LoadLocation(strings.Trim(tzid, `"`))
+		if err != nil {
+			break
+		}
+		if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+			return t.Format(time.DateOnly)
+		}
+	}
+	return value
+}
+
+// icsText unescapes a TEXT value
+func icsText(value string) string {
+	return strings.TrimSpace(strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value))
+}
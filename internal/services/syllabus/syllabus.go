@@ -0,0 +1,360 @@
+// Package syllabus reads the assignments of a course syllabus from a CSV file, an ICS
+// calendar or a markdown or plain text table, to preview them with their validation
+// errors before importing them all at once.
+package syllabus
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Fields of an assignment a column can be mapped to
+const (
+	FieldTitle      = "title"
+	FieldDeadline   = "deadline"
+	FieldType       = "type"
+	FieldTodo       = "todo"
+	FieldCourseCode = "course_code"
+)
+
+// Fields lists the fields in the order columns are guessed for them, the most
+// specific names first
+var Fields = []string{FieldDeadline, FieldCourseCode, FieldType, FieldTodo, FieldTitle}
+
+// hints are the words a column name contains to be guessed for a field
+var hints = map[string][]string{
+	FieldDeadline:   {"deadline", "due", "date", "when"},
+	FieldCourseCode: {"course", "class", "code"},
+	FieldType:       {"type", "categor", "kind"},
+	FieldTodo:       {"todo", "to do", "description", "detail", "note", "instruction"},
+	FieldTitle:      {"title", "assignment", "summary", "name", "task", "item", "topic", "what"},
+}
+
+// DefaultType is the type of rows without one
+const DefaultType = "HW"
+
+// Format is a kind of syllabus file
+type Format string
+
+const (
+	FormatCSV   Format = "csv"
+	FormatICS   Format = "ics"
+	FormatTable Format = "table" // markdown or plain text table
+)
+
+// ErrNoRows is returned by Parse when the file holds no table of assignments
+var ErrNoRows = errors.New("no assignments found in the file")
+
+// Mapping maps fields to the column they are read from, by column name
+type Mapping map[string]string
+
+// Options tell Parse how to read a file
+type Options struct {
+	// Format is detected from the file name and content when empty
+	Format Format
+	// Mapping sets the columns of fields, the others are guessed from the column names
+	Mapping Mapping
+	// CourseCode is the course of rows without one
+	CourseCode string
+	// Type is the type of rows without one, DefaultType when empty
+	Type string
+	// Start is the start of the semester. Dates written without a year fall in the year
+	// of Start, or the next one when they would be more than a month before it. The
+	// current time is used when zero.
+	Start time.Time
+}
+
+// Row is an assignment read from the file. Deadline is YYYY-MM-DD when it parsed,
+// the text of the file otherwise.
+type Row struct {
+	Line       int      `json:"line"`
+	Title      string   `json:"title"`
+	Deadline   string   `json:"deadline"`
+	Type       string   `json:"type"`
+	Todo       string   `json:"todo"`
+	CourseCode string   `json:"course_code"`
+	Errors     []string `json:"errors"`
+
+	deadline time.Time
+}
+
+// Valid reports whether the row can be imported
+func (r *Row) Valid() bool {
+	return len(r.Errors) == 0
+}
+
+func (r *Row) addError(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// Preview is what Parse read from a file: its columns, the column of each field and
+// the rows
+type Preview struct {
+	Format  Format   `json:"format"`
+	Columns []string `json:"columns"`
+	Mapping Mapping  `json:"mapping"`
+	Rows    []Row    `json:"rows"`
+}
+
+// Invalid returns the number of rows with errors
+func (p *Preview) Invalid() int {
+	n := 0
+	for i := range p.Rows {
+		if !p.Rows[i].Valid() {
+			n++
+		}
+	}
+	return n
+}
+
+// table is a file read as a header and records. line holds the line of each record.
+type table struct {
+	columns []string
+	records [][]string
+	lines   []int
+}
+
+// Parse reads the assignments of a syllabus file named name
+func Parse(data []byte, name string, opts Options) (*Preview, error) {
+	format := opts.Format
+	if format == "" {
+		format = DetectFormat(name, data)
+	}
+
+	var t *table
+	var err error
+	switch format {
+	case FormatCSV:
+		t, err = readCSV(data)
+	case FormatICS:
+		t, err = readICS(data)
+	case FormatTable:
+		t, err = readTable(data)
+	default:
+		return nil, fmt.Errorf("unknown syllabus format %q", format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t == nil || len(t.records) == 0 {
+		return nil, ErrNoRows
+	}
+
+	mapping, err := mapColumns(t.columns, opts.Mapping)
+	if err != nil {
+		return nil, err
+	}
+
+	start := opts.Start
+	if start.IsZero() {
+		start = time.Now()
+	}
+	defaultType := opts.Type
+	if defaultType == "" {
+		defaultType = DefaultType
+	}
+
+	preview := &Preview{Format: format, Columns: t.columns, Mapping: mapping}
+	for i, record := range t.records {
+		value := func(field string) string {
+			column, ok := mapping[field]
+			if !ok {
+				return ""
+			}
+			for j, c := range t.columns {
+				if c == column && j < len(record) {
+					return record[j]
+				}
+			}
+			return ""
+		}
+
+		row := Row{
+			Line:       t.lines[i],
+			Title:      value(FieldTitle),
+			Deadline:   value(FieldDeadline),
+			Type:       value(FieldType),
+			Todo:       value(FieldTodo),
+			CourseCode: value(FieldCourseCode),
+		}
+		if row.Title == "" && row.Deadline == "" && row.Todo == "" {
+			continue
+		}
+		if row.Type == "" {
+			row.Type = defaultType
+		}
+		if row.CourseCode == "" {
+			row.CourseCode = opts.CourseCode
+		}
+
+		if row.Title == "" {
+			row.addError("title is missing")
+		}
+		if row.Deadline == "" {
+			row.addError("deadline is missing")
+		} else if deadline, ok := parseDate(row.Deadline, start); ok {
+			row.deadline = deadline
+			row.Deadline = deadline.Format(time.DateOnly)
+		} else {
+			row.addError("deadline %q is not a date", row.Deadline)
+		}
+		if row.CourseCode == "" {
+			row.addError("course code is missing")
+		}
+		preview.Rows = append(preview.Rows, row)
+	}
+	if len(preview.Rows) == 0 {
+		return nil, ErrNoRows
+	}
+	return preview, nil
+}
+
+// DetectFormat guesses the format of a file from its extension, then its content
+func DetectFormat(name string, data []byte) Format {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".csv":
+		return FormatCSV
+	case ".ics", ".ical":
+		return FormatICS
+	case ".md", ".markdown", ".txt", ".tsv":
+		return FormatTable
+	}
+
+	text := strings.TrimSpace(strings.TrimPrefix(string(data), bom))
+	firstLine, _, _ := strings.Cut(text, "\n")
+	switch {
+	case strings.HasPrefix(strings.ToUpper(text), "BEGIN:VCALENDAR"):
+		return FormatICS
+	case strings.Contains(firstLine, "|") || strings.Contains(firstLine, "\t"):
+		return FormatTable
+	case strings.Contains(firstLine, ",") || strings.Contains(firstLine, ";"):
+		return FormatCSV
+	}
+	return FormatTable
+}
+
+// mapColumns checks the columns set by the caller and guesses the others. Titles and
+// deadlines must have a column.
+func mapColumns(columns []string, set Mapping) (Mapping, error) {
+	mapping := Mapping{}
+	used := map[string]bool{}
+	for field, column := range set {
+		if column == "" {
+			continue
+		}
+		if _, ok := hints[field]; !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		found := false
+		for _, c := range columns {
+			found = found || c == column
+		}
+		if !found {
+			return nil, fmt.Errorf("column %q of %s not found in the file", column, field)
+		}
+		mapping[field] = column
+		used[column] = true
+	}
+
+	for _, field := range Fields {
+		if _, ok := mapping[field]; ok {
+			continue
+		}
+		for _, column := range columns {
+			if !used[column] && hasHint(column, hints[field]) {
+				mapping[field] = column
+				used[column] = true
+				break
+			}
+		}
+	}
+
+	for _, field := range []string{FieldTitle, FieldDeadline} {
+		if _, ok := mapping[field]; !ok {
+			return nil, fmt.Errorf("no column found for the %s, choose one among %s", field, strings.Join(columns, ", "))
+		}
+	}
+	return mapping, nil
+}
+
+func hasHint(name string, hints []string) bool {
+	name = strings.ToLower(name)
+	for _, hint := range hints {
+		if strings.Contains(name, hint) {
+			return true
+		}
+	}
+	return false
+}
+
+// dateLayouts are the ways syllabi write dates, layouts without a year last
+var dateLayouts = []string{
+	time.DateOnly,
+	time.RFC3339,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04",
+	"20060102T150405Z",
+	"20060102T150405",
+	"20060102",
+	"2006/01/02",
+	"01/02/2006",
+	"1/2/2006",
+	"1/2/06",
+	"Jan 2 2006",
+	"Jan 2, 2006",
+	"January 2 2006",
+	"January 2, 2006",
+	"2 Jan 2006",
+	"2 January 2006",
+	"Mon Jan 2 2006",
+	"Mon, Jan 2, 2006",
+	"Monday, January 2, 2006",
+}
+
+// yearlessLayouts are layouts of dates written without a year
+var yearlessLayouts = []string{
+	"1/2",
+	"01/02",
+	"Jan 2",
+	"January 2",
+	"2 Jan",
+	"2 January",
+	"Mon Jan 2",
+	"Mon, Jan 2",
+	"Monday, January 2",
+	"Monday Jan 2",
+}
+
+// ordinal matches the suffix of days written 1st, 2nd, 3rd or 4th
+var ordinal = regexp.MustCompile(`(\d)(st|nd|rd|th)\b`)
+
+// parseDate reads a date in one of dateLayouts, see Options.Start for dates without
+// a year. UTC times are read in the local time zone.
+func parseDate(value string, start time.Time) (time.Time, bool) {
+	value = strings.Join(strings.Fields(ordinal.ReplaceAllString(value, "$1")), " ")
+	value = strings.TrimSuffix(value, ".")
+	value = strings.ReplaceAll(value, "Sept ", "Sep ")
+
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			if strings.HasSuffix(layout, "Z") || layout == time.RFC3339 {
+				t = t.In(time.Local)
+			}
+			return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+		}
+	}
+	for _, layout := range yearlessLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			date := time.Date(start.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+			if date.Before(time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, -1, 0)) {
+				date = date.AddDate(1, 0, 0)
+			}
+			return date, true
+		}
+	}
+	return time.Time{}, false
+}
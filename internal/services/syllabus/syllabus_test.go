@@ -0,0 +1,252 @@
+package syllabus
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/storage"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var fall = time.Date(2025, time.August, 25, 0, 0, 0, 0, time.UTC)
+
+func parse(t *testing.T, data, name string, opts Options) *Preview {
+	t.Helper()
+
+	if opts.Start.IsZero() {
+		opts.Start = fall
+	}
+	p, err := Parse([]byte(data), name, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// summary lists the rows as title@deadline/type/course, with their errors
+func summary(p *Preview) string {
+	var rows []string
+	for _, r := range p.Rows {
+		s := r.Title + "@" + r.Deadline + "/" + r.Type + "/" + r.CourseCode
+		if len(r.Errors) > 0 {
+			s += " " + strings.Join(r.Errors, "; ")
+		}
+		rows = append(rows, s)
+	}
+	return strings.Join(rows, "\n")
+}
+
+func TestParseCSV(t *testing.T) {
+	data := bom + "Assignment,Due Date,Category,Notes\n" +
+		"Essay 1,2025-09-12,HW,\"Read chapters 1, 2\"\n" +
+		"\n" +
+		"Midterm,Oct 14th,Exam,\n" +
+		",,,\n" +
+		"Lab 3,someday,HW,\n"
+	p := parse(t, data, "syllabus.csv", Options{CourseCode: "CS101"})
+
+	if p.Format != FormatCSV {
+		t.Errorf("format = %s", p.Format)
+	}
+	want := Mapping{FieldTitle: "Assignment", FieldDeadline: "Due Date", FieldType: "Category", FieldTodo: "Notes"}
+	for field, column := range want {
+		if p.Mapping[field] != column {
+			t.Errorf("mapping = %v, want %v", p.Mapping, want)
+		}
+	}
+	got := summary(p)
+	expected := "Essay 1@2025-09-12/HW/CS101\n" +
+		"Midterm@2025-10-14/Exam/CS101\n" +
+		`Lab 3@someday/HW/CS101 deadline "someday" is not a date`
+	if got != expected {
+		t.Errorf("rows =\n%s\nwant\n%s", got, expected)
+	}
+	if p.Rows[0].Todo != "Read chapters 1, 2" || p.Rows[1].Line != 4 || p.Invalid() != 1 {
+		t.Errorf("rows = %+v", p.Rows)
+	}
+}
+
+func TestParseCSVWithSemicolonsAndMapping(t *testing.T) {
+	data := "Week;Topic;Deliverable;Due\n1;Intro;Quiz 1;9/1/2025\n2;Loops;;\n"
+	p := parse(t, data, "plan.csv", Options{
+		CourseCode: "CS101",
+		Mapping:    Mapping{FieldTitle: "Deliverable", FieldTodo: "Topic"},
+	})
+
+	got := summary(p)
+	expected := "Quiz 1@2025-09-01/HW/CS101\n" +
+		"@/HW/CS101 title is missing; deadline is missing"
+	if got != expected {
+		t.Errorf("rows =\n%s\nwant\n%s", got, expected)
+	}
+
+	if _, err := Parse([]byte(data), "plan.csv", Options{Mapping: Mapping{FieldTitle: "Nope"}}); err == nil {
+		t.Error("a mapping to a missing column was accepted")
+	}
+	if _, err := Parse([]byte("Week;Topic\n1;Intro\n"), "plan.csv", Options{}); err == nil {
+		t.Error("a file without deadlines was accepted")
+	}
+}
+
+func TestParseMarkdownTable(t *testing.T) {
+	data := "# CS 101 schedule\n\n" +
+		"| Course | Assignment | Due |\n" +
+		"|:-------|------------|----:|\n" +
+		"| cs101 | **Project proposal** | Sept 3 |\n" +
+		"| MATH200 | `Problem set 1` | Jan 9 |\n"
+	p := parse(t, data, "syllabus.md", Options{})
+
+	got := summary(p)
+	// January is more than a month before the semester, so it is the next one
+	expected := "Project proposal@2025-09-03/HW/cs101\n" +
+		"Problem set 1@2026-01-09/HW/MATH200"
+	if got != expected {
+		t.Errorf("rows =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestParsePlainTable(t *testing.T) {
+	data := "Schedule\n" +
+		"Title           Deadline       Type\n" +
+		"Reading quiz    Monday, September 8    HW\n" +
+		"Final exam\t2025-12-10\tExam\n"
+	p := parse(t, data, "notes.txt", Options{CourseCode: "HIST110"})
+
+	got := summary(p)
+	expected := "Reading quiz@2025-09-08/HW/HIST110\n" +
+		"Final exam@2025-12-10/Exam/HIST110"
+	if got != expected {
+		t.Errorf("rows =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestParseICS(t *testing.T) {
+	data := "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"SUMMARY:Lab report\\, part 1\r\n" +
+		"DUE;TZID=America/Chicago:20250915T235900\r\n" +
+		"DESCRIPTION:Submit the PDF\\nand the data\r\n" +
+		"END:VTODO\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"SUMMARY:Midterm exa\r\n" +
+		" m\r\n" +
+		"DTSTART;VALUE=DATE:20251020\r\n" +
+		"CATEGORIES:Exam\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	p := parse(t, data, "export", Options{CourseCode: "BIO101"})
+
+	if p.Format != FormatICS {
+		t.Errorf("format = %s", p.Format)
+	}
+	got := summary(p)
+	expected := "Lab report, part 1@2025-09-15/HW/BIO101\n" +
+		"Midterm exam@2025-10-20/Exam/BIO101"
+	if got != expected {
+		t.Errorf("rows =\n%s\nwant\n%s", got, expected)
+	}
+	if p.Rows[0].Todo != "Submit the PDF\nand the data" {
+		t.Errorf("todo = %q", p.Rows[0].Todo)
+	}
+
+	if _, err := Parse([]byte("BEGIN:VCALENDAR\nEND:VCALENDAR\n"), "empty.ics", Options{}); !errors.Is(err, ErrNoRows) {
+		t.Errorf("empty calendar: %v", err)
+	}
+}
+
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitializeSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.Create(&course.LocalCourse{Code: "CS101", Name: "Intro to programming"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestCommitQueuesEveryRow(t *testing.T) {
+	db := newDB(t)
+	data := "Title,Due,Type,Course\nEssay,2025-09-12,hw,cs101\nMidterm,2025-10-14,Exam,CS101\n"
+
+	p := parse(t, data, "s.csv", Options{})
+	if err := p.Check(db); err != nil {
+		t.Fatal(err)
+	}
+	if p.Invalid() != 0 || p.Rows[0].CourseCode != "CS101" || p.Rows[0].Type != "HW" {
+		t.Fatalf("rows = %+v", p.Rows)
+	}
+
+	n, err := Commit(db, p)
+	if err != nil || n != 2 {
+		t.Fatalf("Commit = %d, %v", n, err)
+	}
+	var created []assignment.LocalAssignment
+	db.Order("id").Find(&created)
+	if len(created) != 2 || created[0].Title != "Essay" || created[0].StatusName != DefaultStatus ||
+		created[0].SyncStatus != assignment.SyncStatusPending || !created[0].Deadline.Equal(time.Date(2025, 9, 12, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("created %+v", created)
+	}
+	var updates []models.LocalUpdate
+	db.Order("id").Find(&updates)
+	if len(updates) != 2 || updates[1].EntityID != created[1].ID ||
+		updates[1].Entity != models.Assignment || updates[1].Operation != models.OperationCreate {
+		t.Fatalf("outbox %+v", updates)
+	}
+
+	// Importing the same file again finds the rows imported
+	p = parse(t, data, "s.csv", Options{})
+	if err := p.Check(db); err != nil {
+		t.Fatal(err)
+	}
+	if got := summary(p); strings.Count(got, "already imported") != 2 {
+		t.Errorf("rows =\n%s", got)
+	}
+}
+
+func TestCommitImportsNothingWithInvalidRows(t *testing.T) {
+	db := newDB(t)
+	data := "Title,Due,Type,Course\n" +
+		"Essay,2025-09-12,HW,CS101\n" +
+		"Quiz,2025-09-19,Pop quiz,CS101\n" +
+		"Lab,2025-09-20,HW,CS999\n" +
+		"essay,2025-09-12,HW,CS101\n" +
+		",2025-09-21,HW,CS101\n"
+
+	p := parse(t, data, "s.csv", Options{})
+	_, err := Commit(db, p)
+	if !errors.Is(err, ErrInvalidRows) {
+		t.Fatalf("Commit = %v, want ErrInvalidRows", err)
+	}
+
+	got := summary(p)
+	expected := "Essay@2025-09-12/HW/CS101\n" +
+		`Quiz@2025-09-19/Pop quiz/CS101 unknown type "Pop quiz" (known: HW, Exam)` + "\n" +
+		`Lab@2025-09-20/HW/CS999 unknown course "CS999"` + "\n" +
+		"essay@2025-09-12/HW/CS101 duplicate of line 2\n" +
+		"@2025-09-21/HW/CS101 title is missing"
+	if got != expected {
+		t.Errorf("rows =\n%s\nwant\n%s", got, expected)
+	}
+
+	var assignments, updates int64
+	db.Model(&assignment.LocalAssignment{}).Count(&assignments)
+	db.Model(&models.LocalUpdate{}).Count(&updates)
+	if assignments != 0 || updates != 0 {
+		t.Errorf("%d assignments and %d updates after a failed import", assignments, updates)
+	}
+}
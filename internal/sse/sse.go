@@ -12,6 +12,8 @@ import (
 	"net/http"
 	"sync"
 	"time"
+
+	"unipilot/internal/config"
 )
 
 type SSE struct {
@@ -20,11 +22,19 @@ type SSE struct {
 	mu         sync.Mutex
 	ctx        context.Context
 	cancelFunc context.CancelFunc
+	// lastEventID is sent as Last-Event-ID on reconnect so the server replays missed events
+	lastEventID string
+	// conn numbers the connections, streamCancel closes the current one
+	conn         int
+	streamCancel context.CancelFunc
 }
 
 type Event struct {
+	ID   string
 	Type string
 	Data json.RawMessage
+	// Conn is the number of the connection that delivered the event
+	Conn int
 }
 
 func NewSSE() *SSE {
@@ -37,6 +47,32 @@ func NewSSE() *SSE {
 	}
 }
 
+// SetLastEventID records the ID of the last event applied by the consumer
+func (c *SSE) SetLastEventID(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastEventID = id
+}
+
+// LastEventID returns the ID of the last event applied by the consumer
+func (c *SSE) LastEventID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lastEventID
+}
+
+// Reconnect drops the current connection so the next one resumes from the last
+// event ID. It returns the number of the next connection, events of older ones
+// still in the channel can be told apart by their Conn.
+func (c *SSE) Reconnect() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.streamCancel != nil {
+		c.streamCancel()
+	}
+	return c.conn + 1
+}
+
 // Connect now accepts a context to handle cancellation.
 func (c *SSE) Connect(httpClient *http.Client) {
 	defer func() {
@@ -74,7 +110,16 @@ func (c *SSE) Connect(httpClient *http.Client) {
 }
 
 func (c *SSE) establishAndStream(httpClient *http.Client) error {
-	req, err := http.NewRequestWithContext(c.ctx, "GET", "https://newsroom.dedyn.io/acc-homework/events", nil)
+	ctx, cancel := context.WithCancel(c.ctx)
+	defer cancel()
+
+	c.mu.Lock()
+	c.conn++
+	conn := c.conn
+	c.streamCancel = cancel
+	c.mu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", config.URL("/events"), nil)
 	if err != nil {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
@@ -86,6 +131,9 @@ func (c *SSE) establishAndStream(httpClient *http.Client) error {
 	req.Header.Set("Pragma", "no-cache")
 	// Close connection on completion to prevent reuse
 	req.Close = true
+	if lastEventID := c.LastEventID(); lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
 
 	resp, err := httpClient.Do(req)
 	if err != nil {
@@ -99,10 +147,12 @@ func (c *SSE) establishAndStream(httpClient *http.Client) error {
 
 	log.Println("[SSEClient] Connection established. Streaming events...")
 	reader := bufio.NewReader(resp.Body)
+	var event Event
+	var data []byte
 	for {
 		// Check for context cancellation before each read.
-		if c.ctx.Err() != nil {
-			return c.ctx.Err()
+		if ctx.Err() != nil {
+			return ctx.Err()
 		}
 
 		line, err := reader.ReadBytes('\n')
@@ -113,11 +163,30 @@ func (c *SSE) establishAndStream(httpClient *http.Client) error {
 			return fmt.Errorf("error reading from stream: %w", err)
 		}
 
-		if bytes.HasPrefix(line, []byte("data:")) {
-			data := bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))
+		line = bytes.TrimRight(line, "\r\n")
+
+		switch {
+		case len(line) == 0:
+			// A blank line ends the event
 			if len(data) > 0 {
-				c.events <- Event{Data: data}
+				event.Data = data
+				event.Conn = conn
+				select {
+				case c.events <- event:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+			event, data = Event{}, nil
+		case bytes.HasPrefix(line, []byte("id:")):
+			event.ID = string(bytes.TrimSpace(bytes.TrimPrefix(line, []byte("id:"))))
+		case bytes.HasPrefix(line, []byte("event:")):
+			event.Type = string(bytes.TrimSpace(bytes.TrimPrefix(line, []byte("event:"))))
+		case bytes.HasPrefix(line, []byte("data:")):
+			if data != nil {
+				data = append(data, '\n')
 			}
+			data = append(data, bytes.TrimSpace(bytes.TrimPrefix(line, []byte("data:")))...)
 		}
 	}
 }
@@ -139,3 +208,40 @@ func (c *SSE) StopListener() {
 		c.cancelFunc() // This cancels the context passed to sseClient.Connect
 	}
 }
+
+// ErrNoHandler is returned by Dispatch for events without a registered handler
+var ErrNoHandler = errors.New("no handler for event")
+
+// Handler processes one event, an error means it wasn't applied
+type Handler func(Event) error
+
+// Mux dispatches events to handlers registered by event name
+type Mux struct {
+	handlers map[string]Handler
+}
+
+func NewMux() *Mux {
+	return &Mux{handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler for events sent with the given name
+func (m *Mux) Handle(name string, handler Handler) {
+	m.handlers[name] = handler
+}
+
+// Dispatch calls the handler registered for the event name. Unknown events and
+// handlers that fail or panic return an error, a bad event never stops the stream.
+func (m *Mux) Dispatch(event Event) (err error) {
+	handler, ok := m.handlers[event.Type]
+	if !ok {
+		return fmt.Errorf("%w %q (id %s)", ErrNoHandler, event.Type, event.ID)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("handler for event %q (id %s) panicked: %v", event.Type, event.ID, r)
+		}
+	}()
+
+	return handler(event)
+}
@@ -0,0 +1,49 @@
+package sse
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMuxDispatchesByName(t *testing.T) {
+	m := NewMux()
+	var got []string
+	for _, name := range []string{"course.update", "note.update"} {
+		name := name
+		m.Handle(name, func(e Event) error {
+			got = append(got, name+" "+e.ID)
+			return nil
+		})
+	}
+
+	if err := m.Dispatch(Event{ID: "1", Type: "note.update"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Dispatch(Event{ID: "2", Type: "course.update"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "note.update 1,course.update 2" {
+		t.Errorf("handled %v", got)
+	}
+
+	err := m.Dispatch(Event{ID: "3", Type: "course.archive"})
+	if !errors.Is(err, ErrNoHandler) {
+		t.Errorf("unknown event: %v, want ErrNoHandler", err)
+	}
+}
+
+func TestMuxRecoversFromPanics(t *testing.T) {
+	m := NewMux()
+	failure := errors.New("not applied")
+	m.Handle("course.update", func(Event) error { panic("bad data") })
+	m.Handle("note.update", func(Event) error { return failure })
+
+	err := m.Dispatch(Event{ID: "1", Type: "course.update"})
+	if err == nil || !strings.Contains(err.Error(), "bad data") {
+		t.Errorf("panicking handler: %v", err)
+	}
+	if err := m.Dispatch(Event{ID: "2", Type: "note.update"}); !errors.Is(err, failure) {
+		t.Errorf("failing handler: %v", err)
+	}
+}
@@ -2,9 +2,12 @@ package storage
 
 import (
 	"encoding/json"
+	"errors"
 	"os"
 	"path/filepath"
 	"sync"
+
+	"unipilot/internal/secrets"
 )
 
 type LocalCredentials struct {
@@ -28,12 +31,7 @@ func GetCurrentUser() (*LocalCredentials, error) {
 		return credentials, nil
 	}
 
-	path, err := getCredsPath()
-	if err != nil {
-		return nil, err
-	}
-
-	data, err := os.ReadFile(path)
+	data, err := secrets.Default().Get(credentialsKey)
 	if err != nil {
 		return nil, err
 	}
@@ -55,7 +53,11 @@ func GetCurrentUserID() (uint, error) {
 	return creds.User.UserID, nil
 }
 
-func getCredsPath() (string, error) {
+// credentialsKey is the key of the logged in user in the secret store
+const credentialsKey = "credentials"
+
+// legacyCredsPath returns where the logged in user was kept before the secret store
+func legacyCredsPath() (string, error) {
 	configDir, err := os.UserConfigDir()
 	if err != nil {
 		return "", err
@@ -63,6 +65,32 @@ func getCredsPath() (string, error) {
 	return filepath.Join(configDir, "acc-homework", "credentials.json"), nil
 }
 
+// MigrateCredentials moves the plaintext credentials file to the secret store and
+// deletes it. It runs on launch, a store already holding credentials wins.
+func MigrateCredentials() error {
+	credLock.Lock()
+	defer credLock.Unlock()
+
+	path, err := legacyCredsPath()
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := secrets.Default().Get(credentialsKey); errors.Is(err, secrets.ErrNotFound) {
+		if err := secrets.Default().Set(credentialsKey, data); err != nil {
+			return err
+		}
+	}
+	return os.Remove(path)
+}
+
 func StoreCredentials(userID uint, username string) error {
 	credLock.Lock()
 	defer credLock.Unlock()
@@ -78,32 +106,22 @@ func StoreCredentials(userID uint, username string) error {
 		},
 	}
 
-	path, err := getCredsPath()
+	data, err := json.Marshal(creds)
 	if err != nil {
 		return err
 	}
 
-	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
-		return err
-	}
-
-	data, err := json.Marshal(creds)
-	if err != nil {
+	if err := secrets.Default().Set(credentialsKey, data); err != nil {
 		return err
 	}
-
-	return os.WriteFile(path, data, 0600)
+	credentials = &creds
+	return nil
 }
 
 func ClearCredentials() error {
 	credLock.Lock()
 	defer credLock.Unlock()
 
-	path, err := getCredsPath()
-	if err != nil {
-		return err
-	}
-
 	credentials = nil
-	return os.Remove(path)
+	return secrets.Default().Delete(credentialsKey)
 }
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"sync"
+	"unipilot/internal/config"
 	"unipilot/internal/models"
 	"unipilot/internal/models/assignment"
 	"unipilot/internal/models/course"
@@ -18,7 +19,7 @@ import (
 
 var (
 	dbLock      sync.Mutex
-	dbInstances = make(map[uint]*gorm.DB)
+	dbInstances = make(map[string]*gorm.DB) // by database path
 )
 
 func GetLocalDB() (*gorm.DB, uint, error) {
@@ -30,17 +31,17 @@ func GetLocalDB() (*gorm.DB, uint, error) {
 		return nil, 0, fmt.Errorf("failed to get current user ID: %w", err)
 	}
 
-	// Return cached instance if available
-	if db, exists := dbInstances[userID]; exists {
-		return db, userID, nil
-	}
-
 	// Determine database path
 	dbPath, err := getDBPath(userID)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to get DB path: %w", err)
 	}
 
+	// Return cached instance if available
+	if db, exists := dbInstances[dbPath]; exists {
+		return db, userID, nil
+	}
+
 	// Ensure directory exists
 	dbDir := filepath.Dir(dbPath)
 	if err := os.MkdirAll(dbDir, 0755); err != nil {
@@ -68,7 +69,7 @@ func GetLocalDB() (*gorm.DB, uint, error) {
 	}
 
 	// Cache the instance
-	dbInstances[userID] = db
+	dbInstances[dbPath] = db
 
 	return db, userID, nil
 }
@@ -85,12 +86,13 @@ func getDBPath(userID uint) (string, error) {
 		return "", fmt.Errorf("failed to get config directory: %w", err)
 	}
 
-	return filepath.Join(
-		configDir,
-		"acc-homework",
-		"data",
-		fmt.Sprintf("user_%d.db", userID),
-	), nil
+	// User IDs are only unique per server, other profiles keep their databases apart
+	dataDir := filepath.Join(configDir, "acc-homework", "data")
+	if profile := config.Active().Name; profile != config.ProfileProd {
+		dataDir = filepath.Join(dataDir, profile)
+	}
+
+	return filepath.Join(dataDir, fmt.Sprintf("user_%d.db", userID)), nil
 }
 
 func InitializeSchema(db *gorm.DB) error {
@@ -101,7 +103,11 @@ func InitializeSchema(db *gorm.DB) error {
 		&models.LocalAssignmentStatus{},
 		&assignment.LocalAssignment{},
 		&models.LocalUpdate{},
+		&models.LocalSyncState{},
+		&models.LocalConflict{},
 		&document.LocalDocument{},
+		&document.LocalBlob{},
+		&document.DocumentStorageInfo{},
 		&note.LocalNote{},
 	)
 
@@ -9,6 +9,8 @@ import (
 	"gorm.io/gorm/schema"
 )
 
+// GetRemoteDB connects to the server database configured in .env, for scripts. The
+// server itself gets its DSN from config.LoadServer and uses OpenRemoteDB.
 func GetRemoteDB() (*gorm.DB, error) {
 	viper.SetConfigFile(".env")
 	err := viper.ReadInConfig()
@@ -26,7 +28,12 @@ func GetRemoteDB() (*gorm.DB, error) {
 	psqlInfo := fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=require",
 		host, port, user, password, dbname)
 
-	db, err := gorm.Open(postgres.Open(psqlInfo), &gorm.Config{
+	return OpenRemoteDB(psqlInfo)
+}
+
+// OpenRemoteDB connects to the server database
+func OpenRemoteDB(dsn string) (*gorm.DB, error) {
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{
 		NamingStrategy: schema.NamingStrategy{
 			TablePrefix: "public.",
 		},
@@ -0,0 +1,95 @@
+package sync
+
+import (
+	"fmt"
+
+	"unipilot/internal/models"
+	"unipilot/internal/services/merge"
+
+	"gorm.io/gorm"
+)
+
+// Conflicts returns the field changes the server rejected, oldest first
+func Conflicts(db *gorm.DB) ([]models.LocalConflict, error) {
+	var conflicts []models.LocalConflict
+	err := db.Order("id ASC").Find(&conflicts).Error
+	return conflicts, err
+}
+
+// PreviewMerge returns the three-way merge of a conflict's text, with conflict
+// markers where both sides changed the same lines
+func PreviewMerge(db *gorm.DB, id uint) (string, bool, error) {
+	var conflict models.LocalConflict
+	if err := db.First(&conflict, id).Error; err != nil {
+		return "", false, fmt.Errorf("failed to get conflict %d: %w", id, err)
+	}
+
+	merged, ok := merge.Text(conflict.Base, conflict.Mine, conflict.Theirs)
+	return merged, ok, nil
+}
+
+// ResolveConflict applies the user's choice for a conflict:
+//   - keep-mine sends the local value again, this time based on the server value
+//   - keep-theirs takes the server value locally
+//   - merge-text writes the merged text locally and sends it. The merge is computed
+//     when value is empty and fails if both sides changed the same lines.
+func ResolveConflict(db *gorm.DB, id uint, resolution, value string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var conflict models.LocalConflict
+		if err := tx.First(&conflict, id).Error; err != nil {
+			return fmt.Errorf("failed to get conflict %d: %w", id, err)
+		}
+
+		table := localTable(conflict.Entity)
+		if table == "" {
+			return fmt.Errorf("unknown conflict entity: %s", conflict.Entity)
+		}
+
+		switch resolution {
+		case models.ResolutionKeepTheirs:
+			if err := setLocalColumn(tx, table, conflict.EntityID, conflict.Column, conflict.Theirs); err != nil {
+				return err
+			}
+
+		case models.ResolutionKeepMine:
+			if err := EnqueueUpdate(tx, conflict.Entity, conflict.EntityID, conflict.Column, conflict.Theirs, conflict.Mine); err != nil {
+				return err
+			}
+
+		case models.ResolutionMergeText:
+			if value == "" {
+				merged, ok := merge.Text(conflict.Base, conflict.Mine, conflict.Theirs)
+				if !ok {
+					return fmt.Errorf("both sides changed the same lines of %s, edit the merged text", conflict.Column)
+				}
+				value = merged
+			}
+			if err := setLocalColumn(tx, table, conflict.EntityID, conflict.Column, value); err != nil {
+				return err
+			}
+			if err := EnqueueUpdate(tx, conflict.Entity, conflict.EntityID, conflict.Column, conflict.Theirs, value); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown resolution: %s", resolution)
+		}
+
+		if err := tx.Unscoped().Delete(&conflict).Error; err != nil {
+			return fmt.Errorf("failed to remove conflict %d: %w", id, err)
+		}
+
+		return markSynced(tx, conflict.Entity, conflict.EntityID)
+	})
+}
+
+// setLocalColumn writes a resolved value without queueing it for the server
+func setLocalColumn(tx *gorm.DB, table string, id uint, column, value string) error {
+	if !tx.Migrator().HasColumn(table, column) {
+		return fmt.Errorf("unknown column %s on %s", column, table)
+	}
+	if err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s = ? WHERE id = ?", table, column), value, id).Error; err != nil {
+		return fmt.Errorf("failed to write resolved %s: %w", column, err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"unipilot/internal/client"
+	"unipilot/internal/models/document"
+	"unipilot/internal/services/blobstore"
+	"unipilot/internal/services/fileops"
+
+	"gorm.io/gorm"
+)
+
+// replayDocumentCreate stores the metadata of a local document on the server then
+// uploads its file. Both steps are safe to repeat when a previous attempt failed halfway.
+func (o *Outbox) replayDocumentCreate(id uint) error {
+	var ld document.LocalDocument
+	if err := o.db.Unscoped().First(&ld, id).Error; err != nil {
+		return fmt.Errorf("failed to load document %d: %w", id, err)
+	}
+
+	// Deleted before it reached the server, the queued delete has nothing to remove either
+	if ld.DeletedAt.Valid {
+		return nil
+	}
+
+	metadata := map[string]interface{}{
+		"assignment_id": ld.AssignmentID,
+		"local_id":      ld.ID,
+		"type":          string(ld.Type),
+		"file_name":     ld.FileName,
+		"file_type":     ld.FileType,
+		"file_size":     ld.FileSize,
+		"version":       ld.Version,
+	}
+	if ld.ParentDocID != nil {
+		metadata["parent_local_id"] = *ld.ParentDocID
+	}
+
+	remote, err := client.CreateDocumentMetadata(metadata)
+	if err != nil {
+		return err
+	}
+
+	if ld.RemoteID != remote.ID {
+		if err := o.setRemoteID(&document.LocalDocument{}, id, remote.ID); err != nil {
+			return err
+		}
+	}
+
+	// Without the file there is nothing to upload, retrying would block the outbox for good
+	if !ld.HasLocalFile {
+		log.Printf("[Outbox] Document %d has no local file, only its metadata was sent", id)
+		return nil
+	}
+
+	file, err := os.Open(ld.FilePath)
+	if os.IsNotExist(err) {
+		log.Printf("[Outbox] File of document %d is missing, only its metadata was sent", id)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open file of document %d: %w", id, err)
+	}
+	defer file.Close()
+
+	hash := ld.Hash
+	if hash == "" {
+		// Stored before documents were hashed
+		if hash, _, err = blobstore.Hash(file); err != nil {
+			return fmt.Errorf("failed to hash file of document %d: %w", id, err)
+		}
+		if _, err := file.Seek(0, 0); err != nil {
+			return fmt.Errorf("failed to rewind file of document %d: %w", id, err)
+		}
+	}
+
+	if remote.Hash == hash {
+		return nil
+	}
+
+	return client.UploadDocumentBlob(remote.ID, hash, file)
+}
+
+// EnsureLocalFile downloads the file of a document when this device doesn't have it yet.
+// The file goes to the local content-addressed store like uploaded ones.
+func EnsureLocalFile(db *gorm.DB, ld *document.LocalDocument) error {
+	if ld.HasLocalFile && ld.FilePath != "" {
+		if _, err := os.Stat(ld.FilePath); err == nil {
+			return nil
+		}
+	}
+
+	if ld.RemoteID == 0 {
+		return fmt.Errorf("document %s has not been uploaded yet", ld.FileName)
+	}
+
+	// The store only keeps the file once the whole download matched the server hash.
+	// The download runs outside a transaction, the local database has a single connection.
+	reader, writer := io.Pipe()
+	go func() {
+		_, err := client.DownloadDocumentBlob(ld.RemoteID, writer)
+		writer.CloseWithError(err)
+	}()
+
+	hash, size, path, err := fileops.PutFile(reader)
+	reader.Close()
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", ld.FileName, err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := fileops.AcquireBlob(tx, hash, size); err != nil {
+			return err
+		}
+
+		// A missing file still held a reference, give it back now the new one is taken
+		if ld.HasLocalFile && ld.Hash != "" {
+			if err := fileops.ReleaseBlob(tx, ld.Hash); err != nil {
+				return err
+			}
+		}
+
+		return tx.Model(&document.LocalDocument{}).Where("id = ?", ld.ID).Updates(map[string]interface{}{
+			"file_path":      path,
+			"hash":           hash,
+			"file_size":      size,
+			"has_local_file": true,
+			"last_sync_at":   time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record download of %s: %w", ld.FileName, err)
+	}
+
+	ld.FilePath, ld.Hash, ld.FileSize, ld.HasLocalFile = path, hash, size, true
+
+	if err := document.UpdateLocalStorageInfo(ld.UserID, db); err != nil {
+		log.Printf("[Sync] Failed to update storage info: %v", err)
+	}
+
+	log.Printf("[Sync] Downloaded %s", ld.FileName)
+	return nil
+}
+
+// DownloadPinned downloads the files of pinned documents this device doesn't have.
+// Files that are not on the server yet are skipped until their upload lands.
+func DownloadPinned(db *gorm.DB) error {
+	var documents []document.LocalDocument
+	if err := db.Where("pinned = ? AND has_local_file = ? AND remote_id <> 0", true, false).Find(&documents).Error; err != nil {
+		return fmt.Errorf("failed to list pinned documents: %w", err)
+	}
+
+	for i := range documents {
+		err := EnsureLocalFile(db, &documents[i])
+
+		var statusErr *client.StatusError
+		if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -1,101 +1,359 @@
 package sync
 
 import (
+	"errors"
 	"fmt"
+	"log"
 	"strconv"
 	"time"
 	"unipilot/internal/client"
+	"unipilot/internal/models"
 	"unipilot/internal/models/assignment"
 	"unipilot/internal/models/course"
+	"unipilot/internal/models/document"
+	"unipilot/internal/models/note"
+	"unipilot/internal/services/fileops"
+	"unipilot/internal/storage"
 
 	"gorm.io/gorm"
 )
 
-func MigrateAssignments(db *gorm.DB) error {
+// changesCursorKey is the LocalSyncState key holding the last cursor returned by the server
+const changesCursorKey = "changes_cursor"
 
-	count := 0
+// PullChanges fetches everything that changed on the server since the last pull and
+// applies creates, updates and tombstones to the local tables. The new cursor is only
+// stored once every change is applied, so an interrupted pull is simply retried.
+func PullChanges(db *gorm.DB) error {
 
-	remoteAssignments, err := client.GetAssignments()
+	cursor, err := models.GetSyncState(db, changesCursorKey)
+	if err != nil {
+		return fmt.Errorf("failed to read sync cursor: %w", err)
+	}
+
+	changes, err := client.GetChanges(cursor)
 	if err != nil {
-		fmt.Printf("ERROR : %s", err)
 		return err
 	}
 
-	for _, ra := range remoteAssignments {
+	userID, err := storage.GetCurrentUserID()
+	if err != nil {
+		return fmt.Errorf("failed to get current user ID: %w", err)
+	}
 
-		deadline, err := time.Parse(time.DateOnly, ra["deadline"])
-		if err != nil {
+	err = db.Transaction(func(tx *gorm.DB) error {
+		// Courses first, assignments and notes reference them by code
+		for _, rc := range changes.Courses {
+			if err := ApplyCourse(tx, rc); err != nil {
+				return err
+			}
+		}
 
-			return fmt.Errorf("Error formating deadline : %s", err)
+		for _, ra := range changes.Assignments {
+			if err := ApplyAssignment(tx, ra); err != nil {
+				return err
+			}
 		}
 
-		remote_id, err := strconv.Atoi(ra["id"])
-		if err != nil {
-			return fmt.Errorf("Error formating remote_id : %s", err)
+		for _, rn := range changes.Notes {
+			if err := ApplyNote(tx, rn); err != nil {
+				return err
+			}
 		}
 
-		localAssignment := assignment.LocalAssignment{
-			RemoteID:   uint(remote_id),
-			Title:      ra["title"],
-			Todo:       ra["todo"],
-			Deadline:   deadline,
-			Link:       ra["link"],
-			CourseCode: ra["course_code"],
-			TypeName:   ra["type"],
-			StatusName: ra["status"],
-			NotionID:   ra["notion_id"],
-			SyncStatus: assignment.SyncStatusSynced,
+		for _, rd := range changes.Documents {
+			if err := ApplyDocument(tx, rd, userID); err != nil {
+				return err
+			}
 		}
 
-		if err := db.First(&localAssignment, "remote_id = ?", remote_id).Error; err == nil {
-			continue
+		return models.SetSyncState(tx, changesCursorKey, changes.Cursor)
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[Sync] Pulled %d assignments, %d courses, %d notes, %d documents",
+		len(changes.Assignments), len(changes.Courses), len(changes.Notes), len(changes.Documents))
+
+	return nil
+}
+
+func parseRemoteID(m map[string]string) (uint, error) {
+	remoteID, err := strconv.Atoi(m["id"])
+	if err != nil {
+		return 0, fmt.Errorf("Error formating remote_id : %s", err)
+	}
+	return uint(remoteID), nil
+}
+
+// adoptLocal loads into row the local record the server row was created from, when
+// that record never got its remote ID. Only rows this device created carry its local IDs.
+func adoptLocal(tx *gorm.DB, row interface{}, remote map[string]string) error {
+	id, err := strconv.Atoi(remote["local_id"])
+	if err != nil || id == 0 || remote["device_id"] != client.DeviceID() {
+		return gorm.ErrRecordNotFound
+	}
+	return tx.Unscoped().Where("id = ? AND remote_id = 0", id).First(row).Error
+}
+
+// hasPendingUpdates reports whether the outbox still holds changes of the local record.
+// The server row doesn't include them yet, applying it would undo them.
+func hasPendingUpdates(tx *gorm.DB, entity models.Entity, id uint) (bool, error) {
+	var count int64
+	err := tx.Model(&models.LocalUpdate{}).
+		Where("entity = ? AND entity_id = ? AND status = ?", entity, id, models.UpdateStatusPending).
+		Count(&count).Error
+	if err != nil {
+		return false, fmt.Errorf("failed to count outbox entries: %w", err)
+	}
+	return count > 0, nil
+}
+
+// ApplyAssignment applies an assignment row from the server, sent by delta sync or an event.
+// Rows with local changes that are not pushed yet are left alone.
+func ApplyAssignment(tx *gorm.DB, ra map[string]string) error {
+	remoteID, err := parseRemoteID(ra)
+	if err != nil {
+		return err
+	}
+
+	var la assignment.LocalAssignment
+	err = tx.Unscoped().Where("remote_id = ?", remoteID).First(&la).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Created here but the response with the remote ID was lost, adopt the local row
+		err = adoptLocal(tx, &la, ra)
+	}
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if ra["deleted_at"] != "" {
+		if found && !la.DeletedAt.Valid {
+			return tx.Delete(&la).Error
 		}
+		return nil
+	}
 
-		if err := db.Create(&localAssignment).Error; err != nil {
-			count++
+	// Local changes that have not been pushed yet win, the outbox will send them
+	if found && la.SyncStatus == assignment.SyncStatusPending {
+		return nil
+	}
+	if found {
+		if pending, err := hasPendingUpdates(tx, models.Assignment, la.ID); err != nil || pending {
 			return err
 		}
-		count++
 	}
 
-	return nil
-}
+	deadline, err := time.Parse(time.DateOnly, ra["deadline"])
+	if err != nil {
+		return fmt.Errorf("Error formating deadline : %s", err)
+	}
 
-func MigrateCourses(db *gorm.DB) error {
+	la.RemoteID = remoteID
+	la.Title = ra["title"]
+	la.Todo = ra["todo"]
+	la.Deadline = deadline
+	la.Link = ra["link"]
+	la.CourseCode = ra["course_code"]
+	la.TypeName = ra["type"]
+	la.StatusName = ra["status"]
+	la.NotionID = ra["notion_id"]
+	la.Priority = ra["priority"]
+	la.Completed = ra["completed"] == "true"
+	la.SyncStatus = assignment.SyncStatusSynced
+	la.DeletedAt = gorm.DeletedAt{}
 
-	count := 0
+	if !found {
+		return tx.Create(&la).Error
+	}
+	return tx.Unscoped().Omit("Course", "Type", "Status", "Documents").Save(&la).Error
+}
 
-	remoteCourses, err := client.GetCourses()
+// ApplyCourse applies a course row from the server, sent by delta sync or an event.
+// Rows with local changes that are not pushed yet are left alone.
+func ApplyCourse(tx *gorm.DB, rc map[string]string) error {
+	remoteID, err := parseRemoteID(rc)
 	if err != nil {
-		fmt.Printf("ERROR : %s", err)
 		return err
 	}
 
-	for _, rc := range remoteCourses {
-		remote_id, err := strconv.Atoi(rc["id"])
-		if err != nil {
-			return fmt.Errorf("Error formating remote_id : %s", err)
+	var lc course.LocalCourse
+	err = tx.Unscoped().Where("remote_id = ?", remoteID).First(&lc).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// Course codes are unique per user, adopt a local course created before it had a remote ID
+		err = tx.Where("code = ? AND remote_id = 0", rc["code"]).First(&lc).Error
+	}
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if rc["deleted_at"] != "" {
+		if found && !lc.DeletedAt.Valid {
+			return tx.Delete(&lc).Error
 		}
-		localCourse := course.LocalCourse{
-			RemoteID:   uint(remote_id),
-			Code:       rc["code"],
-			Name:       rc["name"],
-			NotionID:   rc["notion_id"],
-			Duration:   rc["duration"],
-			RoomNumber: rc["room_number"],
-			SyncStatus: course.SyncStatusSynced,
+		return nil
+	}
+
+	if found && lc.SyncStatus == course.SyncStatusPending {
+		return nil
+	}
+	if found {
+		if pending, err := hasPendingUpdates(tx, models.EntityCourse, lc.ID); err != nil || pending {
+			return err
 		}
+	}
+
+	credits, _ := strconv.Atoi(rc["credits"])
+	startDate, _ := time.Parse(time.DateOnly, rc["start_date"])
+	endDate, _ := time.Parse(time.DateOnly, rc["end_date"])
+
+	lc.RemoteID = remoteID
+	lc.Code = rc["code"]
+	lc.Name = rc["name"]
+	lc.NotionID = rc["notion_id"]
+	lc.Duration = rc["duration"]
+	lc.RoomNumber = rc["room_number"]
+	lc.Color = rc["color"]
+	lc.StartDate = startDate
+	lc.EndDate = endDate
+	lc.Credits = credits
+	lc.Schedule = rc["schedule"]
+	lc.Semester = rc["semester"]
+	lc.Instructor = rc["instructor"]
+	lc.InstructorEmail = rc["instructor_email"]
+	lc.SyncStatus = course.SyncStatusSynced
+	lc.DeletedAt = gorm.DeletedAt{}
 
-		if err := db.First(&localCourse, "remote_id = ?", remote_id).Error; err == nil {
-			continue
+	if !found {
+		return tx.Create(&lc).Error
+	}
+	return tx.Unscoped().Save(&lc).Error
+}
+
+// ApplyNote applies a note row from the server, sent by delta sync or an event.
+// Rows with local changes that are not pushed yet are left alone.
+func ApplyNote(tx *gorm.DB, rn map[string]string) error {
+	remoteID, err := parseRemoteID(rn)
+	if err != nil {
+		return err
+	}
+
+	var ln note.LocalNote
+	err = tx.Unscoped().Where("remote_id = ?", remoteID).First(&ln).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		err = adoptLocal(tx, &ln, rn)
+	}
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if rn["deleted_at"] != "" {
+		if found && !ln.DeletedAt.Valid {
+			return tx.Delete(&ln).Error
 		}
+		return nil
+	}
 
-		if err := db.Create(&localCourse).Error; err != nil {
-			count++
+	if found && ln.SyncStatus == note.SyncStatusPending {
+		return nil
+	}
+	if found {
+		if pending, err := hasPendingUpdates(tx, models.EntityNote, ln.ID); err != nil || pending {
 			return err
 		}
-		count++
 	}
 
-	return nil
+	ln.RemoteID = remoteID
+	ln.CourseCode = rn["course_code"]
+	ln.Title = rn["title"]
+	ln.Subject = rn["subject"]
+	ln.Content = rn["content"]
+	ln.Keywords = rn["keywords"]
+	ln.Videos = rn["videos"]
+	ln.SyncStatus = note.SyncStatusSynced
+	ln.DeletedAt = gorm.DeletedAt{}
+
+	if !found {
+		return tx.Create(&ln).Error
+	}
+	return tx.Unscoped().Omit("Course").Save(&ln).Error
+}
+
+// ApplyDocument applies document metadata from the server. Documents of assignments
+// this device doesn't have are skipped, new ones are recorded without a local file.
+func ApplyDocument(tx *gorm.DB, rd map[string]string, userID uint) error {
+	remoteID, err := parseRemoteID(rd)
+	if err != nil {
+		return err
+	}
+
+	uploaderID, _ := strconv.Atoi(rd["user_id"])
+	localID, _ := strconv.Atoi(rd["local_id"])
+
+	var ld document.LocalDocument
+	err = tx.Unscoped().Where("remote_id = ?", remoteID).First(&ld).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) && uint(uploaderID) == userID && rd["device_id"] == client.DeviceID() {
+		// Documents uploaded from this device before their metadata ID was recorded
+		err = tx.Where("id = ? AND remote_id = 0 AND file_name = ?", localID, rd["file_name"]).First(&ld).Error
+	}
+	found := err == nil
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	if rd["deleted_at"] != "" {
+		if found && !ld.DeletedAt.Valid {
+			if err := fileops.ReleaseDocumentFile(tx, &ld); err != nil {
+				log.Printf("[Sync] Failed to remove file of deleted document %d: %v", ld.ID, err)
+			}
+			return tx.Delete(&ld).Error
+		}
+		return nil
+	}
+
+	// Map the server assignment to the local one, skip documents of assignments we don't have
+	assignmentRemoteID, err := strconv.Atoi(rd["assignment_remote_id"])
+	if err != nil {
+		return nil
+	}
+	var la assignment.LocalAssignment
+	if err := tx.Where("remote_id = ?", assignmentRemoteID).First(&la).Error; err != nil {
+		return nil
+	}
+
+	fileSize, _ := strconv.ParseInt(rd["file_size"], 10, 64)
+	version, _ := strconv.Atoi(rd["version"])
+
+	ld.RemoteID = remoteID
+	ld.AssignmentID = la.ID
+	ld.UserID = uint(uploaderID)
+	ld.Type = document.DocumentType(rd["type"])
+	ld.FileName = rd["file_name"]
+	ld.FileType = rd["file_type"]
+	ld.FileSize = fileSize
+	ld.Version = version
+	ld.IsOriginal = rd["is_original"] == "true"
+	// The hash names the file this device holds a reference on, only change it without one
+	if !ld.HasLocalFile {
+		ld.Hash = rd["hash"]
+	}
+	ld.ParentDocID = nil
+	if parentRemoteID, err := strconv.Atoi(rd["parent_doc_id"]); err == nil {
+		var parent document.LocalDocument
+		if err := tx.Unscoped().Where("remote_id = ?", parentRemoteID).First(&parent).Error; err == nil {
+			ld.ParentDocID = &parent.ID
+		}
+	}
+	now := time.Now()
+	ld.LastSyncAt = &now
+	ld.DeletedAt = gorm.DeletedAt{}
+
+	if !found {
+		return tx.Create(&ld).Error
+	}
+	return tx.Unscoped().Omit("ParentDoc", "Versions").Save(&ld).Error
 }
@@ -0,0 +1,502 @@
+package sync
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	gosync "sync"
+	"time"
+
+	"unipilot/internal/client"
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/note"
+	"unipilot/internal/network"
+
+	"gorm.io/gorm"
+)
+
+const (
+	outboxInterval = 30 * time.Second
+	pullInterval   = 5 * time.Minute
+	backoffBase    = 2 * time.Second
+	backoffMax     = 10 * time.Minute
+)
+
+// ErrBackingOff is returned by Flush when the oldest pending entry waits for its next
+// attempt, so local changes are still unpushed
+var ErrBackingOff = errors.New("outbox entries are waiting to be retried")
+
+// ErrNotOnServer is returned when replaying a change of a record the server never
+// created, the change can't be sent
+var ErrNotOnServer = errors.New("record has no server ID")
+
+// Outbox replays the pending LocalUpdate rows to the server, in order, in a background worker.
+// Local writes commit immediately and only record their change here, so the app keeps working offline.
+type Outbox struct {
+	db       *gorm.DB
+	mu       gosync.Mutex
+	wake     chan struct{}
+	stopChan chan struct{}
+	stopOnce gosync.Once
+	lastPull time.Time
+}
+
+func NewOutbox(db *gorm.DB) *Outbox {
+	return &Outbox{
+		db:       db,
+		wake:     make(chan struct{}, 1),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Enqueue records a local change in the outbox. It should be called with the
+// same transaction that wrote the change so both commit or roll back together.
+func Enqueue(tx *gorm.DB, entity models.Entity, op models.Operation, entityID uint, column, value string) error {
+	update := &models.LocalUpdate{
+		Entity:        entity,
+		EntityID:      entityID,
+		Operation:     op,
+		Column:        column,
+		Value:         value,
+		Status:        models.UpdateStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+
+	if err := tx.Create(update).Error; err != nil {
+		return fmt.Errorf("failed to queue %s %s: %w", entity, op, err)
+	}
+	return nil
+}
+
+// EnqueueUpdate records a column change along with the value it replaced locally,
+// so the server can detect that someone else changed the column in the meantime.
+func EnqueueUpdate(tx *gorm.DB, entity models.Entity, entityID uint, column, base, value string) error {
+	update := &models.LocalUpdate{
+		Entity:        entity,
+		EntityID:      entityID,
+		Operation:     models.OperationUpdate,
+		Column:        column,
+		Value:         value,
+		Base:          base,
+		CheckBase:     true,
+		Status:        models.UpdateStatusPending,
+		NextAttemptAt: time.Now(),
+	}
+
+	if err := tx.Create(update).Error; err != nil {
+		return fmt.Errorf("failed to queue %s update: %w", entity, err)
+	}
+	return nil
+}
+
+// Start launches the background worker
+func (o *Outbox) Start() {
+	go func() {
+		log.Println("[Outbox] Starting worker...")
+		ticker := time.NewTicker(outboxInterval)
+		defer ticker.Stop()
+
+		for {
+			if network.IsOnline() {
+				if err := o.Flush(); err != nil {
+					log.Printf("[Outbox] Replay paused: %v", err)
+				} else if time.Since(o.lastPull) >= pullInterval {
+					// Pull only once our own changes are on the server
+					if err := PullChanges(o.db); err != nil {
+						log.Printf("[Outbox] Pull failed: %v", err)
+					} else {
+						o.lastPull = time.Now()
+					}
+
+					if err := DownloadPinned(o.db); err != nil {
+						log.Printf("[Outbox] Downloading pinned documents failed: %v", err)
+					}
+				}
+			}
+
+			select {
+			case <-o.stopChan:
+				log.Println("[Outbox] Stop signal received, shutting down.")
+				return
+			case <-o.wake:
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop signals the worker to terminate
+func (o *Outbox) Stop() {
+	o.stopOnce.Do(func() {
+		close(o.stopChan)
+	})
+}
+
+// Notify wakes the worker so a new entry is replayed without waiting for the next tick
+func (o *Outbox) Notify() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Pending returns the entries that have not been acknowledged by the server yet
+func (o *Outbox) Pending() ([]models.LocalUpdate, error) {
+	var updates []models.LocalUpdate
+	err := o.db.Where("status = ?", models.UpdateStatusPending).Order("id ASC").Find(&updates).Error
+	return updates, err
+}
+
+// Flush replays pending entries in order until the outbox is empty or an entry fails.
+// A failing entry blocks the ones behind it so the server sees changes in the order they were made.
+// It returns ErrBackingOff when entries are left waiting for their next attempt.
+func (o *Outbox) Flush() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for {
+		var update models.LocalUpdate
+		err := o.db.Where("status = ?", models.UpdateStatusPending).Order("id ASC").First(&update).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read outbox: %w", err)
+		}
+
+		if time.Now().Before(update.NextAttemptAt) {
+			return fmt.Errorf("%w, next attempt at %s", ErrBackingOff, update.NextAttemptAt.Format(time.TimeOnly))
+		}
+
+		if err := o.replay(&update); err != nil {
+			var conflict *client.ConflictError
+			if errors.As(err, &conflict) {
+				log.Printf("[Outbox] %s #%d column %s changed on the server, waiting for resolution", update.Entity, update.EntityID, update.Column)
+				if err := o.recordConflict(&update, conflict); err != nil {
+					return err
+				}
+				continue
+			}
+
+			if client.IsPermanentError(err) || errors.Is(err, ErrNotOnServer) {
+				log.Printf("[Outbox] %s %s #%d rejected by server: %v", update.Entity, update.Operation, update.EntityID, err)
+				if err := o.markFailed(&update, err); err != nil {
+					return err
+				}
+				continue
+			}
+
+			update.Attempts++
+			update.NextAttemptAt = time.Now().Add(Backoff(update.Attempts))
+			update.LastError = err.Error()
+			if err := o.db.Save(&update).Error; err != nil {
+				return fmt.Errorf("failed to reschedule outbox entry %d: %w", update.ID, err)
+			}
+			return err
+		}
+
+		if err := o.db.Unscoped().Delete(&update).Error; err != nil {
+			return fmt.Errorf("failed to remove outbox entry %d: %w", update.ID, err)
+		}
+
+		if err := markSynced(o.db, update.Entity, update.EntityID); err != nil {
+			return err
+		}
+	}
+}
+
+// Backoff returns the delay before the given retry attempt
+func Backoff(attempts int) time.Duration {
+	delay := backoffBase
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= backoffMax {
+			return backoffMax
+		}
+	}
+	return delay
+}
+
+func (o *Outbox) replay(update *models.LocalUpdate) error {
+	// Existing records are addressed by their server ID, local IDs only mean something on this device
+	var remoteID uint
+	if update.Operation != models.OperationCreate {
+		var err error
+		if remoteID, err = o.remoteID(update.Entity, update.EntityID); err != nil {
+			return err
+		}
+		if remoteID == 0 && update.Operation == models.OperationUpdate {
+			return fmt.Errorf("%s #%d: %w", update.Entity, update.EntityID, ErrNotOnServer)
+		}
+	}
+	id := strconv.Itoa(int(remoteID))
+
+	var base *string
+	if update.CheckBase {
+		base = &update.Base
+	}
+
+	switch update.Entity {
+	case models.Assignment:
+		if update.Operation == models.OperationCreate {
+			return o.replayAssignmentCreate(update.EntityID)
+		}
+		if update.Operation == models.OperationDelete {
+			return replayDelete(client.DeleteAssignment, remoteID)
+		}
+		return client.SendAssignmentUpdate(id, update.Column, update.Value, base)
+	case models.EntityCourse:
+		if update.Operation == models.OperationCreate {
+			return o.replayCourseCreate(update.EntityID)
+		}
+		if update.Operation == models.OperationDelete {
+			return replayDelete(client.DeleteCourse, remoteID)
+		}
+		return client.SendCourseUpdate(id, update.Column, update.Value, base)
+	case models.EntityNote:
+		if update.Operation == models.OperationCreate {
+			return o.replayNoteCreate(update.EntityID)
+		}
+		if update.Operation == models.OperationDelete {
+			return replayDelete(client.DeleteNote, remoteID)
+		}
+		return client.SendNoteUpdate(id, update.Column, update.Value, base)
+	case models.EntityDocument:
+		if update.Operation == models.OperationDelete {
+			return replayDelete(client.DeleteDocumentMetadata, remoteID)
+		}
+		return o.replayDocumentCreate(update.EntityID)
+	}
+
+	return fmt.Errorf("unknown outbox entity: %s", update.Entity)
+}
+
+// remoteID returns the server ID of a local record, 0 when its create never reached the server
+func (o *Outbox) remoteID(entity models.Entity, id uint) (uint, error) {
+	var remoteID uint
+	if err := o.db.Unscoped().Table(localTable(entity)).Select("remote_id").Where("id = ?", id).Scan(&remoteID).Error; err != nil {
+		return 0, fmt.Errorf("failed to read server id of %s %d: %w", entity, id, err)
+	}
+	return remoteID, nil
+}
+
+// replayDelete sends a delete, records that never reached the server or are already
+// deleted there are done
+func replayDelete(send func(remoteID uint) error, remoteID uint) error {
+	if remoteID == 0 {
+		return nil
+	}
+	err := send(remoteID)
+
+	var statusErr *client.StatusError
+	if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+		return nil
+	}
+	return err
+}
+
+func (o *Outbox) replayAssignmentCreate(id uint) error {
+	var la assignment.LocalAssignment
+	if err := o.db.Unscoped().First(&la, id).Error; err != nil {
+		return fmt.Errorf("failed to load assignment %d: %w", id, err)
+	}
+
+	remoteAssignment := &assignment.Assignment{
+		LocalID:    la.ID,
+		Title:      la.Title,
+		Todo:       la.Todo,
+		Deadline:   la.Deadline,
+		CourseCode: la.CourseCode,
+		TypeName:   la.TypeName,
+		StatusName: la.StatusName,
+		Priority:   la.Priority,
+	}
+
+	response, err := client.CreateAssignment(remoteAssignment)
+	if err != nil {
+		return err
+	}
+
+	return o.setRemoteID(&assignment.LocalAssignment{}, id, response["id"])
+}
+
+func (o *Outbox) replayCourseCreate(id uint) error {
+	var lc course.LocalCourse
+	if err := o.db.Unscoped().First(&lc, id).Error; err != nil {
+		return fmt.Errorf("failed to load course %d: %w", id, err)
+	}
+
+	remoteCourse := &course.Course{
+		LocalID:         lc.ID,
+		Name:            lc.Name,
+		Code:            lc.Code,
+		Color:           lc.Color,
+		Semester:        lc.Semester,
+		Schedule:        lc.Schedule,
+		Credits:         lc.Credits,
+		RoomNumber:      lc.RoomNumber,
+		Instructor:      lc.Instructor,
+		InstructorEmail: lc.InstructorEmail,
+		StartDate:       lc.StartDate,
+		EndDate:         lc.EndDate,
+	}
+
+	response, err := client.CreateCourse(remoteCourse)
+	if err != nil {
+		return err
+	}
+
+	return o.setRemoteID(&course.LocalCourse{}, id, response["id"])
+}
+
+func (o *Outbox) replayNoteCreate(id uint) error {
+	var ln note.LocalNote
+	if err := o.db.Unscoped().First(&ln, id).Error; err != nil {
+		return fmt.Errorf("failed to load note %d: %w", id, err)
+	}
+
+	remoteNote := &note.Note{
+		LocalID:    ln.ID,
+		Title:      ln.Title,
+		Subject:    ln.Subject,
+		CourseCode: ln.CourseCode,
+	}
+
+	response, err := client.CreateNote(remoteNote)
+	if err != nil {
+		return err
+	}
+
+	// The server generates the note content, bring it back into the local copy
+	if err := o.db.Unscoped().Model(&note.LocalNote{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"keywords": response["keywords"],
+		"content":  response["content"],
+	}).Error; err != nil {
+		return fmt.Errorf("failed to store generated note content: %w", err)
+	}
+
+	return o.setRemoteID(&note.LocalNote{}, id, response["id"])
+}
+
+// setRemoteID stores the server ID returned for a created record, when the server sent one
+func (o *Outbox) setRemoteID(model interface{}, id uint, remoteID interface{}) error {
+	value := fmt.Sprint(remoteID)
+	remote, err := strconv.Atoi(value)
+	if remoteID == nil || err != nil {
+		return nil
+	}
+
+	if err := o.db.Unscoped().Model(model).Where("id = ?", id).Update("remote_id", remote).Error; err != nil {
+		return fmt.Errorf("failed to store remote id %d: %w", remote, err)
+	}
+	return nil
+}
+
+// markFailed parks an entry the server rejected. When a create is rejected, every
+// queued change for that record is parked too since the server will never know it.
+func (o *Outbox) markFailed(update *models.LocalUpdate, cause error) error {
+	query := o.db.Model(&models.LocalUpdate{}).Where("id = ?", update.ID)
+	if update.Operation == models.OperationCreate {
+		query = o.db.Model(&models.LocalUpdate{}).Where("entity = ? AND entity_id = ? AND status = ?",
+			update.Entity, update.EntityID, models.UpdateStatusPending)
+	}
+
+	if err := query.Updates(map[string]interface{}{
+		"status":     models.UpdateStatusFailed,
+		"last_error": cause.Error(),
+	}).Error; err != nil {
+		return fmt.Errorf("failed to park outbox entry %d: %w", update.ID, err)
+	}
+	return nil
+}
+
+// recordConflict moves a rejected change to the conflict queue. Later queued changes to the
+// same column are dropped, the local row already holds the latest value which becomes "mine".
+func (o *Outbox) recordConflict(update *models.LocalUpdate, conflict *client.ConflictError) error {
+	return o.db.Transaction(func(tx *gorm.DB) error {
+		mine, err := models.ColumnValue(tx.Unscoped(), localTable(update.Entity), update.EntityID, update.Column)
+		if err != nil {
+			mine = update.Value
+		}
+
+		if err := tx.Create(&models.LocalConflict{
+			Entity:   update.Entity,
+			EntityID: update.EntityID,
+			Column:   update.Column,
+			Base:     update.Base,
+			Mine:     mine,
+			Theirs:   conflict.Theirs,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to record conflict: %w", err)
+		}
+
+		if err := tx.Unscoped().Where(&models.LocalUpdate{
+			Entity:   update.Entity,
+			EntityID: update.EntityID,
+			Column:   update.Column,
+			Status:   models.UpdateStatusPending,
+		}).Delete(&models.LocalUpdate{}).Error; err != nil {
+			return fmt.Errorf("failed to drop conflicting outbox entries: %w", err)
+		}
+		return nil
+	})
+}
+
+// localTable returns the local table holding the records of entity
+func localTable(entity models.Entity) string {
+	switch entity {
+	case models.Assignment:
+		return "local_assignments"
+	case models.EntityCourse:
+		return "local_courses"
+	case models.EntityNote:
+		return "local_notes"
+	case models.EntityDocument:
+		return "local_documents"
+	}
+	return ""
+}
+
+// markSynced flips the record to synced once nothing is left in the outbox
+// or the conflict queue for it
+func markSynced(db *gorm.DB, entity models.Entity, entityID uint) error {
+	var remaining int64
+	if err := db.Model(&models.LocalUpdate{}).
+		Where("entity = ? AND entity_id = ?", entity, entityID).
+		Count(&remaining).Error; err != nil {
+		return fmt.Errorf("failed to count outbox entries: %w", err)
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+
+	if err := db.Model(&models.LocalConflict{}).
+		Where("entity = ? AND entity_id = ?", entity, entityID).
+		Count(&remaining).Error; err != nil {
+		return fmt.Errorf("failed to count conflicts: %w", err)
+	}
+
+	if remaining > 0 {
+		return nil
+	}
+
+	var model interface{}
+	var synced interface{}
+	switch entity {
+	case models.Assignment:
+		model, synced = &assignment.LocalAssignment{}, assignment.SyncStatusSynced
+	case models.EntityCourse:
+		model, synced = &course.LocalCourse{}, course.SyncStatusSynced
+	case models.EntityNote:
+		model, synced = &note.LocalNote{}, note.SyncStatusSynced
+	default:
+		return nil
+	}
+
+	return db.Unscoped().Model(model).Where("id = ?", entityID).Update("sync_status", synced).Error
+}
@@ -0,0 +1,438 @@
+package sync
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	gosync "sync"
+	"testing"
+	"time"
+
+	"unipilot/internal/client"
+	"unipilot/internal/config"
+	"unipilot/internal/models"
+	"unipilot/internal/models/assignment"
+	"unipilot/internal/models/course"
+	"unipilot/internal/models/note"
+	"unipilot/internal/secrets"
+	"unipilot/internal/storage"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// fakeServer answers the client requests with per-route handlers and records
+// every request it receives
+type fakeServer struct {
+	mu       gosync.Mutex
+	requests []string
+	routes   map[string]http.HandlerFunc
+}
+
+var server = &fakeServer{}
+
+func (s *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = append(s.requests, r.URL.Path)
+	handler := s.routes[r.URL.Path]
+	s.mu.Unlock()
+
+	if handler == nil {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{}`))
+		return
+	}
+	handler(w, r)
+}
+
+// reset forgets the recorded requests and replaces the routes
+func (s *fakeServer) reset(routes map[string]http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+	s.routes = routes
+}
+
+func (s *fakeServer) received() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.requests...)
+}
+
+func writeJSON(w http.ResponseWriter, code int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// TestMain points the client at the fake server through a test profile, with the
+// config and secrets kept out of the real home directory
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "unipilot-sync-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	os.Setenv("XDG_CONFIG_HOME", home)
+	secrets.SetDefault(secrets.NewMemory())
+
+	srv := httptest.NewServer(server)
+	if err := config.SaveProfile(config.Profile{Name: "test", BaseURL: srv.URL}); err != nil {
+		panic(err)
+	}
+	os.Setenv(config.ProfileEnv, "test")
+
+	creds, _ := json.Marshal(map[string]interface{}{"is_authenticated": true, "user": map[string]interface{}{"user_id": 1, "username": "ada"}})
+	secrets.Default().Set("credentials", creds)
+	if _, err := storage.GetCurrentUserID(); err != nil {
+		panic(err)
+	}
+
+	code := m.Run()
+	srv.Close()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
+func newDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.InitializeSchema(db); err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func createAssignment(t *testing.T, db *gorm.DB, title string) *assignment.LocalAssignment {
+	t.Helper()
+
+	la := &assignment.LocalAssignment{Title: title, Deadline: time.Now(), CourseCode: "CS101",
+		TypeName: "HW", StatusName: "Not started", SyncStatus: assignment.SyncStatusPending}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("Course", "Type", "Status", "Documents").Create(la).Error; err != nil {
+			return err
+		}
+		return Enqueue(tx, models.Assignment, models.OperationCreate, la.ID, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return la
+}
+
+func TestFlushReplaysInOrder(t *testing.T) {
+	db := newDB(t)
+	// Once created, the record is addressed by the ID the server gave it
+	var addressed []string
+	server.reset(map[string]http.HandlerFunc{
+		"/assignment": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusOK, map[string]interface{}{"assignment": map[string]interface{}{"id": 42}})
+		},
+		"/assignment/update": func(w http.ResponseWriter, r *http.Request) {
+			var body map[string]string
+			json.NewDecoder(r.Body).Decode(&body)
+			addressed = append(addressed, body["remote_id"])
+			writeJSON(w, http.StatusOK, map[string]interface{}{})
+		},
+		"/assignment/delete": func(w http.ResponseWriter, r *http.Request) {
+			addressed = append(addressed, r.URL.Query().Get("remote_id"))
+			writeJSON(w, http.StatusOK, map[string]interface{}{})
+		},
+	})
+
+	la := createAssignment(t, db, "Lab 1")
+	if err := EnqueueUpdate(db, models.Assignment, la.ID, "title", "Lab 1", "Lab 1b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Enqueue(db, models.Assignment, models.OperationDelete, la.ID, "", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	o := NewOutbox(db)
+	if err := o.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	want := []string{"/assignment", "/assignment/update", "/assignment/delete"}
+	if got := server.received(); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("requests = %v, want %v", got, want)
+	}
+	if fmt.Sprint(addressed) != "[42 42]" {
+		t.Fatalf("update and delete addressed %v, want the server ID 42", addressed)
+	}
+
+	pending, err := o.Pending()
+	if err != nil || len(pending) != 0 {
+		t.Fatalf("Pending() = %v, %v, want empty", pending, err)
+	}
+
+	var stored assignment.LocalAssignment
+	db.Unscoped().First(&stored, la.ID)
+	if stored.RemoteID != 42 || stored.SyncStatus != assignment.SyncStatusSynced {
+		t.Fatalf("row remote_id = %d status = %s, want 42 synced", stored.RemoteID, stored.SyncStatus)
+	}
+}
+
+func TestFlushSkipsRecordsNeverCreated(t *testing.T) {
+	db := newDB(t)
+	server.reset(nil)
+
+	// The create of this record never reached the server, changes can't be sent and deleting it is done
+	ln := &note.LocalNote{Title: "Lecture 1", SyncStatus: note.SyncStatusPending}
+	db.Create(ln)
+	EnqueueUpdate(db, models.EntityNote, ln.ID, "title", "Lecture 1", "Lecture one")
+	Enqueue(db, models.EntityNote, models.OperationDelete, ln.ID, "", "")
+
+	o := NewOutbox(db)
+	if err := o.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+	if got := server.received(); len(got) != 0 {
+		t.Fatalf("requests = %v, want none", got)
+	}
+
+	var update models.LocalUpdate
+	if err := db.Where("status = ?", models.UpdateStatusFailed).First(&update).Error; err != nil || update.Operation != models.OperationUpdate {
+		t.Fatalf("failed entry = %+v, %v, want the update", update, err)
+	}
+}
+
+func TestFlushBacksOff(t *testing.T) {
+	db := newDB(t)
+	server.reset(map[string]http.HandlerFunc{
+		"/assignment": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "down", http.StatusServiceUnavailable)
+		},
+	})
+
+	createAssignment(t, db, "Lab 1")
+	o := NewOutbox(db)
+
+	var statusErr interface{ IsPermanent() bool }
+	if err := o.Flush(); !errors.As(err, &statusErr) || statusErr.IsPermanent() {
+		t.Fatalf("Flush() = %v, want a temporary status error", err)
+	}
+
+	var update models.LocalUpdate
+	db.First(&update)
+	if update.Attempts != 1 || !update.NextAttemptAt.After(time.Now()) || update.LastError == "" {
+		t.Fatalf("entry attempts = %d next = %v error = %q, want a scheduled retry", update.Attempts, update.NextAttemptAt, update.LastError)
+	}
+
+	// The entry waits for its next attempt, Flush says so without calling the server
+	if err := o.Flush(); !errors.Is(err, ErrBackingOff) {
+		t.Fatalf("second Flush() = %v, want ErrBackingOff", err)
+	}
+	if got := len(server.received()); got != 1 {
+		t.Fatalf("server got %d requests, want 1", got)
+	}
+
+	if Backoff(1) != backoffBase || Backoff(3) != 4*backoffBase || Backoff(100) != backoffMax {
+		t.Fatalf("Backoff() = %v %v %v", Backoff(1), Backoff(3), Backoff(100))
+	}
+}
+
+func TestFlushRecordsConflicts(t *testing.T) {
+	db := newDB(t)
+	server.reset(map[string]http.HandlerFunc{
+		"/assignment/update": func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusConflict, map[string]interface{}{"conflict": models.FieldConflict{
+				Entity: models.Assignment, EntityID: "1", Column: "title", Base: "Lab 1", Theirs: "Lab one"}})
+		},
+	})
+
+	la := &assignment.LocalAssignment{Title: "Lab 1b", RemoteID: 7, Deadline: time.Now(), CourseCode: "CS101",
+		TypeName: "HW", StatusName: "Not started", SyncStatus: assignment.SyncStatusPending}
+	db.Omit("Course", "Type", "Status", "Documents").Create(la)
+	EnqueueUpdate(db, models.Assignment, la.ID, "title", "Lab 1", "Lab 1a")
+	EnqueueUpdate(db, models.Assignment, la.ID, "title", "Lab 1a", "Lab 1b")
+
+	o := NewOutbox(db)
+	if err := o.Flush(); err != nil {
+		t.Fatalf("Flush() = %v", err)
+	}
+
+	// The later change to the same column is dropped with the conflicting one
+	if got := len(server.received()); got != 1 {
+		t.Fatalf("server got %d requests, want 1", got)
+	}
+
+	conflicts, err := Conflicts(db)
+	if err != nil || len(conflicts) != 1 {
+		t.Fatalf("Conflicts() = %v, %v, want one", conflicts, err)
+	}
+	c := conflicts[0]
+	if c.Mine != "Lab 1b" || c.Theirs != "Lab one" || c.Base != "Lab 1" {
+		t.Fatalf("conflict mine = %q theirs = %q base = %q", c.Mine, c.Theirs, c.Base)
+	}
+
+	var stored assignment.LocalAssignment
+	db.First(&stored, la.ID)
+	if stored.SyncStatus != assignment.SyncStatusPending {
+		t.Fatalf("row status = %s, want pending until resolved", stored.SyncStatus)
+	}
+}
+
+func TestPullChangesStoresCursor(t *testing.T) {
+	db := newDB(t)
+
+	var cursors []string
+	server.reset(map[string]http.HandlerFunc{
+		"/sync/changes": func(w http.ResponseWriter, r *http.Request) {
+			cursors = append(cursors, r.URL.Query().Get("cursor"))
+			changes := map[string]interface{}{"cursor": fmt.Sprintf("c%d", len(cursors))}
+			if len(cursors) == 1 {
+				changes["courses"] = []map[string]string{{"id": "3", "code": "CS101", "name": "Intro"}}
+				changes["assignments"] = []map[string]string{{"id": "5", "title": "Lab", "deadline": "2025-03-01",
+					"course_code": "CS101", "type": "HW", "status": "Not started"}}
+			}
+			writeJSON(w, http.StatusOK, changes)
+		},
+	})
+
+	if err := PullChanges(db); err != nil {
+		t.Fatalf("PullChanges() = %v", err)
+	}
+	if err := PullChanges(db); err != nil {
+		t.Fatalf("second PullChanges() = %v", err)
+	}
+
+	if fmt.Sprint(cursors) != "[ c1]" {
+		t.Fatalf("cursors sent = %q, want the stored one on the second pull", cursors)
+	}
+	if cursor, _ := models.GetSyncState(db, changesCursorKey); cursor != "c2" {
+		t.Fatalf("stored cursor = %q, want c2", cursor)
+	}
+
+	var la assignment.LocalAssignment
+	if err := db.Where("remote_id = ?", 5).First(&la).Error; err != nil || la.Title != "Lab" {
+		t.Fatalf("pulled assignment = %+v, %v", la, err)
+	}
+	var lc course.LocalCourse
+	if err := db.Where("remote_id = ?", 3).First(&lc).Error; err != nil || lc.Name != "Intro" {
+		t.Fatalf("pulled course = %+v, %v", lc, err)
+	}
+}
+
+func TestPullChangesKeepsCursorOnFailure(t *testing.T) {
+	db := newDB(t)
+	models.SetSyncState(db, changesCursorKey, "c1")
+
+	server.reset(map[string]http.HandlerFunc{
+		"/sync/changes": func(w http.ResponseWriter, r *http.Request) {
+			// A row that can't be applied rolls the whole pull back
+			writeJSON(w, http.StatusOK, map[string]interface{}{"cursor": "c2",
+				"notes": []map[string]string{{"id": "not a number"}}})
+		},
+	})
+
+	if err := PullChanges(db); err == nil {
+		t.Fatal("PullChanges() = nil, want the apply error")
+	}
+	if cursor, _ := models.GetSyncState(db, changesCursorKey); cursor != "c1" {
+		t.Fatalf("stored cursor = %q, want c1 kept", cursor)
+	}
+}
+
+// remoteAssignment is an assignment row created on the server by this device
+func remoteAssignment(id, localID, title string) map[string]string {
+	return map[string]string{"id": id, "local_id": localID, "device_id": client.DeviceID(), "title": title,
+		"deadline": "2025-03-01", "course_code": "CS101", "type": "HW", "status": "Not started"}
+}
+
+func TestApplyAssignment(t *testing.T) {
+	db := newDB(t)
+
+	// Created here, the create response with the remote ID was lost
+	lost := &assignment.LocalAssignment{Title: "Lab 1", Deadline: time.Now(), CourseCode: "CS101",
+		TypeName: "HW", StatusName: "Not started", SyncStatus: assignment.SyncStatusSynced}
+	db.Omit("Course", "Type", "Status", "Documents").Create(lost)
+
+	// Another device numbered its own assignment alike, it's a different row
+	other := remoteAssignment("9", fmt.Sprint(lost.ID), "Essay")
+	other["device_id"] = "phone"
+	if err := ApplyAssignment(db, other); err != nil {
+		t.Fatal(err)
+	}
+	if err := ApplyAssignment(db, remoteAssignment("10", fmt.Sprint(lost.ID), "Lab 1 (server)")); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	db.Model(&assignment.LocalAssignment{}).Count(&count)
+	var adopted assignment.LocalAssignment
+	db.First(&adopted, lost.ID)
+	if count != 2 || adopted.RemoteID != 10 || adopted.Title != "Lab 1 (server)" {
+		t.Fatalf("rows = %d, adopted = %+v, want the local row linked and the phone's added", count, adopted)
+	}
+
+	// A row with queued local changes keeps them
+	EnqueueUpdate(db, models.Assignment, adopted.ID, "title", "Lab 1 (server)", "Lab 1 (mine)")
+	db.Model(&adopted).Update("title", "Lab 1 (mine)")
+	if err := ApplyAssignment(db, remoteAssignment("10", fmt.Sprint(lost.ID), "Lab 1 (theirs)")); err != nil {
+		t.Fatal(err)
+	}
+	db.First(&adopted, lost.ID)
+	if adopted.Title != "Lab 1 (mine)" {
+		t.Fatalf("title = %q, want the pending local change kept", adopted.Title)
+	}
+
+	// Tombstones delete
+	tombstone := remoteAssignment("10", fmt.Sprint(lost.ID), "")
+	tombstone["deleted_at"] = time.Now().Format(time.RFC3339)
+	if err := ApplyAssignment(db, tombstone); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&assignment.LocalAssignment{}, lost.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("row after tombstone: %v, want deleted", err)
+	}
+}
+
+func TestApplyKeepsPendingDeletes(t *testing.T) {
+	db := newDB(t)
+
+	ln := &note.LocalNote{RemoteID: 4, Title: "Lecture 1", SyncStatus: note.SyncStatusSynced}
+	db.Omit("Course").Create(ln)
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Delete(ln).Error; err != nil {
+			return err
+		}
+		return Enqueue(tx, models.EntityNote, models.OperationDelete, ln.ID, "", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The server hasn't seen the delete yet and still sends the row
+	if err := ApplyNote(db, map[string]string{"id": "4", "title": "Lecture 1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.First(&note.LocalNote{}, ln.ID).Error; !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Fatalf("deleted note came back: %v", err)
+	}
+}
+
+func TestApplyCourseAdoptsByCode(t *testing.T) {
+	db := newDB(t)
+
+	lc := &course.LocalCourse{Code: "CS101", Name: "Intro", SyncStatus: course.SyncStatusSynced}
+	db.Create(lc)
+
+	if err := ApplyCourse(db, map[string]string{"id": "8", "code": "CS101", "name": "Intro to CS"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var courses []course.LocalCourse
+	db.Find(&courses)
+	if len(courses) != 1 || courses[0].RemoteID != 8 || courses[0].Name != "Intro to CS" {
+		t.Fatalf("courses = %+v, want the local course linked", courses)
+	}
+}
+
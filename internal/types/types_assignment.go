@@ -176,22 +176,4 @@ type DeletionRequest struct {
 	Archived bool `json:"archived,omitempty"`
 }
 
-// COLUMNS is a list of structs that contain the ID and Name of the column
-var COLUMNS = map[string]string{
-	"id":        "id",
-	"w%3FC%3B":  "course_code",
-	"S~Ce":      "type_name",
-	"title":     "title",
-	"_UjC":      "deadline",
-	"%5DJfC":    "todo",
-	"notion_id": "notion_id",
-	"jgPD":      "link",
-	"%5Bm%5Cs":  "status_name",
-}
-
 var DEFAULT_COLUMNS_FOR_LS = []string{"id", "type_name", "course_code", "title", "deadline", "todo", "status_name"}
-
-func GetColumnFromPropertyId(property_id string) (column string, err error) {
-
-	return column, nil
-}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"embed"
+	"log"
+
+	"unipilot/internal/config"
 
 	"github.com/wailsapp/wails/v2"
 	"github.com/wailsapp/wails/v2/pkg/options"
@@ -13,6 +16,11 @@ import (
 var assets embed.FS
 
 func main() {
+	// Refuse to start against a profile that cannot be used rather than guessing one
+	if err := config.Check(); err != nil {
+		log.Fatalf("Invalid server profile: %v", err)
+	}
+
 	// Create an instance of the app structure
 	app := NewApp()
 
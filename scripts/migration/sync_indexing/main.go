@@ -32,7 +32,7 @@ func sync_indexing_assignments() {
 		assignment_id := strconv.Itoa(int(assignment.ID))
 		remote_id := strconv.Itoa(int(assignment.RemoteID))
 
-		if err := client.SendAssignmentUpdate(remote_id, "local_id", assignment_id); err != nil {
+		if err := client.SendAssignmentUpdate(remote_id, "local_id", assignment_id, nil); err != nil {
 			fmt.Printf("ERROR : %s", err)
 		} else {
 			fmt.Printf("Updated assignment %s\n", assignment_id)
@@ -65,7 +65,7 @@ func sync_indexing_courses() {
 		remote_id := strconv.Itoa(int(course.RemoteID))
 
 
-		if err := client.SendCourseUpdate(remote_id, "local_id", course_id); err != nil {
+		if err := client.SendCourseUpdate(remote_id, "local_id", course_id, nil); err != nil {
 			fmt.Printf("ERROR : %s", err)
 		} else {
 			fmt.Printf("Updated course %s\n", course_id)